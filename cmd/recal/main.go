@@ -1,10 +1,14 @@
 package main
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/linus/recal/internal/config"
+	"github.com/linus/recal/internal/logging"
 	"github.com/linus/recal/internal/server"
 )
 
@@ -18,24 +22,48 @@ func main() {
 	// Load configuration
 	cfg, err := config.Load(configPath)
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		slog.Error("failed to load configuration", "path", configPath, "error", err)
+		os.Exit(1)
 	}
 
-	log.Printf("Configuration loaded from %s", configPath)
-	log.Printf("Server port: %d", cfg.Server.Port)
-	log.Printf("Upstream default: %s", cfg.Upstream.DefaultURL)
-	log.Printf("Cache max size: %d", cfg.Cache.MaxSize)
-	log.Printf("Cache min output: %v", cfg.Cache.MinOutputCache)
+	// Log through slog from here on; the standard log package is redirected as well
+	logger, err := logging.New(os.Stderr, cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		slog.Error("failed to set up logging", "error", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	slog.Info("configuration loaded",
+		"path", configPath,
+		"port", cfg.Server.Port,
+		"upstream_default", cfg.Upstream.DefaultURL,
+		"cache_max_size", cfg.Cache.MaxSize,
+		"cache_min_output", cfg.Cache.MinOutputCache,
+		"log_level", cfg.Log.Level)
 
-	// Create and start server
+	// Drain and stop on SIGINT/SIGTERM; a second signal exits immediately
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
+	// Create and run server
 	srv := server.New(cfg)
 
-	log.Printf("Starting ReCal server...")
-	log.Printf("Endpoints:")
-	log.Printf("  - /query  - Filter upstream iCal feed")
-	log.Printf("  - /health  - Health check")
+	// Reload the configuration on SIGHUP and, if enabled, when the file changes
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			_ = srv.Reload(configPath, server.ReloadSignal)
+		}
+	}()
+	if interval := cfg.Server.ReloadInterval; interval > 0 {
+		go srv.WatchConfig(ctx, configPath, interval)
+	}
 
-	if err := srv.Start(); err != nil {
-		log.Fatalf("Server failed: %v", err)
+	if err := srv.Run(ctx); err != nil {
+		slog.Error("server failed", "error", err)
+		os.Exit(1)
 	}
 }
@@ -1,8 +1,11 @@
 package cache
 
 import (
+	"container/list"
 	"crypto/sha256"
 	"fmt"
+	"slices"
+	"sort"
 	"sync"
 	"time"
 )
@@ -13,6 +16,20 @@ type Entry struct {
 	Expiry       time.Time
 	ETag         string
 	LastModified string
+
+	hits int64    // Get hits, carried over when the entry is replaced
+	tags []string // Given to Set, carried over when the entry is replaced without tags
+}
+
+// EntryInfo describes a cache entry without its data
+type EntryInfo struct {
+	Key          string
+	Size         int64
+	Expiry       time.Time
+	ETag         string
+	LastModified string
+	Hits         int64
+	LastAccess   time.Time
 }
 
 // IsExpired checks if the entry has expired
@@ -20,44 +37,102 @@ func (e *Entry) IsExpired() bool {
 	return time.Now().After(e.Expiry)
 }
 
+// IsStale checks if the entry has expired but is still within the given grace period
+func (e *Entry) IsStale(grace time.Duration) bool {
+	now := time.Now()
+	return now.After(e.Expiry) && !now.After(e.Expiry.Add(grace))
+}
+
 // Size returns the approximate size of the entry in bytes
 func (e *Entry) Size() int64 {
 	return int64(len(e.Data) + len(e.ETag) + len(e.LastModified) + 24) // +24 for time.Time
 }
 
-// Cache is a thread-safe in-memory cache with TTL support
-type Cache struct {
+// Cache is a key/value store for upstream feeds and filtered output
+// Implementations must be safe for concurrent use. MemoryCache keeps entries in
+// process; RedisCache shares them between replicas.
+type Cache interface {
+	// Get returns a fresh entry; found is false if the key is missing or expired
+	Get(key string) (*Entry, bool)
+	// GetStale also returns entries that expired within the stale grace period
+	GetStale(key string) (*Entry, bool)
+	// Peek returns an entry, expired or not, without touching statistics
+	Peek(key string) (*Entry, bool)
+	// Set stores an entry; the TTL is clamped to the cache's minimum and maximum
+	// Tags record what the entry derives from, e.g. the upstream URLs a filtered
+	// result was built from; without tags the entry keeps those it had before.
+	Set(key string, data []byte, ttl time.Duration, etag string, lastModified string, tags ...string)
+	// Delete removes an entry
+	Delete(key string)
+	// DeleteTagged removes all entries tagged with tag and returns how many were removed
+	DeleteTagged(tag string) int
+	// Clear removes all entries
+	Clear()
+	// Entries returns a snapshot of all entries without their data
+	Entries() []EntryInfo
+	// GetStats returns cache statistics
+	GetStats() Stats
+	// CleanupExpired removes expired entries and returns how many were removed
+	CleanupExpired() int
+}
+
+// Compile-time check that MemoryCache implements Cache
+var _ Cache = (*MemoryCache)(nil)
+
+// lruItem is the value of an element in the LRU list
+type lruItem struct {
+	key    string
+	access time.Time
+}
+
+// MemoryCache is a thread-safe in-memory cache with TTL support
+// Entries are kept in a doubly linked list ordered by last access, most recent
+// first, so touching and evicting an entry are O(1) regardless of cache size.
+type MemoryCache struct {
 	mu        sync.RWMutex
 	entries   map[string]*Entry
 	maxSize   int
-	maxMemory int64 // Maximum memory usage in bytes
+	maxMemory int64         // Maximum memory usage in bytes
 	maxTTL    time.Duration // Maximum TTL allowed
 	ttl       time.Duration
-	minTTL    time.Duration        // Minimum TTL for entries
-	accessLRU map[string]time.Time // Track access time for LRU eviction
+	minTTL    time.Duration                  // Minimum TTL for entries
+	grace     time.Duration                  // How long expired entries are kept for GetStale
+	lru       *list.List                     // Access order, least recently used at the back
+	elements  map[string]*list.Element       // LRU list element for each key
+	tagged    map[string]map[string]struct{} // Keys carrying each tag
+	disk      *diskStore                     // Optional persistent tier, nil when disabled
+
+	// Background expiry sweeping, see StartJanitor
+	janitorStop chan struct{}
+	janitorDone chan struct{}
 
 	// Metrics
 	hits      int64
 	misses    int64
 	evictions int64
+	expired   int64 // Entries removed by CleanupExpired
+	staleHits int64
+	diskHits  int64 // Entries loaded back from disk after leaving memory
 	memory    int64 // Current memory usage
 }
 
 // NewCache creates a new cache with the given max size and default TTL
-func NewCache(maxSize int, defaultTTL time.Duration, minTTL time.Duration) *Cache {
+func NewCache(maxSize int, defaultTTL time.Duration, minTTL time.Duration) *MemoryCache {
 	return NewCacheWithMemoryLimit(maxSize, defaultTTL, minTTL, 20*1024*1024, 24*time.Hour) // 20MB default, 24h max TTL
 }
 
 // NewCacheWithMemoryLimit creates a cache with memory limit
-func NewCacheWithMemoryLimit(maxSize int, defaultTTL time.Duration, minTTL time.Duration, maxMemory int64, maxTTL time.Duration) *Cache {
-	return &Cache{
+func NewCacheWithMemoryLimit(maxSize int, defaultTTL time.Duration, minTTL time.Duration, maxMemory int64, maxTTL time.Duration) *MemoryCache {
+	return &MemoryCache{
 		entries:   make(map[string]*Entry),
 		maxSize:   maxSize,
 		maxMemory: maxMemory,
 		maxTTL:    maxTTL,
 		ttl:       defaultTTL,
 		minTTL:    minTTL,
-		accessLRU: make(map[string]time.Time),
+		lru:       list.New(),
+		elements:  make(map[string]*list.Element),
+		tagged:    make(map[string]map[string]struct{}),
 		memory:    0,
 		hits:      0,
 		misses:    0,
@@ -65,13 +140,28 @@ func NewCacheWithMemoryLimit(maxSize int, defaultTTL time.Duration, minTTL time.
 	}
 }
 
+// SetStaleGrace sets how long expired entries are kept for GetStale
+// Zero (the default) removes entries as soon as they expire. Call before
+// EnableDisk so persisted entries within the grace period are kept.
+func (c *MemoryCache) SetStaleGrace(grace time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.grace = grace
+}
+
 // Get retrieves an entry from the cache
 // Returns (entry, found) where found is false if not found or expired
-func (c *Cache) Get(key string) (*Entry, bool) {
+// Expired entries within the stale grace period are kept for GetStale.
+func (c *MemoryCache) Get(key string) (*Entry, bool) {
 	c.mu.RLock()
 	entry, exists := c.entries[key]
 	c.mu.RUnlock()
 
+	if !exists {
+		entry, exists = c.loadFromDisk(key)
+	}
+
 	if !exists {
 		c.mu.Lock()
 		c.misses++
@@ -81,31 +171,60 @@ func (c *Cache) Get(key string) (*Entry, bool) {
 
 	// Check expiration
 	if entry.IsExpired() {
-		// Remove expired entry
 		c.mu.Lock()
-		delete(c.entries, key)
-		delete(c.accessLRU, key)
-		c.memory -= entry.Size()
+		// Remove expired entry unless it may still be served stale
+		var obsolete []string
+		if !entry.IsStale(c.grace) && c.entries[key] == entry {
+			obsolete = c.removeWithDisk(key)
+		}
 		c.misses++
 		c.mu.Unlock()
+		c.removeFiles(obsolete)
 		return nil, false
 	}
 
 	// Update access time for LRU and record hit
 	c.mu.Lock()
-	c.accessLRU[key] = time.Now()
+	if c.entries[key] == entry {
+		c.touch(key)
+	}
 	c.hits++
+	entry.hits++
 	c.mu.Unlock()
 
 	return entry, true
 }
 
-// Set stores an entry in the cache with the given TTL
+// GetStale retrieves an expired entry that is still within the stale grace period
+// Used to keep serving the last good data when a refresh fails. Fresh entries
+// are returned too; found is false only if there is nothing usable.
+func (c *MemoryCache) GetStale(key string) (*Entry, bool) {
+	if _, found := c.Peek(key); !found {
+		c.loadFromDisk(key)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[key]
+	if !exists || (entry.IsExpired() && !entry.IsStale(c.grace)) {
+		return nil, false
+	}
+
+	c.touch(key)
+	if entry.IsExpired() {
+		c.staleHits++
+	}
+	return entry, true
+}
+
+// Set stores an entry in the cache with the given TTL and tags
 // If TTL is less than minTTL, minTTL is used
 // If TTL is greater than maxTTL, maxTTL is used
-func (c *Cache) Set(key string, data []byte, ttl time.Duration, etag string, lastModified string) {
+// With a disk tier the entry is also written to disk, outside the lock; a delete
+// or purge that happens before the write completes still wins.
+func (c *MemoryCache) Set(key string, data []byte, ttl time.Duration, etag string, lastModified string, tags ...string) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
 
 	// Enforce minimum TTL
 	if ttl < c.minTTL {
@@ -122,12 +241,34 @@ func (c *Cache) Set(key string, data []byte, ttl time.Duration, etag string, las
 		Expiry:       time.Now().Add(ttl),
 		ETag:         etag,
 		LastModified: lastModified,
+		tags:         slices.Clone(tags),
 	}
+	c.insert(key, newEntry)
+	hits, tags := newEntry.hits, newEntry.tags
+	var gen uint64
+	if c.disk != nil {
+		gen = c.disk.begin(key, tags)
+	}
+	c.mu.Unlock()
+
+	if c.disk != nil {
+		// Write errors are counted in the stats; the entry is still cached in memory
+		_ = c.disk.put(key, gen, newEntry, hits, tags)
+	}
+}
+
+// insert adds an entry to memory, evicting entries as needed
+// Must be called with lock held
+func (c *MemoryCache) insert(key string, newEntry *Entry) {
 	newSize := newEntry.Size()
 
-	// Remove old entry if updating
+	// Remove old entry if updating, keeping its hit count so popularity survives refreshes
 	if oldEntry, exists := c.entries[key]; exists {
-		c.memory -= oldEntry.Size()
+		newEntry.hits = oldEntry.hits
+		if newEntry.tags == nil {
+			newEntry.tags = oldEntry.tags
+		}
+		c.remove(key)
 	}
 
 	// Evict entries if we exceed memory or size limits
@@ -136,39 +277,228 @@ func (c *Cache) Set(key string, data []byte, ttl time.Duration, etag string, las
 	}
 
 	c.entries[key] = newEntry
-	c.accessLRU[key] = time.Now()
+	c.touch(key)
+	c.index(key, newEntry.tags)
 	c.memory += newSize
 }
 
+// touch marks key as most recently used
+// Must be called with lock held
+func (c *MemoryCache) touch(key string) {
+	if elem, exists := c.elements[key]; exists {
+		elem.Value.(*lruItem).access = time.Now()
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.elements[key] = c.lru.PushFront(&lruItem{key: key, access: time.Now()})
+}
+
+// remove deletes key from memory, the LRU list and the tag index
+// Must be called with lock held
+func (c *MemoryCache) remove(key string) {
+	if entry, exists := c.entries[key]; exists {
+		c.memory -= entry.Size()
+		c.unindex(key, entry.tags)
+		delete(c.entries, key)
+	}
+	if elem, exists := c.elements[key]; exists {
+		c.lru.Remove(elem)
+		delete(c.elements, key)
+	}
+}
+
+// index adds key to the tag index under each of tags
+// Must be called with lock held
+func (c *MemoryCache) index(key string, tags []string) {
+	for _, tag := range tags {
+		keys := c.tagged[tag]
+		if keys == nil {
+			keys = make(map[string]struct{})
+			c.tagged[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// unindex removes key from the tag index under each of tags
+// Must be called with lock held
+func (c *MemoryCache) unindex(key string, tags []string) {
+	for _, tag := range tags {
+		delete(c.tagged[tag], key)
+		if len(c.tagged[tag]) == 0 {
+			delete(c.tagged, tag)
+		}
+	}
+}
+
+// DeleteTagged removes all entries tagged with tag, in memory and on disk
+// Tags are persisted with entries, so this also works after a restart.
+func (c *MemoryCache) DeleteTagged(tag string) int {
+	c.mu.Lock()
+	removed := make(map[string]struct{}, len(c.tagged[tag]))
+	for key := range c.tagged[tag] {
+		removed[key] = struct{}{}
+	}
+	for key := range removed {
+		c.remove(key)
+	}
+	var obsolete []string
+	if c.disk != nil {
+		// Entries evicted from memory may still be on disk
+		var keys []string
+		keys, obsolete = c.disk.removeTagged(tag)
+		for _, key := range keys {
+			removed[key] = struct{}{}
+		}
+	}
+	c.mu.Unlock()
+
+	c.removeFiles(obsolete)
+	return len(removed)
+}
+
+// Peek retrieves an entry, expired or not, without affecting LRU order or statistics
+// Used by background jobs that must not make entries look popular.
+func (c *MemoryCache) Peek(key string) (*Entry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, exists := c.entries[key]
+	return entry, exists
+}
+
+// Entries returns a snapshot of all entries, including expired ones not yet removed
+func (c *MemoryCache) Entries() []EntryInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	infos := make([]EntryInfo, 0, len(c.entries))
+	for key, entry := range c.entries {
+		var lastAccess time.Time
+		if elem, exists := c.elements[key]; exists {
+			lastAccess = elem.Value.(*lruItem).access
+		}
+		infos = append(infos, EntryInfo{
+			Key:          key,
+			Size:         entry.Size(),
+			Expiry:       entry.Expiry,
+			ETag:         entry.ETag,
+			LastModified: entry.LastModified,
+			Hits:         entry.hits,
+			LastAccess:   lastAccess,
+		})
+	}
+	return infos
+}
+
+// EnableDisk adds a persistent tier in dir limited to maxBytes (DefaultDiskBytes if zero)
+// Entries left by a previous run are verified and loaded into memory, so the
+// cache is warm right after a restart; entries evicted from memory are read back
+// from disk on demand. Must be called before the cache is used. Returns the
+// number of entries loaded.
+func (c *MemoryCache) EnableDisk(dir string, maxBytes int64) (int, error) {
+	c.mu.RLock()
+	grace := c.grace
+	c.mu.RUnlock()
+
+	disk, loaded, err := openDiskStore(dir, maxBytes, grace)
+	if err != nil {
+		return 0, err
+	}
+
+	// Insert soonest-expiring first so the freshest entries survive memory limits
+	keys := make([]string, 0, len(loaded))
+	for key := range loaded {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return loaded[keys[i]].Expiry.Before(loaded[keys[j]].Expiry) })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		c.insert(key, loaded[key])
+	}
+	c.disk = disk
+	return len(keys), nil
+}
+
+// loadFromDisk promotes the disk copy of key to memory
+func (c *MemoryCache) loadFromDisk(key string) (*Entry, bool) {
+	if c.disk == nil {
+		return nil, false
+	}
+	entry, name, found := c.disk.load(key)
+	if !found {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Another caller may have stored a newer entry meanwhile
+	if current, exists := c.entries[key]; exists {
+		return current, true
+	}
+	// Or deleted the entry after it was read
+	if !c.disk.isCurrent(key, name) {
+		return nil, false
+	}
+	c.insert(key, entry)
+	c.diskHits++
+	return entry, true
+}
+
 // SetWithDefaultTTL stores an entry with the default TTL
-func (c *Cache) SetWithDefaultTTL(key string, data []byte, etag string, lastModified string) {
+func (c *MemoryCache) SetWithDefaultTTL(key string, data []byte, etag string, lastModified string) {
 	c.Set(key, data, c.ttl, etag, lastModified)
 }
 
 // Delete removes an entry from the cache
-func (c *Cache) Delete(key string) {
+func (c *MemoryCache) Delete(key string) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	obsolete := c.removeWithDisk(key)
+	c.mu.Unlock()
 
-	if entry, exists := c.entries[key]; exists {
-		c.memory -= entry.Size()
+	c.removeFiles(obsolete)
+}
+
+// removeWithDisk removes key from memory and the disk tier and returns the
+// names of files to delete with removeFiles once the lock is released
+// Must be called with lock held
+func (c *MemoryCache) removeWithDisk(key string) []string {
+	c.remove(key)
+	if c.disk == nil {
+		return nil
+	}
+	return c.disk.remove(key)
+}
+
+// removeFiles deletes files of the disk tier; call without holding the lock
+func (c *MemoryCache) removeFiles(names []string) {
+	if len(names) > 0 {
+		c.disk.removeFiles(names)
 	}
-	delete(c.entries, key)
-	delete(c.accessLRU, key)
 }
 
 // Clear removes all entries from the cache
-func (c *Cache) Clear() {
+func (c *MemoryCache) Clear() {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	c.entries = make(map[string]*Entry)
-	c.accessLRU = make(map[string]time.Time)
+	c.lru.Init()
+	c.elements = make(map[string]*list.Element)
+	c.tagged = make(map[string]map[string]struct{})
 	c.memory = 0
+
+	var obsolete []string
+	if c.disk != nil {
+		obsolete = c.disk.clear()
+	}
+	c.mu.Unlock()
+
+	c.removeFiles(obsolete)
 }
 
 // Size returns the current number of entries in the cache
-func (c *Cache) Size() int {
+func (c *MemoryCache) Size() int {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
@@ -177,71 +507,112 @@ func (c *Cache) Size() int {
 
 // evictLRU evicts the least recently used entry
 // Must be called with lock held
-func (c *Cache) evictLRU() {
-	if len(c.entries) == 0 {
+func (c *MemoryCache) evictLRU() {
+	oldest := c.lru.Back()
+	if oldest == nil {
 		return
 	}
-
-	var oldestKey string
-	var oldestTime time.Time
-	first := true
-
-	for key, accessTime := range c.accessLRU {
-		if first || accessTime.Before(oldestTime) {
-			oldestKey = key
-			oldestTime = accessTime
-			first = false
-		}
-	}
-
-	if oldestKey != "" {
-		if entry, exists := c.entries[oldestKey]; exists {
-			c.memory -= entry.Size()
-		}
-		delete(c.entries, oldestKey)
-		delete(c.accessLRU, oldestKey)
-		c.evictions++
-	}
+	c.remove(oldest.Value.(*lruItem).key)
+	c.evictions++
 }
 
 // CleanupExpired removes all expired entries from the cache
-// This should be called periodically (e.g., every minute)
-func (c *Cache) CleanupExpired() int {
+// Entries within the stale grace period are kept. Expired files in the disk
+// tier are removed as well but not counted.
+// StartJanitor calls it periodically.
+func (c *MemoryCache) CleanupExpired() int {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	removed := 0
 	now := time.Now()
 
 	for key, entry := range c.entries {
-		if now.After(entry.Expiry) {
-			c.memory -= entry.Size()
-			delete(c.entries, key)
-			delete(c.accessLRU, key)
+		if now.After(entry.Expiry.Add(c.grace)) {
+			c.remove(key)
 			removed++
 		}
 	}
+	c.expired += int64(removed)
 
+	var obsolete []string
+	if c.disk != nil {
+		obsolete = c.disk.removeExpired(c.grace)
+	}
+	c.mu.Unlock()
+
+	c.removeFiles(obsolete)
 	return removed
 }
 
+// StartJanitor runs CleanupExpired every interval in a background goroutine
+// so expired entries do not hold memory until they are next requested. Calling
+// it while a janitor is running does nothing; StopJanitor stops it.
+func (c *MemoryCache) StartJanitor(interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.janitorStop != nil || interval <= 0 {
+		return
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	c.janitorStop, c.janitorDone = stop, done
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.CleanupExpired()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopJanitor stops the background janitor and waits for it to exit
+// It is safe to call when no janitor is running.
+func (c *MemoryCache) StopJanitor() {
+	c.mu.Lock()
+	stop, done := c.janitorStop, c.janitorDone
+	c.janitorStop, c.janitorDone = nil, nil
+	c.mu.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
 // Stats returns cache statistics
 type Stats struct {
-	Entries     int
-	MaxSize     int
-	Memory      int64 // Current memory usage in bytes
-	MaxMemory   int64 // Maximum memory limit in bytes
-	DefaultTTL  time.Duration
-	MinTTL      time.Duration
-	MaxTTL      time.Duration
-	Hits        int64
-	Misses      int64
-	Evictions   int64
-	HitRatio    float64 // Hit ratio (0.0 to 1.0)
+	Entries      int
+	MaxSize      int
+	Memory       int64 // Current memory usage in bytes
+	MaxMemory    int64 // Maximum memory limit in bytes
+	DefaultTTL   time.Duration
+	MinTTL       time.Duration
+	MaxTTL       time.Duration
+	StaleGrace   time.Duration
+	Hits         int64
+	Misses       int64
+	Evictions    int64
+	Expired      int64   // Entries removed by CleanupExpired
+	StaleHits    int64   // Expired entries served by GetStale
+	DiskEntries  int     // Entries in the disk tier (0 when disabled)
+	DiskBytes    int64   // Disk tier usage in bytes
+	DiskMaxBytes int64   // Disk tier budget in bytes
+	DiskHits     int64   // Entries read back from disk
+	DiskErrors   int64   // Failed writes and corrupt files discarded
+	Errors       int64   // Backend errors (shared backends only)
+	HitRatio     float64 // Hit ratio (0.0 to 1.0)
 }
 
 // GetStats returns current cache statistics
-func (c *Cache) GetStats() Stats {
+func (c *MemoryCache) GetStats() Stats {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
@@ -251,7 +622,7 @@ func (c *Cache) GetStats() Stats {
 		hitRatio = float64(c.hits) / float64(total)
 	}
 
-	return Stats{
+	stats := Stats{
 		Entries:    len(c.entries),
 		MaxSize:    c.maxSize,
 		Memory:     c.memory,
@@ -259,11 +630,19 @@ func (c *Cache) GetStats() Stats {
 		DefaultTTL: c.ttl,
 		MinTTL:     c.minTTL,
 		MaxTTL:     c.maxTTL,
+		StaleGrace: c.grace,
 		Hits:       c.hits,
 		Misses:     c.misses,
 		Evictions:  c.evictions,
+		Expired:    c.expired,
+		StaleHits:  c.staleHits,
+		DiskHits:   c.diskHits,
 		HitRatio:   hitRatio,
 	}
+	if c.disk != nil {
+		stats.DiskEntries, stats.DiskBytes, stats.DiskMaxBytes, stats.DiskErrors = c.disk.stats()
+	}
+	return stats
 }
 
 // HashKey generates a cache key from multiple components
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"fmt"
 	"sync"
 	"testing"
 	"time"
@@ -148,6 +149,73 @@ func TestExpiration(t *testing.T) {
 	}
 }
 
+// TestStaleGrace tests keeping expired entries for GetStale
+// Validates: Expired entries hidden from Get but returned by GetStale until the grace period ends
+func TestStaleGrace(t *testing.T) {
+	cache := NewCache(10, 5*time.Minute, 10*time.Millisecond)
+	cache.SetStaleGrace(100 * time.Millisecond)
+
+	cache.Set("key", []byte("last good"), 20*time.Millisecond, "", "")
+	time.Sleep(50 * time.Millisecond)
+
+	if _, found := cache.Get("key"); found {
+		t.Error("Get() returned expired entry, want false")
+	}
+	if cache.CleanupExpired() != 0 {
+		t.Error("CleanupExpired() removed an entry within the grace period")
+	}
+	entry, found := cache.GetStale("key")
+	if !found || string(entry.Data) != "last good" {
+		t.Fatalf("GetStale() = %v, %v, want stale entry", entry, found)
+	}
+	if stats := cache.GetStats(); stats.StaleHits != 1 || stats.StaleGrace != 100*time.Millisecond {
+		t.Errorf("StaleHits = %d, StaleGrace = %v, want 1, 100ms", stats.StaleHits, stats.StaleGrace)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if _, found := cache.GetStale("key"); found {
+		t.Error("GetStale() returned entry after grace period, want false")
+	}
+	if removed := cache.CleanupExpired(); removed != 1 {
+		t.Errorf("CleanupExpired() = %d after grace period, want 1", removed)
+	}
+}
+
+// TestDeleteTagged tests cascading deletes by tag
+// Validates: Tagged entries removed, tags survive Set without tags, untagged entries kept
+func TestDeleteTagged(t *testing.T) {
+	cache := NewCache(10, 5*time.Minute, time.Minute)
+
+	cache.Set("a", []byte("from one"), 5*time.Minute, "", "", "one")
+	cache.Set("b", []byte("from both"), 5*time.Minute, "", "", "one", "two")
+	cache.Set("c", []byte("from two"), 5*time.Minute, "", "", "two")
+	cache.Set("d", []byte("from two, then three"), 5*time.Minute, "", "", "two")
+	cache.Set("d", []byte("from three"), 5*time.Minute, "", "", "three")
+
+	// Replacing an entry without tags keeps its tags
+	cache.Set("a", []byte("from one, refreshed"), 5*time.Minute, "", "")
+
+	if removed := cache.DeleteTagged("one"); removed != 2 {
+		t.Errorf("DeleteTagged(one) = %d, want 2", removed)
+	}
+	if cache.Size() != 2 {
+		t.Errorf("Size() = %d, want 2", cache.Size())
+	}
+	if _, found := cache.Get("c"); !found {
+		t.Error("Entry without the tag was deleted")
+	}
+	if removed := cache.DeleteTagged("one"); removed != 0 {
+		t.Errorf("Second DeleteTagged(one) = %d, want 0", removed)
+	}
+	if removed := cache.DeleteTagged("two"); removed != 1 {
+		t.Errorf("DeleteTagged(two) = %d, want 1 (d was retagged)", removed)
+	}
+	if removed := cache.DeleteTagged("three"); removed != 1 || cache.GetStats().Memory != 0 {
+		t.Errorf("DeleteTagged(three) = %d with %d bytes left, want 1 and 0 bytes", removed, cache.GetStats().Memory)
+	}
+}
+
 // TestDelete tests entry deletion
 // Validates: Delete removes entry from cache
 func TestDelete(t *testing.T) {
@@ -795,3 +863,133 @@ func TestClearResetsMemory(t *testing.T) {
 		t.Errorf("Memory = %d after Clear, want 0", stats.Memory)
 	}
 }
+
+// TestLRUOrder tests eviction order without relying on clock resolution
+// Validates: Access order decides eviction, updating a full cache evicts nothing
+func TestLRUOrder(t *testing.T) {
+	cache := NewCache(3, 5*time.Minute, time.Minute)
+
+	cache.Set("a", []byte("data-a"), 5*time.Minute, "", "")
+	cache.Set("b", []byte("data-b"), 5*time.Minute, "", "")
+	cache.Set("c", []byte("data-c"), 5*time.Minute, "", "")
+	cache.Get("a")
+
+	// Replacing an entry in a full cache must not evict another one
+	cache.Set("c", []byte("data-c2"), 5*time.Minute, "", "")
+	if cache.Size() != 3 || cache.GetStats().Evictions != 0 {
+		t.Fatalf("Size() = %d, Evictions = %d after update, want 3, 0", cache.Size(), cache.GetStats().Evictions)
+	}
+
+	// b is now the least recently used, then a
+	cache.Set("d", []byte("data-d"), 5*time.Minute, "", "")
+	if _, found := cache.Peek("b"); found {
+		t.Error("b not evicted, want least recently used entry evicted")
+	}
+	cache.Set("e", []byte("data-e"), 5*time.Minute, "", "")
+	if _, found := cache.Peek("a"); found {
+		t.Error("a not evicted second")
+	}
+
+	stats := cache.GetStats()
+	if stats.Memory != int64(3*(6+24)+1) || stats.Evictions != 2 {
+		t.Errorf("Memory = %d, Evictions = %d, want %d, 2", stats.Memory, stats.Evictions, 3*(6+24)+1)
+	}
+}
+
+// TestJanitor tests background expiry sweeping
+// Validates: Expired entries removed without being touched, janitor stops cleanly
+func TestJanitor(t *testing.T) {
+	cache := NewCache(10, 5*time.Minute, time.Millisecond)
+	cache.Set("short", []byte("data"), 10*time.Millisecond, "", "")
+	cache.Set("long", []byte("data"), 5*time.Minute, "", "")
+
+	cache.StartJanitor(5 * time.Millisecond)
+	cache.StartJanitor(5 * time.Millisecond) // No second goroutine
+
+	deadline := time.Now().Add(time.Second)
+	for cache.Size() != 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if cache.Size() != 1 {
+		t.Fatalf("Size() = %d, want expired entry swept", cache.Size())
+	}
+	if stats := cache.GetStats(); stats.Expired != 1 {
+		t.Errorf("Expired = %d, want 1", stats.Expired)
+	}
+
+	cache.StopJanitor()
+	cache.StopJanitor() // Safe to call twice
+
+	cache.Set("after", []byte("data"), 10*time.Millisecond, "", "")
+	time.Sleep(30 * time.Millisecond)
+	if cache.Size() != 2 {
+		t.Errorf("Size() = %d after StopJanitor, want expired entry left in place", cache.Size())
+	}
+}
+
+// benchmarkSizes are the cache sizes the benchmarks run at
+var benchmarkSizes = []int{1000, 10000, 100000}
+
+// fillCache creates a full cache with n entries
+func fillCache(n int) (*MemoryCache, []string) {
+	cache := NewCacheWithMemoryLimit(n, 5*time.Minute, time.Minute, int64(n)*1024, time.Hour)
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("https://example.com/%d.ics", i)
+		cache.Set(keys[i], []byte("data"), 5*time.Minute, "", "")
+	}
+	return cache, keys
+}
+
+// BenchmarkSetEvict measures inserting into a full cache, which evicts on every Set
+func BenchmarkSetEvict(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprintf("entries=%d", n), func(b *testing.B) {
+			cache, _ := fillCache(n)
+			data := []byte("data")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cache.Set(fmt.Sprintf("new-%d", i), data, 5*time.Minute, "", "")
+			}
+		})
+	}
+}
+
+// BenchmarkGet measures cache hits, which move the entry to the front of the LRU list
+func BenchmarkGet(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprintf("entries=%d", n), func(b *testing.B) {
+			cache, keys := fillCache(n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cache.Get(keys[i%n])
+			}
+		})
+	}
+}
+
+// BenchmarkGetParallel measures cache hits from concurrent requests
+func BenchmarkGetParallel(b *testing.B) {
+	cache, keys := fillCache(10000)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			cache.Get(keys[i%len(keys)])
+			i++
+		}
+	})
+}
+
+// BenchmarkCleanupExpired measures a janitor sweep over a cache with nothing to remove
+func BenchmarkCleanupExpired(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprintf("entries=%d", n), func(b *testing.B) {
+			cache, _ := fillCache(n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cache.CleanupExpired()
+			}
+		})
+	}
+}
@@ -0,0 +1,431 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDiskBytes is the disk budget used when EnableDisk is given zero
+const DefaultDiskBytes = 100 * 1024 * 1024
+
+// diskExt is the file extension of persisted entries
+const diskExt = ".entry"
+
+// errCorrupt marks an entry file that failed to decode or verify
+var errCorrupt = errors.New("corrupt cache file")
+
+// diskHeader is the JSON line at the start of every entry file
+// The entry data follows the newline unchanged; its SHA-256 is stored in Checksum
+// so truncated or bit-flipped files are detected on load.
+type diskHeader struct {
+	Key          string    `json:"key"`
+	Expiry       time.Time `json:"expiry"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Hits         int64     `json:"hits"`
+	Tags         []string  `json:"tags,omitempty"`
+	Checksum     string    `json:"sha256"`
+}
+
+// diskFile describes a persisted entry
+type diskFile struct {
+	name    string
+	size    int64
+	expiry  time.Time
+	written time.Time
+	tags    []string
+}
+
+// pendingWrite is a write of an entry that has begun but is not in place yet
+type pendingWrite struct {
+	gen  uint64
+	tags []string
+}
+
+// diskStore persists cache entries as one file per key
+// Files are written to a temporary name and renamed into place, so a crash never
+// leaves a half-written entry behind. When the total size exceeds the budget the
+// oldest files are removed.
+//
+// Every write gets its own file name from a generation number handed out by begin.
+// A write only takes effect if no later write, remove or purge of its key began in
+// the meantime, so a slow write cannot bring back an entry that was deleted. Methods
+// that remove entries only update the bookkeeping and return the names of the files
+// to delete, so callers can do that with removeFiles after releasing their locks.
+type diskStore struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	files    map[string]diskFile
+	pending  map[string]pendingWrite // Writes in progress by key
+	gen      uint64                  // Last generation handed out
+	size     int64
+	errors   int64 // Failed writes and corrupt files discarded
+}
+
+// fileName returns the file name for generation gen of key; keys are hashed since they may be URLs
+func fileName(key string, gen uint64) string {
+	return HashKey(key) + "-" + strconv.FormatUint(gen, 16) + diskExt
+}
+
+// fileKeyHash returns the hashed key a file name was created for
+func fileKeyHash(name string) string {
+	hash, _, _ := strings.Cut(strings.TrimSuffix(name, diskExt), "-")
+	return hash
+}
+
+// openDiskStore opens dir, creating it if needed, and loads all valid entries
+// Corrupt files, leftover temporary files and entries that expired more than
+// grace ago are removed. If a crash left two files for a key, the newer one is kept.
+func openDiskStore(dir string, maxBytes int64, grace time.Duration) (*diskStore, map[string]*Entry, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultDiskBytes
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	d := &diskStore{
+		dir:      dir,
+		maxBytes: maxBytes,
+		files:    make(map[string]diskFile),
+		pending:  make(map[string]pendingWrite),
+		// Generations continue from the clock so file names never repeat across restarts
+		gen: uint64(time.Now().UnixNano()),
+	}
+
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	entries := make(map[string]*Entry)
+	var obsolete []string
+	now := time.Now()
+	for _, de := range names {
+		name := de.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			obsolete = append(obsolete, name)
+			continue
+		}
+		if de.IsDir() || !strings.HasSuffix(name, diskExt) {
+			continue
+		}
+
+		key, entry, err := readEntryFile(filepath.Join(dir, name))
+		if err != nil || fileKeyHash(name) != HashKey(key) || now.After(entry.Expiry.Add(grace)) {
+			if err != nil {
+				d.errors++
+			}
+			obsolete = append(obsolete, name)
+			continue
+		}
+
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		if previous, exists := d.files[key]; exists {
+			if previous.written.After(info.ModTime()) {
+				obsolete = append(obsolete, name)
+				continue
+			}
+			obsolete = append(obsolete, d.dropLocked(key)...)
+		}
+		d.files[key] = diskFile{name: name, size: info.Size(), expiry: entry.Expiry, written: info.ModTime(), tags: entry.tags}
+		d.size += info.Size()
+		entries[key] = entry
+	}
+
+	obsolete = append(obsolete, d.enforceBudget("")...)
+	d.removeFiles(obsolete)
+	for key := range entries {
+		if _, kept := d.files[key]; !kept {
+			delete(entries, key)
+		}
+	}
+
+	return d, entries, nil
+}
+
+// readEntryFile reads and verifies an entry file
+func readEntryFile(path string) (string, *Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	r := bufio.NewReader(f)
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: missing header", errCorrupt)
+	}
+	var h diskHeader
+	if err := json.Unmarshal(line, &h); err != nil {
+		return "", nil, fmt.Errorf("%w: %v", errCorrupt, err)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != h.Checksum {
+		return "", nil, fmt.Errorf("%w: checksum mismatch", errCorrupt)
+	}
+
+	return h.Key, &Entry{
+		Data:         data,
+		Expiry:       h.Expiry,
+		ETag:         h.ETag,
+		LastModified: h.LastModified,
+		hits:         h.Hits,
+		tags:         h.Tags,
+	}, nil
+}
+
+// load reads the entry for key and returns it with the name of its file
+// A corrupt file is removed unless it was replaced meanwhile.
+func (d *diskStore) load(key string) (*Entry, string, bool) {
+	d.mu.Lock()
+	file, exists := d.files[key]
+	d.mu.Unlock()
+	if !exists {
+		return nil, "", false
+	}
+
+	storedKey, entry, err := readEntryFile(filepath.Join(d.dir, file.name))
+	if err != nil || storedKey != key {
+		d.mu.Lock()
+		var obsolete []string
+		if d.current(key, file.name) {
+			obsolete = d.dropLocked(key)
+		}
+		d.errors++
+		d.mu.Unlock()
+		d.removeFiles(obsolete)
+		return nil, "", false
+	}
+	return entry, file.name, true
+}
+
+// isCurrent reports whether name is still the file of key, i.e. what load returned
+// has not been replaced or removed since
+func (d *diskStore) isCurrent(key, name string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.current(key, name)
+}
+
+// current is isCurrent. Must be called with lock held.
+func (d *diskStore) current(key, name string) bool {
+	file, exists := d.files[key]
+	return exists && file.name == name
+}
+
+// begin starts a write of key with tags and returns its generation for put
+// Callers hold the lock of the memory cache, which orders begin with the change
+// to memory the write mirrors.
+func (d *diskStore) begin(key string, tags []string) uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.gen++
+	d.pending[key] = pendingWrite{gen: d.gen, tags: tags}
+	return d.gen
+}
+
+// put writes generation gen of the entry for key and enforces the size budget
+// The write is dropped if gen was superseded. Files are not synced: a file torn by
+// a crash fails its checksum on load and is discarded like any lost cache entry.
+func (d *diskStore) put(key string, gen uint64, entry *Entry, hits int64, tags []string) error {
+	sum := sha256.Sum256(entry.Data)
+	header, err := json.Marshal(diskHeader{
+		Key:          key,
+		Expiry:       entry.Expiry,
+		ETag:         entry.ETag,
+		LastModified: entry.LastModified,
+		Hits:         hits,
+		Tags:         tags,
+		Checksum:     hex.EncodeToString(sum[:]),
+	})
+	if err != nil {
+		return d.failed(err)
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(header) + 1 + len(entry.Data))
+	buf.Write(header)
+	buf.WriteByte('\n')
+	buf.Write(entry.Data)
+
+	tmp, err := os.CreateTemp(d.dir, "entry-*.tmp")
+	if err != nil {
+		return d.failed(err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		_ = tmp.Close()
+		return d.failed(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return d.failed(err)
+	}
+
+	// The name is unique to this write, so it can be put in place before checking gen
+	name := fileName(key, gen)
+	if err := os.Rename(tmp.Name(), filepath.Join(d.dir, name)); err != nil {
+		return d.failed(err)
+	}
+
+	d.mu.Lock()
+	obsolete := []string{name}
+	if pending, ok := d.pending[key]; ok && pending.gen == gen {
+		delete(d.pending, key)
+		obsolete = d.dropLocked(key)
+		d.files[key] = diskFile{name: name, size: int64(buf.Len()), expiry: entry.Expiry, written: time.Now(), tags: tags}
+		d.size += int64(buf.Len())
+		obsolete = append(obsolete, d.enforceBudget(key)...)
+	}
+	d.mu.Unlock()
+
+	d.removeFiles(obsolete)
+	return nil
+}
+
+// failed records a write error
+func (d *diskStore) failed(err error) error {
+	d.mu.Lock()
+	d.errors++
+	d.mu.Unlock()
+	return fmt.Errorf("failed to write cache file: %w", err)
+}
+
+// enforceBudget drops the oldest files until the store fits its budget and returns their names
+// The file for keep is never dropped. Must be called with lock held.
+func (d *diskStore) enforceBudget(keep string) []string {
+	if d.size <= d.maxBytes {
+		return nil
+	}
+
+	keys := make([]string, 0, len(d.files))
+	for key := range d.files {
+		if key != keep {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return d.files[keys[i]].written.Before(d.files[keys[j]].written) })
+
+	var obsolete []string
+	for _, key := range keys {
+		if d.size <= d.maxBytes {
+			break
+		}
+		obsolete = append(obsolete, d.dropLocked(key)...)
+	}
+	return obsolete
+}
+
+// dropLocked forgets the file for key and returns its name, if any
+// Must be called with lock held.
+func (d *diskStore) dropLocked(key string) []string {
+	file, exists := d.files[key]
+	if !exists {
+		return nil
+	}
+	d.size -= file.size
+	delete(d.files, key)
+	return []string{file.name}
+}
+
+// remove forgets key, cancelling a write in progress, and returns the names of files to delete
+func (d *diskStore) remove(key string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.pending, key)
+	return d.dropLocked(key)
+}
+
+// removeExpired forgets entries that expired more than grace ago and returns the names
+// of files to delete
+func (d *diskStore) removeExpired(grace time.Duration) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var obsolete []string
+	now := time.Now()
+	for key, file := range d.files {
+		if now.After(file.expiry.Add(grace)) {
+			obsolete = append(obsolete, d.dropLocked(key)...)
+		}
+	}
+	return obsolete
+}
+
+// removeTagged forgets all entries tagged with tag, cancelling writes of such entries in
+// progress, and returns the keys of the forgotten entries and the names of files to delete
+func (d *diskStore) removeTagged(tag string) ([]string, []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key, pending := range d.pending {
+		if slices.Contains(pending.tags, tag) {
+			delete(d.pending, key)
+		}
+	}
+
+	var keys, obsolete []string
+	for key, file := range d.files {
+		if slices.Contains(file.tags, tag) {
+			obsolete = append(obsolete, d.dropLocked(key)...)
+			keys = append(keys, key)
+		}
+	}
+	return keys, obsolete
+}
+
+// clear forgets all entries, cancelling writes in progress, and returns the names of files to delete
+func (d *diskStore) clear() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pending = make(map[string]pendingWrite)
+	var obsolete []string
+	for key := range d.files {
+		obsolete = append(obsolete, d.dropLocked(key)...)
+	}
+	return obsolete
+}
+
+// removeFiles deletes the named files
+// Call without holding any lock; names are never reused, so a file deleted late
+// cannot be a newer copy of the same entry.
+func (d *diskStore) removeFiles(names []string) {
+	for _, name := range names {
+		_ = os.Remove(filepath.Join(d.dir, name))
+	}
+}
+
+// stats returns the number of files, their total size, the budget and the error count
+func (d *diskStore) stats() (int, int64, int64, int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.files), d.size, d.maxBytes, d.errors
+}
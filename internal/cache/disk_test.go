@@ -0,0 +1,210 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newDiskCache creates a cache with a disk tier in dir
+func newDiskCache(t *testing.T, dir string, maxSize int, maxBytes int64) *MemoryCache {
+	t.Helper()
+	c := NewCache(maxSize, 5*time.Minute, time.Millisecond)
+	if _, err := c.EnableDisk(dir, maxBytes); err != nil {
+		t.Fatalf("EnableDisk() failed: %v", err)
+	}
+	return c
+}
+
+// TestDiskPersistence tests that entries survive a restart
+// Validates: Entries, metadata and hit counts reloaded, expired entries dropped
+func TestDiskPersistence(t *testing.T) {
+	dir := t.TempDir()
+
+	c := newDiskCache(t, dir, 10, 0)
+	c.Set("https://example.com/a.ics", []byte("calendar a"), time.Hour, `"etag-a"`, "Mon, 01 Jan 2024 00:00:00 GMT")
+	c.Get("https://example.com/a.ics")
+	// Hit counts are persisted with the next write, e.g. a refresh
+	c.Set("https://example.com/a.ics", []byte("calendar a"), time.Hour, `"etag-a"`, "Mon, 01 Jan 2024 00:00:00 GMT")
+	c.Set("b", []byte("calendar b"), time.Hour, "", "")
+	c.Set("short", []byte("expires"), 10*time.Millisecond, "", "")
+	time.Sleep(20 * time.Millisecond)
+
+	restarted := NewCache(10, 5*time.Minute, time.Millisecond)
+	loaded, err := restarted.EnableDisk(dir, 0)
+	if err != nil {
+		t.Fatalf("EnableDisk() failed: %v", err)
+	}
+	if loaded != 2 {
+		t.Errorf("EnableDisk() loaded %d entries, want 2", loaded)
+	}
+
+	entry, found := restarted.Get("https://example.com/a.ics")
+	if !found {
+		t.Fatal("Get() after restart returned false, want true")
+	}
+	if string(entry.Data) != "calendar a" || entry.ETag != `"etag-a"` || entry.LastModified == "" {
+		t.Errorf("Reloaded entry = %+v, want data and validators preserved", entry)
+	}
+
+	for _, info := range restarted.Entries() {
+		if info.Key == "https://example.com/a.ics" && info.Hits != 2 {
+			t.Errorf("Hits after restart = %d, want 2", info.Hits)
+		}
+	}
+	if _, found := restarted.Get("short"); found {
+		t.Error("Expired entry reloaded from disk")
+	}
+}
+
+// TestDiskCorruption tests checksum verification on load
+// Validates: Corrupt and leftover temporary files are discarded
+func TestDiskCorruption(t *testing.T) {
+	dir := t.TempDir()
+
+	c := newDiskCache(t, dir, 10, 0)
+	c.Set("good", []byte("good data"), time.Hour, "", "")
+	c.Set("bad", []byte("bad data"), time.Hour, "", "")
+
+	// Flip the last data byte of one file and leave a half-written temp file
+	path := filepath.Join(dir, c.disk.files["bad"].name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "entry-123.tmp"), []byte("partial"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newDiskCache(t, dir, 10, 0)
+	if _, found := restarted.Get("good"); !found {
+		t.Error("Valid entry not loaded")
+	}
+	if _, found := restarted.Get("bad"); found {
+		t.Error("Corrupt entry loaded")
+	}
+	if stats := restarted.GetStats(); stats.DiskErrors != 1 || stats.DiskEntries != 1 {
+		t.Errorf("DiskErrors = %d, DiskEntries = %d, want 1, 1", stats.DiskErrors, stats.DiskEntries)
+	}
+
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("%d files left in cache directory, want 1", len(files))
+	}
+}
+
+// TestDiskTier tests the disk tier behind the memory cache
+// Validates: Evicted entries read back from disk, Delete removes files, size budget enforced
+func TestDiskTier(t *testing.T) {
+	dir := t.TempDir()
+
+	c := newDiskCache(t, dir, 1, 0)
+	c.Set("a", []byte("first"), time.Hour, "", "")
+	c.Set("b", []byte("second"), time.Hour, "", "") // Evicts a from memory
+
+	entry, found := c.Get("a")
+	if !found || string(entry.Data) != "first" {
+		t.Fatalf("Get() of evicted entry = %v, %v, want it from disk", entry, found)
+	}
+	if stats := c.GetStats(); stats.DiskHits != 1 {
+		t.Errorf("DiskHits = %d, want 1", stats.DiskHits)
+	}
+
+	name := c.disk.files["a"].name
+	c.Delete("a")
+	if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+		t.Error("Delete() left the file on disk")
+	}
+
+	// A budget of about two entries (header included) keeps only the newest two
+	small := newDiskCache(t, t.TempDir(), 10, 600)
+	var first string
+	for _, key := range []string{"one", "two", "three"} {
+		small.Set(key, make([]byte, 100), time.Hour, "", "")
+		if first == "" {
+			first = small.disk.files[key].name
+		}
+		time.Sleep(10 * time.Millisecond) // Distinct write times
+	}
+	stats := small.GetStats()
+	if stats.DiskEntries != 2 || stats.DiskBytes > 600 {
+		t.Errorf("Disk = %d entries, %d bytes, want 2 entries within 600 bytes", stats.DiskEntries, stats.DiskBytes)
+	}
+	if _, err := os.Stat(filepath.Join(small.disk.dir, first)); !os.IsNotExist(err) {
+		t.Error("Oldest file not removed when over budget")
+	}
+}
+
+// TestDiskTags tests that tags are persisted with entries
+// Validates: DeleteTagged reaches entries evicted to disk and entries reloaded after a restart
+func TestDiskTags(t *testing.T) {
+	dir := t.TempDir()
+
+	c := newDiskCache(t, dir, 1, 0)
+	c.Set("a", []byte("from one"), time.Hour, "", "", "one")
+	c.Set("b", []byte("from one too"), time.Hour, "", "", "one") // Evicts a from memory
+	c.Set("c", []byte("from two"), time.Hour, "", "", "two")
+
+	// a and b only exist on disk now
+	if removed := c.DeleteTagged("two"); removed != 1 {
+		t.Errorf("DeleteTagged(two) = %d, want 1", removed)
+	}
+	if stats := c.GetStats(); stats.DiskEntries != 2 {
+		t.Errorf("DiskEntries = %d, want 2", stats.DiskEntries)
+	}
+
+	restarted := newDiskCache(t, dir, 10, 0)
+	if removed := restarted.DeleteTagged("one"); removed != 2 {
+		t.Errorf("DeleteTagged(one) after restart = %d, want 2", removed)
+	}
+	if stats := restarted.GetStats(); stats.DiskEntries != 0 || stats.Entries != 0 {
+		t.Errorf("Entries = %d, DiskEntries = %d, want 0, 0", stats.Entries, stats.DiskEntries)
+	}
+}
+
+// TestDiskWriteOrdering tests writes that finish after a later change to their key
+// Validates: A purge or delete before the write completes wins, an older write never
+// replaces a newer one, superseded files are removed
+func TestDiskWriteOrdering(t *testing.T) {
+	dir := t.TempDir()
+	c := newDiskCache(t, dir, 10, 0)
+	entry := func(data string) *Entry {
+		return &Entry{Data: []byte(data), Expiry: time.Now().Add(time.Hour)}
+	}
+
+	// Set began writing, then the entry was purged by tag before the file was in place
+	gen := c.disk.begin("purged", []string{"one"})
+	c.DeleteTagged("one")
+	if err := c.disk.put("purged", gen, entry("stale"), 0, []string{"one"}); err != nil {
+		t.Fatalf("put() failed: %v", err)
+	}
+
+	// Likewise for Delete
+	gen = c.disk.begin("deleted", nil)
+	c.Delete("deleted")
+	_ = c.disk.put("deleted", gen, entry("stale"), 0, nil)
+
+	// Two writes of the same key finishing out of order
+	older := c.disk.begin("raced", nil)
+	newer := c.disk.begin("raced", nil)
+	_ = c.disk.put("raced", newer, entry("newer"), 0, nil)
+	_ = c.disk.put("raced", older, entry("older"), 0, nil)
+
+	restarted := newDiskCache(t, dir, 10, 0)
+	for _, key := range []string{"purged", "deleted"} {
+		if _, found := restarted.Get(key); found {
+			t.Errorf("Entry %q came back after it was removed", key)
+		}
+	}
+	if got, found := restarted.Get("raced"); !found || string(got.Data) != "newer" {
+		t.Errorf("Get(raced) = %v, %v, want the newer write", got, found)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("%d files left in cache directory, want 1", len(files))
+	}
+}
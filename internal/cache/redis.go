@@ -0,0 +1,597 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Redis defaults used when the corresponding RedisOptions field is zero
+const (
+	defaultRedisPoolSize = 10
+	defaultRedisTimeout  = 2 * time.Second
+	redisScanCount       = 100
+)
+
+// RedisOptions configures a RedisCache
+type RedisOptions struct {
+	Addr       string        // host:port of the Redis server
+	Password   string        // Sent with AUTH if set
+	DB         int           // Selected with SELECT if non-zero
+	Prefix     string        // Prepended to every key, e.g. "recal:upstream:"
+	PoolSize   int           // Maximum idle connections kept open
+	Timeout    time.Duration // Dial and per-command timeout
+	DefaultTTL time.Duration
+	MinTTL     time.Duration
+	MaxTTL     time.Duration
+	StaleGrace time.Duration // How long expired entries are kept for GetStale
+}
+
+// RedisCache is a Cache shared between replicas through a Redis-compatible server
+// Each entry is a hash with the fields data, expiry, etag, lm, hits and access;
+// tags are sets of entry keys stored under "<prefix>tag:<tag>", and the sorted set
+// "<prefix>index" scores every entry key by when Redis drops it, so stats can be
+// read without scanning the keyspace.
+// Keys expire in Redis once the stale grace period has passed, so no cleanup is
+// needed. Backend errors are counted and treated as cache misses, so an outage
+// degrades to fetching from upstream instead of failing requests.
+type RedisCache struct {
+	opts  RedisOptions
+	conns chan *redisConn // Idle connections
+
+	// Metrics (per replica)
+	hits      atomic.Int64
+	misses    atomic.Int64
+	staleHits atomic.Int64
+	errors    atomic.Int64
+}
+
+// Compile-time check that RedisCache implements Cache
+var _ Cache = (*RedisCache)(nil)
+
+// NewRedisCache creates a Redis-backed cache
+// Connections are opened lazily, so an unreachable server only shows up as errors.
+func NewRedisCache(opts RedisOptions) *RedisCache {
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = defaultRedisPoolSize
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultRedisTimeout
+	}
+	return &RedisCache{
+		opts:  opts,
+		conns: make(chan *redisConn, opts.PoolSize),
+	}
+}
+
+// Get retrieves a fresh entry and records the access
+func (c *RedisCache) Get(key string) (*Entry, bool) {
+	entry, found := c.load(key)
+	if !found || entry.IsExpired() {
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	// Re-applying the expiry keeps a hash recreated by HINCRBY after expiring from living forever
+	_, _ = c.do(
+		[]string{"HINCRBY", c.opts.Prefix + key, "hits", "1"},
+		[]string{"HSET", c.opts.Prefix + key, "access", formatMillis(time.Now())},
+		[]string{"PEXPIREAT", c.opts.Prefix + key, formatMillis(entry.Expiry.Add(c.opts.StaleGrace))},
+	)
+	c.hits.Add(1)
+	return entry, true
+}
+
+// GetStale retrieves an entry that is fresh or expired within the stale grace period
+func (c *RedisCache) GetStale(key string) (*Entry, bool) {
+	entry, found := c.load(key)
+	if !found || (entry.IsExpired() && !entry.IsStale(c.opts.StaleGrace)) {
+		return nil, false
+	}
+	if entry.IsExpired() {
+		c.staleHits.Add(1)
+	}
+	return entry, true
+}
+
+// Peek retrieves an entry, expired or not, without recording the access
+func (c *RedisCache) Peek(key string) (*Entry, bool) {
+	return c.load(key)
+}
+
+// Set stores an entry with the given TTL, clamped to the minimum and maximum
+// The hit count of an existing entry is kept. The key is added to the set of each
+// tag in the same pipeline; the sets expire after the longest possible entry
+// lifetime since their last use, and members that expired in the meantime are
+// ignored by DeleteTagged. The index expires the same way.
+func (c *RedisCache) Set(key string, data []byte, ttl time.Duration, etag string, lastModified string, tags ...string) {
+	if ttl < c.opts.MinTTL {
+		ttl = c.opts.MinTTL
+	}
+	if c.opts.MaxTTL > 0 && ttl > c.opts.MaxTTL {
+		ttl = c.opts.MaxTTL
+	}
+	now := time.Now()
+	expiry := now.Add(ttl)
+
+	lifetime := c.opts.MaxTTL + c.opts.StaleGrace
+	cmds := make([][]string, 0, 4+2*len(tags))
+	cmds = append(cmds,
+		[]string{"HSET", c.opts.Prefix + key,
+			"data", string(data),
+			"expiry", formatMillis(expiry),
+			"etag", etag,
+			"lm", lastModified,
+			"access", formatMillis(now)},
+		[]string{"PEXPIREAT", c.opts.Prefix + key, formatMillis(expiry.Add(c.opts.StaleGrace))},
+		[]string{"ZADD", c.indexKey(), formatMillis(expiry.Add(c.opts.StaleGrace)), key},
+	)
+	if c.opts.MaxTTL > 0 {
+		cmds = append(cmds, []string{"PEXPIRE", c.indexKey(), strconv.FormatInt(lifetime.Milliseconds(), 10)})
+	}
+	for _, tag := range tags {
+		cmds = append(cmds, []string{"SADD", c.tagKey(tag), key})
+		if c.opts.MaxTTL > 0 {
+			cmds = append(cmds, []string{"PEXPIRE", c.tagKey(tag), strconv.FormatInt(lifetime.Milliseconds(), 10)})
+		}
+	}
+	_, _ = c.do(cmds...)
+}
+
+// Delete removes an entry
+func (c *RedisCache) Delete(key string) {
+	_, _ = c.do(
+		[]string{"DEL", c.opts.Prefix + key},
+		[]string{"ZREM", c.indexKey(), key},
+	)
+}
+
+// DeleteTagged removes all entries tagged with tag and the tag set itself
+func (c *RedisCache) DeleteTagged(tag string) int {
+	replies, err := c.do([]string{"SMEMBERS", c.tagKey(tag)})
+	if err != nil {
+		return 0
+	}
+	members, _ := replies[0].([]any)
+
+	cmds := make([][]string, 0, len(members)+2)
+	zrem := []string{"ZREM", c.indexKey()}
+	for _, m := range members {
+		key, _ := m.(string)
+		cmds = append(cmds, []string{"DEL", c.opts.Prefix + key})
+		zrem = append(zrem, key)
+	}
+	cmds = append(cmds, []string{"DEL", c.tagKey(tag)})
+	if len(members) > 0 {
+		cmds = append(cmds, zrem)
+	}
+	replies, err = c.do(cmds...)
+	if err != nil {
+		return 0
+	}
+
+	removed := 0
+	for _, reply := range replies[:len(members)] {
+		if n, _ := reply.(int64); n > 0 {
+			removed++
+		}
+	}
+	return removed
+}
+
+// Clear removes all entries, tag sets and the index under the prefix
+func (c *RedisCache) Clear() {
+	_ = c.scan("", func(keys []string) error {
+		cmds := make([][]string, len(keys))
+		for i, key := range keys {
+			cmds[i] = []string{"DEL", key}
+		}
+		_, err := c.do(cmds...)
+		return err
+	})
+}
+
+// tagKey returns the Redis key of the set for tag
+func (c *RedisCache) tagKey(tag string) string {
+	return c.opts.Prefix + "tag:" + tag
+}
+
+// indexKey returns the Redis key of the sorted set indexing all entries
+func (c *RedisCache) indexKey() string {
+	return c.opts.Prefix + "index"
+}
+
+// scan calls fn with each batch of keys under the prefix, optionally only those of
+// the given Redis type, until the keyspace is exhausted or fn returns an error
+func (c *RedisCache) scan(keyType string, fn func(keys []string) error) error {
+	cursor := "0"
+	for {
+		cmd := []string{"SCAN", cursor, "MATCH", escapeGlob(c.opts.Prefix) + "*", "COUNT", strconv.Itoa(redisScanCount)}
+		if keyType != "" {
+			cmd = append(cmd, "TYPE", keyType)
+		}
+		replies, err := c.do(cmd)
+		if err != nil {
+			return err
+		}
+		scan, ok := replies[0].([]any)
+		if !ok || len(scan) != 2 {
+			c.errors.Add(1)
+			return errors.New("redis: malformed SCAN reply")
+		}
+		cursor, _ = scan[0].(string)
+		batch, _ := scan[1].([]any)
+
+		keys := make([]string, 0, len(batch))
+		for _, k := range batch {
+			name, _ := k.(string)
+			keys = append(keys, name)
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+
+		if cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+// Entries returns a snapshot of all entries under the prefix
+func (c *RedisCache) Entries() []EntryInfo {
+	var infos []EntryInfo
+	// Only hashes are entries; tag sets share the prefix
+	_ = c.scan("hash", func(keys []string) error {
+		// Fetch metadata for the whole batch in one round trip
+		cmds := make([][]string, 0, 2*len(keys))
+		for _, name := range keys {
+			cmds = append(cmds,
+				[]string{"HMGET", name, "expiry", "etag", "lm", "hits", "access"},
+				[]string{"HSTRLEN", name, "data"})
+		}
+		meta, err := c.do(cmds...)
+		if err != nil {
+			return err
+		}
+
+		for i, name := range keys {
+			fields, _ := meta[2*i].([]any)
+			size, _ := meta[2*i+1].(int64)
+			if len(fields) != 5 || fields[0] == nil {
+				continue // Expired or deleted since SCAN
+			}
+			etag, _ := fields[1].(string)
+			lm, _ := fields[2].(string)
+			info := EntryInfo{
+				Key:          strings.TrimPrefix(name, c.opts.Prefix),
+				Expiry:       parseMillis(fields[0]),
+				ETag:         etag,
+				LastModified: lm,
+				Hits:         parseInt(fields[3]),
+				LastAccess:   parseMillis(fields[4]),
+			}
+			info.Size = (&Entry{Data: make([]byte, size), ETag: etag, LastModified: lm}).Size()
+			infos = append(infos, info)
+		}
+		return nil
+	})
+	return infos
+}
+
+// GetStats returns cache statistics
+// The entry count comes from the shared index after dropping keys Redis has expired,
+// so it costs two commands however large the cache is. Memory is not tracked for the
+// shared backend and is reported as zero; hits and misses are per replica.
+func (c *RedisCache) GetStats() Stats {
+	var entries int64
+	replies, err := c.do(
+		[]string{"ZREMRANGEBYSCORE", c.indexKey(), "-inf", formatMillis(time.Now())},
+		[]string{"ZCARD", c.indexKey()},
+	)
+	if err == nil {
+		entries, _ = replies[1].(int64)
+	}
+
+	hits, misses := c.hits.Load(), c.misses.Load()
+	hitRatio := 0.0
+	if hits+misses > 0 {
+		hitRatio = float64(hits) / float64(hits+misses)
+	}
+
+	return Stats{
+		Entries:    int(entries),
+		DefaultTTL: c.opts.DefaultTTL,
+		MinTTL:     c.opts.MinTTL,
+		MaxTTL:     c.opts.MaxTTL,
+		StaleGrace: c.opts.StaleGrace,
+		Hits:       hits,
+		Misses:     misses,
+		StaleHits:  c.staleHits.Load(),
+		Errors:     c.errors.Load(),
+		HitRatio:   hitRatio,
+	}
+}
+
+// CleanupExpired is a no-op; Redis expires keys itself
+func (c *RedisCache) CleanupExpired() int {
+	return 0
+}
+
+// Close closes all idle connections
+func (c *RedisCache) Close() error {
+	for {
+		select {
+		case conn := <-c.conns:
+			_ = conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// load fetches and decodes the hash for key
+func (c *RedisCache) load(key string) (*Entry, bool) {
+	replies, err := c.do([]string{"HGETALL", c.opts.Prefix + key})
+	if err != nil {
+		return nil, false
+	}
+	pairs, _ := replies[0].([]any)
+
+	fields := make(map[string]string, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		name, _ := pairs[i].(string)
+		value, _ := pairs[i+1].(string)
+		fields[name] = value
+	}
+	data, found := fields["data"]
+	if !found {
+		return nil, false
+	}
+
+	return &Entry{
+		Data:         []byte(data),
+		Expiry:       parseMillis(fields["expiry"]),
+		ETag:         fields["etag"],
+		LastModified: fields["lm"],
+		hits:         parseInt(fields["hits"]),
+	}, true
+}
+
+// do sends the commands as one pipeline and returns their replies
+// Network and protocol errors close the connection; error replies fail the call.
+func (c *RedisCache) do(cmds ...[]string) ([]any, error) {
+	conn, err := c.getConn()
+	if err != nil {
+		c.errors.Add(1)
+		return nil, err
+	}
+
+	replies, err := conn.pipeline(c.opts.Timeout, cmds...)
+	if err != nil {
+		c.errors.Add(1)
+		var replyErr redisError
+		if errors.As(err, &replyErr) {
+			c.putConn(conn) // The connection is still in sync
+		} else {
+			_ = conn.Close()
+		}
+		return nil, err
+	}
+
+	c.putConn(conn)
+	return replies, nil
+}
+
+// getConn returns an idle connection or dials a new one
+func (c *RedisCache) getConn() (*redisConn, error) {
+	select {
+	case conn := <-c.conns:
+		return conn, nil
+	default:
+	}
+
+	netConn, err := net.DialTimeout("tcp", c.opts.Addr, c.opts.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	conn := &redisConn{conn: netConn, r: bufio.NewReader(netConn), w: bufio.NewWriter(netConn)}
+
+	var setup [][]string
+	if c.opts.Password != "" {
+		setup = append(setup, []string{"AUTH", c.opts.Password})
+	}
+	if c.opts.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.opts.DB)})
+	}
+	if len(setup) > 0 {
+		if _, err := conn.pipeline(c.opts.Timeout, setup...); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// putConn returns a connection to the idle pool, closing it if the pool is full
+func (c *RedisCache) putConn(conn *redisConn) {
+	select {
+	case c.conns <- conn:
+	default:
+		_ = conn.Close()
+	}
+}
+
+// redisError is an error reply from the server
+type redisError string
+
+// Error implements the error interface
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisConn is a connection speaking RESP2
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// Close closes the connection
+func (c *redisConn) Close() error {
+	return c.conn.Close()
+}
+
+// pipeline writes all commands, then reads one reply per command
+// Replies are string (simple and bulk strings), int64, []any or nil.
+func (c *redisConn) pipeline(timeout time.Duration, cmds ...[]string) ([]any, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	for _, args := range cmds {
+		fmt.Fprintf(c.w, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+
+	replies := make([]any, len(cmds))
+	var firstErr error
+	for i := range cmds {
+		reply, err := c.readReply()
+		if err != nil {
+			var replyErr redisError
+			if !errors.As(err, &replyErr) {
+				return nil, err
+			}
+			// Keep reading so the connection stays in sync
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		replies[i] = reply
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return replies, nil
+}
+
+// readReply reads a single RESP2 reply
+func (c *redisConn) readReply() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	payload := line[1 : len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, redisError(payload)
+	case ':':
+		n, err := strconv.ParseInt(payload, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed integer %q", payload)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", payload)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed array length %q", payload)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		// Nested error replies (e.g. inside EXEC) fail the reply only once all
+		// items are read, so the connection stays in sync
+		items := make([]any, n)
+		var firstErr error
+		for i := range items {
+			item, err := c.readReply()
+			if err != nil {
+				var replyErr redisError
+				if !errors.As(err, &replyErr) {
+					return nil, err
+				}
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+			items[i] = item
+		}
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", line[0])
+	}
+}
+
+// escapeGlob escapes Redis glob characters so a prefix matches literally
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// formatMillis formats t as Unix milliseconds
+func formatMillis(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+// parseMillis parses Unix milliseconds from a reply field, returning the zero time if invalid
+func parseMillis(v any) time.Time {
+	ms := parseInt(v)
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+// parseInt parses an integer from a reply field, returning 0 if invalid
+func parseInt(v any) int64 {
+	switch v := v.(type) {
+	case int64:
+		return v
+	case string:
+		n, _ := strconv.ParseInt(v, 10, 64)
+		return n
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,441 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a minimal in-memory stand-in for a Redis server
+// It implements only the hash, set, sorted set, expiry and SCAN commands RedisCache uses.
+type fakeRedis struct {
+	mu       sync.Mutex
+	hashes   map[string]map[string]string
+	sets     map[string]map[string]bool
+	zsets    map[string]map[string]float64
+	expiry   map[string]time.Time
+	calls    map[string]int // Commands received by name
+	password string
+}
+
+// startFakeRedis starts a fakeRedis on a random local port
+func startFakeRedis(t *testing.T, password string) (*fakeRedis, string) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	f := &fakeRedis{
+		hashes:   make(map[string]map[string]string),
+		sets:     make(map[string]map[string]bool),
+		zsets:    make(map[string]map[string]float64),
+		expiry:   make(map[string]time.Time),
+		calls:    make(map[string]int),
+		password: password,
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f, ln.Addr().String()
+}
+
+// serve handles one client connection
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	r := bufio.NewReader(conn)
+	authed := f.password == ""
+
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		name := strings.ToUpper(args[0])
+
+		var reply string
+		switch {
+		case name == "AUTH":
+			authed = len(args) == 2 && args[1] == f.password
+			reply = "+OK\r\n"
+			if !authed {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		case !authed:
+			reply = "-NOAUTH Authentication required\r\n"
+		default:
+			reply = f.exec(name, args[1:])
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads a RESP array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || line[0] != '*' {
+		return nil, fmt.Errorf("bad command %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+// exec runs a command and returns the encoded reply
+func (f *fakeRedis) exec(name string, args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[name]++
+
+	// Lazily expire keys like Redis does
+	for key, at := range f.expiry {
+		if time.Now().After(at) {
+			delete(f.hashes, key)
+			delete(f.sets, key)
+			delete(f.zsets, key)
+			delete(f.expiry, key)
+		}
+	}
+
+	switch name {
+	case "SELECT":
+		return "+OK\r\n"
+	case "HSET":
+		h := f.hashes[args[0]]
+		if h == nil {
+			h = make(map[string]string)
+			f.hashes[args[0]] = h
+		}
+		for i := 1; i+1 < len(args); i += 2 {
+			h[args[i]] = args[i+1]
+		}
+		return ":1\r\n"
+	case "HINCRBY":
+		h := f.hashes[args[0]]
+		if h == nil {
+			h = make(map[string]string)
+			f.hashes[args[0]] = h
+		}
+		n, _ := strconv.ParseInt(h[args[1]], 10, 64)
+		delta, _ := strconv.ParseInt(args[2], 10, 64)
+		h[args[1]] = strconv.FormatInt(n+delta, 10)
+		return ":" + h[args[1]] + "\r\n"
+	case "HGETALL":
+		var items []string
+		for k, v := range f.hashes[args[0]] {
+			items = append(items, k, v)
+		}
+		return encodeArray(items)
+	case "HMGET":
+		h := f.hashes[args[0]]
+		var b strings.Builder
+		fmt.Fprintf(&b, "*%d\r\n", len(args)-1)
+		for _, field := range args[1:] {
+			if v, ok := h[field]; ok {
+				fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(v), v)
+			} else {
+				b.WriteString("$-1\r\n")
+			}
+		}
+		return b.String()
+	case "HSTRLEN":
+		return fmt.Sprintf(":%d\r\n", len(f.hashes[args[0]][args[1]]))
+	case "SADD":
+		set := f.sets[args[0]]
+		if set == nil {
+			set = make(map[string]bool)
+			f.sets[args[0]] = set
+		}
+		for _, member := range args[1:] {
+			set[member] = true
+		}
+		return ":1\r\n"
+	case "SMEMBERS":
+		var members []string
+		for member := range f.sets[args[0]] {
+			members = append(members, member)
+		}
+		return encodeArray(members)
+	case "ZADD":
+		zset := f.zsets[args[0]]
+		if zset == nil {
+			zset = make(map[string]float64)
+			f.zsets[args[0]] = zset
+		}
+		for i := 1; i+1 < len(args); i += 2 {
+			zset[args[i+1]], _ = strconv.ParseFloat(args[i], 64)
+		}
+		return ":1\r\n"
+	case "ZREM":
+		removed := 0
+		for _, member := range args[1:] {
+			if _, ok := f.zsets[args[0]][member]; ok {
+				delete(f.zsets[args[0]], member)
+				removed++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", removed)
+	case "ZREMRANGEBYSCORE":
+		// Only "-inf" is used as the minimum
+		limit, _ := strconv.ParseFloat(args[2], 64)
+		removed := 0
+		for member, score := range f.zsets[args[0]] {
+			if score <= limit {
+				delete(f.zsets[args[0]], member)
+				removed++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", removed)
+	case "ZCARD":
+		return fmt.Sprintf(":%d\r\n", len(f.zsets[args[0]]))
+	case "NESTED":
+		// An array holding an error, as EXEC may return
+		return "*2\r\n-ERR nested failure\r\n:1\r\n"
+	case "PEXPIREAT", "PEXPIRE":
+		_, isHash := f.hashes[args[0]]
+		_, isSet := f.sets[args[0]]
+		_, isZSet := f.zsets[args[0]]
+		if !isHash && !isSet && !isZSet {
+			return ":0\r\n"
+		}
+		ms, _ := strconv.ParseInt(args[1], 10, 64)
+		at := time.UnixMilli(ms)
+		if name == "PEXPIRE" {
+			at = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		f.expiry[args[0]] = at
+		return ":1\r\n"
+	case "DEL":
+		_, isHash := f.hashes[args[0]]
+		_, isSet := f.sets[args[0]]
+		_, isZSet := f.zsets[args[0]]
+		delete(f.hashes, args[0])
+		delete(f.sets, args[0])
+		delete(f.zsets, args[0])
+		delete(f.expiry, args[0])
+		if isHash || isSet || isZSet {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	case "SCAN":
+		// Patterns are always "<prefix>*" here; everything is returned in one batch
+		prefix := strings.ReplaceAll(strings.TrimSuffix(args[2], "*"), `\`, "")
+		hashesOnly := len(args) == 7 && args[5] == "TYPE" && args[6] == "hash"
+		var keys []string
+		for key := range f.hashes {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		for key := range f.sets {
+			if strings.HasPrefix(key, prefix) && !hashesOnly {
+				keys = append(keys, key)
+			}
+		}
+		for key := range f.zsets {
+			if strings.HasPrefix(key, prefix) && !hashesOnly {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		return "*2\r\n$1\r\n0\r\n" + encodeArray(keys)
+	default:
+		return "-ERR unknown command '" + name + "'\r\n"
+	}
+}
+
+// encodeArray encodes items as a RESP array of bulk strings
+func encodeArray(items []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(items))
+	for _, item := range items {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(item), item)
+	}
+	return b.String()
+}
+
+// newTestRedisCache creates a RedisCache connected to a fakeRedis
+func newTestRedisCache(t *testing.T, addr, password string) *RedisCache {
+	t.Helper()
+	c := NewRedisCache(RedisOptions{
+		Addr:       addr,
+		Password:   password,
+		DB:         2,
+		Prefix:     "recal:test:",
+		DefaultTTL: 5 * time.Minute,
+		MinTTL:     time.Millisecond,
+		MaxTTL:     time.Hour,
+		StaleGrace: time.Hour,
+	})
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+// TestRedisCache tests the Redis backend against a local stand-in
+// Validates: Get/Set/Delete round trip, hit counts, stale reads, Entries and stats read without SCAN
+func TestRedisCache(t *testing.T) {
+	fake, addr := startFakeRedis(t, "s3cret")
+	c := newTestRedisCache(t, addr, "s3cret")
+
+	data := []byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n")
+	c.Set("https://example.com/cal.ics", data, time.Minute, `"v1"`, "Mon, 01 Jan 2024 00:00:00 GMT")
+
+	entry, found := c.Get("https://example.com/cal.ics")
+	if !found {
+		t.Fatal("Get() returned false after Set, want true")
+	}
+	if string(entry.Data) != string(data) || entry.ETag != `"v1"` || entry.LastModified == "" {
+		t.Errorf("Get() = %+v, want stored data and validators", entry)
+	}
+
+	// A second replica shares the entry and its hit count
+	other := newTestRedisCache(t, addr, "s3cret")
+	if _, found := other.Get("https://example.com/cal.ics"); !found {
+		t.Error("Entry not visible to another replica")
+	}
+	infos := other.Entries()
+	if len(infos) != 1 || infos[0].Key != "https://example.com/cal.ics" || infos[0].Hits != 2 {
+		t.Errorf("Entries() = %+v, want one entry with 2 hits", infos)
+	}
+
+	// Expired entries are misses but still available stale
+	c.Set("short", []byte("old"), time.Millisecond, "", "")
+	time.Sleep(10 * time.Millisecond)
+	if _, found := c.Get("short"); found {
+		t.Error("Get() returned expired entry")
+	}
+	if entry, found := c.GetStale("short"); !found || string(entry.Data) != "old" {
+		t.Error("GetStale() did not return expired entry within grace period")
+	}
+
+	c.Delete("short")
+	if _, found := c.Peek("short"); found {
+		t.Error("Peek() found deleted entry")
+	}
+
+	fake.mu.Lock()
+	scans := fake.calls["SCAN"]
+	fake.mu.Unlock()
+	stats := c.GetStats()
+	if stats.Entries != 1 || stats.Hits != 1 || stats.Misses != 1 || stats.StaleHits != 1 || stats.Errors != 0 {
+		t.Errorf("Stats = %+v, want 1 entry, 1 hit, 1 miss, 1 stale hit, no errors", stats)
+	}
+	fake.mu.Lock()
+	if fake.calls["SCAN"] != scans {
+		t.Error("GetStats() scanned the keyspace")
+	}
+	fake.mu.Unlock()
+
+	// Keys Redis has dropped leave the count once their purge time has passed
+	c.Set("gone", []byte("data"), time.Millisecond, "", "")
+	fake.mu.Lock()
+	fake.zsets["recal:test:index"]["gone"] = 0
+	fake.mu.Unlock()
+	if n := c.GetStats().Entries; n != 1 {
+		t.Errorf("Stats.Entries = %d after purge, want 1", n)
+	}
+}
+
+// TestRedisCacheTags tests cascading deletes in the Redis backend
+// Validates: DeleteTagged removes tagged entries only, Clear empties the prefix, tag sets hidden from Entries
+func TestRedisCacheTags(t *testing.T) {
+	_, addr := startFakeRedis(t, "")
+	c := newTestRedisCache(t, addr, "")
+
+	c.Set("a", []byte("from one"), time.Minute, "", "", "https://one.example/cal.ics")
+	c.Set("b", []byte("from both"), time.Minute, "", "", "https://one.example/cal.ics", "https://two.example/cal.ics")
+	c.Set("c", []byte("from two"), time.Minute, "", "", "https://two.example/cal.ics")
+
+	if n := len(c.Entries()); n != 3 {
+		t.Fatalf("Entries() returned %d entries, want 3 (tag sets excluded)", n)
+	}
+	if removed := c.DeleteTagged("https://one.example/cal.ics"); removed != 2 {
+		t.Errorf("DeleteTagged() = %d, want 2", removed)
+	}
+	if n := c.GetStats().Entries; n != 1 {
+		t.Errorf("Stats.Entries = %d after DeleteTagged, want 1", n)
+	}
+	if _, found := c.Peek("c"); !found {
+		t.Error("Entry without the tag was deleted")
+	}
+
+	c.Clear()
+	if _, found := c.Peek("c"); found || len(c.Entries()) != 0 {
+		t.Error("Clear() left entries behind")
+	}
+	if removed := c.DeleteTagged("https://two.example/cal.ics"); removed != 0 {
+		t.Errorf("DeleteTagged() after Clear = %d, want 0", removed)
+	}
+}
+
+// TestRedisCacheErrors tests that backend failures degrade to cache misses
+// Validates: Wrong password and unreachable server counted as errors, never panic,
+// connections kept in sync after error replies nested in arrays
+func TestRedisCacheErrors(t *testing.T) {
+	_, addr := startFakeRedis(t, "s3cret")
+
+	wrong := newTestRedisCache(t, addr, "wrong")
+	wrong.Set("key", []byte("data"), time.Minute, "", "")
+	if _, found := wrong.Get("key"); found {
+		t.Error("Get() with wrong password returned an entry")
+	}
+	if wrong.GetStats().Errors == 0 {
+		t.Error("Authentication failures not counted")
+	}
+
+	// Nothing listens on a closed listener's address
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	down := ln.Addr().String()
+	_ = ln.Close()
+
+	unreachable := newTestRedisCache(t, down, "")
+	unreachable.Set("key", []byte("data"), time.Minute, "", "")
+	if _, found := unreachable.Get("key"); found {
+		t.Error("Get() from unreachable server returned an entry")
+	}
+	if len(unreachable.Entries()) != 0 || unreachable.GetStats().Errors < 2 {
+		t.Error("Unreachable server errors not counted")
+	}
+
+	// An error inside an array reply leaves the pooled connection in sync
+	c := newTestRedisCache(t, addr, "s3cret")
+	c.Set("key", []byte("data"), time.Minute, "", "")
+	if _, err := c.do([]string{"NESTED"}); err == nil || !strings.Contains(err.Error(), "nested failure") {
+		t.Errorf("do() with a nested error reply = %v, want the error", err)
+	}
+	if entry, found := c.Peek("key"); !found || string(entry.Data) != "data" {
+		t.Error("Connection out of sync after a nested error reply")
+	}
+}
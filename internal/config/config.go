@@ -2,49 +2,135 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
+	"net/url"
 	"os"
+	"path"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/linus/recal/internal/logging"
 	"gopkg.in/yaml.v3"
 )
 
 // Config holds the application configuration
 type Config struct {
-	Server   ServerConfig   `yaml:"server"`
-	Upstream UpstreamConfig `yaml:"upstream"`
-	Cache    CacheConfig    `yaml:"cache"`
-	Regex    RegexConfig    `yaml:"regex"`
-	Filters  FiltersConfig  `yaml:"filters"`
+	Server     ServerConfig     `yaml:"server"`
+	Upstream   UpstreamConfig   `yaml:"upstream"`
+	Cache      CacheConfig      `yaml:"cache"`
+	Regex      RegexConfig      `yaml:"regex"`
+	Filters    FiltersConfig    `yaml:"filters"`
+	Transforms TransformsConfig `yaml:"transforms"`
+	Privacy    PrivacyConfig    `yaml:"privacy"`
+	Feeds      FeedsConfig      `yaml:"feeds"`
+	Admin      AdminConfig      `yaml:"admin"`
+	Log        LogConfig        `yaml:"log"`
 }
 
 // ServerConfig holds HTTP server configuration
 type ServerConfig struct {
-	Port         int           `yaml:"port"`
-	ReadTimeout  time.Duration `yaml:"read_timeout"`
-	WriteTimeout time.Duration `yaml:"write_timeout"`
-	IdleTimeout  time.Duration `yaml:"idle_timeout"`
-	BaseURL      string        `yaml:"base_url"`
+	Port            int           `yaml:"port"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	BaseURL         string        `yaml:"base_url"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"` // How long in-flight requests may finish on shutdown (30s if zero)
+	ShutdownDelay   time.Duration `yaml:"shutdown_delay"`   // How long /ready reports draining before new connections are refused
+	ReloadInterval  time.Duration `yaml:"reload_interval"`  // How often the config file is checked for changes, 0 disables (SIGHUP always reloads)
 }
 
 // UpstreamConfig holds upstream feed configuration
 type UpstreamConfig struct {
-	DefaultURL string        `yaml:"default_url"`
-	Timeout    time.Duration `yaml:"timeout"`
+	DefaultURL string            `yaml:"default_url"`
+	Timeout    time.Duration     `yaml:"timeout"`
+	Sources    map[string]string `yaml:"sources"` // Named upstream URLs, selectable with source=name
+	Allowed    AllowedConfig     `yaml:"allowed"`
+	AllowCIDRs []string          `yaml:"allow_cidrs"` // Ranges exempt from SSRF blocking, e.g. an internal calendar server
+	DenyCIDRs  []string          `yaml:"deny_cidrs"`  // Ranges blocked in addition to private and reserved ones
+
+	MaxBodyBytes       int64 `yaml:"max_body_bytes"`      // Maximum (decompressed) response size, 0 uses the default
+	DisableCompression bool  `yaml:"disable_compression"` // Do not request gzip/deflate responses
+}
+
+// AllowedConfig restricts which upstream URLs may be fetched
+// When enabled, only the default URL, named sources and the listed URLs and hosts are allowed.
+type AllowedConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	URLs    []string `yaml:"urls"`  // Exact upstream URLs
+	Hosts   []string `yaml:"hosts"` // Host names or globs, e.g. "calendar.google.com" or "*.example.org"
+}
+
+// FeedsConfig holds saved feed (/f/{slug}) configuration
+type FeedsConfig struct {
+	StorePath   string `yaml:"store_path"`   // JSON file for saved feeds, empty keeps them in memory only
+	AdminToken  string `yaml:"admin_token"`  // Deprecated: alias of admin.token, used if that is empty
+	MaxFeeds    int    `yaml:"max_feeds"`    // Most saved feeds in the store (1000 if zero)
+	CreateLimit int    `yaml:"create_limit"` // Feeds one client address may create per hour (10 if zero); the admin token is exempt
+}
+
+// AdminConfig holds configuration for the operator API under /api/admin/
+type AdminConfig struct {
+	Token string `yaml:"token"` // Bearer token for the admin API and for managing all saved feeds, empty disables both
+}
+
+// LogConfig holds logging configuration
+type LogConfig struct {
+	Level  string `yaml:"level"`  // debug, info (default), warn or error
+	Format string `yaml:"format"` // text (default) or json
 }
 
 // CacheConfig holds caching configuration
 type CacheConfig struct {
-	MaxSize        int           `yaml:"max_size"`
-	MaxMemory      int64         `yaml:"max_memory"`      // Maximum memory in bytes
-	DefaultTTL     time.Duration `yaml:"default_ttl"`
-	MinOutputCache time.Duration `yaml:"min_output_cache"`
-	MaxTTL         time.Duration `yaml:"max_ttl"`          // Maximum TTL allowed
+	MaxSize         int           `yaml:"max_size"`
+	MaxMemory       int64         `yaml:"max_memory"` // Maximum memory in bytes
+	DefaultTTL      time.Duration `yaml:"default_ttl"`
+	MinOutputCache  time.Duration `yaml:"min_output_cache"`
+	MaxTTL          time.Duration `yaml:"max_ttl"`          // Maximum TTL allowed
+	StaleGrace      time.Duration `yaml:"stale_grace"`      // How long expired output may be served when upstreams fail, 0 disables
+	CleanupInterval time.Duration `yaml:"cleanup_interval"` // How often expired entries are swept from memory (1m if zero)
+	Refresh         RefreshConfig `yaml:"refresh"`
+	Disk            DiskConfig    `yaml:"disk"`
+	Backend         string        `yaml:"backend"` // "memory" (default) or "redis" to share caches between replicas
+	Redis           RedisConfig   `yaml:"redis"`
 }
 
-// RegexConfig holds regex execution configuration
+// RedisConfig holds the shared Redis cache backend configuration
+type RedisConfig struct {
+	Addr      string        `yaml:"addr"`       // host:port
+	Password  string        `yaml:"password"`   // Optional AUTH password
+	DB        int           `yaml:"db"`         // Database number
+	KeyPrefix string        `yaml:"key_prefix"` // Prefix for all keys ("recal:" if empty)
+	PoolSize  int           `yaml:"pool_size"`  // Idle connections kept open (10)
+	Timeout   time.Duration `yaml:"timeout"`    // Dial and command timeout (2s)
+}
+
+// DiskConfig holds the persistent cache tier configuration
+type DiskConfig struct {
+	Dir      string `yaml:"dir"`       // Directory for cache files, empty keeps caches in memory only
+	MaxBytes int64  `yaml:"max_bytes"` // Disk budget shared by both caches (100MB per cache if zero)
+}
+
+// RefreshConfig holds background refresh configuration
+// Zero values fall back to the defaults noted on each field.
+type RefreshConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Interval  time.Duration `yaml:"interval"`   // How often caches are scanned for hot entries (30s)
+	Lead      time.Duration `yaml:"lead"`       // Refresh entries expiring within this window (2m)
+	Jitter    time.Duration `yaml:"jitter"`     // Maximum random delay before each refresh (15s)
+	Workers   int           `yaml:"workers"`    // Concurrent refreshes (4)
+	MinHits   int64         `yaml:"min_hits"`   // Cache hits needed for an entry to count as hot (2)
+	HotWindow time.Duration `yaml:"hot_window"` // Entries not accessed for this long are left to expire (1h)
+}
+
+// RegexConfig holds the budgets for user-supplied filter patterns
+// Zero values of the limits fall back to the defaults noted on each field.
 type RegexConfig struct {
-	MaxExecutionTime time.Duration `yaml:"max_execution_time"`
+	MaxExecutionTime time.Duration `yaml:"max_execution_time"` // Time allowed for filtering one calendar
+	MaxPatternLength int           `yaml:"max_pattern_length"` // Longest pattern or expression term in characters (512)
+	MaxFilters       int           `yaml:"max_filters"`        // Most patterns per request, counting expression terms (50)
+	MaxProgramSize   int           `yaml:"max_program_size"`   // Largest compiled pattern in regexp instructions (5000)
 }
 
 // FiltersConfig holds special filter configurations
@@ -97,6 +183,11 @@ func Load(configPath string) (*Config, error) {
 	// Apply environment variable overrides
 	applyEnvOverrides(&cfg)
 
+	// Resolve deprecated settings
+	if err := applyAliases(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
 	// Validate configuration
 	if err := validate(&cfg); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
@@ -117,6 +208,24 @@ func applyEnvOverrides(cfg *Config) {
 		cfg.Server.BaseURL = baseURL
 	}
 
+	if timeout := os.Getenv("SHUTDOWN_TIMEOUT"); timeout != "" {
+		if d, err := time.ParseDuration(timeout); err == nil {
+			cfg.Server.ShutdownTimeout = d
+		}
+	}
+
+	if delay := os.Getenv("SHUTDOWN_DELAY"); delay != "" {
+		if d, err := time.ParseDuration(delay); err == nil {
+			cfg.Server.ShutdownDelay = d
+		}
+	}
+
+	if interval := os.Getenv("CONFIG_RELOAD_INTERVAL"); interval != "" {
+		if d, err := time.ParseDuration(interval); err == nil {
+			cfg.Server.ReloadInterval = d
+		}
+	}
+
 	if url := os.Getenv("DEFAULT_UPSTREAM"); url != "" {
 		cfg.Upstream.DefaultURL = url
 	}
@@ -139,17 +248,87 @@ func applyEnvOverrides(cfg *Config) {
 		}
 	}
 
+	if grace := os.Getenv("CACHE_STALE_GRACE"); grace != "" {
+		if d, err := time.ParseDuration(grace); err == nil {
+			cfg.Cache.StaleGrace = d
+		}
+	}
+
+	if dir := os.Getenv("CACHE_DIR"); dir != "" {
+		cfg.Cache.Disk.Dir = dir
+	}
+
+	if backend := os.Getenv("CACHE_BACKEND"); backend != "" {
+		cfg.Cache.Backend = backend
+	}
+
+	if addr := os.Getenv("REDIS_ADDR"); addr != "" {
+		cfg.Cache.Redis.Addr = addr
+	}
+
+	if password := os.Getenv("REDIS_PASSWORD"); password != "" {
+		cfg.Cache.Redis.Password = password
+	}
+
+	if refresh := os.Getenv("CACHE_REFRESH"); refresh != "" {
+		if enabled, err := strconv.ParseBool(refresh); err == nil {
+			cfg.Cache.Refresh.Enabled = enabled
+		}
+	}
+
 	if timeout := os.Getenv("UPSTREAM_TIMEOUT"); timeout != "" {
 		if d, err := time.ParseDuration(timeout); err == nil {
 			cfg.Upstream.Timeout = d
 		}
 	}
 
+	if maxBody := os.Getenv("UPSTREAM_MAX_BODY_BYTES"); maxBody != "" {
+		if n, err := strconv.ParseInt(maxBody, 10, 64); err == nil {
+			cfg.Upstream.MaxBodyBytes = n
+		}
+	}
+
 	if maxRegex := os.Getenv("MAX_REGEX_TIME"); maxRegex != "" {
 		if d, err := time.ParseDuration(maxRegex); err == nil {
 			cfg.Regex.MaxExecutionTime = d
 		}
 	}
+
+	if storePath := os.Getenv("FEEDS_STORE_PATH"); storePath != "" {
+		cfg.Feeds.StorePath = storePath
+	}
+
+	if adminToken := os.Getenv("FEEDS_ADMIN_TOKEN"); adminToken != "" {
+		cfg.Feeds.AdminToken = adminToken
+	}
+
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+		cfg.Admin.Token = adminToken
+	}
+
+	if hashKey := os.Getenv("PRIVACY_HASH_KEY"); hashKey != "" {
+		cfg.Privacy.HashKey = hashKey
+	}
+
+	if level := os.Getenv("LOG_LEVEL"); level != "" {
+		cfg.Log.Level = level
+	}
+
+	if format := os.Getenv("LOG_FORMAT"); format != "" {
+		cfg.Log.Format = format
+	}
+}
+
+// applyAliases copies deprecated settings to the ones replacing them
+func applyAliases(cfg *Config) error {
+	// feeds.admin_token (FEEDS_ADMIN_TOKEN) was the saved feeds' own admin token
+	if token := cfg.Feeds.AdminToken; token != "" {
+		if cfg.Admin.Token != "" && cfg.Admin.Token != token {
+			return fmt.Errorf("feeds admin token is a deprecated alias of admin token and cannot differ from it")
+		}
+		cfg.Admin.Token = token
+	}
+	return nil
 }
 
 // validate validates the configuration
@@ -162,6 +341,14 @@ func validate(cfg *Config) error {
 		return fmt.Errorf("server base URL cannot be empty")
 	}
 
+	if cfg.Server.ShutdownTimeout < 0 || cfg.Server.ShutdownDelay < 0 {
+		return fmt.Errorf("server shutdown timeout and delay cannot be negative")
+	}
+
+	if cfg.Server.ReloadInterval < 0 {
+		return fmt.Errorf("server reload interval cannot be negative")
+	}
+
 	if cfg.Upstream.DefaultURL == "" {
 		return fmt.Errorf("upstream default URL cannot be empty")
 	}
@@ -186,14 +373,91 @@ func validate(cfg *Config) error {
 		return fmt.Errorf("cache max TTL must be positive")
 	}
 
+	if cfg.Cache.StaleGrace < 0 {
+		return fmt.Errorf("cache stale grace cannot be negative")
+	}
+
+	if cfg.Cache.CleanupInterval < 0 {
+		return fmt.Errorf("cache cleanup interval cannot be negative")
+	}
+
+	if cfg.Cache.Disk.MaxBytes < 0 {
+		return fmt.Errorf("cache disk max bytes cannot be negative")
+	}
+
+	switch cfg.Cache.Backend {
+	case "", "memory":
+	case "redis":
+		if cfg.Cache.Redis.Addr == "" {
+			return fmt.Errorf("cache redis addr is required for the redis backend")
+		}
+		if cfg.Cache.Disk.Dir != "" {
+			return fmt.Errorf("cache disk tier cannot be combined with the redis backend")
+		}
+	default:
+		return fmt.Errorf("unknown cache backend %q (use memory or redis)", cfg.Cache.Backend)
+	}
+
+	refresh := cfg.Cache.Refresh
+	if refresh.Interval < 0 || refresh.Lead < 0 || refresh.Jitter < 0 || refresh.HotWindow < 0 {
+		return fmt.Errorf("cache refresh durations cannot be negative")
+	}
+
+	if refresh.Workers < 0 || refresh.MinHits < 0 {
+		return fmt.Errorf("cache refresh workers and min hits cannot be negative")
+	}
+
 	if cfg.Upstream.Timeout <= 0 {
 		return fmt.Errorf("upstream timeout must be positive")
 	}
 
+	if cfg.Upstream.MaxBodyBytes < 0 {
+		return fmt.Errorf("upstream max body bytes cannot be negative")
+	}
+
+	for _, host := range cfg.Upstream.Allowed.Hosts {
+		if _, err := path.Match(host, ""); err != nil {
+			return fmt.Errorf("invalid allowed upstream host %q: %w", host, err)
+		}
+	}
+
+	for _, cidr := range append(append([]string{}, cfg.Upstream.AllowCIDRs...), cfg.Upstream.DenyCIDRs...) {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("invalid upstream CIDR %q: %w", cidr, err)
+		}
+	}
+
+	for name, sourceURL := range cfg.Upstream.Sources {
+		if name == "" || strings.Contains(name, ",") {
+			return fmt.Errorf("invalid upstream source name %q", name)
+		}
+		if sourceURL == "" {
+			return fmt.Errorf("upstream source %q URL cannot be empty", name)
+		}
+	}
+
 	if cfg.Regex.MaxExecutionTime <= 0 {
 		return fmt.Errorf("regex max execution time must be positive")
 	}
 
+	if cfg.Regex.MaxPatternLength < 0 || cfg.Regex.MaxFilters < 0 || cfg.Regex.MaxProgramSize < 0 {
+		return fmt.Errorf("regex limits cannot be negative")
+	}
+
+	if cfg.Feeds.MaxFeeds < 0 || cfg.Feeds.CreateLimit < 0 {
+		return fmt.Errorf("feeds max feeds and create limit cannot be negative")
+	}
+
+	if _, err := logging.ParseLevel(cfg.Log.Level); err != nil {
+		return err
+	}
+
+	switch strings.ToLower(cfg.Log.Format) {
+	case "", logging.FormatText, logging.FormatJSON:
+	default:
+		return fmt.Errorf("unknown log format %q (use text or json)", cfg.Log.Format)
+	}
+
 	// Validate filter configurations
 	if cfg.Filters.Grade.Field == "" {
 		return fmt.Errorf("grade filter field cannot be empty")
@@ -215,7 +479,56 @@ func validate(cfg *Config) error {
 		return fmt.Errorf("lodge filter must have a default pattern")
 	}
 
-	return nil
+	if err := validateTransforms(cfg); err != nil {
+		return err
+	}
+
+	return validatePrivacy(cfg)
+}
+
+// UpstreamAllowed reports whether an upstream URL may be fetched
+// Always true unless upstream.allowed is enabled.
+func (c *Config) UpstreamAllowed(upstreamURL string) bool {
+	allowed := c.Upstream.Allowed
+	if !allowed.Enabled {
+		return true
+	}
+
+	if upstreamURL == c.Upstream.DefaultURL {
+		return true
+	}
+	for _, sourceURL := range c.Upstream.Sources {
+		if upstreamURL == sourceURL {
+			return true
+		}
+	}
+	for _, u := range allowed.URLs {
+		if upstreamURL == u {
+			return true
+		}
+	}
+
+	parsed, err := url.Parse(upstreamURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	for _, pattern := range allowed.Hosts {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SourceNames returns the names of the configured upstream sources, sorted
+func (c *Config) SourceNames() []string {
+	names := make([]string, 0, len(c.Upstream.Sources))
+	for name := range c.Upstream.Sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // GetLodgePattern returns the pattern template for a given lodge name
@@ -177,13 +177,27 @@ filters:
 
 	// Set environment variables
 	testEnv := map[string]string{
-		"PORT":              "9090",
-		"DEFAULT_UPSTREAM":  "https://override.com/feed.ics",
-		"CACHE_MAX_SIZE":    "200",
-		"CACHE_DEFAULT_TTL": "10m",
-		"CACHE_MIN_OUTPUT":  "30m",
-		"UPSTREAM_TIMEOUT":  "60s",
-		"MAX_REGEX_TIME":    "2s",
+		"PORT":                    "9090",
+		"DEFAULT_UPSTREAM":        "https://override.com/feed.ics",
+		"CACHE_MAX_SIZE":          "200",
+		"CACHE_DEFAULT_TTL":       "10m",
+		"CACHE_MIN_OUTPUT":        "30m",
+		"CACHE_STALE_GRACE":       "24h",
+		"CACHE_REFRESH":           "true",
+		"CACHE_DIR":               "/var/cache/recal",
+		"REDIS_ADDR":              "redis:6379",
+		"REDIS_PASSWORD":          "hunter2",
+		"UPSTREAM_TIMEOUT":        "60s",
+		"MAX_REGEX_TIME":          "2s",
+		"FEEDS_STORE_PATH":        "/data/feeds.json",
+		"ADMIN_TOKEN":             "operator",
+		"PRIVACY_HASH_KEY":        "salt",
+		"LOG_LEVEL":               "debug",
+		"LOG_FORMAT":              "json",
+		"SHUTDOWN_TIMEOUT":        "45s",
+		"SHUTDOWN_DELAY":          "5s",
+		"CONFIG_RELOAD_INTERVAL":  "10s",
+		"UPSTREAM_MAX_BODY_BYTES": "1048576",
 	}
 
 	for k, v := range testEnv {
@@ -211,12 +225,45 @@ filters:
 	if cfg.Cache.MinOutputCache != 30*time.Minute {
 		t.Errorf("Cache.MinOutputCache = %v, want 30m (from CACHE_MIN_OUTPUT env)", cfg.Cache.MinOutputCache)
 	}
+	if cfg.Cache.StaleGrace != 24*time.Hour {
+		t.Errorf("Cache.StaleGrace = %v, want 24h (from CACHE_STALE_GRACE env)", cfg.Cache.StaleGrace)
+	}
+	if !cfg.Cache.Refresh.Enabled {
+		t.Error("Cache.Refresh.Enabled = false, want true (from CACHE_REFRESH env)")
+	}
+	if cfg.Cache.Disk.Dir != "/var/cache/recal" {
+		t.Errorf("Cache.Disk.Dir = %q, want /var/cache/recal (from CACHE_DIR env)", cfg.Cache.Disk.Dir)
+	}
+	if cfg.Cache.Redis.Addr != "redis:6379" || cfg.Cache.Redis.Password != "hunter2" {
+		t.Errorf("Cache.Redis = %+v, want addr and password from REDIS_ADDR/REDIS_PASSWORD env", cfg.Cache.Redis)
+	}
 	if cfg.Upstream.Timeout != 60*time.Second {
 		t.Errorf("Upstream.Timeout = %v, want 60s (from UPSTREAM_TIMEOUT env)", cfg.Upstream.Timeout)
 	}
 	if cfg.Regex.MaxExecutionTime != 2*time.Second {
 		t.Errorf("Regex.MaxExecutionTime = %v, want 2s (from MAX_REGEX_TIME env)", cfg.Regex.MaxExecutionTime)
 	}
+	if cfg.Upstream.MaxBodyBytes != 1048576 {
+		t.Errorf("Upstream.MaxBodyBytes = %d, want 1048576 (from UPSTREAM_MAX_BODY_BYTES env)", cfg.Upstream.MaxBodyBytes)
+	}
+	if cfg.Feeds.StorePath != "/data/feeds.json" {
+		t.Errorf("Feeds.StorePath = %q, want /data/feeds.json (from FEEDS_STORE_PATH env)", cfg.Feeds.StorePath)
+	}
+	if cfg.Admin.Token != "operator" {
+		t.Errorf("Admin.Token = %q, want operator (from ADMIN_TOKEN env)", cfg.Admin.Token)
+	}
+	if cfg.Privacy.HashKey != "salt" {
+		t.Errorf("Privacy.HashKey = %q, want salt (from PRIVACY_HASH_KEY env)", cfg.Privacy.HashKey)
+	}
+	if cfg.Server.ShutdownTimeout != 45*time.Second || cfg.Server.ShutdownDelay != 5*time.Second {
+		t.Errorf("Server shutdown = %v/%v, want 45s/5s (from SHUTDOWN_TIMEOUT/SHUTDOWN_DELAY env)", cfg.Server.ShutdownTimeout, cfg.Server.ShutdownDelay)
+	}
+	if cfg.Server.ReloadInterval != 10*time.Second {
+		t.Errorf("Server.ReloadInterval = %v, want 10s (from CONFIG_RELOAD_INTERVAL env)", cfg.Server.ReloadInterval)
+	}
+	if cfg.Log.Level != "debug" || cfg.Log.Format != "json" {
+		t.Errorf("Log = %+v, want debug and json (from LOG_LEVEL/LOG_FORMAT env)", cfg.Log)
+	}
 }
 
 // TestValidation tests configuration validation
@@ -489,6 +536,247 @@ filters:
 `,
 			errContains: "cache max TTL must be positive",
 		},
+		{
+			name: "unknown log level",
+			config: `
+server:
+  port: 8080
+  read_timeout: 15s
+  write_timeout: 15s
+  idle_timeout: 60s
+  base_url: "http://localhost:8080"
+upstream:
+  default_url: "https://example.com/calendar.ics"
+  timeout: 30s
+cache:
+  max_size: 100
+  max_memory: 20971520
+  default_ttl: 5m
+  min_output_cache: 15m
+  max_ttl: 24h
+regex:
+  max_execution_time: 1s
+log:
+  level: verbose
+filters:
+  grade:
+    field: "SUMMARY"
+    pattern_template: "Grade: [%s]"
+  lodge:
+    field: "SUMMARY"
+    patterns:
+      default:
+        template: "%s PB"
+  confirmed_only:
+    field: "STATUS"
+    pattern: "CONFIRMED"
+  installt:
+    field: "SUMMARY"
+    pattern: "INSTÄLLT"
+`,
+			errContains: "unknown log level",
+		},
+		{
+			name: "negative regex limit",
+			config: `
+server:
+  port: 8080
+  read_timeout: 15s
+  write_timeout: 15s
+  idle_timeout: 60s
+  base_url: "http://localhost:8080"
+upstream:
+  default_url: "https://example.com/calendar.ics"
+  timeout: 30s
+cache:
+  max_size: 100
+  max_memory: 20971520
+  default_ttl: 5m
+  min_output_cache: 15m
+  max_ttl: 24h
+regex:
+  max_execution_time: 1s
+  max_filters: -1
+filters:
+  grade:
+    field: "SUMMARY"
+    pattern_template: "Grade: [%s]"
+  lodge:
+    field: "SUMMARY"
+    patterns:
+      default:
+        template: "%s PB"
+`,
+			errContains: "regex limits cannot be negative",
+		},
+		{
+			name: "negative feed limit",
+			config: `
+server:
+  port: 8080
+  base_url: "http://localhost:8080"
+upstream:
+  default_url: "https://example.com/calendar.ics"
+  timeout: 30s
+cache:
+  max_size: 100
+  max_memory: 20971520
+  default_ttl: 5m
+  min_output_cache: 15m
+  max_ttl: 24h
+regex:
+  max_execution_time: 1s
+feeds:
+  max_feeds: -1
+filters:
+  grade:
+    field: "SUMMARY"
+    pattern_template: "Grade: [%s]"
+  lodge:
+    field: "SUMMARY"
+    patterns:
+      default:
+        template: "%s PB"
+`,
+			errContains: "feeds max feeds and create limit cannot be negative",
+		},
+		{
+			name: "invalid transform rule",
+			config: `
+server:
+  port: 8080
+  base_url: "http://localhost:8080"
+upstream:
+  default_url: "https://example.com/calendar.ics"
+  timeout: 30s
+cache:
+  max_size: 100
+  max_memory: 20971520
+  default_ttl: 5m
+  min_output_cache: 15m
+  max_ttl: 24h
+regex:
+  max_execution_time: 1s
+filters:
+  grade:
+    field: "SUMMARY"
+    pattern_template: "Grade: [%s]"
+  lodge:
+    field: "SUMMARY"
+    patterns:
+      default:
+        template: "%s PB"
+transforms:
+  presets:
+    kort:
+      - type: replace
+        field: SUMMARY
+        pattern: "^Göta PB: "
+      - type: set
+        field: DTSTART
+        value: "20250101"
+`,
+			errContains: `transform preset "kort" rule 2: DTSTART cannot be transformed`,
+		},
+		{
+			name: "privacy profile named like a boolean",
+			config: `
+server:
+  port: 8080
+  base_url: "http://localhost:8080"
+upstream:
+  default_url: "https://example.com/calendar.ics"
+  timeout: 30s
+cache:
+  max_size: 100
+  max_memory: 20971520
+  default_ttl: 5m
+  min_output_cache: 15m
+  max_ttl: 24h
+regex:
+  max_execution_time: 1s
+filters:
+  grade:
+    field: "SUMMARY"
+    pattern_template: "Grade: [%s]"
+  lodge:
+    field: "SUMMARY"
+    patterns:
+      default:
+        template: "%s PB"
+privacy:
+  profiles:
+    "true":
+      minimal: true
+`,
+			errContains: `invalid privacy profile name "true"`,
+		},
+		{
+			name: "hashed UIDs without a key",
+			config: `
+server:
+  port: 8080
+  base_url: "http://localhost:8080"
+upstream:
+  default_url: "https://example.com/calendar.ics"
+  timeout: 30s
+cache:
+  max_size: 100
+  max_memory: 20971520
+  default_ttl: 5m
+  min_output_cache: 15m
+  max_ttl: 24h
+regex:
+  max_execution_time: 1s
+filters:
+  grade:
+    field: "SUMMARY"
+    pattern_template: "Grade: [%s]"
+  lodge:
+    field: "SUMMARY"
+    patterns:
+      default:
+        template: "%s PB"
+privacy:
+  profiles:
+    publik:
+      hash_uid: true
+`,
+			errContains: `privacy profile "publik" sets hash_uid but privacy hash key is empty`,
+		},
+		{
+			name: "conflicting admin tokens",
+			config: `
+server:
+  port: 8080
+  base_url: "http://localhost:8080"
+upstream:
+  default_url: "https://example.com/calendar.ics"
+  timeout: 30s
+cache:
+  max_size: 100
+  max_memory: 20971520
+  default_ttl: 5m
+  min_output_cache: 15m
+  max_ttl: 24h
+regex:
+  max_execution_time: 1s
+filters:
+  grade:
+    field: "SUMMARY"
+    pattern_template: "Grade: [%s]"
+  lodge:
+    field: "SUMMARY"
+    patterns:
+      default:
+        template: "%s PB"
+feeds:
+  admin_token: "old"
+admin:
+  token: "new"
+`,
+			errContains: "feeds admin token is a deprecated alias of admin token",
+		},
 	}
 
 	for _, tt := range tests {
@@ -510,6 +798,58 @@ filters:
 	}
 }
 
+// TestAdminTokenAlias tests the deprecated feeds admin token
+// Validates: feeds.admin_token and FEEDS_ADMIN_TOKEN fill in admin.token
+func TestAdminTokenAlias(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	config := `
+server:
+  port: 8080
+  base_url: "http://localhost:8080"
+upstream:
+  default_url: "https://example.com/calendar.ics"
+  timeout: 30s
+cache:
+  max_size: 100
+  max_memory: 20971520
+  default_ttl: 5m
+  min_output_cache: 15m
+  max_ttl: 24h
+regex:
+  max_execution_time: 1s
+filters:
+  grade:
+    field: "SUMMARY"
+    pattern_template: "Grade: [%s]"
+  lodge:
+    field: "SUMMARY"
+    patterns:
+      default:
+        template: "%s PB"
+feeds:
+  admin_token: "old"
+`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to create temp config file: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if cfg.Admin.Token != "old" {
+		t.Errorf("Admin.Token = %q, want old (from feeds.admin_token)", cfg.Admin.Token)
+	}
+
+	t.Setenv("FEEDS_ADMIN_TOKEN", "s3cret")
+	if cfg, err = Load(configPath); err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if cfg.Admin.Token != "s3cret" {
+		t.Errorf("Admin.Token = %q, want s3cret (from FEEDS_ADMIN_TOKEN env)", cfg.Admin.Token)
+	}
+}
+
 // TestGetLodgePattern tests the GetLodgePattern method
 // Validates: Pattern lookup, default fallback, special cases
 func TestGetLodgePattern(t *testing.T) {
@@ -617,3 +957,45 @@ func containsHelper(s, substr string) bool {
 	}
 	return false
 }
+
+// TestUpstreamAllowed tests the upstream allowlist
+// Validates: Disabled by default, default URL and sources implicit, exact URLs, host globs
+func TestUpstreamAllowed(t *testing.T) {
+	cfg := &Config{
+		Upstream: UpstreamConfig{
+			DefaultURL: "https://default.example.com/cal.ics",
+			Sources:    map[string]string{"gota": "https://gota.example.com/cal.ics"},
+		},
+	}
+
+	if !cfg.UpstreamAllowed("https://anything.example.net/cal.ics") {
+		t.Error("Everything should be allowed while the allowlist is disabled")
+	}
+
+	cfg.Upstream.Allowed = AllowedConfig{
+		Enabled: true,
+		URLs:    []string{"https://exact.example.com/cal.ics"},
+		Hosts:   []string{"*.google.com", "Ical.Example.org"},
+	}
+
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://default.example.com/cal.ics", true},
+		{"https://gota.example.com/cal.ics", true},
+		{"https://exact.example.com/cal.ics", true},
+		{"https://exact.example.com/other.ics", false},
+		{"https://calendar.google.com/calendar/ical/x/basic.ics", true},
+		{"https://google.com.evil.net/cal.ics", false},
+		{"http://ical.example.org:8080/feed", true},
+		{"https://evil.example.com/cal.ics", false},
+		{"::not a url", false},
+	}
+
+	for _, tt := range tests {
+		if got := cfg.UpstreamAllowed(tt.url); got != tt.want {
+			t.Errorf("UpstreamAllowed(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PrivacyConfig holds the anonymized busy/free output mode (privacy=)
+type PrivacyConfig struct {
+	Placeholder string                    `yaml:"placeholder"` // SUMMARY of anonymized events ("Busy" if empty)
+	HashKey     string                    `yaml:"hash_key"`    // Secret for hashed UIDs, required by hash_uid; without it anyone could hash a known UID and match it
+	Profiles    map[string]PrivacyProfile `yaml:"profiles"`    // Named settings, selectable with privacy=name
+}
+
+// PrivacyProfile holds the settings of one anonymized output
+type PrivacyProfile struct {
+	Placeholder string `yaml:"placeholder"` // SUMMARY replacement, privacy.placeholder if empty
+	RoundHours  bool   `yaml:"round_hours"` // Widen start and end to whole hours
+	Minimal     bool   `yaml:"minimal"`     // Keep only UID, DTSTART and DTEND (and what iCalendar requires)
+	HashUID     bool   `yaml:"hash_uid"`    // Replace UIDs with a keyed hash
+}
+
+// Merge returns the profile with the options of other added
+// Boolean options are combined so a request can only make the output more private.
+func (p PrivacyProfile) Merge(other PrivacyProfile) PrivacyProfile {
+	if other.Placeholder != "" {
+		p.Placeholder = other.Placeholder
+	}
+	p.RoundHours = p.RoundHours || other.RoundHours
+	p.Minimal = p.Minimal || other.Minimal
+	p.HashUID = p.HashUID || other.HashUID
+	return p
+}
+
+// String renders the profile for debug output and cache keys
+func (p PrivacyProfile) String() string {
+	parts := []string{fmt.Sprintf("placeholder=%q", p.Placeholder)}
+	if p.RoundHours {
+		parts = append(parts, "round_hours")
+	}
+	if p.Minimal {
+		parts = append(parts, "minimal")
+	}
+	if p.HashUID {
+		parts = append(parts, "hash_uid")
+	}
+	return strings.Join(parts, " ")
+}
+
+// validatePrivacy checks the privacy profile names and that hashed UIDs have a key
+// Names that read as booleans are reserved for turning the default profile on and off.
+func validatePrivacy(cfg *Config) error {
+	for name, profile := range cfg.Privacy.Profiles {
+		if _, err := strconv.ParseBool(name); err == nil || name == "" {
+			return fmt.Errorf("invalid privacy profile name %q", name)
+		}
+		if profile.HashUID && cfg.Privacy.HashKey == "" {
+			return fmt.Errorf("privacy profile %q sets hash_uid but privacy hash key is empty", name)
+		}
+	}
+	return nil
+}
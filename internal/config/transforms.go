@@ -0,0 +1,155 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Transform rule types
+const (
+	TransformSet     = "set"     // Set a property or parameter to value
+	TransformReplace = "replace" // Replace pattern matches in a property or parameter with value
+	TransformAppend  = "append"  // Append value to a property, or add it to a list like CATEGORIES
+	TransformRemove  = "remove"  // Remove a property or parameter, or only the values matching pattern
+	TransformAlarm   = "alarm"   // Add a VALARM reminder before the event starts
+)
+
+// TransformsConfig holds the event rewriting rules applied after filtering
+type TransformsConfig struct {
+	Presets map[string][]TransformRule `yaml:"presets"` // Named rule lists, selectable with transform=name
+}
+
+// TransformRule describes one rewrite of an event, see internal/transform
+// Pattern selects the text to change for replace and remove. For set, append and
+// alarm it is a condition on source instead, and value may refer to its submatches
+// ($1, ${name}).
+type TransformRule struct {
+	Type      string        `yaml:"type"`       // set, replace, append, remove or alarm
+	Field     string        `yaml:"field"`      // Property to change; PROP;PARAM addresses a parameter
+	Value     string        `yaml:"value"`      // New text, or the alarm description (event summary if empty)
+	Pattern   string        `yaml:"pattern"`    // Regex, see above
+	Source    string        `yaml:"source"`     // Property pattern is matched against (field, or SUMMARY for alarms)
+	IfMissing bool          `yaml:"if_missing"` // set: only if the property has no value yet
+	Before    time.Duration `yaml:"before"`     // alarm: how long before the start the reminder fires
+}
+
+// transformFieldName matches a property or parameter name
+var transformFieldName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*$`)
+
+// protectedProps identify an event and its recurrence, are required exactly once
+// (DTSTAMP) or delimit components (BEGIN, END), so transforms may not change them
+var protectedProps = map[string]bool{
+	"BEGIN":         true,
+	"END":           true,
+	"UID":           true,
+	"DTSTAMP":       true,
+	"DTSTART":       true,
+	"DTEND":         true,
+	"DURATION":      true,
+	"RECURRENCE-ID": true,
+	"RRULE":         true,
+	"RDATE":         true,
+	"EXDATE":        true,
+}
+
+// Validate checks that the rule is complete and only uses options of its type
+// The pattern is not compiled here.
+func (r TransformRule) Validate() error {
+	name, param, hasParam := strings.Cut(r.Field, ";")
+
+	switch r.Type {
+	case TransformSet, TransformReplace, TransformRemove:
+	case TransformAppend:
+		if hasParam {
+			return fmt.Errorf("append cannot change a parameter (%s)", r.Field)
+		}
+	case TransformAlarm:
+		if r.Field != "" {
+			return fmt.Errorf("alarm rules take no field")
+		}
+		if r.Before < 0 {
+			return fmt.Errorf("alarm before cannot be negative")
+		}
+	default:
+		return fmt.Errorf("unknown transform type %q (use set, replace, append, remove or alarm)", r.Type)
+	}
+
+	if r.Type != TransformAlarm {
+		if !transformFieldName.MatchString(name) || (hasParam && !transformFieldName.MatchString(param)) {
+			return fmt.Errorf("invalid transform field %q", r.Field)
+		}
+		if protectedProps[strings.ToUpper(name)] {
+			return fmt.Errorf("%s cannot be transformed", strings.ToUpper(name))
+		}
+	}
+
+	if r.Type == TransformReplace && r.Pattern == "" {
+		return fmt.Errorf("replace needs a pattern")
+	}
+	// Replacements are inserted as written and parameter values cannot be escaped
+	if (r.Type == TransformReplace || hasParam) && strings.ContainsAny(r.Value, "\r\n") {
+		return fmt.Errorf("%s value for %s cannot contain line breaks", r.Type, strings.ToUpper(r.Field))
+	}
+	if hasParam && strings.Contains(r.Value, `"`) {
+		return fmt.Errorf("parameter value for %s cannot contain double quotes", strings.ToUpper(r.Field))
+	}
+	if r.Source != "" {
+		if r.Type == TransformReplace || r.Type == TransformRemove {
+			return fmt.Errorf("source only applies to set, append and alarm rules")
+		}
+		if r.Pattern == "" {
+			return fmt.Errorf("source needs a pattern")
+		}
+	}
+	if r.IfMissing && r.Type != TransformSet {
+		return fmt.Errorf("if_missing only applies to set rules")
+	}
+	if r.Before != 0 && r.Type != TransformAlarm {
+		return fmt.Errorf("before only applies to alarm rules")
+	}
+	return nil
+}
+
+// String renders the rule for debug output and cache keys
+func (r TransformRule) String() string {
+	parts := []string{r.Type}
+	if r.Field != "" {
+		parts = append(parts, strings.ToUpper(r.Field))
+	}
+	if r.Pattern != "" {
+		parts = append(parts, fmt.Sprintf("pattern=%q", r.Pattern))
+	}
+	if r.Source != "" {
+		parts = append(parts, "source="+strings.ToUpper(r.Source))
+	}
+	if r.Value != "" || r.Type == TransformSet || r.Type == TransformReplace {
+		parts = append(parts, fmt.Sprintf("value=%q", r.Value))
+	}
+	if r.IfMissing {
+		parts = append(parts, "if_missing")
+	}
+	if r.Type == TransformAlarm {
+		parts = append(parts, "before="+r.Before.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// validateTransforms checks the transform presets, compiling their patterns
+func validateTransforms(cfg *Config) error {
+	for name, rules := range cfg.Transforms.Presets {
+		if name == "" || strings.Contains(name, ",") {
+			return fmt.Errorf("invalid transform preset name %q", name)
+		}
+		for i, rule := range rules {
+			if err := rule.Validate(); err != nil {
+				return fmt.Errorf("transform preset %q rule %d: %w", name, i+1, err)
+			}
+			if _, err := regexp.Compile(rule.Pattern); err != nil {
+				return fmt.Errorf("transform preset %q rule %d: invalid pattern: %w", name, i+1, err)
+			}
+		}
+	}
+	return nil
+}
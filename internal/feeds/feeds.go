@@ -0,0 +1,274 @@
+package feeds
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Errors returned by Store operations
+var (
+	ErrNotFound    = errors.New("feed not found")
+	ErrExists      = errors.New("feed already exists")
+	ErrInvalidSlug = errors.New("invalid slug: use 1-64 lowercase letters, digits and dashes")
+	ErrForbidden   = errors.New("invalid or missing feed token")
+	ErrFull        = errors.New("saved feed limit reached")
+)
+
+// slugPattern restricts slugs to URL-safe, case-insensitive-safe names
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// Feed is a saved filter definition reachable at /f/{slug}
+type Feed struct {
+	Slug      string    `json:"slug"`
+	Query     string    `json:"query"`      // URL query string with the filter parameters
+	TokenHash string    `json:"token_hash"` // SHA-256 of the edit token (the token itself is never stored)
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Store is a thread-safe collection of saved feeds persisted as a JSON file
+// With an empty path the store is memory-only and lost on restart.
+type Store struct {
+	mu    sync.RWMutex
+	path  string
+	feeds map[string]*Feed
+}
+
+// NewStore creates a store backed by the JSON file at path, loading it if it exists
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		path:  path,
+		feeds: make(map[string]*Feed),
+	}
+
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feed store: %w", err)
+	}
+
+	var feeds []*Feed
+	if err := json.Unmarshal(data, &feeds); err != nil {
+		return nil, fmt.Errorf("failed to parse feed store %s: %w", path, err)
+	}
+	for _, f := range feeds {
+		s.feeds[f.Slug] = f
+	}
+
+	return s, nil
+}
+
+// ValidateSlug checks that a slug is usable in a /f/{slug} URL
+func ValidateSlug(slug string) error {
+	if !slugPattern.MatchString(slug) {
+		return ErrInvalidSlug
+	}
+	return nil
+}
+
+// Get returns a copy of the feed with the given slug
+func (s *Store) Get(slug string) (Feed, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	f, ok := s.feeds[slug]
+	if !ok {
+		return Feed{}, false
+	}
+	return *f, true
+}
+
+// List returns copies of all feeds sorted by slug
+func (s *Store) List() []Feed {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]Feed, 0, len(s.feeds))
+	for _, f := range s.feeds {
+		list = append(list, *f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Slug < list[j].Slug })
+	return list
+}
+
+// Create saves a new feed and returns it with its edit token
+// If slug is empty a random one is generated. Fails with ErrFull if the store
+// already holds limit feeds; a limit of 0 means no limit.
+func (s *Store) Create(slug, query string, limit int) (Feed, string, error) {
+	token, err := randomHex(16)
+	if err != nil {
+		return Feed{}, "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if limit > 0 && len(s.feeds) >= limit {
+		return Feed{}, "", ErrFull
+	}
+
+	if slug == "" {
+		for {
+			generated, err := randomHex(4)
+			if err != nil {
+				return Feed{}, "", err
+			}
+			if _, taken := s.feeds[generated]; !taken {
+				slug = generated
+				break
+			}
+		}
+	}
+
+	if err := ValidateSlug(slug); err != nil {
+		return Feed{}, "", err
+	}
+	if _, exists := s.feeds[slug]; exists {
+		return Feed{}, "", ErrExists
+	}
+
+	now := time.Now().UTC()
+	f := &Feed{
+		Slug:      slug,
+		Query:     query,
+		TokenHash: hashToken(token),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	s.feeds[slug] = f
+
+	if err := s.save(); err != nil {
+		delete(s.feeds, slug)
+		return Feed{}, "", err
+	}
+	return *f, token, nil
+}
+
+// Update replaces the query of an existing feed
+// The token must match the feed's edit token unless admin is true.
+func (s *Store) Update(slug, query, token string, admin bool) (Feed, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.feeds[slug]
+	if !ok {
+		return Feed{}, ErrNotFound
+	}
+	if !admin && !f.checkToken(token) {
+		return Feed{}, ErrForbidden
+	}
+
+	previous := *f
+	f.Query = query
+	f.UpdatedAt = time.Now().UTC()
+
+	if err := s.save(); err != nil {
+		*f = previous
+		return Feed{}, err
+	}
+	return *f, nil
+}
+
+// Delete removes a feed
+// The token must match the feed's edit token unless admin is true.
+func (s *Store) Delete(slug, token string, admin bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.feeds[slug]
+	if !ok {
+		return ErrNotFound
+	}
+	if !admin && !f.checkToken(token) {
+		return ErrForbidden
+	}
+
+	delete(s.feeds, slug)
+	if err := s.save(); err != nil {
+		s.feeds[slug] = f
+		return err
+	}
+	return nil
+}
+
+// save writes all feeds to disk atomically (temp file + rename)
+// Must be called with lock held
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	list := make([]*Feed, 0, len(s.feeds))
+	for _, f := range s.feeds {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Slug < list[j].Slug })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode feed store: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".feeds-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to write feed store: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write feed store: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write feed store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write feed store: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write feed store: %w", err)
+	}
+	return nil
+}
+
+// checkToken compares a presented edit token with the stored hash in constant time
+func (f *Feed) checkToken(token string) bool {
+	if token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(f.TokenHash)) == 1
+}
+
+// hashToken returns the hex SHA-256 of an edit token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex returns n random bytes as a hex string
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random value: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
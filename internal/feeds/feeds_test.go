@@ -0,0 +1,115 @@
+package feeds
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestStoreLifecycle tests create, update and delete with edit tokens
+// Validates: Token required for changes, admin override, not-found and duplicate errors
+func TestStoreLifecycle(t *testing.T) {
+	s, err := NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() failed: %v", err)
+	}
+
+	f, token, err := s.Create("mina-loger", "Grad=3", 0)
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	if token == "" || f.TokenHash == "" || f.TokenHash == token {
+		t.Error("Create() should return a token and store only its hash")
+	}
+
+	if _, _, err := s.Create("mina-loger", "Grad=4", 0); !errors.Is(err, ErrExists) {
+		t.Errorf("Duplicate Create() error = %v, want ErrExists", err)
+	}
+
+	if _, err := s.Update("mina-loger", "Grad=4", "wrong", false); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Update() with wrong token error = %v, want ErrForbidden", err)
+	}
+	if _, err := s.Update("mina-loger", "Grad=4", token, false); err != nil {
+		t.Fatalf("Update() with token failed: %v", err)
+	}
+	if got, _ := s.Get("mina-loger"); got.Query != "Grad=4" {
+		t.Errorf("Query = %q, want Grad=4", got.Query)
+	}
+	if _, err := s.Update("mina-loger", "Grad=5", "", true); err != nil {
+		t.Errorf("Admin Update() failed: %v", err)
+	}
+
+	if err := s.Delete("mina-loger", "", false); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Delete() without token error = %v, want ErrForbidden", err)
+	}
+	if err := s.Delete("mina-loger", token, false); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	if _, ok := s.Get("mina-loger"); ok {
+		t.Error("Feed still present after Delete()")
+	}
+	if _, err := s.Update("mina-loger", "Grad=1", token, false); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update() of deleted feed error = %v, want ErrNotFound", err)
+	}
+}
+
+// TestStoreGeneratedSlug tests random slugs, slug validation and the feed limit
+func TestStoreGeneratedSlug(t *testing.T) {
+	s, _ := NewStore("")
+
+	f, _, err := s.Create("", "Grad=3", 0)
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	if err := ValidateSlug(f.Slug); err != nil {
+		t.Errorf("Generated slug %q is invalid: %v", f.Slug, err)
+	}
+
+	for _, slug := range []string{"Upper", "-leading", "with space", "dot.ics", "a/b"} {
+		if _, _, err := s.Create(slug, "", 0); !errors.Is(err, ErrInvalidSlug) {
+			t.Errorf("Create(%q) error = %v, want ErrInvalidSlug", slug, err)
+		}
+	}
+
+	if _, _, err := s.Create("", "Grad=4", 1); !errors.Is(err, ErrFull) {
+		t.Errorf("Create() beyond the limit error = %v, want ErrFull", err)
+	}
+	if _, _, err := s.Create("", "Grad=4", 2); err != nil {
+		t.Errorf("Create() within the limit failed: %v", err)
+	}
+}
+
+// TestStorePersistence tests that feeds survive reopening the store file
+func TestStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feeds.json")
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() failed: %v", err)
+	}
+	_, token, err := s.Create("goteborg", "Loge=Borås", 0)
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	reopened, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("Reopening store failed: %v", err)
+	}
+	f, ok := reopened.Get("goteborg")
+	if !ok || f.Query != "Loge=Borås" {
+		t.Fatalf("Reopened store has %+v, %v; want the saved feed", f, ok)
+	}
+	if _, err := reopened.Update("goteborg", "Loge=Göta", token, false); err != nil {
+		t.Errorf("Edit token not valid after reopen: %v", err)
+	}
+
+	// A corrupt file is an error, not an empty store
+	if err := os.WriteFile(path, []byte("{not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewStore(path); err == nil {
+		t.Error("NewStore() should fail on a corrupt file")
+	}
+}
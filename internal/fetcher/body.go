@@ -0,0 +1,139 @@
+package fetcher
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxBodyBytes is the response size limit used when upstream.max_body_bytes is not set
+const DefaultMaxBodyBytes = 10 * 1024 * 1024
+
+// Errors returned when an upstream response is rejected
+var (
+	ErrBodyTooLarge = errors.New("upstream response too large")
+	ErrNotCalendar  = errors.New("upstream response is not an iCal feed")
+)
+
+// maxBodyBytes returns the configured response size limit
+func (f *Fetcher) maxBodyBytes() int64 {
+	if limit := f.cfg.Load().Upstream.MaxBodyBytes; limit > 0 {
+		return limit
+	}
+	return DefaultMaxBodyBytes
+}
+
+// readBody reads, decompresses and validates an upstream response body
+// The size limit applies both to the bytes on the wire and to the decompressed
+// body, so neither a huge response nor a decompression bomb can exhaust memory.
+// HTML error pages and anything not starting with BEGIN:VCALENDAR are rejected.
+func (f *Fetcher) readBody(resp *http.Response) ([]byte, error) {
+	limit := f.maxBodyBytes()
+
+	if resp.ContentLength > limit {
+		return nil, fmt.Errorf("%w: %d bytes exceeds limit of %d bytes", ErrBodyTooLarge, resp.ContentLength, limit)
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		if mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+			return nil, fmt.Errorf("%w: got content type %s", ErrNotCalendar, mediaType)
+		}
+	}
+
+	var body io.Reader = &limitedReader{r: resp.Body, remaining: limit}
+	switch encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress response: %w", err)
+		}
+		defer func() { _ = gz.Close() }()
+		body = gz
+	case "deflate":
+		deflated, err := newDeflateReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress response: %w", err)
+		}
+		defer func() { _ = deflated.Close() }()
+		body = deflated
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+
+	data, err := io.ReadAll(&limitedReader{r: body, remaining: limit})
+	if errors.Is(err, ErrBodyTooLarge) {
+		return nil, fmt.Errorf("%w: limit is %d bytes", ErrBodyTooLarge, limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if !looksLikeCalendar(data) {
+		return nil, fmt.Errorf("%w: body does not start with BEGIN:VCALENDAR", ErrNotCalendar)
+	}
+
+	return data, nil
+}
+
+// looksLikeCalendar reports whether data starts with BEGIN:VCALENDAR,
+// ignoring a UTF-8 byte order mark, leading whitespace and case
+func looksLikeCalendar(data []byte) bool {
+	const begin = "BEGIN:VCALENDAR"
+
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	data = bytes.TrimLeft(data, " \t\r\n")
+	if len(data) < len(begin) {
+		return false
+	}
+	return strings.EqualFold(string(data[:len(begin)]), begin)
+}
+
+// newDeflateReader decompresses an HTTP "deflate" body
+// The standard says zlib-wrapped, but some servers send raw DEFLATE, so the zlib
+// header is detected rather than assumed.
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+
+	// zlib header: compression method 8 and a header checksum divisible by 31
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// limitedReader is like io.LimitReader but fails with ErrBodyTooLarge instead of
+// silently truncating when more than remaining bytes are available
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+// Read implements io.Reader
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, ErrBodyTooLarge
+	}
+	// Read one byte past the limit to detect oversized bodies
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, ErrBodyTooLarge
+	}
+	return n, err
+}
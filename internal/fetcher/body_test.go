@@ -0,0 +1,120 @@
+package fetcher
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testCalendar = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nEND:VCALENDAR\r\n"
+
+// compress encodes data with the given HTTP content encoding
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	var w interface {
+		Write([]byte) (int, error)
+		Close() error
+	}
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestFetchBodyValidation tests size limits, decompression and content sniffing
+// Validates: Oversized and bomb bodies rejected, gzip/deflate decoded, HTML rejected
+func TestFetchBodyValidation(t *testing.T) {
+	bomb := testCalendar + strings.Repeat("X", 10000)
+
+	tests := []struct {
+		name        string
+		contentType string
+		encoding    string
+		body        []byte
+		wantErr     error
+		wantBody    string
+	}{
+		{name: "plain calendar", contentType: "text/calendar", body: []byte(testCalendar), wantBody: testCalendar},
+		{name: "BOM and whitespace", body: []byte("\xef\xbb\xbf\r\n" + testCalendar), wantBody: "\xef\xbb\xbf\r\n" + testCalendar},
+		{name: "gzip", encoding: "gzip", body: compress(t, "gzip", []byte(testCalendar)), wantBody: testCalendar},
+		{name: "zlib deflate", encoding: "deflate", body: compress(t, "deflate", []byte(testCalendar)), wantBody: testCalendar},
+		{name: "raw deflate", encoding: "deflate", body: compress(t, "raw-deflate", []byte(testCalendar)), wantBody: testCalendar},
+		{name: "too large", body: []byte(testCalendar + strings.Repeat("X", 2000)), wantErr: ErrBodyTooLarge},
+		{name: "decompression bomb", encoding: "gzip", body: compress(t, "gzip", []byte(bomb)), wantErr: ErrBodyTooLarge},
+		{name: "HTML content type", contentType: "text/html; charset=utf-8", body: []byte(testCalendar), wantErr: ErrNotCalendar},
+		{name: "HTML error page", contentType: "text/calendar", body: []byte("<!DOCTYPE html><html>Oops</html>"), wantErr: ErrNotCalendar},
+		{name: "empty body", body: nil, wantErr: ErrNotCalendar},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("Accept-Encoding"); got != "gzip, deflate" {
+					t.Errorf("Accept-Encoding = %q, want gzip, deflate", got)
+				}
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				_, _ = w.Write(tt.body)
+			}))
+			defer server.Close()
+
+			cfg := getTestConfig()
+			cfg.Upstream.MaxBodyBytes = 1024
+			resp, err := NewTestFetcher(cfg).Fetch(context.Background(), server.URL)
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Fetch() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Fetch() failed: %v", err)
+			}
+			if string(resp.Body) != tt.wantBody {
+				t.Errorf("Body = %q, want %q", resp.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+// TestFetchCompressionDisabled tests that upstream.disable_compression requests identity encoding
+func TestFetchCompressionDisabled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Accept-Encoding"); got != "identity" {
+			t.Errorf("Accept-Encoding = %q, want identity", got)
+		}
+		_, _ = w.Write([]byte(testCalendar))
+	}))
+	defer server.Close()
+
+	cfg := getTestConfig()
+	cfg.Upstream.DisableCompression = true
+	if _, err := NewTestFetcher(cfg).Fetch(context.Background(), server.URL); err != nil {
+		t.Fatalf("Fetch() failed: %v", err)
+	}
+}
@@ -2,15 +2,30 @@ package fetcher
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"sync/atomic"
 	"time"
 
 	"github.com/linus/recal/internal/config"
 )
 
+// ErrUpstreamNotAllowed is returned for URLs outside the configured upstream allowlist
+var ErrUpstreamNotAllowed = errors.New("upstream not allowed")
+
+// StatusError is returned when an upstream answers with a status other than 200 or 304
+type StatusError struct {
+	Code int
+}
+
+// Error implements the error interface
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.Code)
+}
+
 // Response represents an HTTP response with caching metadata
 type Response struct {
 	Body         []byte
@@ -24,26 +39,48 @@ type Response struct {
 // Fetcher fetches upstream iCal feeds with HTTP cache support
 type Fetcher struct {
 	client            *http.Client
-	cfg               *config.Config
+	cfg               atomic.Pointer[config.Config] // Replaced on config reload, see SetConfig
+	policy            *ipPolicy
 	disableSSRFChecks bool // For testing only
 }
 
 // NewFetcher creates a new fetcher
+// Every connection is checked against the SSRF policy after DNS resolution, so
+// hostnames resolving to internal addresses and redirects to them are blocked too.
 func NewFetcher(cfg *config.Config) *Fetcher {
-	return &Fetcher{
-		client: &http.Client{
-			Timeout: cfg.Upstream.Timeout,
-			CheckRedirect: func(req *http.Request, via []*http.Request) error {
-				// Limit redirects to prevent redirect loops
-				if len(via) >= 10 {
-					return fmt.Errorf("too many redirects")
-				}
-				return nil
-			},
-		},
-		cfg:               cfg,
+	f := &Fetcher{
+		policy:            newIPPolicy(cfg.Upstream.AllowCIDRs, cfg.Upstream.DenyCIDRs),
 		disableSSRFChecks: false,
 	}
+	f.cfg.Store(cfg)
+
+	dialer := &net.Dialer{
+		Timeout:   cfg.Upstream.Timeout,
+		KeepAlive: 30 * time.Second,
+		Control:   f.policy.dialControl(func() bool { return f.disableSSRFChecks }),
+	}
+
+	// No proxy: the dialed address must be the upstream itself for the check to mean anything.
+	// Decompression is done by readBody so its size limit covers the decompressed body.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	transport.DisableCompression = true
+
+	f.client = &http.Client{
+		Timeout:   cfg.Upstream.Timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// Limit redirects to prevent redirect loops
+			if len(via) >= 10 {
+				return fmt.Errorf("too many redirects")
+			}
+			// Re-validate every hop before connecting
+			return f.validateRedirect(req.URL)
+		},
+	}
+
+	return f
 }
 
 // NewTestFetcher creates a fetcher with SSRF checks disabled (for testing only)
@@ -53,6 +90,13 @@ func NewTestFetcher(cfg *config.Config) *Fetcher {
 	return f
 }
 
+// SetConfig replaces the configuration used for later fetches
+// The allowlist, body limit and compression setting follow the new config; the
+// timeout and CIDR policy are fixed when the fetcher is created.
+func (f *Fetcher) SetConfig(cfg *config.Config) {
+	f.cfg.Store(cfg)
+}
+
 // Fetch fetches a URL and returns the response
 func (f *Fetcher) Fetch(ctx context.Context, urlStr string) (*Response, error) {
 	// Validate URL
@@ -66,25 +110,26 @@ func (f *Fetcher) Fetch(ctx context.Context, urlStr string) (*Response, error) {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	// Set user agent
+	// Set user agent and accepted encodings
 	req.Header.Set("User-Agent", "iCal-Filter/1.0")
+	f.setAcceptEncoding(req)
 
 	// Execute request
 	resp, err := f.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch URL: %w", err)
+		return nil, fmt.Errorf("failed to fetch URL: %w", withoutURL(err))
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	// Check status code
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, &StatusError{Code: resp.StatusCode}
 	}
 
-	// Read body
-	body, err := io.ReadAll(resp.Body)
+	// Read body (size-limited, decompressed and sniffed)
+	body, err := f.readBody(resp)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, err
 	}
 
 	return &Response{
@@ -98,7 +143,8 @@ func (f *Fetcher) Fetch(ctx context.Context, urlStr string) (*Response, error) {
 }
 
 // FetchConditional fetches with conditional request headers (ETag/Last-Modified)
-// Returns (response, notModified, error)
+// Returns (response, notModified, error). A 304 response has no body, only the
+// headers that renew the cached copy.
 func (f *Fetcher) FetchConditional(ctx context.Context, urlStr string, etag string, lastModified string) (*Response, bool, error) {
 	// Validate URL
 	if err := f.validateURL(urlStr); err != nil {
@@ -111,8 +157,9 @@ func (f *Fetcher) FetchConditional(ctx context.Context, urlStr string, etag stri
 		return nil, false, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	// Set user agent
+	// Set user agent and accepted encodings
 	req.Header.Set("User-Agent", "iCal-Filter/1.0")
+	f.setAcceptEncoding(req)
 
 	// Set conditional headers
 	if etag != "" {
@@ -125,24 +172,30 @@ func (f *Fetcher) FetchConditional(ctx context.Context, urlStr string, etag stri
 	// Execute request
 	resp, err := f.client.Do(req)
 	if err != nil {
-		return nil, false, fmt.Errorf("failed to fetch URL: %w", err)
+		return nil, false, fmt.Errorf("failed to fetch URL: %w", withoutURL(err))
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	// Check for 304 Not Modified
 	if resp.StatusCode == http.StatusNotModified {
-		return nil, true, nil
+		return &Response{
+			StatusCode:   resp.StatusCode,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			CacheControl: resp.Header.Get("Cache-Control"),
+			Expires:      resp.Header.Get("Expires"),
+		}, true, nil
 	}
 
 	// Check status code
 	if resp.StatusCode != http.StatusOK {
-		return nil, false, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, false, &StatusError{Code: resp.StatusCode}
 	}
 
-	// Read body
-	body, err := io.ReadAll(resp.Body)
+	// Read body (size-limited, decompressed and sniffed)
+	body, err := f.readBody(resp)
 	if err != nil {
-		return nil, false, fmt.Errorf("failed to read response body: %w", err)
+		return nil, false, err
 	}
 
 	return &Response{
@@ -155,6 +208,15 @@ func (f *Fetcher) FetchConditional(ctx context.Context, urlStr string, etag stri
 	}, false, nil
 }
 
+// setAcceptEncoding advertises gzip and deflate unless compression is disabled
+func (f *Fetcher) setAcceptEncoding(req *http.Request) {
+	if f.cfg.Load().Upstream.DisableCompression {
+		req.Header.Set("Accept-Encoding", "identity")
+		return
+	}
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+}
+
 // validateURL validates and sanitizes a URL
 func (f *Fetcher) validateURL(urlStr string) error {
 	if urlStr == "" {
@@ -163,7 +225,7 @@ func (f *Fetcher) validateURL(urlStr string) error {
 
 	parsedURL, err := url.Parse(urlStr)
 	if err != nil {
-		return fmt.Errorf("invalid URL format: %w", err)
+		return fmt.Errorf("invalid URL format: %w", withoutURL(err))
 	}
 
 	// Must be HTTP or HTTPS
@@ -171,37 +233,49 @@ func (f *Fetcher) validateURL(urlStr string) error {
 		return fmt.Errorf("URL must use HTTP or HTTPS scheme, got %q", parsedURL.Scheme)
 	}
 
+	// Enforce the configured allowlist (also in test mode)
+	if !f.cfg.Load().UpstreamAllowed(urlStr) {
+		return ErrUpstreamNotAllowed
+	}
+
 	// Skip SSRF checks if disabled (for testing)
 	if f.disableSSRFChecks {
 		return nil
 	}
 
-	// Check for SSRF: block private IP ranges
-	// This is a basic check; for production, use a more comprehensive library
-	host := parsedURL.Hostname()
-	if host == "localhost" || host == "127.0.0.1" || host == "::1" {
-		return fmt.Errorf("cannot access localhost")
+	// Check for SSRF: reject blocked hosts early; resolved addresses are checked at dial time
+	return f.policy.checkHost(parsedURL.Hostname())
+}
+
+// withoutURL drops the URL that *url.Error repeats in its message
+// Fetch errors end up in logs, and private calendar URLs carry secret tokens.
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
 	}
+	return err
+}
 
-	// Block common private IP ranges (basic check)
-	// In production, use a proper IP parsing library and check all RFC 1918 ranges
-	if len(host) > 0 {
-		// Check for 10.x.x.x, 192.168.x.x, 172.16-31.x.x
-		if len(host) >= 3 && host[:3] == "10." {
-			return fmt.Errorf("cannot access private IP addresses")
-		}
-		if len(host) >= 8 && host[:8] == "192.168." {
-			return fmt.Errorf("cannot access private IP addresses")
-		}
-		if len(host) >= 7 && host[:4] == "172." {
-			// Basic check for 172.16.0.0 - 172.31.255.255
-			// This is simplified; proper implementation should parse the IP
-			if len(host) >= 6 && host[4:6] >= "16" && host[4:6] <= "31" {
-				return fmt.Errorf("cannot access private IP addresses")
-			}
-		}
+// validateRedirect validates a redirect target before following it
+// Every hop must pass the upstream allowlist too, so an allowed host with an open
+// redirect cannot be used to fetch from anywhere else.
+func (f *Fetcher) validateRedirect(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("redirect to non-HTTP scheme %q", u.Scheme)
+	}
+
+	if !f.cfg.Load().UpstreamAllowed(u.String()) {
+		return fmt.Errorf("redirect to %s: %w", u.Host, ErrUpstreamNotAllowed)
+	}
+
+	if f.disableSSRFChecks {
+		return nil
 	}
 
+	if err := f.policy.checkHost(u.Hostname()); err != nil {
+		return fmt.Errorf("redirect blocked: %w", err)
+	}
 	return nil
 }
 
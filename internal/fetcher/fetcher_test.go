@@ -2,6 +2,7 @@ package fetcher
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -33,7 +34,7 @@ func TestFetch(t *testing.T) {
 		w.Header().Set("Last-Modified", "Mon, 01 Jan 2025 00:00:00 GMT")
 		w.Header().Set("Cache-Control", "max-age=300")
 		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("Test response body"))
+		_, _ = w.Write([]byte("BEGIN:VCALENDAR\r\nX-TEST:Test response body\r\nEND:VCALENDAR\r\n"))
 	}))
 	defer server.Close()
 
@@ -45,8 +46,8 @@ func TestFetch(t *testing.T) {
 		t.Fatalf("Fetch() failed: %v", err)
 	}
 
-	if string(resp.Body) != "Test response body" {
-		t.Errorf("Body = %q, want 'Test response body'", string(resp.Body))
+	if string(resp.Body) != "BEGIN:VCALENDAR\r\nX-TEST:Test response body\r\nEND:VCALENDAR\r\n" {
+		t.Errorf("Body = %q, want the served calendar", string(resp.Body))
 	}
 	if resp.StatusCode != http.StatusOK {
 		t.Errorf("StatusCode = %d, want 200", resp.StatusCode)
@@ -63,7 +64,7 @@ func TestFetch(t *testing.T) {
 }
 
 // TestFetchConditional tests conditional requests with ETag
-// Validates: If-None-Match header, 304 Not Modified handling
+// Validates: If-None-Match header, 304 Not Modified handling with its cache headers
 func TestFetchConditionalETag(t *testing.T) {
 	etag := `"abc123"`
 	requestCount := 0
@@ -77,6 +78,7 @@ func TestFetchConditionalETag(t *testing.T) {
 		}
 
 		// Return 304 Not Modified
+		w.Header().Set("Cache-Control", "max-age=600")
 		w.WriteHeader(http.StatusNotModified)
 	}))
 	defer server.Close()
@@ -92,8 +94,8 @@ func TestFetchConditionalETag(t *testing.T) {
 	if !notModified {
 		t.Error("Expected notModified = true for 304 response")
 	}
-	if resp != nil {
-		t.Error("Expected resp = nil for 304 response")
+	if resp == nil || resp.StatusCode != http.StatusNotModified || resp.Body != nil || resp.CacheControl != "max-age=600" {
+		t.Errorf("Response = %+v, want 304 headers without a body", resp)
 	}
 	if requestCount != 1 {
 		t.Errorf("Request count = %d, want 1", requestCount)
@@ -112,6 +114,7 @@ func TestFetchConditionalLastModified(t *testing.T) {
 		}
 
 		// Return 304 Not Modified
+		w.Header().Set("Cache-Control", "max-age=600")
 		w.WriteHeader(http.StatusNotModified)
 	}))
 	defer server.Close()
@@ -136,7 +139,7 @@ func TestFetchConditionalModified(t *testing.T) {
 		// Content has changed, return new content
 		w.Header().Set("ETag", `"new-etag"`)
 		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("New content"))
+		_, _ = w.Write([]byte("BEGIN:VCALENDAR\r\nX-TEST:New content\r\nEND:VCALENDAR\r\n"))
 	}))
 	defer server.Close()
 
@@ -154,8 +157,8 @@ func TestFetchConditionalModified(t *testing.T) {
 	if resp == nil {
 		t.Fatal("Expected response, got nil")
 	}
-	if string(resp.Body) != "New content" {
-		t.Errorf("Body = %q, want 'New content'", string(resp.Body))
+	if string(resp.Body) != "BEGIN:VCALENDAR\r\nX-TEST:New content\r\nEND:VCALENDAR\r\n" {
+		t.Errorf("Body = %q, want the new calendar", string(resp.Body))
 	}
 	if resp.ETag != `"new-etag"` {
 		t.Errorf("ETag = %q, want '\"new-etag\"'", resp.ETag)
@@ -247,6 +250,10 @@ func TestFetchNon200Status(t *testing.T) {
 			if !contains(err.Error(), "unexpected status code") {
 				t.Errorf("Error = %q, want error containing 'unexpected status code'", err.Error())
 			}
+			var statusErr *StatusError
+			if !errors.As(err, &statusErr) || statusErr.Code != tt.status {
+				t.Errorf("Error = %v, want StatusError with code %d", err, tt.status)
+			}
 		})
 	}
 }
@@ -282,7 +289,7 @@ func TestFetchRedirect(t *testing.T) {
 			http.Redirect(w, r, "/redirect", http.StatusFound)
 		} else {
 			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write([]byte("Final destination"))
+			_, _ = w.Write([]byte("BEGIN:VCALENDAR\r\nX-TEST:Final destination\r\nEND:VCALENDAR\r\n"))
 		}
 	}))
 	defer server.Close()
@@ -295,8 +302,8 @@ func TestFetchRedirect(t *testing.T) {
 		t.Fatalf("Fetch() failed: %v", err)
 	}
 
-	if string(resp.Body) != "Final destination" {
-		t.Errorf("Body = %q, want 'Final destination'", string(resp.Body))
+	if string(resp.Body) != "BEGIN:VCALENDAR\r\nX-TEST:Final destination\r\nEND:VCALENDAR\r\n" {
+		t.Errorf("Body = %q, want the final destination calendar", string(resp.Body))
 	}
 	if redirectCount != 3 {
 		t.Errorf("Redirect count = %d, want 3", redirectCount)
@@ -415,6 +422,40 @@ func TestValidateURL(t *testing.T) {
 	}
 }
 
+// TestValidateURLAllowlist tests that the upstream allowlist is enforced by the fetcher
+// Validates: Unlisted URLs and redirect hops rejected with ErrUpstreamNotAllowed, also in test mode
+func TestValidateURLAllowlist(t *testing.T) {
+	cfg := getTestConfig()
+	cfg.Upstream.Allowed = config.AllowedConfig{Enabled: true, Hosts: []string{"calendar.google.com"}}
+	fetcher := NewTestFetcher(cfg)
+
+	if err := fetcher.validateURL("https://calendar.google.com/calendar.ics"); err != nil {
+		t.Errorf("Allowed host rejected: %v", err)
+	}
+	if err := fetcher.validateURL("https://example.com/calendar.ics"); !errors.Is(err, ErrUpstreamNotAllowed) {
+		t.Errorf("validateURL() error = %v, want ErrUpstreamNotAllowed", err)
+	}
+
+	// Redirect hops must be allowed too
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"))
+	}))
+	defer target.Close()
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusFound)
+	}))
+	defer redirector.Close()
+
+	cfg.Upstream.Allowed.URLs = []string{redirector.URL}
+	if _, err := NewTestFetcher(cfg).Fetch(context.Background(), redirector.URL); !errors.Is(err, ErrUpstreamNotAllowed) {
+		t.Errorf("Fetch() redirected to an unlisted host error = %v, want ErrUpstreamNotAllowed", err)
+	}
+	cfg.Upstream.Allowed.URLs = append(cfg.Upstream.Allowed.URLs, target.URL)
+	if _, err := NewTestFetcher(cfg).Fetch(context.Background(), redirector.URL); err != nil {
+		t.Errorf("Fetch() redirected to a listed URL failed: %v", err)
+	}
+}
+
 // contains checks if a string contains a substring
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(substr) == 0 ||
@@ -0,0 +1,165 @@
+package fetcher
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"syscall"
+)
+
+// ErrBlockedAddress is returned when an upstream resolves to a reserved or denied address
+var ErrBlockedAddress = errors.New("address blocked by SSRF protection")
+
+// reservedPrefixes are ranges that must never be reachable from upstream URLs:
+// private, loopback, link-local (incl. cloud metadata), shared, documentation,
+// multicast and other special-purpose ranges (RFC 6890 and the IANA registries).
+var reservedPrefixes = mustParsePrefixes(
+	// IPv4
+	"0.0.0.0/8",       // "This network"
+	"10.0.0.0/8",      // Private
+	"100.64.0.0/10",   // Shared address space (CGNAT)
+	"127.0.0.0/8",     // Loopback
+	"169.254.0.0/16",  // Link-local, incl. 169.254.169.254 metadata
+	"172.16.0.0/12",   // Private
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // TEST-NET-1
+	"192.88.99.0/24",  // 6to4 relay anycast
+	"192.168.0.0/16",  // Private
+	"198.18.0.0/15",   // Benchmarking
+	"198.51.100.0/24", // TEST-NET-2
+	"203.0.113.0/24",  // TEST-NET-3
+	"224.0.0.0/4",     // Multicast
+	"240.0.0.0/4",     // Reserved, incl. broadcast
+	// IPv6 (IPv4-mapped addresses are unmapped before checking)
+	"::/128",         // Unspecified
+	"::1/128",        // Loopback
+	"64:ff9b::/96",   // NAT64, may embed private IPv4
+	"64:ff9b:1::/48", // Local-use NAT64
+	"100::/64",       // Discard-only
+	"2001::/23",      // IETF protocol assignments, incl. Teredo
+	"2001:db8::/32",  // Documentation
+	"2002::/16",      // 6to4, may embed private IPv4
+	"fc00::/7",       // Unique local (ULA)
+	"fe80::/10",      // Link-local
+	"fec0::/10",      // Deprecated site-local
+	"ff00::/8",       // Multicast
+)
+
+// ipPolicy decides which resolved addresses the fetcher may connect to
+type ipPolicy struct {
+	allow []netip.Prefix // Exempt from the reserved ranges (checked first)
+	deny  []netip.Prefix // Blocked in addition to the reserved ranges
+}
+
+// newIPPolicy builds a policy from configured CIDR lists
+// Invalid entries are rejected by config validation, so they are skipped here.
+func newIPPolicy(allow, deny []string) *ipPolicy {
+	p := &ipPolicy{}
+	for _, cidr := range allow {
+		if prefix, err := netip.ParsePrefix(cidr); err == nil {
+			p.allow = append(p.allow, prefix)
+		}
+	}
+	for _, cidr := range deny {
+		if prefix, err := netip.ParsePrefix(cidr); err == nil {
+			p.deny = append(p.deny, prefix)
+		}
+	}
+	return p
+}
+
+// check returns ErrBlockedAddress if ip may not be connected to
+// The zone is dropped first, as prefixes never contain zoned addresses.
+func (p *ipPolicy) check(ip netip.Addr) error {
+	ip = ip.Unmap().WithZone("")
+
+	for _, prefix := range p.allow {
+		if prefix.Contains(ip) {
+			return nil
+		}
+	}
+	for _, prefix := range p.deny {
+		if prefix.Contains(ip) {
+			return fmt.Errorf("%w: %s is in denied range %s", ErrBlockedAddress, ip, prefix)
+		}
+	}
+	for _, prefix := range reservedPrefixes {
+		if !prefix.Contains(ip) {
+			continue
+		}
+		switch {
+		case ip.IsLoopback():
+			return fmt.Errorf("%w: cannot access localhost (%s)", ErrBlockedAddress, ip)
+		case ip.IsPrivate():
+			return fmt.Errorf("%w: cannot access private IP addresses (%s)", ErrBlockedAddress, ip)
+		default:
+			return fmt.Errorf("%w: cannot access reserved address %s (%s)", ErrBlockedAddress, ip, prefix)
+		}
+	}
+	return nil
+}
+
+// checkHost rejects URL hosts that are blocked before any DNS lookup happens:
+// localhost names, literal IPs in blocked ranges, IPv6 literals with a zone and
+// non-standard numeric IPv4 encodings (decimal, octal, hex) that some resolvers
+// would accept.
+// DNS names are checked at dial time against the address actually connected to.
+func (p *ipPolicy) checkHost(host string) error {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return fmt.Errorf("URL has no host")
+	}
+
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: cannot access localhost", ErrBlockedAddress)
+	}
+
+	if ip, err := netip.ParseAddr(strings.Trim(host, "[]")); err == nil {
+		// A zone only makes sense for link-local and loopback addresses
+		if ip.Zone() != "" {
+			return fmt.Errorf("%w: zoned address %s", ErrBlockedAddress, ip)
+		}
+		return p.check(ip)
+	}
+
+	// Top-level domains are never numeric, so a numeric last label means an IP encoding
+	labels := strings.Split(host, ".")
+	last := labels[len(labels)-1]
+	if strings.HasPrefix(last, "0x") || strings.Trim(last, "0123456789") == "" {
+		return fmt.Errorf("%w: ambiguous numeric host %q", ErrBlockedAddress, host)
+	}
+
+	return nil
+}
+
+// dialControl returns a net.Dialer Control function enforcing the policy on every
+// connection, after DNS resolution and for every redirect hop
+// The check is skipped while disabled() reports true (test mode).
+func (p *ipPolicy) dialControl(disabled func() bool) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		if disabled() {
+			return nil
+		}
+
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrBlockedAddress, err)
+		}
+		ip, err := netip.ParseAddr(host)
+		if err != nil {
+			return fmt.Errorf("%w: unexpected dial address %q", ErrBlockedAddress, address)
+		}
+		return p.check(ip)
+	}
+}
+
+// mustParsePrefixes parses a list of CIDR prefixes, panicking on invalid input
+func mustParsePrefixes(cidrs ...string) []netip.Prefix {
+	prefixes := make([]netip.Prefix, len(cidrs))
+	for i, cidr := range cidrs {
+		prefixes[i] = netip.MustParsePrefix(cidr)
+	}
+	return prefixes
+}
@@ -0,0 +1,153 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// TestIPPolicyCheck tests the resolved-address policy
+// Validates: Reserved IPv4/IPv6 ranges, IPv4-mapped and zoned addresses, allow and deny CIDRs
+func TestIPPolicyCheck(t *testing.T) {
+	policy := newIPPolicy([]string{"10.1.0.0/16"}, []string{"8.8.4.0/24"})
+
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		{"8.8.8.8", false},
+		{"2001:4860:4860::8888", false},
+		{"0.0.0.0", true},
+		{"127.0.0.1", true},
+		{"10.0.0.1", true},
+		{"172.31.255.1", true},
+		{"192.168.1.1", true},
+		{"100.64.0.1", true},
+		{"169.254.169.254", true},
+		{"224.0.0.1", true},
+		{"255.255.255.255", true},
+		{"::1", true},
+		{"::", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:169.254.169.254", true},
+		{"fd00::1", true},
+		{"fe80::1", true},
+		{"::1%lo", true},
+		{"fe80::1%eth0", true},
+		{"64:ff9b::a00:1", true},
+		{"2002:a00:1::", true},
+		{"10.1.2.3", false}, // allow_cidrs exempts it from 10.0.0.0/8
+		{"8.8.4.4", true},   // deny_cidrs blocks a public range
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			err := policy.check(netip.MustParseAddr(tt.ip))
+			if (err != nil) != tt.blocked {
+				t.Errorf("check(%s) error = %v, want blocked %v", tt.ip, err, tt.blocked)
+			}
+			if err != nil && !errors.Is(err, ErrBlockedAddress) {
+				t.Errorf("check(%s) error = %v, want ErrBlockedAddress", tt.ip, err)
+			}
+		})
+	}
+}
+
+// TestIPPolicyCheckHost tests host checks done before DNS resolution
+// Validates: localhost names, literal and zoned IPs, decimal/octal/hex IPv4 encodings
+func TestIPPolicyCheckHost(t *testing.T) {
+	policy := newIPPolicy(nil, nil)
+
+	tests := []struct {
+		host    string
+		blocked bool
+	}{
+		{"calendar.google.com", false},
+		{"cafe.be", false},
+		{"example.com.", false},
+		{"localhost", true},
+		{"LOCALHOST.", true},
+		{"api.localhost", true},
+		{"127.0.0.1", true},
+		{"[::1]", true},
+		{"[::1%lo]", true},
+		{"fe80::1%eth0", true},
+		{"[2001:4860:4860::8888%eth0]", true}, // Zones are rejected even on public addresses
+		{"169.254.169.254", true},
+		{"2130706433", true}, // 127.0.0.1 as a decimal integer
+		{"0177.0.0.1", true}, // octal
+		{"0x7f.0.0.1", true}, // hex
+		{"127.1", true},      // short form
+		{"", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if err := policy.checkHost(tt.host); (err != nil) != tt.blocked {
+				t.Errorf("checkHost(%q) error = %v, want blocked %v", tt.host, err, tt.blocked)
+			}
+		})
+	}
+}
+
+// TestDialControl tests that the connected address is checked at dial time
+// Validates: Blocked and public addresses, test mode escape hatch
+func TestDialControl(t *testing.T) {
+	disabled := false
+	control := newIPPolicy(nil, nil).dialControl(func() bool { return disabled })
+
+	if err := control("tcp4", "169.254.169.254:80", nil); !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("Metadata address error = %v, want ErrBlockedAddress", err)
+	}
+	if err := control("tcp6", "[fd12::1]:443", nil); !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("ULA address error = %v, want ErrBlockedAddress", err)
+	}
+	if err := control("tcp4", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("Public address blocked: %v", err)
+	}
+
+	disabled = true
+	if err := control("tcp4", "127.0.0.1:80", nil); err != nil {
+		t.Errorf("Test mode should not block: %v", err)
+	}
+}
+
+// TestFetchSSRFProtection tests SSRF protection on real connections
+// Validates: Allowed CIDR reaches a local server, redirects to internal hosts and zoned
+// loopback literals are blocked
+func TestFetchSSRFProtection(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"))
+	}))
+	defer server.Close()
+
+	cfg := getTestConfig()
+	if _, err := NewFetcher(cfg).Fetch(context.Background(), server.URL); !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("Fetch() of loopback server error = %v, want ErrBlockedAddress", err)
+	}
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	for _, host := range []string{"[::1%25lo]", "[fe80::1%25eth0]"} {
+		if _, err := NewFetcher(cfg).Fetch(context.Background(), "http://"+host+":"+port+"/"); !errors.Is(err, ErrBlockedAddress) {
+			t.Errorf("Fetch() of %s error = %v, want ErrBlockedAddress", host, err)
+		}
+	}
+
+	cfg.Upstream.AllowCIDRs = []string{"127.0.0.0/8"}
+	fetcher := NewFetcher(cfg)
+
+	if _, err := fetcher.Fetch(context.Background(), server.URL); err != nil {
+		t.Errorf("Fetch() from allowed range failed: %v", err)
+	}
+	if _, err := fetcher.Fetch(context.Background(), server.URL+"/redirect"); !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("Fetch() following redirect to metadata error = %v, want ErrBlockedAddress", err)
+	}
+}
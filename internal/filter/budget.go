@@ -0,0 +1,104 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"time"
+)
+
+// Regex budget defaults used when the corresponding regex setting is zero
+const (
+	defaultMaxPatternLength = 512
+	defaultMaxFilters       = 50
+	defaultMaxProgramSize   = 5000
+
+	maxQuotedFilter = 64 // Characters of an offending filter quoted in errors
+)
+
+// BudgetError is returned when a filter exceeds one of the regex budgets
+// Filter is the pattern or expression term at fault, shortened for long input.
+type BudgetError struct {
+	Filter string
+	Reason string
+}
+
+// Error implements the error interface
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("filter %q %s", e.Filter, e.Reason)
+}
+
+// newBudgetError returns a BudgetError naming filter
+func newBudgetError(filter, format string, args ...any) *BudgetError {
+	if runes := []rune(filter); len(runes) > maxQuotedFilter {
+		filter = string(runes[:maxQuotedFilter]) + "…"
+	}
+	return &BudgetError{Filter: filter, Reason: fmt.Sprintf(format, args...)}
+}
+
+// limit returns value, or def if value is zero
+func limit(value, def int) int {
+	if value == 0 {
+		return def
+	}
+	return value
+}
+
+// checkLength rejects user-supplied pattern text longer than regex.max_pattern_length
+func (e *Engine) checkLength(input string) error {
+	maxLength := limit(e.cfg.Regex.MaxPatternLength, defaultMaxPatternLength)
+	if n := len([]rune(input)); n > maxLength {
+		return newBudgetError(input, "is %d characters long, the limit is %d", n, maxLength)
+	}
+	return nil
+}
+
+// checkBudget counts pattern as one more filter and checks its compiled size
+// against regex.max_filters and regex.max_program_size. Syntax errors are left
+// for regexp.Compile to report.
+func (e *Engine) checkBudget(pattern string) error {
+	e.compiled++
+	if maxFilters := limit(e.cfg.Regex.MaxFilters, defaultMaxFilters); e.compiled > maxFilters {
+		return newBudgetError(pattern, "exceeds the limit of %d filters per request", maxFilters)
+	}
+
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return nil
+	}
+	if maxSize := limit(e.cfg.Regex.MaxProgramSize, defaultMaxProgramSize); len(prog.Inst) > maxSize {
+		return newBudgetError(pattern, "is too complex (%d instructions, the limit is %d)", len(prog.Inst), maxSize)
+	}
+	return nil
+}
+
+// CompilePattern compiles a user-supplied pattern within the regex budgets
+// The pattern counts as one more filter of the request.
+func (e *Engine) CompilePattern(pattern string) (*regexp.Regexp, error) {
+	if err := e.checkLength(pattern); err != nil {
+		return nil, err
+	}
+	if err := e.checkBudget(pattern); err != nil {
+		return nil, err
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex pattern %q: %w", pattern, err)
+	}
+	return re, nil
+}
+
+// deadlineError reports that filtering ran over regex.max_execution_time
+// No single term is to blame, so the filters are named as the whole expression.
+func deadlineError(expr Expr, budget time.Duration, done, total int) *BudgetError {
+	name := expr.String()
+	if name == "" {
+		name = "date window"
+	}
+	return newBudgetError(name, "exceeded the %v time budget after %d of %d events", budget, done, total)
+}
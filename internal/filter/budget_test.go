@@ -0,0 +1,105 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linus/recal/internal/parser"
+)
+
+// TestBudgets tests the pattern budgets enforced while building filters
+// Validates: Pattern and term length, filter count including expression terms,
+// compiled program size, offending filter named and shortened in the error
+func TestBudgets(t *testing.T) {
+	tests := []struct {
+		name       string
+		build      func(e *Engine) error
+		wantFilter string
+		wantReason string
+	}{
+		{
+			name:       "long pattern",
+			build:      func(e *Engine) error { return e.AddFilter([]string{"SUMMARY"}, strings.Repeat("a", 101)) },
+			wantFilter: strings.Repeat("a", maxQuotedFilter) + "…",
+			wantReason: "is 101 characters long, the limit is 100",
+		},
+		{
+			name:       "long expression term",
+			build:      func(e *Engine) error { return e.AddExpression("Göta OR summary:" + strings.Repeat("b", 101)) },
+			wantFilter: strings.Repeat("b", maxQuotedFilter) + "…",
+			wantReason: "is 101 characters long, the limit is 100",
+		},
+		{
+			name: "too many filters",
+			build: func(e *Engine) error {
+				if err := e.AddFilter([]string{"SUMMARY"}, "one"); err != nil {
+					return err
+				}
+				return e.AddExpression("two OR three OR four")
+			},
+			wantFilter: "four",
+			wantReason: "exceeds the limit of 3 filters per request",
+		},
+		{
+			name:       "complex pattern",
+			build:      func(e *Engine) error { return e.AddFilter([]string{"SUMMARY"}, "(a|b){40}") },
+			wantFilter: "(a|b){40}",
+			wantReason: "is too complex",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := getTestConfig()
+			cfg.Regex.MaxPatternLength = 100
+			cfg.Regex.MaxFilters = 3
+			cfg.Regex.MaxProgramSize = 100
+
+			err := tt.build(NewEngine(cfg))
+			var budgetErr *BudgetError
+			if !errors.As(err, &budgetErr) {
+				t.Fatalf("build error = %v, want a *BudgetError", err)
+			}
+			if budgetErr.Filter != tt.wantFilter || !strings.HasPrefix(budgetErr.Reason, tt.wantReason) {
+				t.Errorf("BudgetError = %q %q, want %q %q", budgetErr.Filter, budgetErr.Reason, tt.wantFilter, tt.wantReason)
+			}
+		})
+	}
+
+	// The defaults leave room for ordinary filters
+	engine := NewEngine(getTestConfig())
+	if err := engine.AddLodgeFilter("Göta,Moderlogen,Sundsvall"); err != nil {
+		t.Errorf("AddLodgeFilter() with default budgets failed: %v", err)
+	}
+}
+
+// TestApplyDeadline tests regex.max_execution_time
+// Validates: Filtering stops once the budget is spent and names the whole expression
+func TestApplyDeadline(t *testing.T) {
+	cfg := getTestConfig()
+	cfg.Regex.MaxExecutionTime = time.Nanosecond
+	engine := NewEngine(cfg)
+	if err := engine.AddExpression(`summary:"(x+x+)+y" OR Göta`); err != nil {
+		t.Fatalf("AddExpression() failed: %v", err)
+	}
+
+	cal := &parser.Calendar{}
+	for i := 0; i < 100; i++ {
+		cal.Events = append(cal.Events, &parser.Event{UID: fmt.Sprint(i), Summary: strings.Repeat("x", 1000)})
+	}
+
+	_, _, err := engine.Apply(cal)
+	var budgetErr *BudgetError
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("Apply() error = %v, want a *BudgetError", err)
+	}
+	if want := engine.Expression().String(); budgetErr.Filter != want || !strings.Contains(budgetErr.Reason, "time budget after 1 of 100 events") {
+		t.Errorf("BudgetError = %q %q, want %q after the first event", budgetErr.Filter, budgetErr.Reason, want)
+	}
+	if !strings.Contains(budgetErr.Filter, "(x+x+)+y") || !strings.Contains(budgetErr.Filter, "Göta") {
+		t.Errorf("BudgetError filter %q does not name every term", budgetErr.Filter)
+	}
+}
@@ -0,0 +1,453 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/linus/recal/internal/parser"
+)
+
+// Expr is a node in a boolean filter expression
+// Eval returns true if the event should be kept. Matching terms are recorded
+// in matches; the engine discards them for kept events, so the debug page only
+// shows why an event was removed.
+type Expr interface {
+	Eval(event *parser.Event, matches *[]MatchResult) bool
+	String() string
+}
+
+// andExpr keeps an event if all children keep it (short-circuits on the first false)
+type andExpr struct {
+	children []Expr
+}
+
+func (x *andExpr) Eval(event *parser.Event, matches *[]MatchResult) bool {
+	for _, child := range x.children {
+		if !child.Eval(event, matches) {
+			return false
+		}
+	}
+	return true
+}
+
+func (x *andExpr) String() string {
+	return joinExprs(x.children, " AND ")
+}
+
+// orExpr keeps an event if any child keeps it (short-circuits on the first true)
+type orExpr struct {
+	children []Expr
+}
+
+func (x *orExpr) Eval(event *parser.Event, matches *[]MatchResult) bool {
+	for _, child := range x.children {
+		if child.Eval(event, matches) {
+			return true
+		}
+	}
+	return false
+}
+
+func (x *orExpr) String() string {
+	return joinExprs(x.children, " OR ")
+}
+
+// notExpr inverts its child
+type notExpr struct {
+	child Expr
+}
+
+func (x *notExpr) Eval(event *parser.Event, matches *[]MatchResult) bool {
+	return !x.child.Eval(event, matches)
+}
+
+func (x *notExpr) String() string {
+	return "NOT " + x.child.String()
+}
+
+// termExpr is true if the filter's pattern matches any of its fields
+type termExpr struct {
+	filter Filter
+}
+
+func (x *termExpr) Eval(event *parser.Event, matches *[]MatchResult) bool {
+	matched, field, matchedText := matchFilter(x.filter, event)
+	if matched {
+		*matches = append(*matches, MatchResult{
+			EventUID:     event.UID,
+			EventSummary: event.Summary,
+			FilterRaw:    x.filter.Raw,
+			Field:        field,
+			MatchedText:  matchedText,
+		})
+	}
+	return matched
+}
+
+func (x *termExpr) String() string {
+	return strings.Join(x.filter.Fields, ",") + ":" + quoteTerm(x.filter.Raw)
+}
+
+// constExpr always returns the same value (e.g. a grad: term that excludes nothing)
+type constExpr struct {
+	value bool
+}
+
+func (x *constExpr) Eval(event *parser.Event, matches *[]MatchResult) bool {
+	return x.value
+}
+
+func (x *constExpr) String() string {
+	if x.value {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// joinExprs renders children with parentheses around nested AND/OR nodes
+func joinExprs(children []Expr, sep string) string {
+	parts := make([]string, len(children))
+	for i, child := range children {
+		switch child.(type) {
+		case *andExpr, *orExpr:
+			parts[i] = "(" + child.String() + ")"
+		default:
+			parts[i] = child.String()
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+// quoteTerm quotes a pattern if it would not survive re-parsing as a bare word
+func quoteTerm(pattern string) string {
+	if pattern != "" && !strings.ContainsAny(pattern, " \t\"()") {
+		return pattern
+	}
+	return `"` + strings.ReplaceAll(strings.ReplaceAll(pattern, `\`, `\\`), `"`, `\"`) + `"`
+}
+
+// Expression syntax (the q= parameter):
+//
+//	expr    = or
+//	or      = and { "OR" and }
+//	and     = unary { ["AND"] unary }      adjacent terms are ANDed
+//	unary   = "NOT" unary | primary
+//	primary = "(" expr ")" | term
+//	term    = [ fields ":" ] value           fields: comma-separated, default SUMMARY,DESCRIPTION
+//	value   = bare-word | '"' quoted '"'     quoted values may contain spaces and parentheses
+//
+// Values are regular expressions. Inside quotes, \" is a literal quote and \\ a
+// literal backslash; other escapes (\d, \s, ...) are passed to the regex as-is.
+// Only bare AND, OR and NOT are operators: "OR" searches for the word.
+// Two macro fields expand through the filter configuration:
+//
+//	grad:N        true for events at grade N or lower (same as Grad=N keeps)
+//	loge:A,B      true for events belonging to any of the lodges (same as Loge=A,B removes)
+//
+// Example: (grad:4 OR loge:Moderlogen) AND NOT summary:INSTÄLLT
+
+// defaultExprFields are searched by terms without an explicit field
+var defaultExprFields = []string{"SUMMARY", "DESCRIPTION"}
+
+// fieldPrefix matches the "fields:" prefix of a term; each field is PROP or PROP;PARAM
+var fieldPrefix = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*(;[A-Za-z][A-Za-z0-9_-]*)?(,[A-Za-z][A-Za-z0-9_-]*(;[A-Za-z][A-Za-z0-9_-]*)?)*:`)
+
+// tokenKind identifies expression tokens
+type tokenKind int
+
+const (
+	tokTerm tokenKind = iota
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+)
+
+// token is a lexed expression token
+type token struct {
+	kind   tokenKind
+	fields string // Term field list (empty for default fields)
+	value  string // Term pattern
+	pos    int    // Byte offset in the input, for error messages
+}
+
+// tokenize splits an expression into tokens
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(input) {
+		r := rune(input[i])
+		switch {
+		case strings.ContainsRune(" \t\r\n", r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokLParen, pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokRParen, pos: i})
+			i++
+		default:
+			start := i
+			fields := ""
+			if prefix := fieldPrefix.FindString(input[i:]); prefix != "" {
+				fields = prefix[:len(prefix)-1]
+				i += len(prefix)
+			}
+
+			var value string
+			quoted := i < len(input) && input[i] == '"'
+			if quoted {
+				unquoted, next, err := readQuoted(input, i)
+				if err != nil {
+					return nil, err
+				}
+				value, i = unquoted, next
+			} else {
+				end := i
+				for end < len(input) && !strings.ContainsRune(" \t\r\n()", rune(input[end])) {
+					end++
+				}
+				value, i = input[i:end], end
+			}
+
+			// Only bare keywords are operators; "OR" searches for the word
+			if fields == "" && !quoted {
+				switch value {
+				case "AND":
+					tokens = append(tokens, token{kind: tokAnd, pos: start})
+					continue
+				case "OR":
+					tokens = append(tokens, token{kind: tokOr, pos: start})
+					continue
+				case "NOT":
+					tokens = append(tokens, token{kind: tokNot, pos: start})
+					continue
+				}
+			}
+
+			if value == "" {
+				return nil, fmt.Errorf("empty pattern at position %d", start)
+			}
+			tokens = append(tokens, token{kind: tokTerm, fields: fields, value: value, pos: start})
+		}
+	}
+	return tokens, nil
+}
+
+// readQuoted reads a double-quoted value starting at input[start]
+// Returns the unescaped value and the offset just past the closing quote
+func readQuoted(input string, start int) (string, int, error) {
+	var b strings.Builder
+	for i := start + 1; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			if i+1 < len(input) && (input[i+1] == '"' || input[i+1] == '\\') {
+				b.WriteByte(input[i+1])
+				i++
+				continue
+			}
+			b.WriteByte('\\')
+		case '"':
+			return b.String(), i + 1, nil
+		default:
+			b.WriteByte(input[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated quote at position %d", start)
+}
+
+// exprParser is a recursive-descent parser over expression tokens
+type exprParser struct {
+	engine *Engine
+	tokens []token
+	pos    int
+}
+
+// ParseExpression parses a boolean filter expression into an AST
+// See the syntax description above; grad: and loge: use the engine's configuration.
+func (e *Engine) ParseExpression(input string) (Expr, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression: %w", err)
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("invalid expression: expression is empty")
+	}
+
+	p := &exprParser{engine: e, tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression: %w", err)
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("invalid expression: unexpected %s at position %d", p.describe(p.tokens[p.pos]), p.tokens[p.pos].pos)
+	}
+	return expr, nil
+}
+
+func (p *exprParser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *exprParser) parseOr() (Expr, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	children := []Expr{first}
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.kind != tokOr {
+			break
+		}
+		p.pos++
+		next, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, next)
+	}
+	if len(children) == 1 {
+		return first, nil
+	}
+	return &orExpr{children: children}, nil
+}
+
+func (p *exprParser) parseAnd() (Expr, error) {
+	first, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	children := []Expr{first}
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.kind == tokOr || tok.kind == tokRParen {
+			break
+		}
+		if tok.kind == tokAnd {
+			p.pos++
+		}
+		next, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, next)
+	}
+	if len(children) == 1 {
+		return first, nil
+	}
+	return &andExpr{children: children}, nil
+}
+
+func (p *exprParser) parseUnary() (Expr, error) {
+	tok, ok := p.peek()
+	if ok && tok.kind == tokNot {
+		p.pos++
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{child: child}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (Expr, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	switch tok.kind {
+	case tokLParen:
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		closing, ok := p.peek()
+		if !ok || closing.kind != tokRParen {
+			return nil, fmt.Errorf("missing ) for ( at position %d", tok.pos)
+		}
+		p.pos++
+		return expr, nil
+	case tokTerm:
+		p.pos++
+		return p.engine.termFor(tok)
+	default:
+		return nil, fmt.Errorf("unexpected %s at position %d", p.describe(tok), tok.pos)
+	}
+}
+
+// describe names a token for error messages
+func (p *exprParser) describe(tok token) string {
+	switch tok.kind {
+	case tokAnd:
+		return "AND"
+	case tokOr:
+		return "OR"
+	case tokNot:
+		return "NOT"
+	case tokLParen:
+		return "("
+	case tokRParen:
+		return ")"
+	default:
+		return fmt.Sprintf("term %q", tok.value)
+	}
+}
+
+// termFor compiles a term token, expanding the grad: and loge: macros
+func (e *Engine) termFor(tok token) (Expr, error) {
+	if err := e.checkLength(tok.value); err != nil {
+		return nil, err
+	}
+
+	switch strings.ToUpper(tok.fields) {
+	case "GRAD":
+		pattern, err := e.gradePattern(tok.value)
+		if err != nil {
+			return nil, fmt.Errorf("grad term at position %d: %w", tok.pos, err)
+		}
+		if pattern == "" {
+			return &constExpr{value: true}, nil
+		}
+		term, err := e.newTermExpr([]string{e.cfg.Filters.Grade.Field}, pattern)
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{child: term}, nil
+	case "LOGE":
+		pattern, err := e.lodgePattern(tok.value)
+		if err != nil {
+			return nil, fmt.Errorf("loge term at position %d: %w", tok.pos, err)
+		}
+		return e.newTermExpr([]string{e.cfg.Filters.Lodge.Field}, pattern)
+	}
+
+	fields := defaultExprFields
+	if tok.fields != "" {
+		fields = nil
+		for _, f := range strings.Split(tok.fields, ",") {
+			fields = append(fields, strings.ToUpper(f))
+		}
+	}
+	return e.newTermExpr(fields, tok.value)
+}
+
+// newTermExpr compiles a field:regex term within the regex budgets
+func (e *Engine) newTermExpr(fields []string, pattern string) (Expr, error) {
+	if err := e.checkBudget(pattern); err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex pattern %q: %w", pattern, err)
+	}
+	return &termExpr{filter: Filter{Fields: fields, Pattern: re, Raw: pattern}}, nil
+}
@@ -0,0 +1,217 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/linus/recal/internal/parser"
+)
+
+// exprTestEvents is a small calendar covering grades, lodges and cancellations
+func exprTestEvents() *parser.Calendar {
+	return &parser.Calendar{
+		Events: []*parser.Event{
+			{UID: "1", Summary: "Göta PB: Grad 2"},
+			{UID: "2", Summary: "Göta PB: Grad 7"},
+			{UID: "3", Summary: "PB, Moderlogen: Grad 8"},
+			{UID: "4", Summary: "INSTÄLLT PB, Moderlogen: Grad 3"},
+			{UID: "5", Summary: "Sundsvalls PB: Grad 1", Location: "Sundsvall"},
+			{UID: "6", Summary: "Styrelsemöte", Description: "Grad 9 genomgång"},
+		},
+	}
+}
+
+// keptUIDs returns the UIDs of the kept events, in order
+func keptUIDs(cal *parser.Calendar) string {
+	uids := ""
+	for _, e := range cal.Events {
+		uids += e.UID
+	}
+	return uids
+}
+
+// TestExpressionEval tests evaluating q= expressions
+// Validates: AND/OR/NOT, grouping, implicit AND, field terms, quoting, grad:/loge: macros,
+// matches only reported for removed events
+func TestExpressionEval(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		want string
+	}{
+		{"bare term", "Göta", "12"},
+		{"field term", "location:Sundsvall", "5"},
+		{"case-insensitive field", "LoCaTiOn:Sundsvall", "5"},
+		{"multiple fields", "summary,description:\"Grad 9\"", "6"},
+		{"not", "NOT Göta", "3456"},
+		{"or", "Göta OR Sundsvall", "125"},
+		{"and", "Göta AND \"Grad 7\"", "2"},
+		{"implicit and", "Göta \"Grad 7\"", "2"},
+		{"grouping", "(Göta OR Moderlogen) AND NOT \"Grad [78]\"", "14"},
+		{"grad macro", "grad:4", "1456"},
+		{"loge macro", "loge:Moderlogen", "34"},
+		{"grad 9 keeps all", "grad:9", "123456"},
+		{"backlog example", "(grad:4 OR loge:Moderlogen) AND NOT summary:INSTÄLLT", "1356"},
+		{"regex escapes in quotes", `summary:"Grad \d$" NOT Göta`, "345"},
+		{"escaped quote", `summary:"\"" OR Sundsvall`, "5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine(getTestConfig())
+			if err := engine.AddExpression(tt.expr); err != nil {
+				t.Fatalf("AddExpression(%q) failed: %v", tt.expr, err)
+			}
+
+			filtered, matches, err := engine.Apply(exprTestEvents())
+			if err != nil {
+				t.Fatalf("Apply() error: %v", err)
+			}
+			if got := keptUIDs(filtered); got != tt.want {
+				t.Errorf("AddExpression(%q) kept %q, want %q", tt.expr, got, tt.want)
+			}
+			for _, match := range matches {
+				if strings.Contains(tt.want, match.EventUID) {
+					t.Errorf("AddExpression(%q) reported a match on kept event %s", tt.expr, match.EventUID)
+				}
+			}
+		})
+	}
+}
+
+// TestExpressionQuotedKeywords tests quoted AND, OR and NOT
+// Validates: Quoted keywords are search terms, bare ones stay operators
+func TestExpressionQuotedKeywords(t *testing.T) {
+	cal := func() *parser.Calendar {
+		return &parser.Calendar{
+			Events: []*parser.Event{
+				{UID: "1", Summary: "Lunch OR middag"},
+				{UID: "2", Summary: "Installation"},
+				{UID: "3", Summary: "AND then NOT"},
+			},
+		}
+	}
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{`"OR"`, "1"},
+		{`"AND"`, "3"},
+		{`"NOT" OR "OR"`, "13"},
+		{`NOT "OR"`, "23"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			engine := NewEngine(getTestConfig())
+			if err := engine.AddExpression(tt.expr); err != nil {
+				t.Fatalf("AddExpression(%q) failed: %v", tt.expr, err)
+			}
+			filtered, _, err := engine.Apply(cal())
+			if err != nil {
+				t.Fatalf("Apply() error: %v", err)
+			}
+			if got := keptUIDs(filtered); got != tt.want {
+				t.Errorf("AddExpression(%q) kept %q, want %q", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestExpressionErrors tests rejection of malformed expressions
+// Validates: Syntax errors, bad regex, bad macros
+func TestExpressionErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"   ",
+		"(Göta",
+		"Göta)",
+		"Göta OR",
+		"AND Göta",
+		"NOT",
+		`summary:"unterminated`,
+		"summary:[invalid(",
+		"grad:none",
+		"loge:,",
+		"summary:",
+	}
+
+	for _, input := range tests {
+		t.Run(input, func(t *testing.T) {
+			engine := NewEngine(getTestConfig())
+			if err := engine.AddExpression(input); err == nil {
+				t.Errorf("AddExpression(%q) should fail", input)
+			}
+		})
+	}
+}
+
+// TestExpressionString tests rendering an expression for debug output
+// Validates: Rendered form re-parses to an equivalent expression
+func TestExpressionString(t *testing.T) {
+	engine := NewEngine(getTestConfig())
+	expr, err := engine.ParseExpression(`(Göta OR location:Sundsvall) AND NOT summary:"Grad [78]"`)
+	if err != nil {
+		t.Fatalf("ParseExpression() failed: %v", err)
+	}
+
+	want := `(SUMMARY,DESCRIPTION:Göta OR LOCATION:Sundsvall) AND NOT SUMMARY:"Grad [78]"`
+	if got := expr.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	reparsed := NewEngine(getTestConfig())
+	if err := reparsed.AddExpression(expr.String()); err != nil {
+		t.Fatalf("Re-parsing %q failed: %v", expr.String(), err)
+	}
+	filtered, _, err := reparsed.Apply(exprTestEvents())
+	if err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
+	if got := keptUIDs(filtered); got != "15" {
+		t.Errorf("Re-parsed expression kept %q, want %q", got, "15")
+	}
+}
+
+// TestLegacyFiltersCompileToExpression tests that pattern/Grad/Loge/RemoveUnconfirmed/RemoveInstallt
+// produce the same AST-based result as the old remove/keep chain
+// Validates: Normal filters remove on match, inverted filters remove on no match, combined with q=
+func TestLegacyFiltersCompileToExpression(t *testing.T) {
+	engine := NewEngine(getTestConfig())
+	if err := engine.AddGradeFilter("4"); err != nil {
+		t.Fatalf("AddGradeFilter() failed: %v", err)
+	}
+	if err := engine.AddInstalltFilter(); err != nil {
+		t.Fatalf("AddInstalltFilter() failed: %v", err)
+	}
+
+	filtered, _, err := engine.Apply(exprTestEvents())
+	if err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
+	if got := keptUIDs(filtered); got != "156" {
+		t.Errorf("Legacy filters kept %q, want %q", got, "156")
+	}
+
+	// q= is ANDed with the legacy filters
+	if err := engine.AddExpression("NOT Sundsvall"); err != nil {
+		t.Fatalf("AddExpression() failed: %v", err)
+	}
+	filtered, _, err = engine.Apply(exprTestEvents())
+	if err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
+	if got := keptUIDs(filtered); got != "16" {
+		t.Errorf("Legacy filters with q kept %q, want %q", got, "16")
+	}
+
+	// Inverted filter: RemoveUnconfirmed keeps only matching events
+	confirmed := NewEngine(getTestConfig())
+	if err := confirmed.AddConfirmedOnlyFilter(); err != nil {
+		t.Fatalf("AddConfirmedOnlyFilter() failed: %v", err)
+	}
+	if got := confirmed.Expression().String(); got != "STATUS:CONFIRMED" {
+		t.Errorf("Expression() = %q, want STATUS:CONFIRMED", got)
+	}
+}
@@ -1,11 +1,16 @@
 package filter
 
 import (
+	"context"
 	"fmt"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/linus/recal/internal/config"
+	"github.com/linus/recal/internal/logging"
 	"github.com/linus/recal/internal/parser"
 )
 
@@ -26,29 +31,76 @@ type MatchResult struct {
 	MatchedText  string
 }
 
+// DateWindow restricts output to events overlapping [From, To)
+// A zero From or To leaves that side open
+type DateWindow struct {
+	From time.Time
+	To   time.Time
+}
+
+// String returns a human-readable description of the window for debug output
+func (w DateWindow) String() string {
+	from, to := "-∞", "+∞"
+	if !w.From.IsZero() {
+		from = w.From.Format(time.RFC3339)
+	}
+	if !w.To.IsZero() {
+		to = w.To.Format(time.RFC3339)
+	}
+	return "[" + from + ", " + to + ")"
+}
+
+// Filter config sections special filters are built from, named after their keys under filters:
+const (
+	SectionGrade         = "grade"
+	SectionLodge         = "lodge"
+	SectionConfirmedOnly = "confirmed_only"
+	SectionInstallt      = "installt"
+)
+
 // Engine is the filter engine that applies filters to events
 type Engine struct {
-	filters []Filter
-	cfg     *config.Config
+	filters  []Filter
+	exprs    []Expr // Parsed q= expressions, ANDed with the filters
+	window   *DateWindow
+	cfg      *config.Config
+	sections map[string]bool // Filter config sections used so far, see Sections
+	compiled int             // Patterns compiled so far, counted against regex.max_filters
 }
 
 // NewEngine creates a new filter engine
 func NewEngine(cfg *config.Config) *Engine {
 	return &Engine{
-		filters: []Filter{},
-		cfg:     cfg,
+		filters:  []Filter{},
+		cfg:      cfg,
+		sections: make(map[string]bool),
 	}
 }
 
+// Config returns the configuration the engine builds filters from
+func (e *Engine) Config() *config.Config {
+	return e.cfg
+}
+
+// Sections returns the filter config sections the added filters were built from, sorted
+// Output built by this engine changes when one of these sections is reloaded.
+func (e *Engine) Sections() []string {
+	sections := make([]string, 0, len(e.sections))
+	for section := range e.sections {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+	return sections
+}
+
 // AddFilter adds a basic filter
 func (e *Engine) AddFilter(fields []string, pattern string) error {
 	if pattern == "" {
 		return fmt.Errorf("pattern cannot be empty")
 	}
-
-	re, err := regexp.Compile(pattern)
+	re, err := e.CompilePattern(pattern)
 	if err != nil {
-		return fmt.Errorf("invalid regex pattern %q: %w", pattern, err)
+		return err
 	}
 
 	e.filters = append(e.filters, Filter{
@@ -63,9 +115,41 @@ func (e *Engine) AddFilter(fields []string, pattern string) error {
 
 // AddGradeFilter adds a Grad filter (e.g., Grad=1,2,3 -> matches "Grad: [1]", "Grad: [2]", "Grad: [3]")
 func (e *Engine) AddGradeFilter(threshold string) error {
+	combinedPattern, err := e.gradePattern(threshold)
+	if err != nil {
+		return err
+	}
+
+	if combinedPattern == "" {
+		// If threshold is 10, no grades to filter out
+		return nil
+	}
+	if err := e.checkBudget(combinedPattern); err != nil {
+		return err
+	}
+
+	re, err := regexp.Compile(combinedPattern)
+	if err != nil {
+		return fmt.Errorf("failed to compile grad pattern %q: %w", combinedPattern, err)
+	}
+
+	e.filters = append(e.filters, Filter{
+		Fields:  []string{e.cfg.Filters.Grade.Field},
+		Pattern: re,
+		Raw:     combinedPattern,
+		Invert:  false,
+	})
+
+	return nil
+}
+
+// gradePattern builds a pattern matching all grades above the threshold
+// Returns "" if no grades are above the threshold (threshold 10)
+func (e *Engine) gradePattern(threshold string) (string, error) {
 	if threshold == "" {
-		return fmt.Errorf("threshold cannot be empty")
+		return "", fmt.Errorf("threshold cannot be empty")
 	}
+	e.sections[SectionGrade] = true
 
 	// Parse the threshold grade number
 	maxGrade := 0
@@ -79,7 +163,7 @@ func (e *Engine) AddGradeFilter(threshold string) error {
 	}
 
 	if maxGrade == 0 {
-		return fmt.Errorf("no valid grade threshold found in %q", threshold)
+		return "", fmt.Errorf("no valid grade threshold found in %q", threshold)
 	}
 
 	// Create a pattern that matches all grades ABOVE the threshold
@@ -92,19 +176,32 @@ func (e *Engine) AddGradeFilter(threshold string) error {
 	}
 
 	if len(patterns) == 0 {
-		// If threshold is 10, no grades to filter out
-		return nil
+		return "", nil
 	}
 
-	combinedPattern := "(" + strings.Join(patterns, "|") + ")"
+	return "(" + strings.Join(patterns, "|") + ")", nil
+}
+
+// AddLodgeFilter adds a Loge filter (e.g., Loge=Göta,Borås,Moderlogen)
+func (e *Engine) AddLodgeFilter(lodges string) error {
+	if err := e.checkLength(lodges); err != nil {
+		return err
+	}
+	combinedPattern, err := e.lodgePattern(lodges)
+	if err != nil {
+		return err
+	}
+	if err := e.checkBudget(combinedPattern); err != nil {
+		return err
+	}
 
 	re, err := regexp.Compile(combinedPattern)
 	if err != nil {
-		return fmt.Errorf("failed to compile grad pattern %q: %w", combinedPattern, err)
+		return fmt.Errorf("failed to compile loge pattern %q: %w", combinedPattern, err)
 	}
 
 	e.filters = append(e.filters, Filter{
-		Fields:  []string{e.cfg.Filters.Grade.Field},
+		Fields:  []string{e.cfg.Filters.Lodge.Field},
 		Pattern: re,
 		Raw:     combinedPattern,
 		Invert:  false,
@@ -113,11 +210,12 @@ func (e *Engine) AddGradeFilter(threshold string) error {
 	return nil
 }
 
-// AddLodgeFilter adds a Loge filter (e.g., Loge=Göta,Borås,Moderlogen)
-func (e *Engine) AddLodgeFilter(lodges string) error {
+// lodgePattern builds a pattern matching events of any of the comma-separated lodges
+func (e *Engine) lodgePattern(lodges string) (string, error) {
 	if lodges == "" {
-		return fmt.Errorf("lodges cannot be empty")
+		return "", fmt.Errorf("lodges cannot be empty")
 	}
+	e.sections[SectionLodge] = true
 
 	// Split lodge names
 	lodgeNames := strings.Split(lodges, ",")
@@ -158,29 +256,19 @@ func (e *Engine) AddLodgeFilter(lodges string) error {
 	}
 
 	if len(patterns) == 0 {
-		return fmt.Errorf("no valid lodge names found in %q", lodges)
+		return "", fmt.Errorf("no valid lodge names found in %q", lodges)
 	}
 
 	// Combine patterns with OR
-	combinedPattern := "(" + strings.Join(patterns, "|") + ")"
-
-	re, err := regexp.Compile(combinedPattern)
-	if err != nil {
-		return fmt.Errorf("failed to compile loge pattern %q: %w", combinedPattern, err)
-	}
-
-	e.filters = append(e.filters, Filter{
-		Fields:  []string{e.cfg.Filters.Lodge.Field},
-		Pattern: re,
-		Raw:     combinedPattern,
-		Invert:  false,
-	})
-
-	return nil
+	return "(" + strings.Join(patterns, "|") + ")", nil
 }
 
 // AddConfirmedOnlyFilter adds the ConfirmedOnly filter (inverted - keeps matching events)
 func (e *Engine) AddConfirmedOnlyFilter() error {
+	e.sections[SectionConfirmedOnly] = true
+	if err := e.checkBudget(e.cfg.Filters.ConfirmedOnly.Pattern); err != nil {
+		return err
+	}
 	re, err := regexp.Compile(e.cfg.Filters.ConfirmedOnly.Pattern)
 	if err != nil {
 		return fmt.Errorf("failed to compile confirmed_only pattern: %w", err)
@@ -198,6 +286,10 @@ func (e *Engine) AddConfirmedOnlyFilter() error {
 
 // AddInstalltFilter adds the Installt filter (removes events with "INSTÄLLT")
 func (e *Engine) AddInstalltFilter() error {
+	e.sections[SectionInstallt] = true
+	if err := e.checkBudget(e.cfg.Filters.Installt.Pattern); err != nil {
+		return err
+	}
 	re, err := regexp.Compile(e.cfg.Filters.Installt.Pattern)
 	if err != nil {
 		return fmt.Errorf("failed to compile installt pattern: %w", err)
@@ -213,82 +305,225 @@ func (e *Engine) AddInstalltFilter() error {
 	return nil
 }
 
+// AddDateRangeFilter restricts output to events overlapping [from, to)
+// Either bound may be zero to leave that side open. Recurring series are only
+// checked against the upper bound since later occurrences may fall inside the window.
+func (e *Engine) AddDateRangeFilter(from, to time.Time) error {
+	if from.IsZero() && to.IsZero() {
+		return fmt.Errorf("date range needs at least one bound")
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return fmt.Errorf("date range start %s must be before end %s",
+			from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+
+	e.window = &DateWindow{From: from, To: to}
+	return nil
+}
+
+// GetDateWindow returns the active date window, or nil if none is set
+func (e *Engine) GetDateWindow() *DateWindow {
+	return e.window
+}
+
+// ParseTimeBound parses a from/to/horizon value relative to now
+// Accepted forms:
+//   - relative offsets: "-30d", "+180d", "+2w", "-12h", "0d" (units: h, d, w)
+//   - "now" and "today" (midnight of the current day in loc)
+//   - absolute dates: "2025-01-31" or "20250131" (midnight in loc)
+//   - absolute timestamps in RFC 3339: "2025-01-31T18:00:00+01:00"
+func ParseTimeBound(value string, now time.Time, loc *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, fmt.Errorf("empty time value")
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	now = now.In(loc) // Day and week offsets keep the wall-clock time across DST
+
+	switch strings.ToLower(value) {
+	case "now":
+		return now, nil
+	case "today":
+		y, m, d := now.In(loc).Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, loc), nil
+	}
+
+	if t, ok := parseRelativeBound(value, now); ok {
+		return t, nil
+	}
+
+	for _, layout := range []string{"2006-01-02", "20060102"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time value %q (use e.g. -30d, +180d, 2025-01-31 or RFC 3339)", value)
+}
+
+// parseRelativeBound parses offsets like "+180d", "-2w" or "12h" relative to now
+func parseRelativeBound(value string, now time.Time) (time.Time, bool) {
+	if len(value) < 2 {
+		return time.Time{}, false
+	}
+
+	unit := value[len(value)-1]
+	number := value[:len(value)-1]
+	n, err := strconv.Atoi(strings.TrimPrefix(number, "+"))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	switch unit {
+	case 'h', 'H':
+		return now.Add(time.Duration(n) * time.Hour), true
+	case 'd', 'D':
+		return now.AddDate(0, 0, n), true
+	case 'w', 'W':
+		return now.AddDate(0, 0, 7*n), true
+	}
+	return time.Time{}, false
+}
+
+// AddExpression parses a boolean filter expression (the q= parameter) and adds it
+// Events are kept only if they satisfy every expression as well as all other filters
+func (e *Engine) AddExpression(input string) error {
+	expr, err := e.ParseExpression(input)
+	if err != nil {
+		return err
+	}
+	e.exprs = append(e.exprs, expr)
+	return nil
+}
+
+// GetExpressions returns the parsed q= expressions for display purposes
+func (e *Engine) GetExpressions() []Expr {
+	return e.exprs
+}
+
+// Expression returns the keep-expression evaluated for every event
+// Normal filters compile to NOT term (remove on match), inverted filters to a plain
+// term (remove unless matched), and everything is ANDed in order with the q= expressions.
+func (e *Engine) Expression() Expr {
+	var children []Expr
+	for _, f := range e.filters {
+		var term Expr = &termExpr{filter: f}
+		if !f.Invert {
+			term = &notExpr{child: term}
+		}
+		children = append(children, term)
+	}
+	children = append(children, e.exprs...)
+
+	if len(children) == 1 {
+		return children[0]
+	}
+	return &andExpr{children: children}
+}
+
 // Apply applies all filters to a calendar and returns the filtered calendar
-// Also returns match results for debug mode
-func (e *Engine) Apply(cal *parser.Calendar) (*parser.Calendar, []MatchResult) {
+// Also returns match results for debug mode. Filtering stops with a *BudgetError
+// once it has taken longer than regex.max_execution_time.
+func (e *Engine) Apply(cal *parser.Calendar) (*parser.Calendar, []MatchResult, error) {
+	return e.ApplyContext(context.Background(), cal)
+}
+
+// ApplyContext is Apply, stopping early if ctx is cancelled and logging a summary
+// under the request ID in ctx
+func (e *Engine) ApplyContext(ctx context.Context, cal *parser.Calendar) (*parser.Calendar, []MatchResult, error) {
+	start := time.Now()
+	budget := e.cfg.Regex.MaxExecutionTime
 	var filteredEvents []*parser.Event
 	var matchResults []MatchResult
 
-	for _, event := range cal.Events {
-		keep := e.shouldKeepEvent(event, &matchResults)
+	expr := e.Expression()
+	for i, event := range cal.Events {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		keep := e.shouldKeepEvent(expr, event, &matchResults)
 		if keep {
 			filteredEvents = append(filteredEvents, event)
 		}
+		if budget > 0 && time.Since(start) > budget {
+			logging.FromContext(ctx).Warn("filtering timed out",
+				"events_done", i+1, "events_in", len(cal.Events), "budget", budget)
+			return nil, nil, deadlineError(expr, budget, i+1, len(cal.Events))
+		}
 	}
 
+	logging.FromContext(ctx).Debug("filtered calendar",
+		"events_in", len(cal.Events), "events_out", len(filteredEvents),
+		"filters", len(e.filters), "expressions", len(e.exprs), "duration", time.Since(start))
+
 	return &parser.Calendar{
-		Events: filteredEvents,
-		Raw:    cal.Raw,
-	}, matchResults
+		Events:   filteredEvents,
+		Raw:      cal.Raw,
+		Location: cal.Location,
+	}, matchResults, nil
 }
 
 // shouldKeepEvent determines if an event should be kept based on all filters
 // Returns true if the event should be kept, false if it should be removed
-func (e *Engine) shouldKeepEvent(event *parser.Event, matchResults *[]MatchResult) bool {
-	// If no filters, keep everything
-	if len(e.filters) == 0 {
-		return true
+func (e *Engine) shouldKeepEvent(expr Expr, event *parser.Event, matchResults *[]MatchResult) bool {
+	// Drop events outside the date window before running any regex
+	if e.window != nil && !e.inWindow(event) {
+		*matchResults = append(*matchResults, MatchResult{
+			EventUID:     event.UID,
+			EventSummary: event.Summary,
+			FilterRaw:    "date window " + e.window.String(),
+			Field:        "DTSTART",
+			MatchedText:  event.DTStart,
+		})
+		return false
 	}
 
-	// Apply each filter
-	for _, filter := range e.filters {
-		matched, field, matchedText := e.matchFilter(filter, event)
-
-		if matched {
-			// Record the match for debug mode
-			*matchResults = append(*matchResults, MatchResult{
-				EventUID:     event.UID,
-				EventSummary: event.Summary,
-				FilterRaw:    filter.Raw,
-				Field:        field,
-				MatchedText:  matchedText,
-			})
-
-			// If inverted filter (like ConfirmedOnly), we want to REMOVE events that DON'T match
-			// So if it matches an inverted filter, keep going (don't remove yet)
-			// If inverted filter doesn't match, remove the event
-			if filter.Invert {
-				// Match found on inverted filter - keep going to check other filters
-				continue
-			} else {
-				// Match found on normal filter - remove this event
-				return false
-			}
-		} else {
-			// No match
-			if filter.Invert {
-				// Inverted filter didn't match - remove the event
-				return false
-			}
-			// Normal filter didn't match - keep going to check other filters
-		}
+	// AND short-circuits on the first removing filter, matching the old remove/keep chain:
+	// a normal filter that matches removes the event, an inverted filter that
+	// doesn't match removes it. Matching terms are only reported for removed events;
+	// under NOT or OR a match can just as well be what keeps one.
+	recorded := len(*matchResults)
+	keep := expr.Eval(event, matchResults)
+	if keep {
+		*matchResults = (*matchResults)[:recorded]
 	}
+	return keep
+}
 
-	// If we get here, the event passed all filters
-	return true
+// inWindow checks if an event overlaps the engine's date window
+func (e *Engine) inWindow(event *parser.Event) bool {
+	if event.IsRecurring() {
+		// Occurrences may continue into the window; only reject series starting after
+		// it or ending before it
+		if event.Start.IsZero() {
+			return true
+		}
+		if !e.window.To.IsZero() && !event.Start.Before(e.window.To) {
+			return false
+		}
+		if end, ok := event.SeriesEnd(); ok && !e.window.From.IsZero() && end.Before(e.window.From) {
+			return false
+		}
+		return true
+	}
+	return event.Overlaps(e.window.From, e.window.To)
 }
 
 // matchFilter checks if a single filter matches an event
+// A field with several values matches if any of them does.
 // Returns (matched, fieldName, matchedText)
-func (e *Engine) matchFilter(filter Filter, event *parser.Event) (bool, string, string) {
+func matchFilter(filter Filter, event *parser.Event) (bool, string, string) {
 	for _, field := range filter.Fields {
-		value := event.GetField(field)
-		if value == "" {
-			continue
-		}
-
-		if filter.Pattern.MatchString(value) {
-			return true, field, value
+		for _, value := range event.GetFieldValues(field) {
+			if value != "" && filter.Pattern.MatchString(value) {
+				return true, field, value
+			}
 		}
 	}
 	return false, "", ""
@@ -1,7 +1,9 @@
 package filter
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/linus/recal/internal/config"
 	"github.com/linus/recal/internal/parser"
@@ -191,7 +193,10 @@ func TestApplyNoFilters(t *testing.T) {
 		},
 	}
 
-	filtered, matches := engine.Apply(cal)
+	filtered, matches, err := engine.Apply(cal)
+	if err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
 
 	if len(filtered.Events) != 3 {
 		t.Errorf("Expected 3 events, got %d (no filters should keep all events)", len(filtered.Events))
@@ -221,7 +226,10 @@ func TestApplyBasicFilter(t *testing.T) {
 		},
 	}
 
-	filtered, matches := engine.Apply(cal)
+	filtered, matches, err := engine.Apply(cal)
+	if err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
 
 	// Should remove the 2 events with "Meeting" in summary
 	if len(filtered.Events) != 1 {
@@ -259,7 +267,10 @@ func TestApplyGradFilter(t *testing.T) {
 		},
 	}
 
-	filtered, matches := engine.Apply(cal)
+	filtered, matches, err := engine.Apply(cal)
+	if err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
 
 	// Should remove events with Grad 3 and Grad 7 (grades above threshold of 2)
 	if len(filtered.Events) != 3 {
@@ -301,7 +312,10 @@ func TestApplyLogeFilter(t *testing.T) {
 		},
 	}
 
-	filtered, matches := engine.Apply(cal)
+	filtered, matches, err := engine.Apply(cal)
+	if err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
 
 	// Should remove events matching Moderlogen and Göta patterns
 	if len(filtered.Events) != 2 {
@@ -343,7 +357,10 @@ func TestApplyConfirmedOnlyFilter(t *testing.T) {
 		},
 	}
 
-	filtered, _ := engine.Apply(cal)
+	filtered, _, err := engine.Apply(cal)
+	if err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
 
 	// Should KEEP only CONFIRMED events (inverted filter)
 	if len(filtered.Events) != 2 {
@@ -377,7 +394,10 @@ func TestApplyInstalltFilter(t *testing.T) {
 		},
 	}
 
-	filtered, matches := engine.Apply(cal)
+	filtered, matches, err := engine.Apply(cal)
+	if err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
 
 	// Should remove events with "INSTÄLLT" in summary
 	if len(filtered.Events) != 2 {
@@ -423,7 +443,10 @@ func TestApplyMultipleFilters(t *testing.T) {
 		},
 	}
 
-	filtered, matches := engine.Apply(cal)
+	filtered, matches, err := engine.Apply(cal)
+	if err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
 
 	// Should remove:
 	// - Event 2 (INSTÄLLT)
@@ -471,7 +494,10 @@ func TestApplyMultipleFieldsFilter(t *testing.T) {
 		},
 	}
 
-	filtered, matches := engine.Apply(cal)
+	filtered, matches, err := engine.Apply(cal)
+	if err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
 
 	// Should remove events 2, 3, and 4 (all have "important" in SUMMARY or DESCRIPTION)
 	if len(filtered.Events) != 1 {
@@ -519,3 +545,172 @@ func TestGetStats(t *testing.T) {
 		t.Errorf("RemovedEvents = %d, want 3", stats.RemovedEvents)
 	}
 }
+
+// TestSections tests tracking of the filter config sections an engine depends on
+// Validates: Special filters and grad:/loge: expression terms recorded, plain patterns ignored
+func TestSections(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(e *Engine) error
+		want  string
+	}{
+		{"plain pattern", func(e *Engine) error { return e.AddFilter([]string{"SUMMARY"}, "x") }, ""},
+		{"special filters", func(e *Engine) error {
+			if err := e.AddLodgeFilter("Göta"); err != nil {
+				return err
+			}
+			return e.AddInstalltFilter()
+		}, "installt,lodge"},
+		{"expression terms", func(e *Engine) error { return e.AddExpression("grad:3 OR summary:x") }, "grade"},
+		{"grade 10", func(e *Engine) error { return e.AddGradeFilter("10") }, "grade"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine(getTestConfig())
+			if err := tt.build(engine); err != nil {
+				t.Fatalf("build error: %v", err)
+			}
+			if got := strings.Join(engine.Sections(), ","); got != tt.want {
+				t.Errorf("Sections() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseTimeBound tests from/to value parsing
+// Validates: Relative offsets, keywords, absolute dates, RFC 3339, invalid input
+func TestParseTimeBound(t *testing.T) {
+	now := time.Date(2025, 6, 15, 14, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		input   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"-30d", now.AddDate(0, 0, -30), false},
+		{"+180d", now.AddDate(0, 0, 180), false},
+		{"180d", now.AddDate(0, 0, 180), false},
+		{"+2w", now.AddDate(0, 0, 14), false},
+		{"-12h", now.Add(-12 * time.Hour), false},
+		{"now", now, false},
+		{"today", time.Date(2025, 6, 15, 0, 0, 0, 0, time.UTC), false},
+		{"2025-01-31", time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC), false},
+		{"20250131", time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC), false},
+		{"2025-01-31T18:00:00+01:00", time.Date(2025, 1, 31, 17, 0, 0, 0, time.UTC), false},
+		{"", time.Time{}, true},
+		{"yesterday", time.Time{}, true},
+		{"+30y", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseTimeBound(tt.input, now, time.UTC)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTimeBound(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if !tt.wantErr && !got.Equal(tt.want) {
+				t.Errorf("ParseTimeBound(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestApplyDateRangeFilter tests the date window filter
+// Validates: Past/future events removed, overlapping and undated events kept, recurring series
+func TestApplyDateRangeFilter(t *testing.T) {
+	cfg := getTestConfig()
+	engine := NewEngine(cfg)
+
+	day := func(d int) time.Time { return time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	if err := engine.AddDateRangeFilter(day(10), day(20)); err != nil {
+		t.Fatalf("AddDateRangeFilter() failed: %v", err)
+	}
+
+	cal := &parser.Calendar{
+		Events: []*parser.Event{
+			{UID: "past", Start: day(1), End: day(2)},
+			{UID: "inside", Start: day(12), End: day(13)},
+			{UID: "overlap", Start: day(9), End: day(11)},
+			{UID: "future", Start: day(25), End: day(26)},
+			{UID: "undated"},
+		},
+	}
+
+	filtered, matches, err := engine.Apply(cal)
+	if err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
+
+	want := []string{"inside", "overlap", "undated"}
+	if len(filtered.Events) != len(want) {
+		t.Fatalf("Expected %d events, got %d", len(want), len(filtered.Events))
+	}
+	for i, uid := range want {
+		if filtered.Events[i].UID != uid {
+			t.Errorf("Event[%d].UID = %q, want %q", i, filtered.Events[i].UID, uid)
+		}
+	}
+
+	if len(matches) != 2 {
+		t.Errorf("Expected 2 matches (removed events), got %d", len(matches))
+	}
+}
+
+// TestApplyDateRangeRecurring tests the date window on recurring series without expand
+// Validates: Series that ended before the window or start after it are removed, open-ended
+// and still running series kept
+func TestApplyDateRangeRecurring(t *testing.T) {
+	event := func(uid, props string) string {
+		return "BEGIN:VEVENT\r\nUID:" + uid + "\r\nDTSTAMP:20200101T000000Z\r\n" + props + "SUMMARY:" + uid + "\r\nEND:VEVENT\r\n"
+	}
+	cal, err := parser.Parse(strings.NewReader("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n" +
+		event("ended-until", "DTSTART:20200106T180000Z\r\nRRULE:FREQ=WEEKLY;UNTIL=20201231T180000Z\r\n") +
+		event("ended-count", "DTSTART:20200106T180000Z\r\nRRULE:FREQ=WEEKLY;COUNT=10\r\n") +
+		event("ended-rdate", "DTSTART:20200106T180000Z\r\nRDATE:20200201T180000Z\r\n") +
+		event("open-ended", "DTSTART:20200106T180000Z\r\nRRULE:FREQ=WEEKLY\r\n") +
+		event("running", "DTSTART:20200106T180000Z\r\nRRULE:FREQ=WEEKLY;UNTIL=20250301T180000Z\r\n") +
+		event("late-rdate", "DTSTART:20200106T180000Z\r\nRRULE:FREQ=WEEKLY;COUNT=2\r\nRDATE:20250115T180000Z\r\n") +
+		event("future", "DTSTART:20260106T180000Z\r\nRRULE:FREQ=WEEKLY\r\n") +
+		"END:VCALENDAR\r\n"))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	engine := NewEngine(getTestConfig())
+	if err := engine.AddDateRangeFilter(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("AddDateRangeFilter() failed: %v", err)
+	}
+	filtered, _, err := engine.Apply(cal)
+	if err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
+
+	var got []string
+	for _, e := range filtered.Events {
+		got = append(got, e.UID)
+	}
+	if want := []string{"open-ended", "running", "late-rdate"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Kept %v, want %v", got, want)
+	}
+}
+
+// TestAddDateRangeFilterValidation tests date window validation
+// Validates: Empty window and inverted bounds are rejected
+func TestAddDateRangeFilterValidation(t *testing.T) {
+	cfg := getTestConfig()
+	engine := NewEngine(cfg)
+
+	now := time.Now()
+
+	if err := engine.AddDateRangeFilter(time.Time{}, time.Time{}); err == nil {
+		t.Error("AddDateRangeFilter() with no bounds should fail")
+	}
+	if err := engine.AddDateRangeFilter(now, now.Add(-time.Hour)); err == nil {
+		t.Error("AddDateRangeFilter() with from after to should fail")
+	}
+	if err := engine.AddDateRangeFilter(now, time.Time{}); err != nil {
+		t.Errorf("AddDateRangeFilter() with open end failed: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestNew tests logger construction
+// Validates: Level filtering, JSON output, unknown levels and formats rejected
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "warn", "json")
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	logger.Info("hidden")
+	logger.Warn("shown", "events", 3)
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Output is not one JSON line: %q", buf.String())
+	}
+	if line["msg"] != "shown" || line["events"] != 3.0 {
+		t.Errorf("Logged %v, want the warning with its attributes", line)
+	}
+
+	if _, err := New(&buf, "loud", ""); err == nil {
+		t.Error("New() with unknown level succeeded")
+	}
+	if _, err := New(&buf, "", "xml"); err == nil {
+		t.Error("New() with unknown format succeeded")
+	}
+}
+
+// TestRequestID tests request ID generation and context propagation
+// Validates: Random IDs, validation of client IDs, request_id attribute on context loggers
+func TestRequestID(t *testing.T) {
+	a, b := NewRequestID(), NewRequestID()
+	if len(a) != 16 || a == b || !ValidRequestID(a) {
+		t.Errorf("NewRequestID() = %q, %q, want distinct valid 16 character IDs", a, b)
+	}
+
+	for id, want := range map[string]bool{
+		"abc-123_x.y":             true,
+		"":                        false,
+		"two words":               false,
+		"line\nbreak":             false,
+		strings.Repeat("a", 65):   false,
+		"550e8400-e29b-41d4-a716": true,
+	} {
+		if got := ValidRequestID(id); got != want {
+			t.Errorf("ValidRequestID(%q) = %v, want %v", id, got, want)
+		}
+	}
+
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	if RequestID(ctx) != "req-1" || RequestID(context.Background()) != "" {
+		t.Error("RequestID() did not return the stored ID")
+	}
+	FromContext(ctx).Info("fetched")
+	FromContext(context.Background()).Info("startup")
+	if out := buf.String(); !strings.Contains(out, "msg=fetched request_id=req-1") || strings.Count(out, "request_id") != 1 {
+		t.Errorf("Log output = %q, want request_id only on the request line", out)
+	}
+}
@@ -1,156 +1,199 @@
 package metrics
 
 import (
+	"sort"
 	"sync"
 	"time"
 )
 
-// RequestMetrics tracks HTTP request statistics
+// Requests are counted in one-minute buckets in a ring covering the longest
+// window, so memory does not grow with traffic and windows are accurate to a minute.
+const (
+	bucketWidth = time.Minute
+	numBuckets  = int64(24 * time.Hour / bucketWidth)
+)
+
+// RequestKey identifies the requests counted together
+type RequestKey struct {
+	Endpoint string // Route pattern, e.g. "/query"
+	Status   int
+}
+
+// RequestCount is the number of requests for one endpoint and status
+type RequestCount struct {
+	RequestKey
+	Count int64
+}
+
+// bucket counts the requests of one minute
+type bucket struct {
+	minute int64 // Minutes since the Unix epoch; the bucket is stale if it is not current
+	total  int64
+	counts map[RequestKey]int64
+}
+
+// RequestMetrics tracks HTTP request statistics over sliding windows of up to 24 hours
 type RequestMetrics struct {
-	mu          sync.RWMutex
-	requests5m  []time.Time // Last 5 minutes
-	requests1h  []time.Time // Last 1 hour
-	requests24h []time.Time // Last 24 hours
+	mu      sync.Mutex
+	buckets [numBuckets]bucket
+	now     func() time.Time // Replaced in tests
+
+	// Background cleanup, see StartCleanup
+	stop chan struct{}
+	done chan struct{}
 }
 
 // NewRequestMetrics creates a new request metrics tracker
 func NewRequestMetrics() *RequestMetrics {
-	m := &RequestMetrics{
-		requests5m:  make([]time.Time, 0),
-		requests1h:  make([]time.Time, 0),
-		requests24h: make([]time.Time, 0),
-	}
-	// Start background cleanup goroutine
-	go m.cleanup()
-	return m
+	return &RequestMetrics{now: time.Now}
 }
 
-// RecordRequest records a new request
-func (m *RequestMetrics) RecordRequest() {
+// Record counts a request to endpoint answered with status
+func (m *RequestMetrics) Record(endpoint string, status int) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	now := time.Now()
-	m.requests5m = append(m.requests5m, now)
-	m.requests1h = append(m.requests1h, now)
-	m.requests24h = append(m.requests24h, now)
+	minute := m.minute()
+	b := &m.buckets[minute%numBuckets]
+	if b.minute != minute {
+		// The slot last held a minute that has left every window
+		b.minute = minute
+		b.total = 0
+		clear(b.counts)
+	}
+	if b.counts == nil {
+		b.counts = make(map[RequestKey]int64)
+	}
+	b.total++
+	b.counts[RequestKey{Endpoint: endpoint, Status: status}]++
 }
 
 // GetStats returns request counts for different time windows
 func (m *RequestMetrics) GetStats() (count5m, count1h, count24h int) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	now := time.Now()
-	cutoff5m := now.Add(-5 * time.Minute)
-	cutoff1h := now.Add(-1 * time.Hour)
-	cutoff24h := now.Add(-24 * time.Hour)
-
-	// Count requests within each time window
-	for _, t := range m.requests5m {
-		if t.After(cutoff5m) {
-			count5m++
-		}
-	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	for _, t := range m.requests1h {
-		if t.After(cutoff1h) {
-			count1h++
+	now := m.minute()
+	for i := range m.buckets {
+		b := &m.buckets[i]
+		age := now - b.minute
+		if b.total == 0 || age < 0 || age >= numBuckets {
+			continue
 		}
-	}
-
-	for _, t := range m.requests24h {
-		if t.After(cutoff24h) {
-			count24h++
+		count24h += int(b.total)
+		if age < int64(time.Hour/bucketWidth) {
+			count1h += int(b.total)
+		}
+		if age < int64(5*time.Minute/bucketWidth) {
+			count5m += int(b.total)
 		}
 	}
-
 	return
 }
 
-// cleanup removes old entries periodically
-func (m *RequestMetrics) cleanup() {
-	ticker := time.NewTicker(1 * time.Minute)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		m.mu.Lock()
-
-		now := time.Now()
-		cutoff5m := now.Add(-5 * time.Minute)
-		cutoff1h := now.Add(-1 * time.Hour)
-		cutoff24h := now.Add(-24 * time.Hour)
-
-		// Clean 5 minute window
-		m.requests5m = filterOldRequests(m.requests5m, cutoff5m)
-
-		// Clean 1 hour window
-		m.requests1h = filterOldRequests(m.requests1h, cutoff1h)
-
-		// Clean 24 hour window
-		m.requests24h = filterOldRequests(m.requests24h, cutoff24h)
+// Breakdown returns request counts per endpoint and status within window
+// (rounded up to whole minutes, at most 24 hours), largest first.
+func (m *RequestMetrics) Breakdown(window time.Duration) []RequestCount {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-		m.mu.Unlock()
+	minutes := int64((window + bucketWidth - 1) / bucketWidth)
+	now := m.minute()
+	totals := make(map[RequestKey]int64)
+	for i := range m.buckets {
+		b := &m.buckets[i]
+		if age := now - b.minute; age < 0 || age >= minutes || age >= numBuckets {
+			continue
+		}
+		for key, n := range b.counts {
+			totals[key] += n
+		}
 	}
-}
 
-// filterOldRequests removes requests older than cutoff
-func filterOldRequests(requests []time.Time, cutoff time.Time) []time.Time {
-	filtered := make([]time.Time, 0, len(requests))
-	for _, t := range requests {
-		if t.After(cutoff) {
-			filtered = append(filtered, t)
-		}
+	counts := make([]RequestCount, 0, len(totals))
+	for key, n := range totals {
+		counts = append(counts, RequestCount{RequestKey: key, Count: n})
 	}
-	return filtered
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		if counts[i].Endpoint != counts[j].Endpoint {
+			return counts[i].Endpoint < counts[j].Endpoint
+		}
+		return counts[i].Status < counts[j].Status
+	})
+	return counts
 }
 
-// CacheMetrics tracks cache performance statistics
-type CacheMetrics struct {
-	mu    sync.RWMutex
-	hits  int64
-	misses int64
-}
+// StartCleanup releases the counters of buckets older than 24 hours every interval
+// Buckets are reused as the ring wraps, so this only returns memory after a
+// traffic peak. Calling it while cleanup is running does nothing; StopCleanup stops it.
+func (m *RequestMetrics) StartCleanup(interval time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-// NewCacheMetrics creates a new cache metrics tracker
-func NewCacheMetrics() *CacheMetrics {
-	return &CacheMetrics{}
+	if m.stop != nil || interval <= 0 {
+		return
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	m.stop, m.done = stop, done
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.cleanup()
+			case <-stop:
+				return
+			}
+		}
+	}()
 }
 
-// RecordHit records a cache hit
-func (m *CacheMetrics) RecordHit() {
+// StopCleanup stops the background cleanup and waits for it to exit
+// It is safe to call when cleanup is not running.
+func (m *RequestMetrics) StopCleanup() {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-	m.hits++
+	stop, done := m.stop, m.done
+	m.stop, m.done = nil, nil
+	m.mu.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
 }
 
-// RecordMiss records a cache miss
-func (m *CacheMetrics) RecordMiss() {
+// cleanup drops buckets that have left the 24 hour window
+func (m *RequestMetrics) cleanup() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.misses++
-}
-
-// GetStats returns cache hit/miss statistics
-func (m *CacheMetrics) GetStats() (hits, misses int64, ratio float64) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	hits = m.hits
-	misses = m.misses
-	total := hits + misses
 
-	if total > 0 {
-		ratio = float64(hits) / float64(total)
+	now := m.minute()
+	for i := range m.buckets {
+		b := &m.buckets[i]
+		if b.counts != nil && now-b.minute >= numBuckets {
+			m.buckets[i] = bucket{}
+		}
 	}
+}
 
-	return
+// minute returns the current bucket index. Must be called with lock held.
+func (m *RequestMetrics) minute() int64 {
+	return m.now().Unix() / int64(bucketWidth/time.Second)
 }
 
-// Reset resets all metrics
-func (m *CacheMetrics) Reset() {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	m.hits = 0
-	m.misses = 0
+// counters returns the number of per-key counters held. Must be called with lock held.
+func (m *RequestMetrics) counters() int {
+	n := 0
+	for i := range m.buckets {
+		n += len(m.buckets[i].counts)
+	}
+	return n
 }
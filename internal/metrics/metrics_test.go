@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock returns a RequestMetrics whose clock is advanced by the returned function
+func fakeClock() (*RequestMetrics, func(time.Duration)) {
+	now := time.Date(2025, 1, 10, 12, 0, 30, 0, time.UTC)
+	m := NewRequestMetrics()
+	m.now = func() time.Time { return now }
+	return m, func(d time.Duration) { now = now.Add(d) }
+}
+
+// TestRequestWindows tests sliding window counts
+// Validates: Requests leave the 5m, 1h and 24h windows as time passes
+func TestRequestWindows(t *testing.T) {
+	m, advance := fakeClock()
+
+	m.Record("/query", 200)
+	advance(30 * time.Minute)
+	m.Record("/query", 200)
+	m.Record("/f/", 404)
+	advance(3 * time.Minute)
+	m.Record("/status", 200)
+
+	check := func(when string, want5m, want1h, want24h int) {
+		t.Helper()
+		c5m, c1h, c24h := m.GetStats()
+		if c5m != want5m || c1h != want1h || c24h != want24h {
+			t.Errorf("%s: GetStats() = %d, %d, %d, want %d, %d, %d", when, c5m, c1h, c24h, want5m, want1h, want24h)
+		}
+	}
+	check("now", 3, 4, 4)
+	advance(10 * time.Minute)
+	check("after 10m", 0, 4, 4)
+	advance(time.Hour)
+	check("after 1h", 0, 0, 4)
+	advance(23 * time.Hour)
+	check("after 24h", 0, 0, 0)
+}
+
+// TestRequestBreakdown tests per endpoint and status counts
+// Validates: Grouping by endpoint and status, window limit, ordering by count
+func TestRequestBreakdown(t *testing.T) {
+	m, advance := fakeClock()
+
+	m.Record("/query", 200)
+	advance(2 * time.Hour)
+	for range 3 {
+		m.Record("/query", 200)
+	}
+	m.Record("/query", 502)
+	m.Record("/f/", 200)
+
+	got := m.Breakdown(time.Hour)
+	want := []RequestCount{
+		{RequestKey{"/query", 200}, 3},
+		{RequestKey{"/f/", 200}, 1},
+		{RequestKey{"/query", 502}, 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Breakdown(1h) = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Breakdown(1h)[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if got := m.Breakdown(24 * time.Hour); len(got) != 3 || got[0].Count != 4 {
+		t.Errorf("Breakdown(24h) = %+v, want /query 200 counted 4 times", got)
+	}
+}
+
+// TestRequestMemoryBound tests that memory does not grow with traffic
+// Validates: Counters are bounded by buckets times distinct keys, idle buckets are released
+func TestRequestMemoryBound(t *testing.T) {
+	m, advance := fakeClock()
+	endpoints := []string{"/query", "/f/", "/status"}
+
+	// Three days of steady traffic, far more requests than counters
+	requests := 0
+	for minute := 0; minute < 3*24*60; minute++ {
+		for i := range 20 {
+			m.Record(endpoints[i%len(endpoints)], 200)
+			requests++
+		}
+		advance(time.Minute)
+	}
+
+	maxCounters := int(numBuckets) * len(endpoints)
+	if n := m.counters(); n > maxCounters {
+		t.Errorf("%d counters after %d requests, want at most %d", n, requests, maxCounters)
+	}
+	// The current minute has no requests yet, the 1439 before it are full
+	if _, _, c24h := m.GetStats(); c24h != (24*60-1)*20 {
+		t.Errorf("24h count = %d, want %d", c24h, (24*60-1)*20)
+	}
+
+	// After a day without traffic cleanup releases every bucket
+	advance(24 * time.Hour)
+	m.cleanup()
+	if n := m.counters(); n != 0 {
+		t.Errorf("%d counters after idle cleanup, want 0", n)
+	}
+}
+
+// TestRequestCleanupStop tests the background cleanup lifecycle
+// Validates: Start is idempotent, Stop waits for the goroutine and can be repeated
+func TestRequestCleanupStop(t *testing.T) {
+	m := NewRequestMetrics()
+	m.StopCleanup() // Not running yet
+
+	m.StartCleanup(time.Millisecond)
+	m.StartCleanup(time.Millisecond)
+	done := m.done
+	time.Sleep(5 * time.Millisecond)
+
+	m.StopCleanup()
+	select {
+	case <-done:
+	default:
+		t.Fatal("Cleanup goroutine still running after StopCleanup")
+	}
+	m.StopCleanup()
+}
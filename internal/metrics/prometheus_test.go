@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestRegistryText tests the Prometheus text exposition output
+// Validates: HELP/TYPE lines, sorted labelled series, cumulative buckets, escaping, func collectors
+func TestRegistryText(t *testing.T) {
+	r := NewRegistry()
+
+	requests := r.NewCounterVec("test_requests_total", "Requests handled.", "endpoint", "code")
+	requests.Inc("/query", "200")
+	requests.Inc("/query", "200")
+	requests.Add(3, "/f/", "404")
+
+	latency := r.NewHistogramVec("test_duration_seconds", "Request latency.", []float64{1, 0.1}, "endpoint")
+	latency.Observe(0.05, "/query")
+	latency.Observe(0.5, "/query")
+	latency.Observe(2, "/query")
+
+	r.RegisterFunc(func() []Family {
+		return []Family{{
+			Name: "test_entries",
+			Help: "Entries with a \\ and\na newline.",
+			Type: TypeGauge,
+			Samples: []Sample{
+				{Labels: []Label{{Name: "cache", Value: `say "hi"`}}, Value: 7},
+			},
+		}}
+	})
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("WriteText() error: %v", err)
+	}
+
+	want := `# HELP test_requests_total Requests handled.
+# TYPE test_requests_total counter
+test_requests_total{endpoint="/f/",code="404"} 3
+test_requests_total{endpoint="/query",code="200"} 2
+# HELP test_duration_seconds Request latency.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{endpoint="/query",le="0.1"} 1
+test_duration_seconds_bucket{endpoint="/query",le="1"} 2
+test_duration_seconds_bucket{endpoint="/query",le="+Inf"} 3
+test_duration_seconds_sum{endpoint="/query"} 2.55
+test_duration_seconds_count{endpoint="/query"} 3
+# HELP test_entries Entries with a \\ and\na newline.
+# TYPE test_entries gauge
+test_entries{cache="say \"hi\""} 7
+`
+	if b.String() != want {
+		t.Errorf("WriteText() =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+// TestRegistryServeHTTP tests the scrape endpoint
+// Validates: Content type, method check
+func TestRegistryServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("test_total", "Test.").Inc()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("GET status = %d, Content-Type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Body.String(), "test_total 1\n") {
+		t.Errorf("Body missing unlabelled sample:\n%s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/metrics", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+// TestLabelMismatch tests that using the wrong number of label values panics
+// Validates: Programming errors are caught instead of producing invalid output
+func TestLabelMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Inc() with missing label value did not panic")
+		}
+	}()
+	NewRegistry().NewCounterVec("test_total", "Test.", "endpoint").Inc()
+}
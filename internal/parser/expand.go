@@ -0,0 +1,463 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-ical"
+	"github.com/teambition/rrule-go"
+)
+
+// Limits protecting expansion from pathological rules (e.g. FREQ=SECONDLY since 1970)
+const (
+	// MaxOccurrences is the maximum number of instances materialised per series
+	MaxOccurrences = 1000
+
+	// maxIterations bounds how many recurrence dates are generated per series,
+	// including those skipped because they fall before the window
+	maxIterations = 100000
+)
+
+// Expand materialises recurring events into one event per occurrence within [from, to)
+// Each instance is a standalone VEVENT: RRULE, RDATE and EXDATE are removed, DTSTART and
+// DTEND are moved to the occurrence, and the UID gets the occurrence time appended so
+// instances stay unique. RECURRENCE-ID overrides from the feed replace the instance they
+// modify. Non-recurring events are passed through unchanged. Masters whose rule cannot be
+// parsed are also passed through unchanged so no data is lost.
+func (c *Calendar) Expand(from, to time.Time) *Calendar {
+	// Index overrides by UID so they can replace generated instances
+	overrides := make(map[string][]*Event)
+	masters := make(map[string]bool)
+	for _, event := range c.Events {
+		if event.IsRecurring() {
+			masters[event.UID] = true
+		}
+	}
+	for _, event := range c.Events {
+		if event.recurrenceIDProp() != nil && masters[event.UID] {
+			overrides[event.UID] = append(overrides[event.UID], event)
+		}
+	}
+
+	var events []*Event
+	for _, event := range c.Events {
+		switch {
+		case event.IsRecurring():
+			instances, ok := event.expandSeries(from, to, overrides[event.UID], c.Location)
+			if !ok {
+				events = append(events, event)
+				continue
+			}
+			events = append(events, instances...)
+		case event.recurrenceIDProp() != nil && masters[event.UID]:
+			// Emitted by expandSeries in place of the instance it overrides
+			continue
+		default:
+			events = append(events, event)
+		}
+	}
+
+	return &Calendar{
+		Events:   events,
+		Raw:      c.Raw,
+		Location: c.Location,
+	}
+}
+
+// Collapse re-emits recurring series in their compact form after filtering
+// The receiver is the filtered subset of expanded, which must come from Expand.
+// Each series master is emitted once; surviving overrides are emitted after their
+// master with their RECURRENCE-ID restored. If the window covered the whole series,
+// the master keeps its rule with an EXDATE for every instance that was filtered out.
+// Otherwise only the occurrences the filters saw can be vouched for, so the master is
+// bounded to the surviving ones: DTSTART moves to the first and the rest become
+// RDATEs. Series with no surviving instances are dropped.
+func (c *Calendar) Collapse(expanded *Calendar) *Calendar {
+	kept := make(map[*Event]bool, len(c.Events))
+	for _, event := range c.Events {
+		kept[event] = true
+	}
+
+	// Group the expanded instances by series
+	type series struct {
+		master    *Event
+		instances []*Event
+	}
+	bySeries := make(map[*Event]*series)
+	for _, event := range expanded.Events {
+		if event.Master == nil {
+			continue
+		}
+		s, ok := bySeries[event.Master]
+		if !ok {
+			s = &series{master: event.Master}
+			bySeries[event.Master] = s
+		}
+		s.instances = append(s.instances, event)
+	}
+
+	var events []*Event
+	emitted := make(map[*Event]bool)
+	for _, event := range expanded.Events {
+		if event.Master == nil {
+			if kept[event] {
+				events = append(events, event)
+			}
+			continue
+		}
+		if emitted[event.Master] {
+			continue
+		}
+		emitted[event.Master] = true
+
+		s := bySeries[event.Master]
+		anyKept := false
+		for _, instance := range s.instances {
+			if kept[instance] {
+				anyKept = true
+				break
+			}
+		}
+		if !anyKept {
+			continue
+		}
+
+		master := s.master.Clone()
+		partial := s.instances[0].partial
+		var occurrences []time.Time
+		var keptOverrides []*Event
+		for _, instance := range s.instances {
+			if !kept[instance] {
+				if !partial {
+					exdate := ical.NewProp(ical.PropExceptionDates)
+					setTimeLike(exdate, master.RawEvent.Props.Get(ical.PropDateTimeStart), instance.RecurrenceID)
+					master.RawEvent.Props.Add(exdate)
+				}
+				continue
+			}
+			occurrences = append(occurrences, instance.RecurrenceID)
+			if instance.isOverride {
+				keptOverrides = append(keptOverrides, instance.reattach(s.master))
+			}
+		}
+		if partial {
+			master.limitTo(occurrences)
+		}
+
+		events = append(events, master)
+		events = append(events, keptOverrides...)
+	}
+
+	return &Calendar{
+		Events:   events,
+		Raw:      c.Raw,
+		Location: c.Location,
+	}
+}
+
+// expandSeries generates the instances of a recurring master within [from, to)
+// Returns false if the recurrence rule cannot be evaluated
+func (e *Event) expandSeries(from, to time.Time, overrides []*Event, loc *time.Location) ([]*Event, bool) {
+	if e.Start.IsZero() {
+		return nil, false
+	}
+
+	set, err := e.recurrenceSet(loc)
+	if err != nil {
+		return nil, false
+	}
+
+	duration := e.End.Sub(e.Start)
+	if duration < 0 {
+		duration = 0
+	}
+
+	// Match overrides to the occurrence they replace by RECURRENCE-ID
+	byRecurrenceID := make(map[int64]*Event, len(overrides))
+	for _, override := range overrides {
+		if rid, err := ParseDateTime(override.recurrenceIDProp(), loc); err == nil {
+			byRecurrenceID[rid.Unix()] = override
+		}
+	}
+
+	var instances []*Event
+	skipped, exhausted := false, false
+	next := set.Iterator()
+	for i := 0; i < maxIterations && len(instances) < MaxOccurrences; i++ {
+		occurrence, ok := next()
+		if !ok {
+			exhausted = true
+			break
+		}
+		if !to.IsZero() && !occurrence.Before(to) {
+			break
+		}
+		if !from.IsZero() && !occurrence.Add(duration).After(from) && !occurrence.Equal(from) {
+			skipped = true
+			continue
+		}
+
+		if override, ok := byRecurrenceID[occurrence.Unix()]; ok {
+			delete(byRecurrenceID, occurrence.Unix())
+			instances = append(instances, override.detach(e, occurrence))
+			continue
+		}
+		instances = append(instances, e.instanceAt(occurrence, duration))
+	}
+
+	// Overrides moved into the window from an occurrence outside it
+	for rid, override := range byRecurrenceID {
+		if override.Overlaps(from, to) {
+			instances = append(instances, override.detach(e, time.Unix(rid, 0).In(e.Start.Location())))
+		}
+	}
+
+	// Collapse can only keep the rule if the filters saw every occurrence
+	for _, instance := range instances {
+		instance.partial = skipped || !exhausted
+	}
+	return instances, true
+}
+
+// recurrenceSet builds the rrule set from RRULE, RDATE and EXDATE
+func (e *Event) recurrenceSet(loc *time.Location) (*rrule.Set, error) {
+	props := e.RawEvent.Props
+	set := &rrule.Set{}
+
+	roption, err := props.RecurrenceRule()
+	if err != nil {
+		return nil, err
+	}
+	if roption != nil {
+		roption.Dtstart = e.Start
+		rule, err := rrule.NewRRule(*roption)
+		if err != nil {
+			return nil, err
+		}
+		set.RRule(rule)
+	}
+	set.DTStart(e.Start)
+
+	// DTSTART is always the first instance (RFC 5545 section 3.8.5.3)
+	set.RDate(e.Start)
+
+	for _, t := range listDateTimes(props.Values(ical.PropRecurrenceDates), loc) {
+		set.RDate(t)
+	}
+	for _, t := range listDateTimes(props.Values(ical.PropExceptionDates), loc) {
+		set.ExDate(t)
+	}
+
+	return set, nil
+}
+
+// SeriesEnd returns when the last occurrence of a recurring master ends
+// That is the later of the RRULE's UNTIL or COUNT-th occurrence and the last RDATE.
+// ok is false if the series does not end or its end cannot be worked out.
+func (e *Event) SeriesEnd() (end time.Time, ok bool) {
+	if e.Start.IsZero() || e.RawEvent == nil || e.RawEvent.Component == nil {
+		return time.Time{}, false
+	}
+	props := e.RawEvent.Props
+	loc := e.Start.Location()
+	last := e.Start
+
+	if prop := props.Get(ical.PropRecurrenceRule); prop != nil {
+		roption, err := rrule.StrToROptionInLocation(prop.Value, loc)
+		if err != nil {
+			return time.Time{}, false
+		}
+		switch {
+		case !roption.Until.IsZero():
+			last = roption.Until
+			if untilIsDate(prop.Value) {
+				// Occurrences on the UNTIL date itself are included
+				last = last.AddDate(0, 0, 1)
+			}
+		case roption.Count > 0:
+			roption.Dtstart = e.Start
+			rule, err := rrule.NewRRule(*roption)
+			if err != nil {
+				return time.Time{}, false
+			}
+			next := rule.Iterator()
+			for i := 0; ; i++ {
+				occurrence, more := next()
+				if !more {
+					break
+				}
+				if i == maxIterations {
+					return time.Time{}, false
+				}
+				last = occurrence
+			}
+		default:
+			return time.Time{}, false
+		}
+	}
+
+	for _, prop := range props.Values(ical.PropRecurrenceDates) {
+		if prop.Params.Get(ical.ParamValue) == string(ical.ValuePeriod) {
+			return time.Time{}, false // Not parsed by listDateTimes
+		}
+	}
+	for _, t := range listDateTimes(props.Values(ical.PropRecurrenceDates), loc) {
+		if t.After(last) {
+			last = t
+		}
+	}
+	return last.Add(max(e.End.Sub(e.Start), 0)), true
+}
+
+// untilIsDate reports whether the UNTIL of an RRULE value is a DATE
+func untilIsDate(rule string) bool {
+	for _, part := range strings.Split(rule, ";") {
+		if key, value, _ := strings.Cut(part, "="); strings.EqualFold(key, "UNTIL") {
+			return len(value) == len(dateLayout)
+		}
+	}
+	return false
+}
+
+// listDateTimes parses RDATE/EXDATE properties, which may hold comma-separated lists
+// Values that cannot be parsed (including PERIOD values) are skipped
+func listDateTimes(props []ical.Prop, loc *time.Location) []time.Time {
+	var times []time.Time
+	for _, prop := range props {
+		for _, value := range strings.Split(prop.Value, ",") {
+			single := prop
+			single.Value = value
+			if t, err := ParseDateTime(&single, loc); err == nil {
+				times = append(times, t)
+			}
+		}
+	}
+	return times
+}
+
+// instanceAt creates a standalone instance of the master starting at occurrence
+func (e *Event) instanceAt(occurrence time.Time, duration time.Duration) *Event {
+	instance := e.Clone()
+	instance.moveTo(occurrence, duration)
+	instance.setInstanceUID(e, occurrence)
+	return instance
+}
+
+// limitTo replaces the recurrence of the event with the given occurrences
+// DTSTART and DTEND move to the first occurrence and the rest become RDATEs.
+func (e *Event) limitTo(occurrences []time.Time) {
+	sort.Slice(occurrences, func(i, j int) bool { return occurrences[i].Before(occurrences[j]) })
+	e.moveTo(occurrences[0], max(e.End.Sub(e.Start), 0))
+
+	props := e.RawEvent.Props
+	for _, occurrence := range occurrences[1:] {
+		rdate := ical.NewProp(ical.PropRecurrenceDates)
+		setTimeLike(rdate, props.Get(ical.PropDateTimeStart), occurrence)
+		props.Add(rdate)
+	}
+}
+
+// moveTo removes the recurrence of the event and moves DTSTART and DTEND to occurrence
+func (e *Event) moveTo(occurrence time.Time, duration time.Duration) {
+	props := e.RawEvent.Props
+
+	props.Del(ical.PropRecurrenceRule)
+	props.Del(ical.PropRecurrenceDates)
+	props.Del(ical.PropExceptionDates)
+
+	start := props.Get(ical.PropDateTimeStart)
+	newStart := ical.NewProp(ical.PropDateTimeStart)
+	setTimeLike(newStart, start, occurrence)
+	props.Set(newStart)
+
+	if end := props.Get(ical.PropDateTimeEnd); end != nil {
+		newEnd := ical.NewProp(ical.PropDateTimeEnd)
+		setTimeLike(newEnd, end, occurrence.Add(duration))
+		props.Set(newEnd)
+	}
+
+	e.Start = occurrence
+	e.End = occurrence.Add(duration)
+	e.DTStart = props.Get(ical.PropDateTimeStart).Value
+	if end := props.Get(ical.PropDateTimeEnd); end != nil {
+		e.DTEnd = end.Value
+	}
+}
+
+// detach turns a RECURRENCE-ID override into a standalone instance of master
+func (e *Event) detach(master *Event, recurrenceID time.Time) *Event {
+	instance := e.Clone()
+	instance.RawEvent.Props.Del(ical.PropRecurrenceID)
+	instance.setInstanceUID(master, recurrenceID)
+	instance.isOverride = true
+	return instance
+}
+
+// reattach turns a detached override back into a RECURRENCE-ID override of master
+func (e *Event) reattach(master *Event) *Event {
+	override := e.Clone()
+	override.UID = master.UID
+	override.RawEvent.Props.SetText(ical.PropUID, master.UID)
+
+	rid := ical.NewProp(ical.PropRecurrenceID)
+	setTimeLike(rid, master.RawEvent.Props.Get(ical.PropDateTimeStart), e.RecurrenceID)
+	override.RawEvent.Props.Set(rid)
+	return override
+}
+
+// setInstanceUID gives an instance a unique UID derived from its master and occurrence
+func (e *Event) setInstanceUID(master *Event, occurrence time.Time) {
+	e.Master = master
+	e.RecurrenceID = occurrence
+	e.UID = master.UID + "-" + occurrence.UTC().Format(dateTimeUTCLayout)
+	e.RawEvent.Props.SetText(ical.PropUID, e.UID)
+}
+
+// recurrenceIDProp returns the RECURRENCE-ID property, or nil
+func (e *Event) recurrenceIDProp() *ical.Prop {
+	if e.RawEvent == nil || e.RawEvent.Component == nil {
+		return nil
+	}
+	return e.RawEvent.Props.Get(ical.PropRecurrenceID)
+}
+
+// Clone returns a copy of the event with its own component, so edits don't leak into the source
+func (e *Event) Clone() *Event {
+	c := *e
+	c.RawEvent = &ical.Event{Component: cloneComponent(e.RawEvent.Component)}
+	return &c
+}
+
+// cloneComponent deep-copies a component's properties and children
+func cloneComponent(comp *ical.Component) *ical.Component {
+	out := ical.NewComponent(comp.Name)
+	for name, props := range comp.Props {
+		copied := make([]ical.Prop, len(props))
+		for i, prop := range props {
+			copied[i] = prop
+			copied[i].Params = make(ical.Params, len(prop.Params))
+			for k, v := range prop.Params {
+				copied[i].Params[k] = append([]string(nil), v...)
+			}
+		}
+		out.Props[name] = copied
+	}
+	for _, child := range comp.Children {
+		out.Children = append(out.Children, cloneComponent(child))
+	}
+	return out
+}
+
+// setTimeLike writes t into prop using the same form as like (DATE, UTC, or TZID local time)
+func setTimeLike(prop *ical.Prop, like *ical.Prop, t time.Time) {
+	switch {
+	case like != nil && isDateValue(like):
+		prop.SetDate(t)
+	case like != nil && like.Params.Get(ical.PropTimezoneID) != "" && !strings.HasSuffix(like.Value, "Z"):
+		prop.Params.Set(ical.PropTimezoneID, like.Params.Get(ical.PropTimezoneID))
+		prop.Value = t.Format(dateTimeLayout)
+	default:
+		prop.Value = t.UTC().Format(dateTimeUTCLayout)
+	}
+}
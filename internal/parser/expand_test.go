@@ -0,0 +1,272 @@
+package parser
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recurringFeed is a weekly series with an EXDATE, an RDATE and a cancelled override
+const recurringFeed = `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//EN
+BEGIN:VEVENT
+UID:weekly@example.com
+DTSTAMP:20250101T000000Z
+DTSTART;TZID=Europe/Stockholm:20250106T180000
+DTEND;TZID=Europe/Stockholm:20250106T200000
+RRULE:FREQ=WEEKLY;COUNT=6
+EXDATE;TZID=Europe/Stockholm:20250113T180000
+RDATE;TZID=Europe/Stockholm:20250301T120000
+SUMMARY:Göta PB: Grad 4
+END:VEVENT
+BEGIN:VEVENT
+UID:weekly@example.com
+DTSTAMP:20250101T000000Z
+RECURRENCE-ID;TZID=Europe/Stockholm:20250120T180000
+DTSTART;TZID=Europe/Stockholm:20250120T180000
+DTEND;TZID=Europe/Stockholm:20250120T200000
+SUMMARY:INSTÄLLT Göta PB: Grad 4
+END:VEVENT
+BEGIN:VEVENT
+UID:single@example.com
+DTSTAMP:20250101T000000Z
+DTSTART:20250110T100000Z
+SUMMARY:Single
+END:VEVENT
+END:VCALENDAR`
+
+// TestExpand tests materialising recurring events
+// Validates: RRULE occurrences, EXDATE, RDATE, RECURRENCE-ID overrides, window bounds, unique UIDs
+func TestExpand(t *testing.T) {
+	cal, err := Parse(strings.NewReader(recurringFeed))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	stockholm, _ := time.LoadLocation("Europe/Stockholm")
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	expanded := cal.Expand(from, to)
+
+	// 6 weekly occurrences - 1 EXDATE + 1 RDATE, plus the single event
+	var summaries []string
+	uids := make(map[string]bool)
+	for _, event := range expanded.Events {
+		summaries = append(summaries, event.Summary)
+		if uids[event.UID] {
+			t.Errorf("Duplicate UID %q", event.UID)
+		}
+		uids[event.UID] = true
+	}
+	if len(expanded.Events) != 7 {
+		t.Fatalf("Expand() got %d events, want 7: %v", len(expanded.Events), summaries)
+	}
+
+	// The 2025-01-13 occurrence is excluded
+	for _, event := range expanded.Events {
+		if event.Start.Equal(time.Date(2025, 1, 13, 18, 0, 0, 0, stockholm)) {
+			t.Error("EXDATE occurrence was not excluded")
+		}
+	}
+
+	// The 2025-01-20 occurrence is replaced by the override
+	found := false
+	for _, event := range expanded.Events {
+		if event.RecurrenceID.Equal(time.Date(2025, 1, 20, 18, 0, 0, 0, stockholm)) {
+			found = true
+			if !strings.HasPrefix(event.Summary, "INSTÄLLT") {
+				t.Errorf("Override not applied, summary = %q", event.Summary)
+			}
+		}
+	}
+	if !found {
+		t.Error("Override occurrence missing")
+	}
+
+	// Instances are standalone: no RRULE/EXDATE/RECURRENCE-ID, DTSTART keeps its TZID
+	for _, event := range expanded.Events {
+		if event.Master == nil {
+			continue
+		}
+		props := event.RawEvent.Props
+		for _, name := range []string{"RRULE", "EXDATE", "RDATE", "RECURRENCE-ID"} {
+			if props.Get(name) != nil {
+				t.Errorf("Instance %s still has %s", event.UID, name)
+			}
+		}
+		if tzid := props.Get("DTSTART").Params.Get("TZID"); tzid != "Europe/Stockholm" {
+			t.Errorf("Instance %s DTSTART TZID = %q, want Europe/Stockholm", event.UID, tzid)
+		}
+		if got := event.End.Sub(event.Start); got != 2*time.Hour {
+			t.Errorf("Instance %s duration = %v, want 2h", event.UID, got)
+		}
+	}
+
+	// A narrow window only materialises the occurrences inside it
+	narrow := cal.Expand(time.Date(2025, 1, 25, 0, 0, 0, 0, time.UTC), time.Date(2025, 2, 5, 0, 0, 0, 0, time.UTC))
+	if len(narrow.Events) != 3 { // 2025-01-27, 2025-02-03, plus the single (non-recurring) event
+		t.Errorf("Narrow Expand() got %d events, want 3", len(narrow.Events))
+	}
+}
+
+// TestCollapse tests re-emitting filtered instances as master + EXDATE
+// Validates: Removed occurrences become EXDATEs, override removal, series dropped when empty,
+// series reaching outside the window bounded to the kept occurrences
+func TestCollapse(t *testing.T) {
+	cal, err := Parse(strings.NewReader(recurringFeed))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	expanded := cal.Expand(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC))
+
+	// Drop the cancelled override, keep everything else
+	kept := &Calendar{Raw: expanded.Raw, Location: expanded.Location}
+	for _, event := range expanded.Events {
+		if !strings.HasPrefix(event.Summary, "INSTÄLLT") {
+			kept.Events = append(kept.Events, event)
+		}
+	}
+
+	collapsed := kept.Collapse(expanded)
+	if len(collapsed.Events) != 2 {
+		t.Fatalf("Collapse() got %d events, want 2 (master + single)", len(collapsed.Events))
+	}
+
+	master := collapsed.Events[0]
+	if master.UID != "weekly@example.com" {
+		t.Errorf("Master UID = %q, want weekly@example.com", master.UID)
+	}
+	if master.RawEvent.Props.Get("RRULE") == nil {
+		t.Error("Collapsed master lost its RRULE")
+	}
+
+	var exdates []string
+	for _, prop := range master.RawEvent.Props.Values("EXDATE") {
+		exdates = append(exdates, prop.Value)
+	}
+	if len(exdates) != 2 || exdates[1] != "20250120T180000" {
+		t.Errorf("EXDATE values = %v, want original plus 20250120T180000", exdates)
+	}
+
+	// The source calendar must not be modified by expansion or collapse
+	if got := len(cal.Events[0].RawEvent.Props.Values("EXDATE")); got != 1 {
+		t.Errorf("Source master has %d EXDATEs, want 1", got)
+	}
+
+	var buf bytes.Buffer
+	if err := collapsed.Serialize(&buf); err != nil {
+		t.Fatalf("Serialize() failed: %v", err)
+	}
+
+	// Dropping every instance drops the series
+	onlySingle := &Calendar{Raw: expanded.Raw}
+	for _, event := range expanded.Events {
+		if event.Master == nil {
+			onlySingle.Events = append(onlySingle.Events, event)
+		}
+	}
+	if got := len(onlySingle.Collapse(expanded).Events); got != 1 {
+		t.Errorf("Collapse() with no instances kept got %d events, want 1", got)
+	}
+
+	// A window covering part of the series only publishes the kept occurrences in it
+	narrow := cal.Expand(time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC), time.Date(2025, 2, 5, 0, 0, 0, 0, time.UTC))
+	keptNarrow := &Calendar{Raw: narrow.Raw, Location: narrow.Location}
+	for _, event := range narrow.Events {
+		if !strings.HasPrefix(event.Summary, "INSTÄLLT") {
+			keptNarrow.Events = append(keptNarrow.Events, event)
+		}
+	}
+	bounded := keptNarrow.Collapse(narrow).Events[0]
+	props := bounded.RawEvent.Props
+	if props.Get("RRULE") != nil || props.Get("EXDATE") != nil {
+		t.Error("Bounded master still has its RRULE or EXDATEs")
+	}
+	if got := props.Get("DTSTART").Value; got != "20250127T180000" {
+		t.Errorf("Bounded DTSTART = %q, want the first kept occurrence 20250127T180000", got)
+	}
+	if got := props.Get("DTEND").Value; got != "20250127T200000" {
+		t.Errorf("Bounded DTEND = %q, want 20250127T200000", got)
+	}
+	if rdates := props.Values("RDATE"); len(rdates) != 1 || rdates[0].Value != "20250203T180000" {
+		t.Errorf("Bounded RDATEs = %v, want 20250203T180000", rdates)
+	}
+	republished := (&Calendar{Events: []*Event{bounded}}).Expand(time.Time{}, time.Time{})
+	if len(republished.Events) != 2 {
+		t.Errorf("Bounded series has %d occurrences, want the 2 kept in the window", len(republished.Events))
+	}
+}
+
+// TestExpandKeepsOverrideReattached tests that a surviving override keeps its RECURRENCE-ID
+// Validates: Override re-emitted after its master with the master UID
+func TestExpandKeepsOverrideReattached(t *testing.T) {
+	cal, err := Parse(strings.NewReader(recurringFeed))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	expanded := cal.Expand(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC))
+	collapsed := expanded.Collapse(expanded)
+
+	if len(collapsed.Events) != 3 {
+		t.Fatalf("Collapse() got %d events, want 3 (master, override, single)", len(collapsed.Events))
+	}
+
+	override := collapsed.Events[1]
+	if override.UID != "weekly@example.com" {
+		t.Errorf("Override UID = %q, want weekly@example.com", override.UID)
+	}
+	rid := override.RawEvent.Props.Get("RECURRENCE-ID")
+	if rid == nil || rid.Value != "20250120T180000" {
+		t.Errorf("Override RECURRENCE-ID = %v, want 20250120T180000", rid)
+	}
+}
+
+// TestSeriesEnd tests working out when a recurring series ends
+// Validates: UNTIL, COUNT, RDATE after the rule, floating UNTIL in the calendar zone,
+// open-ended rules and PERIOD RDATEs reported as unknown
+func TestSeriesEnd(t *testing.T) {
+	tests := []struct {
+		name   string
+		zone   string
+		props  string
+		want   time.Time
+		wantOK bool
+	}{
+		{"until", "", "DTSTART:20200106T180000Z\r\nDTEND:20200106T200000Z\r\nRRULE:FREQ=WEEKLY;UNTIL=20200302T180000Z\r\n",
+			time.Date(2020, 3, 2, 20, 0, 0, 0, time.UTC), true},
+		{"count", "", "DTSTART:20250106T180000Z\r\nDTEND:20250106T200000Z\r\nRRULE:FREQ=WEEKLY;COUNT=3\r\n",
+			time.Date(2025, 1, 20, 20, 0, 0, 0, time.UTC), true},
+		{"rdate after rule", "", "DTSTART:20250106T180000Z\r\nDTEND:20250106T200000Z\r\nRRULE:FREQ=WEEKLY;COUNT=3\r\nRDATE:20250301T120000Z\r\n",
+			time.Date(2025, 3, 1, 14, 0, 0, 0, time.UTC), true},
+		{"rdate only", "", "DTSTART:20250106T180000Z\r\nRDATE:20250110T180000Z,20250201T180000Z\r\n",
+			time.Date(2025, 2, 1, 18, 0, 0, 0, time.UTC), true},
+		{"floating until", "America/New_York", "DTSTART:20200106T180000\r\nDTEND:20200106T200000\r\nRRULE:FREQ=WEEKLY;UNTIL=20200302T000000\r\n",
+			time.Date(2020, 3, 2, 7, 0, 0, 0, time.UTC), true},
+		{"open-ended", "", "DTSTART:20200106T180000Z\r\nRRULE:FREQ=WEEKLY\r\n", time.Time{}, false},
+		{"period rdate", "", "DTSTART:20200106T180000Z\r\nRRULE:FREQ=WEEKLY;COUNT=2\r\nRDATE;VALUE=PERIOD:20300301T120000Z/PT1H\r\n", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n"
+			if tt.zone != "" {
+				header += "X-WR-TIMEZONE:" + tt.zone + "\r\n"
+			}
+			cal, err := Parse(strings.NewReader(header + "BEGIN:VEVENT\r\nUID:a\r\nDTSTAMP:20200101T000000Z\r\n" +
+				tt.props + "SUMMARY:Serie\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"))
+			if err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+
+			end, ok := cal.Events[0].SeriesEnd()
+			if ok != tt.wantOK || !end.Equal(tt.want) {
+				t.Errorf("SeriesEnd() = %v, %v, want %v, %v", end, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
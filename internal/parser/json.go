@@ -0,0 +1,327 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+// propWRCalName is the de facto calendar name property
+const propWRCalName = "X-WR-CALNAME"
+
+// jsonCalendar is the document written by SerializeJSON
+type jsonCalendar struct {
+	Name     string      `json:"name,omitempty"` // X-WR-CALNAME
+	Timezone string      `json:"timezone"`       // Zone of floating times and dates
+	Events   []jsonEvent `json:"events"`
+}
+
+// jsonEvent is one event written by SerializeJSON
+// Start and End are RFC 3339 times, or dates for all-day events (End exclusive).
+type jsonEvent struct {
+	UID         string                    `json:"uid"`
+	Summary     string                    `json:"summary,omitempty"`
+	Description string                    `json:"description,omitempty"`
+	Location    string                    `json:"location,omitempty"`
+	Status      string                    `json:"status,omitempty"`
+	Start       string                    `json:"start,omitempty"`
+	End         string                    `json:"end,omitempty"`
+	AllDay      bool                      `json:"all_day"`
+	Properties  map[string][]jsonProperty `json:"properties"` // Every property by name, occurrences in feed order
+}
+
+// jsonProperty is one occurrence of a property; TEXT values are unescaped
+type jsonProperty struct {
+	Value  string              `json:"value"`
+	Params map[string][]string `json:"params,omitempty"`
+}
+
+// SerializeJSON writes the events Serialize would emit as a simple JSON event list
+// Unlike iCal output, a calendar without events is written as an empty list.
+func (c *Calendar) SerializeJSON(w io.Writer) error {
+	out := jsonCalendar{Timezone: time.UTC.String(), Events: make([]jsonEvent, 0, len(c.Events))}
+	if c.Location != nil {
+		out.Timezone = c.Location.String()
+	}
+	if c.Raw != nil {
+		if prop := c.Raw.Props.Get(propWRCalName); prop != nil {
+			out.Name = propText(prop)
+		}
+	}
+
+	for _, event := range c.Events {
+		if event.RawEvent == nil || event.RawEvent.Component == nil {
+			continue
+		}
+		props := event.RawEvent.Props
+		e := jsonEvent{UID: event.UID, AllDay: event.AllDay, Properties: make(map[string][]jsonProperty, len(props))}
+		for name, field := range map[string]*string{
+			ical.PropSummary:     &e.Summary,
+			ical.PropDescription: &e.Description,
+			ical.PropLocation:    &e.Location,
+			ical.PropStatus:      &e.Status,
+		} {
+			if prop := props.Get(name); prop != nil {
+				*field = propText(prop)
+			}
+		}
+		e.Start, e.End = jsonTime(event.Start, event.AllDay), jsonTime(event.End, event.AllDay)
+
+		for name, occurrences := range props {
+			for _, prop := range occurrences {
+				value := prop.Value
+				if prop.ValueType() == ical.ValueText {
+					value = propText(&prop)
+				}
+				var params map[string][]string
+				if len(prop.Params) > 0 {
+					params = prop.Params
+				}
+				e.Properties[name] = append(e.Properties[name], jsonProperty{Value: value, Params: params})
+			}
+		}
+		out.Events = append(out.Events, e)
+	}
+
+	return encodeJSON(w, "JSON", out)
+}
+
+// SerializeJCal writes the calendar Serialize would emit in jCal format (RFC 7265)
+// Properties are ordered by name; values are converted to their JSON forms where
+// RFC 7265 defines one and kept as strings otherwise.
+func (c *Calendar) SerializeJCal(w io.Writer) error {
+	return encodeJSON(w, "jCal", jcalComponent(c.output().Component))
+}
+
+// encodeJSON writes v as JSON, leaving HTML characters in values as they are
+func encodeJSON(w io.Writer, format string, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", format, err)
+	}
+	return nil
+}
+
+// propText returns the unescaped TEXT value of prop, or the value as is if it is malformed
+func propText(prop *ical.Prop) string {
+	if text, err := prop.Text(); err == nil {
+		return text
+	}
+	return prop.Value
+}
+
+// jsonTime formats an event time for SerializeJSON, empty if unknown
+func jsonTime(t time.Time, allDay bool) string {
+	switch {
+	case t.IsZero():
+		return ""
+	case allDay:
+		return t.Format(time.DateOnly)
+	}
+	return t.Format(time.RFC3339)
+}
+
+// jcalComponent converts a component to [name, properties, components] (RFC 7265 section 3.1)
+func jcalComponent(comp *ical.Component) []any {
+	names := make([]string, 0, len(comp.Props))
+	for name := range comp.Props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	props := make([]any, 0, len(names))
+	for _, name := range names {
+		for _, prop := range comp.Props[name] {
+			props = append(props, jcalProperty(&prop))
+		}
+	}
+
+	children := make([]any, 0, len(comp.Children))
+	for _, child := range comp.Children {
+		children = append(children, jcalComponent(child))
+	}
+	return []any{strings.ToLower(comp.Name), props, children}
+}
+
+// jcalProperty converts a property to [name, parameters, type, values...] (RFC 7265 section 3.4)
+// Properties without a known type are "unknown" with the value kept as a string.
+func jcalProperty(prop *ical.Prop) []any {
+	valueType := prop.ValueType()
+	if valueType == ical.ValueDateTime && isDateValue(prop) {
+		valueType = ical.ValueDate
+	}
+
+	params := make(map[string]any, len(prop.Params))
+	for name, values := range prop.Params {
+		switch {
+		case name == ical.ParamValue: // Given by the type instead (RFC 7265 section 3.5.2)
+		case len(values) == 1:
+			params[strings.ToLower(name)] = values[0]
+		default:
+			params[strings.ToLower(name)] = values
+		}
+	}
+
+	typeName := strings.ToLower(string(valueType))
+	if valueType == ical.ValueDefault {
+		typeName = "unknown"
+	}
+	return append([]any{strings.ToLower(prop.Name), params, typeName}, jcalValues(prop, valueType)...)
+}
+
+// jcalValues converts the value of a property to its jCal values (RFC 7265 section 3.6)
+func jcalValues(prop *ical.Prop, valueType ical.ValueType) []any {
+	value := prop.Value
+	switch valueType {
+	case ical.ValueText:
+		if IsListProp(prop.Name) {
+			if items, err := prop.TextList(); err == nil {
+				return toAny(items)
+			}
+		}
+		return []any{propText(prop)}
+	case ical.ValueDate, ical.ValueDateTime:
+		return toAny(mapList(value, jcalDateTime))
+	case ical.ValueTime:
+		return toAny(mapList(value, jcalTime))
+	case ical.ValueUTCOffset:
+		return []any{jcalUTCOffset(value)}
+	case ical.ValuePeriod:
+		var periods []any
+		for _, period := range strings.Split(value, ",") {
+			start, end, _ := strings.Cut(period, "/")
+			if !strings.HasPrefix(end, "P") && !strings.HasPrefix(end, "+P") && !strings.HasPrefix(end, "-P") {
+				end = jcalDateTime(end)
+			}
+			periods = append(periods, []string{jcalDateTime(start), end})
+		}
+		return periods
+	case ical.ValueInt:
+		if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			return []any{n}
+		}
+	case ical.ValueFloat:
+		// GEO is a structured value of two floats
+		var floats []any
+		for _, part := range strings.Split(value, ";") {
+			f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil {
+				return []any{value}
+			}
+			floats = append(floats, f)
+		}
+		if len(floats) > 1 {
+			return []any{floats}
+		}
+		return floats
+	case ical.ValueBool:
+		if b, err := prop.Bool(); err == nil {
+			return []any{b}
+		}
+	case ical.ValueRecurrence:
+		return []any{jcalRecur(value)}
+	}
+	return []any{value}
+}
+
+// recurNumeric are the RECUR rule parts with integer values
+var recurNumeric = map[string]bool{
+	"count": true, "interval": true, "bysecond": true, "byminute": true, "byhour": true,
+	"bymonthday": true, "byyearday": true, "byweekno": true, "bymonth": true, "bysetpos": true,
+}
+
+// jcalRecur converts a RECUR value to an object of lower-case rule parts (RFC 7265 section 3.6.10)
+// Parts listing several values become arrays.
+func jcalRecur(value string) map[string]any {
+	out := make(map[string]any)
+	for _, part := range strings.Split(value, ";") {
+		key, val, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+
+		var items []any
+		for _, item := range strings.Split(val, ",") {
+			if key == "until" {
+				items = append(items, jcalDateTime(item))
+				continue
+			}
+			if recurNumeric[key] {
+				if n, err := strconv.Atoi(item); err == nil {
+					items = append(items, n)
+					continue
+				}
+			}
+			items = append(items, item)
+		}
+		if len(items) == 1 {
+			out[key] = items[0]
+		} else {
+			out[key] = items
+		}
+	}
+	return out
+}
+
+// jcalDateTime converts a DATE or DATE-TIME value to the extended format jCal
+// uses ("2025-01-10" or "2025-01-10T17:00:00Z"); other values are kept as is
+func jcalDateTime(value string) string {
+	value = strings.TrimSpace(value)
+	date, clock, hasTime := strings.Cut(value, "T")
+	if len(date) != len(dateLayout) {
+		return value
+	}
+	out := date[:4] + "-" + date[4:6] + "-" + date[6:]
+	if hasTime {
+		out += "T" + jcalTime(clock)
+	}
+	return out
+}
+
+// jcalTime converts a TIME value ("170000Z") to "17:00:00Z"
+func jcalTime(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) < 6 {
+		return value
+	}
+	return value[:2] + ":" + value[2:4] + ":" + value[4:]
+}
+
+// jcalUTCOffset converts a UTC-OFFSET value ("+0200") to "+02:00"
+func jcalUTCOffset(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) != 5 && len(value) != 7 {
+		return value
+	}
+	out := value[:3] + ":" + value[3:5]
+	if len(value) == 7 {
+		out += ":" + value[5:]
+	}
+	return out
+}
+
+// mapList applies convert to each item of a comma-separated value
+func mapList(value string, convert func(string) string) []string {
+	items := strings.Split(value, ",")
+	for i, item := range items {
+		items[i] = convert(item)
+	}
+	return items
+}
+
+// toAny converts strings to jCal values
+func toAny(items []string) []any {
+	out := make([]any, len(items))
+	for i, item := range items {
+		out[i] = item
+	}
+	return out
+}
@@ -0,0 +1,148 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// jsonTestCalendar has a timed event with escaped text, a list property and an alarm,
+// a weekly series in a timezone and an all-day event
+const jsonTestCalendar = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n" +
+	"X-WR-CALNAME:Göta PB\r\nX-WR-TIMEZONE:Europe/Stockholm\r\n" +
+	"BEGIN:VTIMEZONE\r\nTZID:Europe/Stockholm\r\nBEGIN:STANDARD\r\nDTSTART:19701025T030000\r\n" +
+	"TZOFFSETFROM:+0200\r\nTZOFFSETTO:+0100\r\nEND:STANDARD\r\nEND:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\nUID:1\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\nDTEND:20250110T190000Z\r\n" +
+	"SUMMARY:PB\\, Göta: Grad 3\r\nLOCATION:Logehuset\r\nCATEGORIES:Möte,Grad\\, hög\r\nSEQUENCE:2\r\nGEO:57.7;11.9\r\n" +
+	"ATTENDEE;CN=Ordförande;ROLE=CHAIR:mailto:ordf@example.com\r\n" +
+	"BEGIN:VALARM\r\nACTION:DISPLAY\r\nTRIGGER:-PT15M\r\nDESCRIPTION:Påminnelse\r\nEND:VALARM\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:2\r\nDTSTAMP:20250101T000000Z\r\nDTSTART;TZID=Europe/Stockholm:20250113T180000\r\n" +
+	"DURATION:PT2H\r\nSUMMARY:Övning\r\nRRULE:FREQ=WEEKLY;BYDAY=MO,WE;COUNT=4\r\n" +
+	"EXDATE;TZID=Europe/Stockholm:20250115T180000,20250120T180000\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:3\r\nDTSTAMP:20250101T000000Z\r\nDTSTART;VALUE=DATE:20250201\r\nSUMMARY:Högtid\r\nEND:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+// TestSerializeJSON tests the JSON event list
+// Validates: Calendar name and timezone, unescaped text, RFC 3339 times in the event zone,
+// dates for all-day events, every property with its parameters
+func TestSerializeJSON(t *testing.T) {
+	cal, err := Parse(strings.NewReader(jsonTestCalendar))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := cal.SerializeJSON(&buf); err != nil {
+		t.Fatalf("SerializeJSON() failed: %v", err)
+	}
+	var got jsonCalendar
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, buf.String())
+	}
+
+	if got.Name != "Göta PB" || got.Timezone != "Europe/Stockholm" || len(got.Events) != 3 {
+		t.Fatalf("Calendar = %q in %q with %d events, want Göta PB in Europe/Stockholm with 3", got.Name, got.Timezone, len(got.Events))
+	}
+
+	first := got.Events[0]
+	if first.Summary != "PB, Göta: Grad 3" || first.Location != "Logehuset" {
+		t.Errorf("Summary, Location = %q, %q, want unescaped text", first.Summary, first.Location)
+	}
+	if first.Start != "2025-01-10T17:00:00Z" || first.End != "2025-01-10T19:00:00Z" || first.AllDay {
+		t.Errorf("Start, End = %q, %q, want RFC 3339 UTC times", first.Start, first.End)
+	}
+	attendee := first.Properties["ATTENDEE"]
+	if len(attendee) != 1 || attendee[0].Value != "mailto:ordf@example.com" || attendee[0].Params["CN"][0] != "Ordförande" {
+		t.Errorf("ATTENDEE = %+v, want the address with its parameters", attendee)
+	}
+	if seq := first.Properties["SEQUENCE"]; len(seq) != 1 || seq[0].Value != "2" {
+		t.Errorf("SEQUENCE = %+v, want every property listed", seq)
+	}
+
+	if series := got.Events[1]; series.Start != "2025-01-13T18:00:00+01:00" || series.End != "2025-01-13T20:00:00+01:00" {
+		t.Errorf("Series Start, End = %q, %q, want times in the TZID zone with the DURATION applied", series.Start, series.End)
+	}
+	if allDay := got.Events[2]; allDay.Start != "2025-02-01" || allDay.End != "2025-02-02" || !allDay.AllDay {
+		t.Errorf("All-day Start, End = %q, %q, want dates with an exclusive end", allDay.Start, allDay.End)
+	}
+
+	empty := &Calendar{}
+	buf.Reset()
+	if err := empty.SerializeJSON(&buf); err != nil || !strings.Contains(buf.String(), `"events":[]`) {
+		t.Errorf("SerializeJSON() of an empty calendar = %q, %v, want an empty event list", buf.String(), err)
+	}
+}
+
+// TestSerializeJCal tests the jCal output against RFC 7265
+// Validates: Component structure, lower-case names, types, converted date-time, date,
+// integer, float, utc-offset, recur and list values, parameters without VALUE, alarms
+func TestSerializeJCal(t *testing.T) {
+	cal, err := Parse(strings.NewReader(jsonTestCalendar))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := cal.SerializeJCal(&buf); err != nil {
+		t.Fatalf("SerializeJCal() failed: %v", err)
+	}
+	var got []any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, buf.String())
+	}
+	if len(got) != 3 || got[0] != "vcalendar" {
+		t.Fatalf("Output = %v, want [\"vcalendar\", properties, components]", got)
+	}
+
+	// Index the properties of each component by type and name
+	props := make(map[string][][]any)
+	var walk func(comp []any)
+	walk = func(comp []any) {
+		for _, p := range comp[1].([]any) {
+			prop := p.([]any)
+			key := comp[0].(string) + " " + prop[0].(string)
+			props[key] = append(props[key], prop)
+		}
+		for _, child := range comp[2].([]any) {
+			walk(child.([]any))
+		}
+	}
+	walk(got)
+
+	tests := []struct {
+		key  string
+		want []any
+	}{
+		{"vcalendar x-wr-calname", []any{"x-wr-calname", map[string]any{}, "unknown", "Göta PB"}},
+		{"standard tzoffsetfrom", []any{"tzoffsetfrom", map[string]any{}, "utc-offset", "+02:00"}},
+		{"vevent summary", []any{"summary", map[string]any{}, "text", "PB, Göta: Grad 3"}},
+		{"vevent categories", []any{"categories", map[string]any{}, "text", "Möte", "Grad, hög"}},
+		{"vevent sequence", []any{"sequence", map[string]any{}, "integer", float64(2)}},
+		{"vevent geo", []any{"geo", map[string]any{}, "float", []any{57.7, 11.9}}},
+		{"vevent attendee", []any{"attendee", map[string]any{"cn": "Ordförande", "role": "CHAIR"}, "cal-address", "mailto:ordf@example.com"}},
+		{"vevent rrule", []any{"rrule", map[string]any{}, "recur", map[string]any{"freq": "WEEKLY", "byday": []any{"MO", "WE"}, "count": float64(4)}}},
+		{"vevent exdate", []any{"exdate", map[string]any{"tzid": "Europe/Stockholm"}, "date-time", "2025-01-15T18:00:00", "2025-01-20T18:00:00"}},
+		{"vevent duration", []any{"duration", map[string]any{}, "duration", "PT2H"}},
+		{"valarm trigger", []any{"trigger", map[string]any{}, "duration", "-PT15M"}},
+	}
+	for _, tt := range tests {
+		if len(props[tt.key]) == 0 || !reflect.DeepEqual(props[tt.key][0], tt.want) {
+			t.Errorf("%s = %v, want %v", tt.key, props[tt.key], tt.want)
+		}
+	}
+
+	// DTSTART takes the form of each event: UTC, TZID and VALUE=DATE
+	wantStarts := []any{
+		[]any{"dtstart", map[string]any{}, "date-time", "2025-01-10T17:00:00Z"},
+		[]any{"dtstart", map[string]any{"tzid": "Europe/Stockholm"}, "date-time", "2025-01-13T18:00:00"},
+		[]any{"dtstart", map[string]any{}, "date", "2025-02-01"},
+	}
+	for i, want := range wantStarts {
+		if len(props["vevent dtstart"]) != 3 || !reflect.DeepEqual(props["vevent dtstart"][i], want) {
+			t.Errorf("dtstart %d = %v, want %v", i, props["vevent dtstart"], want)
+			break
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+// Merge combines calendars from several upstream sources into one
+// Calendar properties and the floating-time location come from the first calendar.
+// If the sources have different zones (X-WR-TIMEZONE), X-WR-TIMEZONE is dropped and
+// floating times are given the TZID of their own source's zone, with a VTIMEZONE
+// for it, so they keep meaning the same instant; dates are left floating.
+// Events are de-duplicated by UID and RECURRENCE-ID: the copy with the highest
+// SEQUENCE wins and ties go to the earlier source, keeping the position of the first
+// copy. Events without a UID are always kept. VTIMEZONE definitions are unioned by
+// TZID, the first definition of each zone winning. Nil calendars are skipped.
+func Merge(cals ...*Calendar) *Calendar {
+	var first *Calendar
+	for _, cal := range cals {
+		if cal != nil {
+			first = cal
+			break
+		}
+	}
+	if first == nil {
+		return &Calendar{}
+	}
+
+	mixed := false
+	for _, cal := range cals {
+		if cal != nil && cal.zone().String() != first.zone().String() {
+			mixed = true
+		}
+	}
+
+	raw := ical.NewCalendar()
+	location := first.Location
+	if first.Raw != nil {
+		raw.Props = first.Raw.Props
+	}
+	if mixed {
+		raw.Props = make(ical.Props, len(raw.Props))
+		for name, props := range first.Raw.Props {
+			if name != propWRTimezone {
+				raw.Props[name] = props
+			}
+		}
+		location = time.UTC
+	}
+
+	var events []*Event
+	index := make(map[string]int) // event key -> position in events
+	zones := make(map[string]bool)
+	var pinned []*zoneSpan // Zones floating times were pinned to, in order of first use
+
+	for _, cal := range cals {
+		if cal == nil {
+			continue
+		}
+
+		if cal.Raw != nil {
+			for _, child := range cal.Raw.Children {
+				if child.Name != ical.CompTimezone {
+					continue
+				}
+				tzid := ""
+				if prop := child.Props.Get(ical.PropTimezoneID); prop != nil {
+					tzid = prop.Value
+				}
+				if zones[tzid] {
+					continue
+				}
+				zones[tzid] = true
+				raw.Children = append(raw.Children, child)
+			}
+		}
+
+		for _, event := range cal.Events {
+			if mixed && cal.zone() != time.UTC {
+				if p := event.pinFloating(cal.zone()); p != event {
+					event = p
+					pinned = extendSpan(pinned, cal.zone(), event.Start)
+				}
+			}
+			key := event.mergeKey()
+			if key == "" {
+				events = append(events, event)
+				continue
+			}
+
+			i, seen := index[key]
+			if !seen {
+				index[key] = len(events)
+				events = append(events, event)
+				continue
+			}
+			if event.sequence() > events[i].sequence() {
+				events[i] = event
+			}
+		}
+	}
+
+	// Define the zones floating times were pinned to, unless a source already did
+	for _, span := range pinned {
+		if !zones[span.loc.String()] {
+			zones[span.loc.String()] = true
+			raw.Children = append(raw.Children, newTimezone(span.loc, span.from, span.to))
+		}
+	}
+
+	return &Calendar{
+		Events:   events,
+		Raw:      raw,
+		Location: location,
+	}
+}
+
+// zoneSpan is the range of event starts pinned to a zone
+type zoneSpan struct {
+	loc      *time.Location
+	from, to time.Time
+}
+
+// extendSpan widens the span of loc in spans to include start, adding it if needed
+func extendSpan(spans []*zoneSpan, loc *time.Location, start time.Time) []*zoneSpan {
+	for _, span := range spans {
+		if span.loc.String() != loc.String() {
+			continue
+		}
+		if start.Before(span.from) {
+			span.from = start
+		}
+		if start.After(span.to) {
+			span.to = start
+		}
+		return spans
+	}
+	return append(spans, &zoneSpan{loc: loc, from: start, to: start})
+}
+
+// zone returns the location floating times of the calendar are read in
+func (c *Calendar) zone() *time.Location {
+	if c.Location == nil {
+		return time.UTC
+	}
+	return c.Location
+}
+
+// floatingProps are the event properties that may hold floating DATE-TIME values
+var floatingProps = []string{
+	ical.PropDateTimeStart, ical.PropDateTimeEnd, ical.PropRecurrenceID,
+	ical.PropRecurrenceDates, ical.PropExceptionDates,
+}
+
+// pinFloating returns a copy of the event with floating DATE-TIME values given the
+// TZID of loc, or the event itself if it has none
+// A floating UNTIL in the RRULE is converted to UTC, as RFC 5545 requires with a TZID.
+func (e *Event) pinFloating(loc *time.Location) *Event {
+	if e.RawEvent == nil || e.RawEvent.Component == nil {
+		return e
+	}
+
+	pinned := e
+	for _, name := range floatingProps {
+		for i := range e.RawEvent.Props[name] {
+			if !isFloating(&e.RawEvent.Props[name][i]) {
+				continue
+			}
+			if pinned == e {
+				pinned = e.Clone()
+			}
+			pinned.RawEvent.Props[name][i].Params.Set(ical.PropTimezoneID, loc.String())
+		}
+	}
+	if pinned == e {
+		return e
+	}
+
+	if rule := pinned.RawEvent.Props.Get(ical.PropRecurrenceRule); rule != nil {
+		parts := strings.Split(rule.Value, ";")
+		for i, part := range parts {
+			key, value, _ := strings.Cut(part, "=")
+			if !strings.EqualFold(key, "UNTIL") || len(value) != len(dateTimeLayout) {
+				continue
+			}
+			if until, err := time.ParseInLocation(dateTimeLayout, value, loc); err == nil {
+				parts[i] = key + "=" + until.UTC().Format(dateTimeUTCLayout)
+			}
+		}
+		rule.Value = strings.Join(parts, ";")
+	}
+	return pinned
+}
+
+// isFloating reports whether a property holds a DATE-TIME without a zone
+func isFloating(prop *ical.Prop) bool {
+	return !isDateValue(prop) &&
+		prop.Params.Get(ical.ParamValue) != string(ical.ValuePeriod) &&
+		prop.Params.Get(ical.PropTimezoneID) == "" &&
+		!strings.HasSuffix(strings.TrimSpace(prop.Value), "Z")
+}
+
+// mergeKey identifies the same event across sources: UID plus RECURRENCE-ID for overrides
+// Returns "" for events without a UID
+func (e *Event) mergeKey() string {
+	if e.UID == "" {
+		return ""
+	}
+	if prop := e.recurrenceIDProp(); prop != nil {
+		return e.UID + "\x00" + strings.TrimSpace(prop.Value)
+	}
+	return e.UID
+}
+
+// sequence returns the event's SEQUENCE revision number, 0 if absent or invalid
+func (e *Event) sequence() int {
+	if e.RawEvent == nil || e.RawEvent.Component == nil {
+		return 0
+	}
+	prop := e.RawEvent.Props.Get(ical.PropSequence)
+	if prop == nil {
+		return 0
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(prop.Value))
+	if err != nil {
+		return 0
+	}
+	return n
+}
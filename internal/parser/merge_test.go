@@ -0,0 +1,172 @@
+package parser
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMerge tests combining calendars from several sources
+// Validates: UID de-duplication, SEQUENCE precedence, overrides kept apart, VTIMEZONE union
+func TestMerge(t *testing.T) {
+	first, err := Parse(strings.NewReader(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//First//EN
+X-WR-CALNAME:Göta
+BEGIN:VTIMEZONE
+TZID:Europe/Stockholm
+BEGIN:STANDARD
+DTSTART:19701025T030000
+TZOFFSETFROM:+0200
+TZOFFSETTO:+0100
+END:STANDARD
+END:VTIMEZONE
+BEGIN:VEVENT
+UID:shared@example.com
+DTSTAMP:20250101T000000Z
+DTSTART;TZID=Europe/Stockholm:20250110T180000
+SUMMARY:Old title
+END:VEVENT
+BEGIN:VEVENT
+UID:first@example.com
+DTSTAMP:20250101T000000Z
+DTSTART:20250111T170000Z
+SUMMARY:First only
+END:VEVENT
+END:VCALENDAR`))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	second, err := Parse(strings.NewReader(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Second//EN
+BEGIN:VTIMEZONE
+TZID:Europe/Stockholm
+BEGIN:STANDARD
+DTSTART:19701025T030000
+TZOFFSETFROM:+0200
+TZOFFSETTO:+0100
+END:STANDARD
+END:VTIMEZONE
+BEGIN:VTIMEZONE
+TZID:Europe/Helsinki
+BEGIN:STANDARD
+DTSTART:19701025T040000
+TZOFFSETFROM:+0300
+TZOFFSETTO:+0200
+END:STANDARD
+END:VTIMEZONE
+BEGIN:VEVENT
+UID:shared@example.com
+DTSTAMP:20250101T000000Z
+SEQUENCE:2
+DTSTART;TZID=Europe/Stockholm:20250110T180000
+SUMMARY:New title
+END:VEVENT
+BEGIN:VEVENT
+UID:shared@example.com
+DTSTAMP:20250101T000000Z
+RECURRENCE-ID:20250117T170000Z
+DTSTART:20250117T170000Z
+SUMMARY:Override
+END:VEVENT
+BEGIN:VEVENT
+DTSTAMP:20250101T000000Z
+DTSTART:20250112T170000Z
+SUMMARY:No UID
+END:VEVENT
+END:VCALENDAR`))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	merged := Merge(first, nil, second)
+
+	var summaries []string
+	for _, event := range merged.Events {
+		summaries = append(summaries, event.Summary)
+	}
+	want := []string{"New title", "First only", "Override", "No UID"}
+	if strings.Join(summaries, "|") != strings.Join(want, "|") {
+		t.Errorf("Merged events = %q, want %q", summaries, want)
+	}
+
+	// The encoder rejects events without a UID, so leave that one out of the output check
+	merged.Events = merged.Events[:3]
+
+	var buf bytes.Buffer
+	if err := merged.Serialize(&buf); err != nil {
+		t.Fatalf("Serialize() failed: %v", err)
+	}
+	out := buf.String()
+	if got := strings.Count(out, "BEGIN:VTIMEZONE"); got != 2 {
+		t.Errorf("Got %d VTIMEZONE components, want 2", got)
+	}
+	if !strings.Contains(out, "X-WR-CALNAME:Göta") {
+		t.Error("Calendar properties should come from the first source")
+	}
+}
+
+// TestMergeTimezones tests merging sources whose floating times are in different zones
+// Validates: X-WR-TIMEZONE dropped, floating times pinned to their source zone with UNTIL in
+// UTC and a VTIMEZONE per pinned zone, instants unchanged after re-parsing, sources not
+// modified, same zones left as they are
+func TestMergeTimezones(t *testing.T) {
+	parse := func(zone, uid, extra string) *Calendar {
+		t.Helper()
+		cal, err := Parse(strings.NewReader("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n" +
+			"X-WR-TIMEZONE:" + zone + "\r\nBEGIN:VEVENT\r\nUID:" + uid + "\r\nDTSTAMP:20250101T000000Z\r\n" +
+			"DTSTART:20250110T180000\r\nDTEND:20250110T200000\r\n" + extra + "SUMMARY:" + uid + "\r\nEND:VEVENT\r\n" +
+			"END:VCALENDAR\r\n"))
+		if err != nil {
+			t.Fatalf("Parse() failed: %v", err)
+		}
+		return cal
+	}
+	stockholm := parse("Europe/Stockholm", "goteborg", "RRULE:FREQ=WEEKLY;UNTIL=20250124T180000\r\nEXDATE:20250117T180000\r\n")
+	newYork := parse("America/New_York", "newyork", "")
+
+	var buf bytes.Buffer
+	if err := Merge(stockholm, newYork).Serialize(&buf); err != nil {
+		t.Fatalf("Serialize() failed: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"DTSTART;TZID=Europe/Stockholm:20250110T180000\r\n",
+		"EXDATE;TZID=Europe/Stockholm:20250117T180000\r\n",
+		"RRULE:FREQ=WEEKLY;UNTIL=20250124T170000Z\r\n",
+		"DTSTART;TZID=America/New_York:20250110T180000\r\n",
+		"BEGIN:VTIMEZONE\r\nTZID:Europe/Stockholm\r\n",
+		"BEGIN:VTIMEZONE\r\nTZID:America/New_York\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Merged output does not contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "X-WR-TIMEZONE") {
+		t.Error("X-WR-TIMEZONE kept although the sources have different zones")
+	}
+
+	reparsed, err := Parse(strings.NewReader(out))
+	if err != nil {
+		t.Fatalf("Parse() of merged output failed: %v", err)
+	}
+	for i, want := range []string{"2025-01-10T17:00:00Z", "2025-01-10T23:00:00Z"} {
+		if got := reparsed.Events[i].Start.UTC().Format(time.RFC3339); got != want {
+			t.Errorf("Event %d starts at %s, want %s", i, got, want)
+		}
+	}
+	if stockholm.Events[0].RawEvent.Props.Get("DTSTART").Params.Get("TZID") != "" {
+		t.Error("Merge() modified the source event")
+	}
+
+	buf.Reset()
+	if err := Merge(stockholm, parse("Europe/Stockholm", "other", "")).Serialize(&buf); err != nil {
+		t.Fatalf("Serialize() failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "X-WR-TIMEZONE:Europe/Stockholm") || strings.Contains(buf.String(), "TZID") {
+		t.Errorf("Sources in the same zone should be merged as they are:\n%s", buf.String())
+	}
+}
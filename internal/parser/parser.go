@@ -1,11 +1,24 @@
 package parser
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	"github.com/emersion/go-ical"
+	"github.com/linus/recal/internal/logging"
+)
+
+// propWRTimezone is the de facto calendar-wide timezone property used by Google Calendar
+const propWRTimezone = "X-WR-TIMEZONE"
+
+// iCal DATE and DATE-TIME layouts (RFC 5545 section 3.3.4 and 3.3.5)
+const (
+	dateLayout        = "20060102"
+	dateTimeLayout    = "20060102T150405"
+	dateTimeUTCLayout = "20060102T150405Z"
 )
 
 // Event represents a parsed iCal event with relevant fields
@@ -17,17 +30,34 @@ type Event struct {
 	Status      string
 	DTStart     string
 	DTEnd       string
+	Start       time.Time   // Parsed DTSTART, zero if missing or unparseable
+	End         time.Time   // Parsed DTEND (or DTSTART+DURATION), zero if unknown
+	AllDay      bool        // True if DTSTART is a VALUE=DATE
 	RawEvent    *ical.Event // Keep the raw event for full iCal generation
+
+	// Set on instances produced by Calendar.Expand
+	Master       *Event    // Series master this instance was generated from
+	RecurrenceID time.Time // Original start of the occurrence within the series
+	isOverride   bool      // Instance came from a RECURRENCE-ID override in the feed
+	partial      bool      // Series has occurrences outside the expansion window
 }
 
 // Calendar represents a parsed iCal calendar
 type Calendar struct {
-	Events []*Event
-	Raw    *ical.Calendar // Keep the raw calendar for metadata
+	Events   []*Event
+	Raw      *ical.Calendar // Keep the raw calendar for metadata
+	Location *time.Location // Zone used for floating times and dates (X-WR-TIMEZONE or UTC)
 }
 
 // Parse parses an iCal feed from a reader
 func Parse(r io.Reader) (*Calendar, error) {
+	return ParseContext(context.Background(), r)
+}
+
+// ParseContext parses an iCal feed from a reader, logging under the request ID in ctx
+// Events whose start time cannot be read are kept but reported at debug level,
+// since date filters cannot place them.
+func ParseContext(ctx context.Context, r io.Reader) (*Calendar, error) {
 	decoder := ical.NewDecoder(r)
 
 	var calendar *ical.Calendar
@@ -39,11 +69,13 @@ func Parse(r io.Reader) (*Calendar, error) {
 		return nil, fmt.Errorf("failed to decode iCal: %w", err)
 	}
 
+	loc := calendarLocation(calendar)
+
 	// Extract events
 	var events []*Event
 	for _, component := range calendar.Children {
 		if component.Name == ical.CompEvent {
-			event, err := parseEvent(component)
+			event, err := parseEvent(component, loc)
 			if err != nil {
 				// Log the error but continue processing other events
 				continue
@@ -52,14 +84,36 @@ func Parse(r io.Reader) (*Calendar, error) {
 		}
 	}
 
+	unreadable := 0
+	for _, event := range events {
+		if event.DTStart != "" && event.Start.IsZero() {
+			unreadable++
+		}
+	}
+	logging.FromContext(ctx).Debug("parsed calendar",
+		"events", len(events), "unreadable_start", unreadable, "timezone", loc.String())
+
 	return &Calendar{
-		Events: events,
-		Raw:    calendar,
+		Events:   events,
+		Raw:      calendar,
+		Location: loc,
 	}, nil
 }
 
+// calendarLocation returns the location named by X-WR-TIMEZONE, or UTC if absent or unknown
+// "Local" is refused: it is the server's zone, not one a calendar can name.
+func calendarLocation(calendar *ical.Calendar) *time.Location {
+	if prop := calendar.Props.Get(propWRTimezone); prop != nil && prop.Value != "" && prop.Value != "Local" {
+		if loc, err := time.LoadLocation(prop.Value); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
 // parseEvent converts an ical.Component to our Event struct
-func parseEvent(component *ical.Component) (*Event, error) {
+// Floating times and dates are interpreted in loc
+func parseEvent(component *ical.Component, loc *time.Location) (*Event, error) {
 	event := &Event{
 		RawEvent: ical.NewEvent(),
 	}
@@ -71,18 +125,7 @@ func parseEvent(component *ical.Component) (*Event, error) {
 	if prop := component.Props.Get(ical.PropUID); prop != nil {
 		event.UID = prop.Value
 	}
-	if prop := component.Props.Get(ical.PropSummary); prop != nil {
-		event.Summary = prop.Value
-	}
-	if prop := component.Props.Get(ical.PropDescription); prop != nil {
-		event.Description = prop.Value
-	}
-	if prop := component.Props.Get(ical.PropLocation); prop != nil {
-		event.Location = prop.Value
-	}
-	if prop := component.Props.Get(ical.PropStatus); prop != nil {
-		event.Status = prop.Value
-	}
+	event.SyncText()
 	if prop := component.Props.Get(ical.PropDateTimeStart); prop != nil {
 		event.DTStart = prop.Value
 	}
@@ -90,36 +133,238 @@ func parseEvent(component *ical.Component) (*Event, error) {
 		event.DTEnd = prop.Value
 	}
 
+	parseEventTimes(event, component, loc)
+
 	return event, nil
 }
 
-// GetField returns the value of a field by name
+// SyncText re-reads SUMMARY, DESCRIPTION, LOCATION and STATUS from RawEvent
+// after its properties were edited
+func (e *Event) SyncText() {
+	props := e.RawEvent.Props
+	e.Summary, e.Description, e.Location, e.Status = "", "", "", ""
+	if prop := props.Get(ical.PropSummary); prop != nil {
+		e.Summary = prop.Value
+	}
+	if prop := props.Get(ical.PropDescription); prop != nil {
+		e.Description = prop.Value
+	}
+	if prop := props.Get(ical.PropLocation); prop != nil {
+		e.Location = prop.Value
+	}
+	if prop := props.Get(ical.PropStatus); prop != nil {
+		e.Status = prop.Value
+	}
+}
+
+// parseEventTimes fills in Start, End and AllDay from DTSTART, DTEND and DURATION
+// Unparseable values leave the corresponding time zero rather than failing the event
+func parseEventTimes(event *Event, component *ical.Component, loc *time.Location) {
+	startProp := component.Props.Get(ical.PropDateTimeStart)
+	if startProp == nil {
+		return
+	}
+
+	start, err := ParseDateTime(startProp, loc)
+	if err != nil {
+		return
+	}
+	event.Start = start
+	event.AllDay = isDateValue(startProp)
+
+	if endProp := component.Props.Get(ical.PropDateTimeEnd); endProp != nil {
+		if end, err := ParseDateTime(endProp, loc); err == nil {
+			event.End = end
+		}
+		return
+	}
+
+	// No DTEND: use DURATION, or one day for all-day events, or a zero-length event
+	if durProp := component.Props.Get(ical.PropDuration); durProp != nil {
+		if dur, err := durProp.Duration(); err == nil {
+			event.End = start.Add(dur)
+		}
+		return
+	}
+	if event.AllDay {
+		event.End = start.AddDate(0, 0, 1)
+		return
+	}
+	event.End = start
+}
+
+// ParseDateTime parses a DATE or DATE-TIME property value
+// UTC values ("Z" suffix) are returned in UTC, values with a TZID in that zone,
+// and floating times and dates in loc. An unknown TZID (e.g. a Windows zone name)
+// falls back to loc instead of failing.
+func ParseDateTime(prop *ical.Prop, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	value := strings.TrimSpace(prop.Value)
+	if isDateValue(prop) {
+		return time.ParseInLocation(dateLayout, value, loc)
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		return time.ParseInLocation(dateTimeUTCLayout, value, time.UTC)
+	}
+
+	if tzid := prop.Params.Get(ical.PropTimezoneID); tzid != "" {
+		if tzLoc, err := time.LoadLocation(tzid); err == nil {
+			loc = tzLoc
+		}
+	}
+	return time.ParseInLocation(dateTimeLayout, value, loc)
+}
+
+// isDateValue reports whether a property holds a DATE (not DATE-TIME) value
+func isDateValue(prop *ical.Prop) bool {
+	return prop.Params.Get(ical.ParamValue) == string(ical.ValueDate) ||
+		len(strings.TrimSpace(prop.Value)) == len(dateLayout)
+}
+
+// IsRecurring reports whether the event is the master of a recurring series (has RRULE or RDATE)
+func (e *Event) IsRecurring() bool {
+	if e.RawEvent == nil || e.RawEvent.Component == nil {
+		return false
+	}
+	props := e.RawEvent.Props
+	return props.Get(ical.PropRecurrenceRule) != nil || props.Get(ical.PropRecurrenceDates) != nil
+}
+
+// Overlaps reports whether the event overlaps the half-open window [from, to)
+// A zero from or to leaves that side of the window open. Events without a
+// parseable start are treated as overlapping so they are never dropped by accident.
+func (e *Event) Overlaps(from, to time.Time) bool {
+	if e.Start.IsZero() {
+		return true
+	}
+
+	end := e.End
+	if end.IsZero() || end.Before(e.Start) {
+		end = e.Start
+	}
+
+	if !to.IsZero() && !e.Start.Before(to) {
+		return false
+	}
+	if !from.IsZero() {
+		// Zero-length events are kept if they start inside the window
+		if end.Equal(e.Start) {
+			return !e.Start.Before(from)
+		}
+		if !end.After(from) {
+			return false
+		}
+	}
+	return true
+}
+
+// listProps are text properties holding comma-separated lists (RFC 5545 section 3.8.1.2 and 3.8.1.10)
+var listProps = map[string]bool{
+	ical.PropCategories: true,
+	ical.PropResources:  true,
+}
+
+// GetField returns the first value of a field by name
 // Field names are case-insensitive: SUMMARY, summary, Summary all work
 func (e *Event) GetField(fieldName string) string {
+	if values := e.GetFieldValues(fieldName); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// GetFieldValues returns every value of a field by name
+// Any property of the event can be named, including X- properties. A property
+// that occurs several times, or holds a comma-separated list like CATEGORIES,
+// gives one value per entry. "PROP;PARAM" returns the PARAM parameter of each
+// PROP, e.g. ATTENDEE;PARTSTAT. Values are returned as they appear in the feed.
+func (e *Event) GetFieldValues(fieldName string) []string {
 	fieldName = strings.ToUpper(fieldName)
+	name, param, hasParam := strings.Cut(fieldName, ";")
+
+	// Instances from Expand rewrite these, so read them from the event itself
+	if !hasParam {
+		switch name {
+		case "UID":
+			return nonEmpty(e.UID)
+		case "SUMMARY":
+			return nonEmpty(e.Summary)
+		case "DESCRIPTION":
+			return nonEmpty(e.Description)
+		case "LOCATION":
+			return nonEmpty(e.Location)
+		case "STATUS":
+			return nonEmpty(e.Status)
+		case "DTSTART":
+			return nonEmpty(e.DTStart)
+		case "DTEND":
+			return nonEmpty(e.DTEnd)
+		}
+	}
 
-	switch fieldName {
-	case "UID":
-		return e.UID
-	case "SUMMARY":
-		return e.Summary
-	case "DESCRIPTION":
-		return e.Description
-	case "LOCATION":
-		return e.Location
-	case "STATUS":
-		return e.Status
-	case "DTSTART":
-		return e.DTStart
-	case "DTEND":
-		return e.DTEnd
-	default:
-		return ""
+	if name == "" || e.RawEvent == nil || e.RawEvent.Component == nil {
+		return nil
+	}
+	var values []string
+	for _, prop := range e.RawEvent.Props.Values(name) {
+		switch {
+		case hasParam:
+			values = append(values, prop.Params.Values(param)...)
+		case listProps[name]:
+			values = append(values, SplitList(prop.Value)...)
+		default:
+			values = append(values, prop.Value)
+		}
 	}
+	return values
+}
+
+// nonEmpty returns value as a single-element slice, or nil if it is empty
+func nonEmpty(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
+
+// IsListProp reports whether the named property holds a comma-separated text list
+func IsListProp(name string) bool {
+	return listProps[strings.ToUpper(name)]
+}
+
+// SplitList splits a text list on commas that are not escaped with a backslash
+func SplitList(value string) []string {
+	var items []string
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++ // Skip the escaped character
+		case ',':
+			items = append(items, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, value[start:])
 }
 
 // Serialize converts a Calendar back to iCal format
 func (c *Calendar) Serialize(w io.Writer) error {
+	encoder := ical.NewEncoder(w)
+	if err := encoder.Encode(c.output()); err != nil {
+		return fmt.Errorf("failed to encode iCal: %w", err)
+	}
+
+	return nil
+}
+
+// output builds the calendar Serialize emits: the calendar properties, the
+// timezone definitions and the events
+func (c *Calendar) output() *ical.Calendar {
 	// Create a new calendar with the same properties as the original
 	outCal := ical.NewCalendar()
 
@@ -133,6 +378,15 @@ func (c *Calendar) Serialize(w io.Writer) error {
 		outCal.Props.SetText(ical.PropProductID, "-//ReCal//EN")
 	}
 
+	// Add timezone definitions referenced by TZID parameters
+	if c.Raw != nil {
+		for _, child := range c.Raw.Children {
+			if child.Name == ical.CompTimezone {
+				outCal.Children = append(outCal.Children, child)
+			}
+		}
+	}
+
 	// Add all events
 	for _, event := range c.Events {
 		if event.RawEvent != nil && event.RawEvent.Component != nil {
@@ -140,11 +394,5 @@ func (c *Calendar) Serialize(w io.Writer) error {
 		}
 	}
 
-	// Encode to writer
-	encoder := ical.NewEncoder(w)
-	if err := encoder.Encode(outCal); err != nil {
-		return fmt.Errorf("failed to encode iCal: %w", err)
-	}
-
-	return nil
+	return outCal
 }
@@ -3,8 +3,10 @@ package parser
 import (
 	"bytes"
 	"os"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 )
 
 // TestParse tests parsing a valid iCal feed
@@ -129,6 +131,46 @@ func TestGetField(t *testing.T) {
 	}
 }
 
+// TestGetFieldValues tests generic property lookup
+// Validates: Any property including X- properties, repeated properties, comma lists
+// with escaped commas, PROP;PARAM addressing, case-insensitivity, missing fields
+func TestGetFieldValues(t *testing.T) {
+	icalData := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n" +
+		"BEGIN:VEVENT\r\nUID:1\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\n" +
+		"SUMMARY:Möte\r\nCLASS:PRIVATE\r\nCATEGORIES:Styrelse,Kök\\, bar\r\nCATEGORIES:Ekonomi\r\n" +
+		"ATTENDEE;PARTSTAT=ACCEPTED:mailto:a@example.com\r\nATTENDEE;PARTSTAT=DECLINED:mailto:b@example.com\r\n" +
+		"X-GOOGLE-CONFERENCE:https://meet.google.com/abc\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	cal, err := Parse(strings.NewReader(icalData))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	event := cal.Events[0]
+
+	tests := []struct {
+		field string
+		want  []string
+	}{
+		{"SUMMARY", []string{"Möte"}},
+		{"class", []string{"PRIVATE"}},
+		{"CATEGORIES", []string{"Styrelse", `Kök\, bar`, "Ekonomi"}},
+		{"ATTENDEE", []string{"mailto:a@example.com", "mailto:b@example.com"}},
+		{"attendee;partstat", []string{"ACCEPTED", "DECLINED"}},
+		{"X-GOOGLE-CONFERENCE", []string{"https://meet.google.com/abc"}},
+		{"SUMMARY;LANGUAGE", nil},
+		{"URL", nil},
+		{";PARTSTAT", nil},
+	}
+
+	for _, tt := range tests {
+		if got := event.GetFieldValues(tt.field); !slices.Equal(got, tt.want) {
+			t.Errorf("GetFieldValues(%q) = %q, want %q", tt.field, got, tt.want)
+		}
+	}
+	if got := event.GetField("ATTENDEE;PARTSTAT"); got != "ACCEPTED" {
+		t.Errorf("GetField(ATTENDEE;PARTSTAT) = %q, want the first value", got)
+	}
+}
+
 // TestSerialize tests serializing a calendar back to iCal format
 // Validates: iCal generation, round-trip parsing
 func TestSerialize(t *testing.T) {
@@ -286,3 +328,122 @@ func TestSerializeEmptyCalendar(t *testing.T) {
 		t.Error("Serialize() succeeded for empty calendar, want error (RFC 5545 requires at least one component)")
 	}
 }
+
+// TestParseEventTimes tests DTSTART/DTEND parsing
+// Validates: UTC, TZID, floating (X-WR-TIMEZONE), VALUE=DATE, DURATION and unknown TZID fallback
+func TestParseEventTimes(t *testing.T) {
+	icalData := `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//EN
+X-WR-TIMEZONE:Europe/Stockholm
+BEGIN:VEVENT
+UID:utc@example.com
+DTSTART:20250115T180000Z
+DTEND:20250115T190000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:tzid@example.com
+DTSTART;TZID=America/New_York:20250115T180000
+DTEND;TZID=America/New_York:20250115T190000
+END:VEVENT
+BEGIN:VEVENT
+UID:floating@example.com
+DTSTART:20250115T180000
+DURATION:PT2H
+END:VEVENT
+BEGIN:VEVENT
+UID:date@example.com
+DTSTART;VALUE=DATE:20250115
+END:VEVENT
+BEGIN:VEVENT
+UID:windows@example.com
+DTSTART;TZID=W. Europe Standard Time:20250115T180000
+END:VEVENT
+END:VCALENDAR`
+
+	cal, err := Parse(strings.NewReader(icalData))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	stockholm, _ := time.LoadLocation("Europe/Stockholm")
+	newYork, _ := time.LoadLocation("America/New_York")
+
+	tests := []struct {
+		uid       string
+		wantStart time.Time
+		wantEnd   time.Time
+		wantAll   bool
+	}{
+		{"utc@example.com", time.Date(2025, 1, 15, 18, 0, 0, 0, time.UTC), time.Date(2025, 1, 15, 19, 0, 0, 0, time.UTC), false},
+		{"tzid@example.com", time.Date(2025, 1, 15, 18, 0, 0, 0, newYork), time.Date(2025, 1, 15, 19, 0, 0, 0, newYork), false},
+		{"floating@example.com", time.Date(2025, 1, 15, 18, 0, 0, 0, stockholm), time.Date(2025, 1, 15, 20, 0, 0, 0, stockholm), false},
+		{"date@example.com", time.Date(2025, 1, 15, 0, 0, 0, 0, stockholm), time.Date(2025, 1, 16, 0, 0, 0, 0, stockholm), true},
+		{"windows@example.com", time.Date(2025, 1, 15, 18, 0, 0, 0, stockholm), time.Date(2025, 1, 15, 18, 0, 0, 0, stockholm), false},
+	}
+
+	if len(cal.Events) != len(tests) {
+		t.Fatalf("Parse() got %d events, want %d", len(cal.Events), len(tests))
+	}
+
+	for i, tt := range tests {
+		event := cal.Events[i]
+		if event.UID != tt.uid {
+			t.Fatalf("Event[%d].UID = %q, want %q", i, event.UID, tt.uid)
+		}
+		if !event.Start.Equal(tt.wantStart) {
+			t.Errorf("%s: Start = %v, want %v", tt.uid, event.Start, tt.wantStart)
+		}
+		if !event.End.Equal(tt.wantEnd) {
+			t.Errorf("%s: End = %v, want %v", tt.uid, event.End, tt.wantEnd)
+		}
+		if event.AllDay != tt.wantAll {
+			t.Errorf("%s: AllDay = %v, want %v", tt.uid, event.AllDay, tt.wantAll)
+		}
+	}
+}
+
+// TestParseLocalZone tests X-WR-TIMEZONE:Local
+// Validates: Floating times are read in UTC, not the server's zone
+func TestParseLocalZone(t *testing.T) {
+	cal, err := Parse(strings.NewReader("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\nX-WR-TIMEZONE:Local\r\n" +
+		"BEGIN:VEVENT\r\nUID:a\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250115T180000\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if cal.Location != time.UTC {
+		t.Errorf("Location = %v, want UTC", cal.Location)
+	}
+}
+
+// TestOverlaps tests the date window overlap check
+// Validates: Half-open window, open bounds, zero-length and undated events
+func TestOverlaps(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name  string
+		event Event
+		from  time.Time
+		to    time.Time
+		want  bool
+	}{
+		{"inside", Event{Start: day(10), End: day(11)}, day(5), day(20), true},
+		{"before", Event{Start: day(1), End: day(2)}, day(5), day(20), false},
+		{"after", Event{Start: day(20), End: day(21)}, day(5), day(20), false},
+		{"spans start", Event{Start: day(4), End: day(6)}, day(5), day(20), true},
+		{"ends at from", Event{Start: day(4), End: day(5)}, day(5), day(20), false},
+		{"open from", Event{Start: day(1), End: day(2)}, time.Time{}, day(20), true},
+		{"open to", Event{Start: day(25), End: day(26)}, day(5), time.Time{}, true},
+		{"zero length at from", Event{Start: day(5), End: day(5)}, day(5), day(20), true},
+		{"no start", Event{}, day(5), day(20), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.event.Overlaps(tt.from, tt.to); got != tt.want {
+				t.Errorf("Overlaps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package parser
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+// timezoneYears is how many years past the later of the last event and now a
+// generated VTIMEZONE lists transitions for, so open-ended series stay covered
+const timezoneYears = 10
+
+// newTimezone returns a VTIMEZONE definition of loc for events starting between
+// from and to
+// Go locations do not expose their rules, so every transition from the start of
+// from's year is listed as an RDATE of its observance instead of an RRULE. A zero
+// from starts at to.
+func newTimezone(loc *time.Location, from, to time.Time) *ical.Component {
+	if now := time.Now(); to.Before(now) {
+		to = now
+	}
+	if from.IsZero() {
+		from = to
+	}
+	start := time.Date(from.Year(), time.January, 1, 0, 0, 0, 0, loc)
+	end := time.Date(to.Year()+timezoneYears+1, time.January, 1, 0, 0, 0, 0, loc)
+
+	tz := ical.NewComponent(ical.CompTimezone)
+	tz.Props.SetText(ical.PropTimezoneID, loc.String())
+	observances := make(map[string]*ical.Component)
+
+	// addObservance records that the zone in effect at at started there, coming from offsetFrom
+	addObservance := func(at time.Time, offsetFrom int) {
+		name, offset := at.Zone()
+		kind := ical.CompTimezoneStandard
+		if at.IsDST() {
+			kind = ical.CompTimezoneDaylight
+		}
+		// Observances start at the wall clock time before the change
+		local := at.UTC().Add(time.Duration(offsetFrom) * time.Second).Format(dateTimeLayout)
+
+		key := fmt.Sprintf("%s %s %d %d", kind, name, offsetFrom, offset)
+		if obs := observances[key]; obs != nil {
+			if rdate := obs.Props.Get(ical.PropRecurrenceDates); rdate != nil {
+				rdate.Value += "," + local
+				return
+			}
+			rdate := ical.NewProp(ical.PropRecurrenceDates)
+			rdate.Value = local
+			obs.Props.Set(rdate)
+			return
+		}
+
+		obs := ical.NewComponent(kind)
+		for prop, value := range map[string]string{
+			ical.PropDateTimeStart:      local,
+			ical.PropTimezoneOffsetFrom: formatOffset(offsetFrom),
+			ical.PropTimezoneOffsetTo:   formatOffset(offset),
+		} {
+			p := ical.NewProp(prop)
+			p.Value = value
+			obs.Props.Set(p)
+		}
+		obs.Props.SetText(ical.PropTimezoneName, name)
+		observances[key] = obs
+		tz.Children = append(tz.Children, obs)
+	}
+
+	_, offset := start.Zone()
+	addObservance(start, offset)
+	for at := start; ; {
+		_, next := at.ZoneBounds()
+		if next.IsZero() || !next.Before(end) {
+			break
+		}
+		_, offset := at.Zone()
+		at = next.In(loc)
+		addObservance(at, offset)
+	}
+	return tz
+}
+
+// formatOffset formats a UTC offset in seconds as a UTC-OFFSET value (+HHMM or +HHMMSS)
+func formatOffset(seconds int) string {
+	sign := '+'
+	if seconds < 0 {
+		sign, seconds = '-', -seconds
+	}
+	h, m, s := seconds/3600, seconds/60%60, seconds%60
+	if s != 0 {
+		return fmt.Sprintf("%c%02d%02d%02d", sign, h, m, s)
+	}
+	return fmt.Sprintf("%c%02d%02d", sign, h, m)
+}
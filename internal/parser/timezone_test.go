@@ -0,0 +1,59 @@
+package parser
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+// TestNewTimezone tests VTIMEZONE definitions generated from Go locations
+// Validates: Transitions listed per observance from the start of the first year, zones
+// without transitions, offsets with seconds, output accepted by the encoder
+func TestNewTimezone(t *testing.T) {
+	encode := func(tz *ical.Component) string {
+		t.Helper()
+		cal := ical.NewCalendar()
+		cal.Props.SetText(ical.PropVersion, "2.0")
+		cal.Props.SetText(ical.PropProductID, "-//Test//EN")
+		cal.Children = append(cal.Children, tz)
+		var buf bytes.Buffer
+		if err := ical.NewEncoder(&buf).Encode(cal); err != nil {
+			t.Fatalf("Encode() failed: %v", err)
+		}
+		return buf.String()
+	}
+
+	stockholm, _ := time.LoadLocation("Europe/Stockholm")
+	out := encode(newTimezone(stockholm, time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC), time.Time{}))
+	for _, want := range []string{
+		"TZID:Europe/Stockholm\r\n",
+		"BEGIN:STANDARD\r\nDTSTART:20250101T000000\r\nTZNAME:CET\r\nTZOFFSETFROM:+0100\r\nTZOFFSETTO:+0100\r\n",
+		"BEGIN:DAYLIGHT\r\nDTSTART:20250330T020000\r\nRDATE:20260329T020000,",
+		"TZNAME:CEST\r\nTZOFFSETFROM:+0100\r\nTZOFFSETTO:+0200\r\n",
+		"BEGIN:STANDARD\r\nDTSTART:20251026T030000\r\nRDATE:20261025T030000,",
+		"TZNAME:CET\r\nTZOFFSETFROM:+0200\r\nTZOFFSETTO:+0100\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Stockholm VTIMEZONE does not contain %q:\n%s", want, out)
+		}
+	}
+	if year := time.Now().Year() + timezoneYears; !strings.Contains(out, ","+strconv.Itoa(year)+"10") {
+		t.Errorf("Stockholm VTIMEZONE does not cover %d:\n%s", year, out)
+	}
+
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	out = encode(newTimezone(tokyo, time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC), time.Time{}))
+	if strings.Count(out, "BEGIN:STANDARD") != 1 || strings.Contains(out, "DAYLIGHT") || !strings.Contains(out, "TZOFFSETTO:+0900\r\n") {
+		t.Errorf("Tokyo VTIMEZONE should have one +0900 observance:\n%s", out)
+	}
+
+	for seconds, want := range map[int]string{3600: "+0100", -18000: "-0500", 3208: "+005328", 0: "+0000"} {
+		if got := formatOffset(seconds); got != want {
+			t.Errorf("formatOffset(%d) = %q, want %q", seconds, got, want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Package privacy anonymizes calendars for publishing as busy/free feeds
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-ical"
+	"github.com/linus/recal/internal/config"
+	"github.com/linus/recal/internal/parser"
+)
+
+// DefaultPlaceholder is the SUMMARY of anonymized events unless configured otherwise
+const DefaultPlaceholder = "Busy"
+
+// dateTimeLayout is an iCal DATE-TIME without the UTC suffix (RFC 5545 section 3.3.5)
+const dateTimeLayout = "20060102T150405"
+
+// Properties kept on anonymized events; everything else, including X- properties
+// and alarms, is dropped. SUMMARY is replaced by the placeholder.
+var (
+	// minimalProps are kept in every mode: identity, time and recurrence, plus the
+	// DTSTAMP iCalendar requires
+	minimalProps = map[string]bool{
+		ical.PropUID:             true,
+		ical.PropDateTimeStamp:   true,
+		ical.PropDateTimeStart:   true,
+		ical.PropDateTimeEnd:     true,
+		ical.PropDuration:        true,
+		ical.PropRecurrenceRule:  true,
+		ical.PropRecurrenceDates: true,
+		ical.PropExceptionDates:  true,
+		ical.PropRecurrenceID:    true,
+	}
+
+	// busyProps are also kept unless the profile is minimal
+	busyProps = map[string]bool{
+		ical.PropSequence:     true,
+		ical.PropStatus:       true,
+		ical.PropTransparency: true,
+		ical.PropCreated:      true,
+		ical.PropLastModified: true,
+	}
+
+	// keptParams are the parameters kept on kept properties, since any other may carry text
+	keptParams = map[string]bool{
+		ical.ParamTimezoneID: true,
+		ical.ParamValue:      true,
+		ical.ParamRange:      true,
+	}
+
+	// calendarProps are the calendar properties kept; names and descriptions are dropped
+	calendarProps = map[string]bool{
+		ical.PropVersion:       true,
+		ical.PropProductID:     true,
+		ical.PropCalendarScale: true,
+		ical.PropMethod:        true,
+		"X-WR-TIMEZONE":        true,
+	}
+)
+
+// Anonymizer rewrites calendars according to one privacy profile
+type Anonymizer struct {
+	name    string // Profile name, empty for the default profile
+	profile config.PrivacyProfile
+	hashKey string
+}
+
+// New creates an anonymizer for the named profile from privacy.profiles, or the
+// default profile if name is empty, with the request options added
+// Hashed UIDs are refused without privacy.hash_key, as an unkeyed hash of a known
+// UID could be recomputed by anyone.
+func New(cfg *config.Config, name string, options config.PrivacyProfile) (*Anonymizer, error) {
+	var profile config.PrivacyProfile
+	if name != "" {
+		var ok bool
+		if profile, ok = cfg.Privacy.Profiles[name]; !ok {
+			return nil, fmt.Errorf("unknown privacy profile %q", name)
+		}
+	}
+	profile = profile.Merge(options)
+	if profile.HashUID && cfg.Privacy.HashKey == "" {
+		return nil, fmt.Errorf("privacy.hash_uid is not available: no privacy hash key is configured")
+	}
+	if profile.Placeholder == "" {
+		profile.Placeholder = cfg.Privacy.Placeholder
+	}
+	if profile.Placeholder == "" {
+		profile.Placeholder = DefaultPlaceholder
+	}
+	return &Anonymizer{name: name, profile: profile, hashKey: cfg.Privacy.HashKey}, nil
+}
+
+// String describes the profile in effect for debug output
+func (a *Anonymizer) String() string {
+	name := a.name
+	if name == "" {
+		name = "default"
+	}
+	return name + ": " + a.profile.String()
+}
+
+// Apply returns an anonymized copy of cal, leaving cal itself as it was
+// It works on the raw components Calendar.Serialize emits, so properties
+// that are not kept never reach the output. A nil Anonymizer returns cal as is.
+func (a *Anonymizer) Apply(cal *parser.Calendar) *parser.Calendar {
+	if a == nil {
+		return cal
+	}
+
+	out := &parser.Calendar{Location: cal.Location, Events: make([]*parser.Event, 0, len(cal.Events))}
+	if cal.Raw != nil {
+		out.Raw = anonymizeCalendar(cal.Raw)
+	}
+
+	for _, event := range cal.Events {
+		if event.RawEvent == nil || event.RawEvent.Component == nil {
+			continue
+		}
+		event = event.Clone()
+		a.anonymizeEvent(event)
+		out.Events = append(out.Events, event)
+	}
+	return out
+}
+
+// anonymizeCalendar returns a copy of raw with only the kept properties and timezones
+func anonymizeCalendar(raw *ical.Calendar) *ical.Calendar {
+	out := ical.NewCalendar()
+	for name, props := range raw.Props {
+		if calendarProps[name] {
+			out.Props[name] = props
+		}
+	}
+	for _, child := range raw.Children {
+		if child.Name == ical.CompTimezone {
+			out.Children = append(out.Children, child)
+		}
+	}
+	return out
+}
+
+// anonymizeEvent strips event down to the kept properties
+func (a *Anonymizer) anonymizeEvent(event *parser.Event) {
+	comp := event.RawEvent.Component
+	for name, props := range comp.Props {
+		if !minimalProps[name] && (a.profile.Minimal || !busyProps[name]) {
+			comp.Props.Del(name)
+			continue
+		}
+		for _, prop := range props {
+			for param := range prop.Params {
+				if !keptParams[param] {
+					prop.Params.Del(param)
+				}
+			}
+		}
+	}
+	comp.Children = nil
+
+	if !a.profile.Minimal {
+		summary := ical.NewProp(ical.PropSummary)
+		summary.SetText(a.profile.Placeholder)
+		comp.Props.Set(summary)
+	}
+	if a.profile.RoundHours {
+		roundToHours(comp.Props)
+	}
+	if a.profile.HashUID {
+		if uid := comp.Props.Get(ical.PropUID); uid != nil {
+			uid.Value = a.hashUID(uid.Value)
+		}
+	}
+
+	// Keep the parsed fields in line for debug output
+	event.SyncText()
+	event.UID, event.DTStart, event.DTEnd = "", "", ""
+	if prop := comp.Props.Get(ical.PropUID); prop != nil {
+		event.UID = prop.Value
+	}
+	if prop := comp.Props.Get(ical.PropDateTimeStart); prop != nil {
+		event.DTStart = prop.Value
+	}
+	if prop := comp.Props.Get(ical.PropDateTimeEnd); prop != nil {
+		event.DTEnd = prop.Value
+	}
+}
+
+// hashUID returns a stable keyed hash of uid
+// Overrides and their series share a UID, so they still match after hashing.
+func (a *Anonymizer) hashUID(uid string) string {
+	mac := hmac.New(sha256.New, []byte(a.hashKey))
+	mac.Write([]byte(uid))
+	return hex.EncodeToString(mac.Sum(nil)[:16]) + "@recal"
+}
+
+// roundToHours widens the event to whole hours: start times are rounded down and
+// the end up. DATE values are left alone.
+func roundToHours(props ical.Props) {
+	start := props.Get(ical.PropDateTimeStart)
+	if dur := props.Get(ical.PropDuration); dur != nil && start != nil {
+		// Round the end implied by DURATION before the start moves
+		if d, err := dur.Duration(); err == nil {
+			if t, _, ok := parseDateTime(start.Value); ok {
+				dur.SetDuration(roundTime(t.Add(d), true).Sub(roundTime(t, false)))
+			}
+		}
+	}
+
+	for _, name := range []string{ical.PropDateTimeStart, ical.PropRecurrenceID, ical.PropRecurrenceDates, ical.PropExceptionDates} {
+		for i := range props[name] {
+			props[name][i].Value = roundList(props[name][i].Value, false)
+		}
+	}
+	for i := range props[ical.PropDateTimeEnd] {
+		props[ical.PropDateTimeEnd][i].Value = roundList(props[ical.PropDateTimeEnd][i].Value, true)
+	}
+}
+
+// roundList rounds each DATE-TIME in a comma-separated value to the hour, up or down
+func roundList(value string, up bool) string {
+	items := strings.Split(value, ",")
+	for i, item := range items {
+		if t, utc, ok := parseDateTime(item); ok {
+			items[i] = roundTime(t, up).Format(dateTimeLayout)
+			if utc {
+				items[i] += "Z"
+			}
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// parseDateTime parses a DATE-TIME value as wall-clock time, reporting whether it is UTC
+// DATE values, periods and anything else unparseable return false.
+func parseDateTime(value string) (time.Time, bool, bool) {
+	naive, utc := strings.CutSuffix(strings.TrimSpace(value), "Z")
+	t, err := time.Parse(dateTimeLayout, naive)
+	return t, utc, err == nil
+}
+
+// roundTime rounds a wall-clock time to the hour, up or down
+func roundTime(t time.Time, up bool) time.Time {
+	rounded := t.Truncate(time.Hour)
+	if up && rounded.Before(t) {
+		rounded = rounded.Add(time.Hour)
+	}
+	return rounded
+}
@@ -0,0 +1,165 @@
+package privacy
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/linus/recal/internal/config"
+	"github.com/linus/recal/internal/parser"
+)
+
+// privateCalendar carries private text in every place a feed can hold it; each
+// secret contains "HEMLIG" so a leak shows up in the serialized output
+const privateCalendar = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n" +
+	"X-WR-CALNAME:HEMLIG kalender\r\nX-WR-CALDESC:HEMLIG beskrivning\r\nX-WR-TIMEZONE:Europe/Stockholm\r\n" +
+	"BEGIN:VTIMEZONE\r\nTZID:Europe/Stockholm\r\nBEGIN:STANDARD\r\nDTSTART:19701025T030000\r\n" +
+	"TZOFFSETFROM:+0200\r\nTZOFFSETTO:+0100\r\nEND:STANDARD\r\nEND:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\nUID:styrelse-1@example.com\r\nDTSTAMP:20250101T000000Z\r\n" +
+	"DTSTART;TZID=Europe/Stockholm;X-NOTE=HEMLIG:20250110T173000\r\nDTEND;TZID=Europe/Stockholm:20250110T191500\r\n" +
+	"SUMMARY:HEMLIG styrelsemöte\r\nDESCRIPTION:HEMLIG dagordning\r\nLOCATION:HEMLIG lokal\r\n" +
+	"ATTENDEE;CN=HEMLIG Person:mailto:hemlig@example.com\r\nORGANIZER;CN=HEMLIG Ordf:mailto:ordf@example.com\r\n" +
+	"URL:https://example.com/HEMLIG\r\nCATEGORIES:HEMLIG\r\nCOMMENT:HEMLIG\r\nCONTACT:HEMLIG\r\nGEO:57.7;11.9\r\n" +
+	"X-ALT-DESC;FMTTYPE=text/html:<b>HEMLIG</b>\r\nX-GOOGLE-CONFERENCE:https://meet.example.com/HEMLIG\r\n" +
+	"STATUS:CONFIRMED\r\nTRANSP:OPAQUE\r\nSEQUENCE:2\r\nRRULE:FREQ=WEEKLY;COUNT=4\r\n" +
+	"EXDATE;TZID=Europe/Stockholm:20250117T173000\r\n" +
+	"BEGIN:VALARM\r\nACTION:DISPLAY\r\nTRIGGER:-PT15M\r\nDESCRIPTION:HEMLIG påminnelse\r\nEND:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:styrelse-1@example.com\r\nDTSTAMP:20250101T000000Z\r\n" +
+	"RECURRENCE-ID;TZID=Europe/Stockholm:20250124T173000\r\nDTSTART:20250124T170500Z\r\nDURATION:PT50M\r\n" +
+	"SUMMARY:HEMLIG flyttat möte\r\nEND:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+// anonymize parses privateCalendar, anonymizes it with profile and returns the serialized output
+func anonymize(t *testing.T, cfg *config.Config, name string, options config.PrivacyProfile) (*parser.Calendar, string) {
+	t.Helper()
+	cal, err := parser.Parse(strings.NewReader(privateCalendar))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	anonymizer, err := New(cfg, name, options)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	out := anonymizer.Apply(cal)
+	var buf bytes.Buffer
+	if err := out.Serialize(&buf); err != nil {
+		t.Fatalf("Serialize() failed: %v", err)
+	}
+	if !strings.Contains(cal.Events[0].Summary, "HEMLIG") {
+		t.Error("Apply() changed the input calendar")
+	}
+	return out, buf.String()
+}
+
+// eventProps returns the property names used inside VEVENTs of a serialized calendar
+func eventProps(ics string) map[string]bool {
+	names := make(map[string]bool)
+	inEvent := false
+	for _, line := range strings.Split(ics, "\r\n") {
+		switch {
+		case line == "BEGIN:VEVENT":
+			inEvent = true
+		case line == "END:VEVENT":
+			inEvent = false
+		case inEvent && line != "":
+			names[strings.FieldsFunc(line, func(r rune) bool { return r == ':' || r == ';' })[0]] = true
+		}
+	}
+	return names
+}
+
+// TestNoPrivateDataLeaks tests every profile against a calendar full of private data
+// Validates: No private text in the output, only allowed properties on events, alarms and
+// calendar names dropped, placeholder summary, timezones kept, output still valid iCal
+func TestNoPrivateDataLeaks(t *testing.T) {
+	cfg := &config.Config{Privacy: config.PrivacyConfig{
+		Placeholder: "Upptagen",
+		HashKey:     "nyckel",
+		Profiles: map[string]config.PrivacyProfile{
+			"publik": {RoundHours: true, HashUID: true},
+		},
+	}}
+
+	tests := []struct {
+		name    string
+		profile string
+		options config.PrivacyProfile
+		allowed string
+	}{
+		{"default", "", config.PrivacyProfile{}, "UID DTSTAMP DTSTART DTEND DURATION RRULE EXDATE RECURRENCE-ID SUMMARY STATUS TRANSP SEQUENCE"},
+		{"named profile", "publik", config.PrivacyProfile{}, "UID DTSTAMP DTSTART DTEND DURATION RRULE EXDATE RECURRENCE-ID SUMMARY STATUS TRANSP SEQUENCE"},
+		{"minimal", "publik", config.PrivacyProfile{Minimal: true}, "UID DTSTAMP DTSTART DTEND DURATION RRULE EXDATE RECURRENCE-ID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, ics := anonymize(t, cfg, tt.profile, tt.options)
+			if strings.Contains(ics, "HEMLIG") || strings.Contains(ics, "hemlig") || strings.Contains(ics, "ordf@") {
+				t.Errorf("Private data leaked:\n%s", ics)
+			}
+
+			allowed := make(map[string]bool)
+			for _, name := range strings.Fields(tt.allowed) {
+				allowed[name] = true
+			}
+			for name := range eventProps(ics) {
+				if !allowed[name] && name != "BEGIN" && name != "END" {
+					t.Errorf("Event property %s kept, want only %s", name, tt.allowed)
+				}
+			}
+			if strings.Contains(ics, "VALARM") || !strings.Contains(ics, "BEGIN:VTIMEZONE") {
+				t.Error("Want alarms dropped and timezones kept")
+			}
+			if _, err := parser.Parse(strings.NewReader(ics)); err != nil {
+				t.Errorf("Anonymized output does not parse: %v", err)
+			}
+			if len(out.Events) != 2 {
+				t.Errorf("Anonymized calendar has %d events, want 2", len(out.Events))
+			}
+		})
+	}
+
+	_, ics := anonymize(t, cfg, "", config.PrivacyProfile{})
+	if strings.Count(ics, "SUMMARY:Upptagen\r\n") != 2 || !strings.Contains(ics, "UID:styrelse-1@example.com") {
+		t.Errorf("Default profile should use the placeholder and keep UIDs:\n%s", ics)
+	}
+}
+
+// TestRoundHoursAndHashUID tests the optional profile settings
+// Validates: Start and recurrence times rounded down, end and DURATION up, DATE-TIME forms
+// kept, UIDs hashed stably with overrides still matching their series, no hashing without a key
+func TestRoundHoursAndHashUID(t *testing.T) {
+	cfg := &config.Config{Privacy: config.PrivacyConfig{HashKey: "nyckel"}}
+	out, ics := anonymize(t, cfg, "", config.PrivacyProfile{RoundHours: true, HashUID: true})
+
+	for _, want := range []string{
+		"DTSTART;TZID=Europe/Stockholm:20250110T170000\r\n",
+		"DTEND;TZID=Europe/Stockholm:20250110T200000\r\n",
+		"EXDATE;TZID=Europe/Stockholm:20250117T170000\r\n",
+		"RECURRENCE-ID;TZID=Europe/Stockholm:20250124T170000\r\n",
+		"DTSTART:20250124T170000Z\r\n",
+		"DURATION:PT3600S\r\n",
+		"SUMMARY:" + DefaultPlaceholder + "\r\n",
+	} {
+		if !strings.Contains(ics, want) {
+			t.Errorf("Output does not contain %q:\n%s", want, ics)
+		}
+	}
+
+	if out.Events[0].UID == "styrelse-1@example.com" || out.Events[0].UID != out.Events[1].UID {
+		t.Errorf("UIDs = %q and %q, want the same hash for the series and its override", out.Events[0].UID, out.Events[1].UID)
+	}
+	other, _ := anonymize(t, &config.Config{Privacy: config.PrivacyConfig{HashKey: "annan"}}, "", config.PrivacyProfile{HashUID: true})
+	if other.Events[0].UID == out.Events[0].UID {
+		t.Error("Hashed UID does not depend on privacy.hash_key")
+	}
+
+	if _, err := New(cfg, "saknas", config.PrivacyProfile{}); err == nil {
+		t.Error("New() with an unknown profile should fail")
+	}
+	if _, err := New(&config.Config{}, "", config.PrivacyProfile{HashUID: true}); err == nil {
+		t.Error("New() with hash_uid and no hash key should fail")
+	}
+}
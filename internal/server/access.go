@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/linus/recal/internal/fetcher"
+	"github.com/linus/recal/internal/logging"
+)
+
+// quietEndpoints are polled by monitoring, so their access lines are logged at debug level
+var quietEndpoints = map[string]bool{"/health": true, "/ready": true, "/metrics": true}
+
+// accessInfo collects details about a request for its access log line
+// Handlers fill it in through the request context; a nil *accessInfo ignores
+// updates, so code shared with the background refresher does not need to check.
+type accessInfo struct {
+	mu        sync.Mutex // Upstreams are fetched concurrently
+	cache     string     // "HIT", "STALE" or "MISS", empty if not cacheable
+	upstream  []string   // Status of each upstream fetch: HTTP code or "error"
+	events    bool       // Whether eventsIn and eventsOut are set
+	eventsIn  int
+	eventsOut int
+}
+
+// accessInfoKey is the context key for the request's accessInfo
+type accessInfoKey struct{}
+
+// withAccessInfo returns a context carrying info
+func withAccessInfo(ctx context.Context, info *accessInfo) context.Context {
+	return context.WithValue(ctx, accessInfoKey{}, info)
+}
+
+// accessFromContext returns the accessInfo in ctx, or nil if there is none
+func accessFromContext(ctx context.Context) *accessInfo {
+	info, _ := ctx.Value(accessInfoKey{}).(*accessInfo)
+	return info
+}
+
+// setCache records how the response was served from the filtered cache
+func (a *accessInfo) setCache(result string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cache = result
+}
+
+// addUpstream records the status of upstream fetches
+func (a *accessInfo) addUpstream(statuses ...string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.upstream = append(a.upstream, statuses...)
+}
+
+// setEvents records the number of events before and after filtering
+func (a *accessInfo) setEvents(in, out int) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.events, a.eventsIn, a.eventsOut = true, in, out
+}
+
+// upstreamStatuses returns a copy of the recorded upstream statuses
+func (a *accessInfo) upstreamStatuses() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.upstream...)
+}
+
+// attrs returns the collected details as log attributes, leaving out unset ones
+func (a *accessInfo) attrs() []slog.Attr {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var attrs []slog.Attr
+	if a.cache != "" {
+		attrs = append(attrs, slog.String("cache", a.cache))
+	}
+	if len(a.upstream) > 0 {
+		attrs = append(attrs, slog.String("upstream_status", strings.Join(a.upstream, ",")))
+	}
+	if a.events {
+		attrs = append(attrs, slog.Int("events_in", a.eventsIn), slog.Int("events_out", a.eventsOut))
+	}
+	return attrs
+}
+
+// upstreamStatus describes the outcome of an upstream fetch for the access log
+func upstreamStatus(resp *fetcher.Response, notModified bool, err error) string {
+	var statusErr *fetcher.StatusError
+	switch {
+	case errors.As(err, &statusErr):
+		return strconv.Itoa(statusErr.Code)
+	case err != nil:
+		return "error"
+	case notModified:
+		return strconv.Itoa(http.StatusNotModified)
+	}
+	return strconv.Itoa(resp.StatusCode)
+}
+
+// requestID returns the request ID sent by a client or proxy, or a new one
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); logging.ValidRequestID(id) {
+		return id
+	}
+	return logging.NewRequestID()
+}
+
+// logAccess writes the access log line for a finished request
+// Only the path is logged: query strings can contain private calendar URLs.
+func logAccess(ctx context.Context, endpoint string, r *http.Request, rec *statusRecorder, info *accessInfo, duration time.Duration) {
+	level := slog.LevelInfo
+	if quietEndpoints[endpoint] {
+		level = slog.LevelDebug
+	}
+
+	attrs := append([]slog.Attr{
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.String("endpoint", endpoint),
+		slog.Int("status", rec.status),
+		slog.Int64("bytes", rec.bytes),
+		slog.Float64("duration_ms", float64(duration.Microseconds())/1000),
+	}, info.attrs()...)
+	logging.FromContext(ctx).LogAttrs(ctx, level, "request", attrs...)
+}
+
+// logSourceErrors logs upstream sources that could not be used for a request
+func logSourceErrors(ctx context.Context, failed []sourceError) {
+	for _, f := range failed {
+		logging.FromContext(ctx).Error("upstream source failed", "host", upstreamHost(f.URL), "stage", f.Stage, "error", f.Err)
+	}
+}
+
+// upstreamHost returns the host of an upstream URL for logs and the status page
+// Only the host is shown: private calendar URLs carry secret tokens in the path,
+// query or userinfo.
+func upstreamHost(upstreamURL string) string {
+	u, err := url.Parse(upstreamURL)
+	if err != nil || u.Host == "" {
+		return "invalid URL"
+	}
+	return u.Host
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// logBuffer collects JSON log lines written through the default logger
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// lines returns the decoded log lines with the given message
+func (b *logBuffer) lines(t *testing.T, msg string) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var lines []map[string]any
+	for _, raw := range bytes.Split(bytes.TrimSpace(b.buf.Bytes()), []byte("\n")) {
+		var line map[string]any
+		if err := json.Unmarshal(raw, &line); err != nil {
+			t.Fatalf("Log line is not JSON: %q", raw)
+		}
+		if line["msg"] == msg {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// captureLogs sends the default logger to a buffer at debug level for the test
+func captureLogs(t *testing.T) *logBuffer {
+	logs := &logBuffer{}
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return logs
+}
+
+// TestAccessLog tests request IDs and access log lines
+// Validates: X-Request-ID returned and reused, cache result, upstream status and event counts
+// logged, upstream errors logged under the request ID, monitoring requests at debug level
+func TestAccessLog(t *testing.T) {
+	upstream := newICSUpstream(t, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n"+
+		"BEGIN:VEVENT\r\nUID:a\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\nSUMMARY:Grad 3\r\nEND:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\nUID:b\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250111T170000Z\r\nSUMMARY:Grad 8\r\nEND:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+	missing := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(missing.Close)
+
+	cfg := getTestConfig()
+	cfg.Upstream.DefaultURL = upstream.URL
+	cfg.Upstream.Sources = map[string]string{"missing": missing.URL}
+	cfg.Cache.MaxMemory = 1024 * 1024
+	cfg.Cache.MaxTTL = time.Hour
+	handler := New(cfg).Handler()
+	logs := captureLogs(t)
+
+	get := func(path, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if id != "" {
+			req.Header.Set("X-Request-ID", id)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	first := get("/query?pattern=Grad%208", "")
+	if id := first.Header().Get("X-Request-ID"); len(id) != 16 {
+		t.Errorf("X-Request-ID = %q, want a generated ID", id)
+	}
+	if id := get("/query?pattern=Grad%208", "from-proxy-1").Header().Get("X-Request-ID"); id != "from-proxy-1" {
+		t.Errorf("X-Request-ID = %q, want the ID sent by the proxy", id)
+	}
+	if id := get("/health", "bad id\n").Header().Get("X-Request-ID"); id == "bad id\n" || id == "" {
+		t.Errorf("X-Request-ID = %q, want invalid incoming ID replaced", id)
+	}
+	get("/query?source=missing&pattern=x", "req-missing")
+
+	requests := logs.lines(t, "request")
+	if len(requests) != 4 {
+		t.Fatalf("Logged %d access lines, want 4", len(requests))
+	}
+	miss, hit, health, failed := requests[0], requests[1], requests[2], requests[3]
+
+	for key, want := range map[string]any{
+		"request_id": first.Header().Get("X-Request-ID"), "method": "GET", "path": "/query",
+		"status": 200.0, "cache": "MISS", "upstream_status": "200", "events_in": 2.0, "events_out": 1.0,
+	} {
+		if miss[key] != want {
+			t.Errorf("Miss line %s = %v, want %v", key, miss[key], want)
+		}
+	}
+	if miss["bytes"].(float64) != float64(first.Body.Len()) {
+		t.Errorf("Miss line bytes = %v, want %d", miss["bytes"], first.Body.Len())
+	}
+	if hit["request_id"] != "from-proxy-1" || hit["cache"] != "HIT" || hit["upstream_status"] != nil {
+		t.Errorf("Hit line = %v, want proxy ID, HIT and no upstream", hit)
+	}
+	if health["level"] != "DEBUG" {
+		t.Errorf("Health check logged at %v, want DEBUG", health["level"])
+	}
+	if failed["status"] != 502.0 || failed["upstream_status"] != "404" {
+		t.Errorf("Failed line = %v, want 502 with upstream status 404", failed)
+	}
+
+	errs := logs.lines(t, "upstream source failed")
+	if len(errs) != 1 || errs[0]["request_id"] != "req-missing" || errs[0]["level"] != "ERROR" {
+		t.Errorf("Upstream error lines = %v, want one error under req-missing", errs)
+	}
+	if fetches := logs.lines(t, "upstream fetch"); len(fetches) != 2 || fetches[0]["request_id"] == nil {
+		t.Errorf("Upstream fetch lines = %v, want two with request IDs", fetches)
+	}
+}
+
+// TestUpstreamURLRedaction tests that private upstream URLs stay out of logs
+// Validates: Failed sources, upstream fetches and background refreshes log and show
+// only the host, not the path, query or credentials
+func TestUpstreamURLRedaction(t *testing.T) {
+	t.Setenv("DISABLE_SSRF_PROTECTION", "true")
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	host := strings.TrimPrefix(down.URL, "http://")
+	secret := "http://anna:hemlig@" + host + "/calendar/private-hemlig/basic.ics?token=hemlig"
+
+	cfg := getTestConfig()
+	cfg.Upstream.Sources = map[string]string{"private": secret}
+	cfg.Cache.Refresh.Enabled = true
+	server := New(cfg)
+	logs := captureLogs(t)
+
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/query?source=private&pattern=x", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("Status = %d, want %d", w.Code, http.StatusBadGateway)
+	}
+	if errs := logs.lines(t, "upstream source failed"); len(errs) != 1 || errs[0]["host"] != host {
+		t.Errorf("Upstream error lines = %v, want one with host %s", errs, host)
+	}
+
+	server.refresher.track("0123456789abcdef", &Params{Upstreams: []string{secret}})
+	server.refresher.run(context.Background(), refreshJob{Kind: refreshFiltered, Key: "0123456789abcdef"})
+	server.refresher.run(context.Background(), refreshJob{Kind: refreshUpstream, Key: secret})
+	if failed := logs.lines(t, "background refresh failed"); len(failed) != 2 {
+		t.Errorf("Logged %d failed refreshes, want 2", len(failed))
+	}
+
+	status := httptest.NewRecorder()
+	server.Status(status, httptest.NewRequest("GET", "/status", nil))
+	if !strings.Contains(status.Body.String(), host) {
+		t.Error("Status page does not show the refreshed host")
+	}
+	for name, out := range map[string]string{"Log output": logs.buf.String(), "Status page": status.Body.String()} {
+		if strings.Contains(out, "hemlig") {
+			t.Errorf("%s contains the private upstream URL", name)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/linus/recal/internal/cache"
+)
+
+// errAdminForbidden is returned when the admin token is missing, wrong or not configured
+var errAdminForbidden = errors.New("admin token required")
+
+// cacheEntryResponse is the JSON representation of a cache entry
+type cacheEntryResponse struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	Expiry       time.Time `json:"expiry"`
+	Expired      bool      `json:"expired"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Hits         int64     `json:"hits"`
+	LastAccess   time.Time `json:"last_access"`
+}
+
+// cacheListResponse lists the entries of both caches
+type cacheListResponse struct {
+	Upstream []cacheEntryResponse `json:"upstream"`
+	Filtered []cacheEntryResponse `json:"filtered"`
+}
+
+// purgeResponse reports how many entries a purge removed from each cache
+type purgeResponse struct {
+	Upstream int `json:"upstream"`
+	Filtered int `json:"filtered"`
+}
+
+// AdminCacheAPI handles cache inspection and invalidation
+//
+//	GET    /api/admin/cache                 list upstream and filtered entries
+//	DELETE /api/admin/cache?upstream={url}  purge an upstream and all output built from it
+//	DELETE /api/admin/cache?source={name}   same, for a named source
+//	DELETE /api/admin/cache?all=true        purge everything
+//
+// Requires "Authorization: Bearer <admin.token>".
+func (s *Server) AdminCacheAPI(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(bearerToken(r)) {
+		writeJSONError(w, http.StatusForbidden, errAdminForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, cacheListResponse{
+			Upstream: cacheEntries(s.upstreamCache),
+			Filtered: cacheEntries(s.filteredCache),
+		})
+
+	case http.MethodDelete:
+		q := r.URL.Query()
+		upstreamURL := q.Get("upstream")
+		if name := q.Get("source"); name != "" {
+			sourceURL, ok := s.config().Upstream.Sources[name]
+			if !ok {
+				writeJSONError(w, http.StatusNotFound, fmt.Errorf("unknown source %q", name))
+				return
+			}
+			upstreamURL = sourceURL
+		}
+
+		switch {
+		case upstreamURL != "":
+			writeJSON(w, http.StatusOK, s.purgeUpstream(upstreamURL))
+		case q.Get("all") == "true":
+			writeJSON(w, http.StatusOK, s.purgeAll())
+		default:
+			writeJSONError(w, http.StatusBadRequest, errors.New("specify upstream, source or all=true"))
+		}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// purgeUpstream removes an upstream feed and every filtered result derived from it
+// Results still being built when it runs are not stored.
+func (s *Server) purgeUpstream(upstreamURL string) purgeResponse {
+	s.bumpGeneration()
+	var resp purgeResponse
+	if _, found := s.upstreamCache.Peek(upstreamURL); found {
+		s.upstreamCache.Delete(upstreamURL)
+		resp.Upstream = 1
+	}
+	resp.Filtered = s.filteredCache.DeleteTagged(upstreamURL)
+	return resp
+}
+
+// purgeAll empties both caches
+func (s *Server) purgeAll() purgeResponse {
+	s.bumpGeneration()
+	resp := purgeResponse{
+		Upstream: len(s.upstreamCache.Entries()),
+		Filtered: len(s.filteredCache.Entries()),
+	}
+	s.upstreamCache.Clear()
+	s.filteredCache.Clear()
+	return resp
+}
+
+// isAdmin reports whether token matches admin.token, which guards both the admin and feeds APIs
+func (s *Server) isAdmin(token string) bool {
+	adminToken := s.config().Admin.Token
+	if adminToken == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+// cacheEntries lists the entries of c, most recently used first
+func cacheEntries(c cache.Cache) []cacheEntryResponse {
+	infos := c.Entries()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].LastAccess.After(infos[j].LastAccess) })
+
+	now := time.Now()
+	entries := make([]cacheEntryResponse, len(infos))
+	for i, info := range infos {
+		entries[i] = cacheEntryResponse{
+			Key:          info.Key,
+			Size:         info.Size,
+			Expiry:       info.Expiry,
+			Expired:      now.After(info.Expiry),
+			ETag:         info.ETag,
+			LastModified: info.LastModified,
+			Hits:         info.Hits,
+			LastAccess:   info.LastAccess,
+		}
+	}
+	return entries
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// adminRequest sends a request to the admin cache API and returns the recorder
+func adminRequest(s *Server, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.AdminCacheAPI(w, req)
+	return w
+}
+
+// TestAdminCacheAPI tests cache inspection and invalidation
+// Validates: Token required, entries listed, upstream purge cascades to derived output only, purge all
+func TestAdminCacheAPI(t *testing.T) {
+	t.Setenv("DISABLE_SSRF_PROTECTION", "true")
+
+	var summary atomic.Value
+	summary.Store("Grad 3")
+	fixed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/calendar")
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n" +
+			"BEGIN:VEVENT\r\nUID:a\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\nSUMMARY:" + summary.Load().(string) + "\r\nEND:VEVENT\r\n" +
+			"END:VCALENDAR\r\n"))
+	}))
+	t.Cleanup(fixed.Close)
+	other := newICSUpstream(t, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n"+
+		"BEGIN:VEVENT\r\nUID:b\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250111T170000Z\r\nSUMMARY:Grad 3\r\nEND:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+
+	cfg := getTestConfig()
+	cfg.Cache.MaxMemory = 1024 * 1024
+	cfg.Cache.MaxTTL = time.Hour
+	cfg.Upstream.Sources = map[string]string{"other": other.URL}
+	cfg.Admin.Token = "operator"
+	server := New(cfg)
+
+	query := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	fixedOnly := "/query?upstream=" + url.QueryEscape(fixed.URL) + "&pattern=Cancelled"
+	merged := "/query?upstream=" + url.QueryEscape(fixed.URL) + "&source=other&pattern=Cancelled"
+	otherOnly := "/query?source=other&pattern=Cancelled"
+	for _, path := range []string{fixedOnly, merged, otherOnly} {
+		if w := query(path); w.Code != http.StatusOK {
+			t.Fatalf("GET %s status = %d: %s", path, w.Code, w.Body.String())
+		}
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		if w := adminRequest(server, "GET", "/api/admin/cache", token); w.Code != http.StatusForbidden {
+			t.Errorf("Token %q: status = %d, want %d", token, w.Code, http.StatusForbidden)
+		}
+	}
+
+	w := adminRequest(server, "GET", "/api/admin/cache", "operator")
+	var list cacheListResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("List status = %d, err = %v", w.Code, err)
+	}
+	if len(list.Upstream) != 2 || len(list.Filtered) != 3 {
+		t.Fatalf("Listed %d upstream and %d filtered entries, want 2 and 3", len(list.Upstream), len(list.Filtered))
+	}
+	for _, entry := range list.Upstream {
+		if entry.Key == fixed.URL && (entry.ETag != `"v1"` || entry.Size == 0 || entry.Expired) {
+			t.Errorf("Upstream entry = %+v, want ETag, size and future expiry", entry)
+		}
+	}
+
+	// The secretary fixes the event upstream; purging makes it visible right away
+	summary.Store("Grad 3 (moved)")
+	if body := query(fixedOnly).Body.String(); strings.Contains(body, "moved") {
+		t.Fatal("Change visible before purge, want cached output")
+	}
+
+	w = adminRequest(server, "DELETE", "/api/admin/cache?upstream="+url.QueryEscape(fixed.URL), "operator")
+	var purged purgeResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &purged)
+	if w.Code != http.StatusOK || purged.Upstream != 1 || purged.Filtered != 2 {
+		t.Errorf("Purge = %d %+v, want 200 with 1 upstream and 2 filtered", w.Code, purged)
+	}
+	if n := len(server.filteredCache.Entries()); n != 1 {
+		t.Errorf("%d filtered entries left, want output from the other upstream kept", n)
+	}
+	if body := query(fixedOnly).Body.String(); !strings.Contains(body, "moved") {
+		t.Error("Change not visible after purge")
+	}
+
+	if w := adminRequest(server, "DELETE", "/api/admin/cache?source=missing", "operator"); w.Code != http.StatusNotFound {
+		t.Errorf("Unknown source status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := adminRequest(server, "DELETE", "/api/admin/cache", "operator"); w.Code != http.StatusBadRequest {
+		t.Errorf("Purge without target status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w = adminRequest(server, "DELETE", "/api/admin/cache?all=true", "operator")
+	_ = json.Unmarshal(w.Body.Bytes(), &purged)
+	if w.Code != http.StatusOK || purged.Upstream != 2 || purged.Filtered != 2 {
+		t.Errorf("Purge all = %d %+v, want 200 with 2 upstream and 2 filtered", w.Code, purged)
+	}
+	if len(server.upstreamCache.Entries())+len(server.filteredCache.Entries()) != 0 {
+		t.Error("Entries left after purge all")
+	}
+}
+
+// TestPurgeInFlight tests purging while a filtered result is being built
+// Validates: Output from a render that started before the purge is served but not cached
+func TestPurgeInFlight(t *testing.T) {
+	t.Setenv("DISABLE_SSRF_PROTECTION", "true")
+
+	started, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { close(started) })
+		<-release
+		w.Header().Set("Content-Type", "text/calendar")
+		_, _ = w.Write([]byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n" +
+			"BEGIN:VEVENT\r\nUID:a\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\nSUMMARY:Grad 3\r\nEND:VEVENT\r\n" +
+			"END:VCALENDAR\r\n"))
+	}))
+	t.Cleanup(upstream.Close)
+
+	cfg := getTestConfig()
+	cfg.Cache.MaxMemory = 1024 * 1024
+	cfg.Admin.Token = "operator"
+	server := New(cfg)
+
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("GET", "/query?upstream="+url.QueryEscape(upstream.URL)+"&pattern=Cancelled", nil))
+		done <- w.Code
+	}()
+
+	<-started
+	if w := adminRequest(server, "DELETE", "/api/admin/cache?upstream="+url.QueryEscape(upstream.URL), "operator"); w.Code != http.StatusOK {
+		t.Fatalf("Purge status = %d: %s", w.Code, w.Body.String())
+	}
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("Query status = %d, want %d", code, http.StatusOK)
+	}
+	if n := len(server.filteredCache.Entries()); n != 0 {
+		t.Errorf("%d filtered entries after a purge during the build, want 0", n)
+	}
+}
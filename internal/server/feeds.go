@@ -0,0 +1,348 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/linus/recal/internal/feeds"
+	"github.com/linus/recal/internal/fetcher"
+	"github.com/linus/recal/internal/filter"
+)
+
+// maxFeedRequestBody limits the size of JSON bodies sent to /api/feeds
+const maxFeedRequestBody = 64 * 1024
+
+// Defaults for feeds.max_feeds and feeds.create_limit
+const (
+	defaultMaxFeeds        = 1000
+	defaultFeedCreateLimit = 10
+)
+
+// feedCreateWindow is the period feeds.create_limit counts creations over
+const feedCreateWindow = time.Hour
+
+// errCreateLimit is returned when a client has created too many feeds recently
+var errCreateLimit = errors.New("too many feeds created, try again later")
+
+// feedRequest is the JSON body accepted when creating or updating a saved feed
+type feedRequest struct {
+	Slug  string `json:"slug"`
+	Query string `json:"query"` // Filter parameters as a URL query string, e.g. "Grad=3&RemoveInstallt"
+}
+
+// feedResponse is the JSON representation of a saved feed
+type feedResponse struct {
+	Slug      string    `json:"slug"`
+	Query     string    `json:"query"`
+	URL       string    `json:"url"`
+	Token     string    `json:"token,omitempty"` // Only returned once, on create
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FeedHTTP serves a saved feed at /f/{slug}
+// The stored parameters are evaluated on every request, so editing a feed changes
+// what existing subscribers receive.
+func (s *Server) FeedHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Calendar apps sometimes insist on an .ics suffix; slugs never contain dots
+	slug := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/f/"), ".ics")
+
+	feed, ok := s.feeds.Get(slug)
+	if !ok {
+		http.Error(w, "Feed not found", http.StatusNotFound)
+		return
+	}
+
+	q, err := url.ParseQuery(feed.Query)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Saved feed is invalid: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Open the configuration page with the saved parameters for editing
+	if _, hasConfig := r.URL.Query()["configure"]; hasConfig {
+		q.Set("feed", slug)
+		http.Redirect(w, r, "/?"+q.Encode(), http.StatusSeeOther)
+		return
+	}
+
+	params, err := parseQuery(q)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Saved feed is invalid: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// The output format may be chosen per request (/f/{slug}?format=json) or by Accept
+	if _, ok := r.URL.Query()["format"]; ok {
+		if params.Format, err = parseFormat(r.URL.Query().Get("format")); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid parameters: %v", err), http.StatusBadRequest)
+			return
+		}
+	} else if _, ok := q["format"]; !ok {
+		params.Format = acceptFormat(r.Header.Get("Accept"))
+	}
+
+	s.serveFiltered(w, r, params)
+}
+
+// FeedsAPI handles the saved feed JSON API
+//
+//	GET    /api/feeds         list all feeds (admin token required)
+//	POST   /api/feeds         create a feed, returns its edit token
+//	GET    /api/feeds/{slug}  show a feed
+//	PUT    /api/feeds/{slug}  replace a feed's query (edit or admin token required)
+//	DELETE /api/feeds/{slug}  delete a feed (edit or admin token required)
+//
+// Tokens are sent as "Authorization: Bearer <token>"; the admin token is admin.token.
+// Creation is limited per client address by feeds.create_limit (429) and in total by
+// feeds.max_feeds (507).
+func (s *Server) FeedsAPI(w http.ResponseWriter, r *http.Request) {
+	slug := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/feeds"), "/")
+	token := bearerToken(r)
+	admin := s.isAdmin(token)
+
+	if slug == "" {
+		switch r.Method {
+		case http.MethodGet:
+			if !admin {
+				writeJSONError(w, http.StatusForbidden, feeds.ErrForbidden)
+				return
+			}
+			list := s.feeds.List()
+			resp := make([]feedResponse, 0, len(list))
+			for _, f := range list {
+				resp = append(resp, s.feedResponse(f, ""))
+			}
+			writeJSON(w, http.StatusOK, resp)
+
+		case http.MethodPost:
+			cfg := s.config()
+			if !admin {
+				limit := cfg.Feeds.CreateLimit
+				if limit == 0 {
+					limit = defaultFeedCreateLimit
+				}
+				if retry, ok := s.feedLimiter.allow(clientAddr(r), limit, time.Now()); !ok {
+					w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+					writeJSONError(w, http.StatusTooManyRequests, errCreateLimit)
+					return
+				}
+			}
+			maxFeeds := cfg.Feeds.MaxFeeds
+			if maxFeeds == 0 {
+				maxFeeds = defaultMaxFeeds
+			}
+
+			req, err := decodeFeedRequest(w, r)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err)
+				return
+			}
+			query, err := s.normalizeFeedQuery(req.Query)
+			if err != nil {
+				writeJSONError(w, feedQueryStatus(err), err)
+				return
+			}
+			f, editToken, err := s.feeds.Create(strings.ToLower(trimSpace(req.Slug)), query, maxFeeds)
+			if err != nil {
+				writeJSONError(w, feedErrorStatus(err), err)
+				return
+			}
+			writeJSON(w, http.StatusCreated, s.feedResponse(f, editToken))
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		f, ok := s.feeds.Get(slug)
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, feeds.ErrNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.feedResponse(f, ""))
+
+	case http.MethodPut:
+		req, err := decodeFeedRequest(w, r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		query, err := s.normalizeFeedQuery(req.Query)
+		if err != nil {
+			writeJSONError(w, feedQueryStatus(err), err)
+			return
+		}
+		f, err := s.feeds.Update(slug, query, token, admin)
+		if err != nil {
+			writeJSONError(w, feedErrorStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.feedResponse(f, ""))
+
+	case http.MethodDelete:
+		if err := s.feeds.Delete(slug, token, admin); err != nil {
+			writeJSONError(w, feedErrorStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// normalizeFeedQuery validates a filter query string and returns it in canonical form
+// UI-only parameters are dropped and every filter is compiled so broken patterns
+// are rejected when saving rather than when subscribers poll.
+func (s *Server) normalizeFeedQuery(raw string) (string, error) {
+	q, err := url.ParseQuery(strings.TrimPrefix(trimSpace(raw), "?"))
+	if err != nil {
+		return "", fmt.Errorf("invalid query: %w", err)
+	}
+	q.Del("configure")
+	q.Del("debug")
+	q.Del("feed")
+
+	params, err := parseQuery(q)
+	if err != nil {
+		return "", fmt.Errorf("invalid parameters: %w", err)
+	}
+	if err := s.resolveUpstreams(params); err != nil {
+		return "", err
+	}
+	engine := filter.NewEngine(s.config())
+	if err := s.buildFilters(engine, params); err != nil {
+		return "", err
+	}
+	if _, err := s.buildTransforms(engine, params); err != nil {
+		return "", err
+	}
+	if _, err := s.buildPrivacy(engine, params); err != nil {
+		return "", err
+	}
+
+	return q.Encode(), nil
+}
+
+// feedResponse converts a stored feed to its API representation
+func (s *Server) feedResponse(f feeds.Feed, token string) feedResponse {
+	return feedResponse{
+		Slug:      f.Slug,
+		Query:     f.Query,
+		URL:       s.config().Server.BaseURL + "/f/" + f.Slug,
+		Token:     token,
+		CreatedAt: f.CreatedAt,
+		UpdatedAt: f.UpdatedAt,
+	}
+}
+
+// clientAddr returns the address of the client without its port
+func clientAddr(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// createLimiter counts feed creations per client address in fixed windows
+// Counts are dropped when a window ends, so memory is bounded by the clients seen in one.
+type createLimiter struct {
+	mu     sync.Mutex
+	start  time.Time
+	counts map[string]int
+}
+
+// allow records a creation by client if it has made fewer than limit in the current
+// window; otherwise it returns how long until the window ends
+func (l *createLimiter) allow(client string, limit int, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.counts == nil || now.Sub(l.start) >= feedCreateWindow {
+		l.start = now
+		l.counts = make(map[string]int)
+	}
+	if l.counts[client] >= limit {
+		return l.start.Add(feedCreateWindow).Sub(now), false
+	}
+	l.counts[client]++
+	return 0, true
+}
+
+// decodeFeedRequest reads a size-limited JSON feed request body
+func decodeFeedRequest(w http.ResponseWriter, r *http.Request) (*feedRequest, error) {
+	var req feedRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxFeedRequestBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return nil, fmt.Errorf("invalid JSON body: %w", err)
+	}
+	return &req, nil
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return ""
+	}
+	return trimSpace(auth[len(prefix):])
+}
+
+// feedQueryStatus maps errors from normalizeFeedQuery to HTTP status codes
+// Upstreams outside the allowlist are forbidden, as on /query.
+func feedQueryStatus(err error) int {
+	if errors.Is(err, fetcher.ErrUpstreamNotAllowed) {
+		return http.StatusForbidden
+	}
+	return filterErrorStatus(err)
+}
+
+// feedErrorStatus maps feed store errors to HTTP status codes
+func feedErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, feeds.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, feeds.ErrExists):
+		return http.StatusConflict
+	case errors.Is(err, feeds.ErrInvalidSlug):
+		return http.StatusBadRequest
+	case errors.Is(err, feeds.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, feeds.ErrFull):
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONError writes err as a JSON error response
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
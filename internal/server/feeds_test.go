@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/linus/recal/internal/config"
+)
+
+// feedsAPIRequest sends a request to the feeds API and decodes the JSON response
+func feedsAPIRequest(t *testing.T, s *Server, method, path, token, body string) (int, map[string]any) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.FeedsAPI(w, req)
+
+	var resp map[string]any
+	if w.Body.Len() > 0 {
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	}
+	return w.Code, resp
+}
+
+// TestSavedFeed tests saved feeds end to end
+// Validates: Create via API, serve at /f/{slug}, edits reach existing subscribers, token checks
+func TestSavedFeed(t *testing.T) {
+	upstream := newICSUpstream(t, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n"+
+		"BEGIN:VEVENT\r\nUID:1\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\nSUMMARY:TestLodge PB: Grad 3\r\nEND:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\nUID:2\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250111T170000Z\r\nSUMMARY:INSTÄLLT TestLodge PB: Grad 2\r\nEND:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+
+	cfg := getTestConfig()
+	cfg.Upstream.DefaultURL = upstream.URL
+	cfg.Server.BaseURL = "https://recal.example.com"
+	server := New(cfg)
+
+	code, created := feedsAPIRequest(t, server, "POST", "/api/feeds", "", `{"slug":"mina-loger","query":"pattern=Grad%203"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("Create status = %d, want %d (%v)", code, http.StatusCreated, created)
+	}
+	if created["url"] != "https://recal.example.com/f/mina-loger" {
+		t.Errorf("url = %v, want https://recal.example.com/f/mina-loger", created["url"])
+	}
+	token, _ := created["token"].(string)
+	if token == "" {
+		t.Fatal("Create response missing edit token")
+	}
+
+	fetchFeed := func(path string) string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		server.FeedHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s status = %d, body: %s", path, w.Code, w.Body.String())
+		}
+		return w.Body.String()
+	}
+
+	body := fetchFeed("/f/mina-loger")
+	if strings.Contains(body, "UID:1\r\n") || !strings.Contains(body, "UID:2\r\n") {
+		t.Errorf("Saved pattern not applied:\n%s", body)
+	}
+
+	// Editing requires the token, and changes what the same URL serves
+	if code, _ := feedsAPIRequest(t, server, "PUT", "/api/feeds/mina-loger", "wrong", `{"query":"RemoveInstallt"}`); code != http.StatusForbidden {
+		t.Errorf("Update with wrong token status = %d, want %d", code, http.StatusForbidden)
+	}
+	if code, resp := feedsAPIRequest(t, server, "PUT", "/api/feeds/mina-loger", token, `{"query":"RemoveInstallt"}`); code != http.StatusOK {
+		t.Fatalf("Update status = %d, want %d (%v)", code, http.StatusOK, resp)
+	}
+	body = fetchFeed("/f/mina-loger.ics")
+	if !strings.Contains(body, "UID:1\r\n") || strings.Contains(body, "UID:2\r\n") {
+		t.Errorf("Edited feed not applied:\n%s", body)
+	}
+
+	// Configure redirects to the config page with the saved parameters
+	w := httptest.NewRecorder()
+	server.FeedHTTP(w, httptest.NewRequest("GET", "/f/mina-loger?configure", nil))
+	if loc := w.Header().Get("Location"); w.Code != http.StatusSeeOther || loc != "/?RemoveInstallt=&feed=mina-loger" {
+		t.Errorf("Configure redirect = %d %q", w.Code, loc)
+	}
+
+	if code, _ := feedsAPIRequest(t, server, "DELETE", "/api/feeds/mina-loger", token, ""); code != http.StatusNoContent {
+		t.Errorf("Delete status = %d, want %d", code, http.StatusNoContent)
+	}
+	w = httptest.NewRecorder()
+	server.FeedHTTP(w, httptest.NewRequest("GET", "/f/mina-loger", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Deleted feed status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+// TestFeedsAPIValidation tests rejected feed definitions and admin access
+func TestFeedsAPIValidation(t *testing.T) {
+	cfg := getTestConfig()
+	cfg.Admin.Token = "admin-secret"
+	cfg.Upstream.Allowed = config.AllowedConfig{Enabled: true, Hosts: []string{"*.google.com"}}
+	server := New(cfg)
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{"invalid regex", `{"slug":"bad","query":"pattern=(unclosed"}`, http.StatusBadRequest},
+		{"invalid date range", `{"slug":"bad","query":"from=yesterday-ish"}`, http.StatusBadRequest},
+		{"invalid slug", `{"slug":"Not A Slug","query":"Grad=3"}`, http.StatusBadRequest},
+		{"upstream not allowed", `{"slug":"bad","query":"upstream=https%3A%2F%2Fevil.example.com%2Fcal.ics"}`, http.StatusForbidden},
+		{"malformed JSON", `{"slug":`, http.StatusBadRequest},
+		{"valid", `{"slug":"ok","query":"?Grad=3&debug=true"}`, http.StatusCreated},
+		{"duplicate", `{"slug":"ok","query":"Grad=4"}`, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, resp := feedsAPIRequest(t, server, "POST", "/api/feeds", "", tt.body)
+			if code != tt.wantCode {
+				t.Errorf("Status = %d, want %d (%v)", code, tt.wantCode, resp)
+			}
+		})
+	}
+
+	// UI-only parameters are stripped before saving
+	if _, resp := feedsAPIRequest(t, server, "GET", "/api/feeds/ok", "", ""); resp["query"] != "Grad=3" {
+		t.Errorf("Stored query = %v, want Grad=3", resp["query"])
+	}
+
+	// Listing needs the admin token, which may also edit any feed
+	if code, _ := feedsAPIRequest(t, server, "GET", "/api/feeds", "", ""); code != http.StatusForbidden {
+		t.Errorf("List without admin token status = %d, want %d", code, http.StatusForbidden)
+	}
+	if code, _ := feedsAPIRequest(t, server, "GET", "/api/feeds", "admin-secret", ""); code != http.StatusOK {
+		t.Errorf("List with admin token status = %d, want %d", code, http.StatusOK)
+	}
+	if code, _ := feedsAPIRequest(t, server, "PUT", "/api/feeds/ok", "admin-secret", `{"query":"Grad=5"}`); code != http.StatusOK {
+		t.Errorf("Admin update status = %d, want %d", code, http.StatusOK)
+	}
+	if code, _ := feedsAPIRequest(t, server, "PUT", "/api/feeds/ok", "admin-secret", `{"query":"upstream=https%3A%2F%2Fevil.example.com%2Fcal.ics"}`); code != http.StatusForbidden {
+		t.Errorf("Update to an unlisted upstream status = %d, want %d", code, http.StatusForbidden)
+	}
+}
+
+// TestFeedsAPILimits tests the limits on creating feeds
+// Validates: Per-client create limit with Retry-After, admin exemption, store size limit
+func TestFeedsAPILimits(t *testing.T) {
+	cfg := getTestConfig()
+	cfg.Admin.Token = "admin-secret"
+	cfg.Feeds.CreateLimit = 2
+	cfg.Feeds.MaxFeeds = 3
+	server := New(cfg)
+
+	for i := 0; i < 2; i++ {
+		if code, resp := feedsAPIRequest(t, server, "POST", "/api/feeds", "", `{"query":"Grad=3"}`); code != http.StatusCreated {
+			t.Fatalf("Create %d status = %d, want %d (%v)", i+1, code, http.StatusCreated, resp)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/api/feeds", strings.NewReader(`{"query":"Grad=3"}`))
+	w := httptest.NewRecorder()
+	server.FeedsAPI(w, req)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Create over the client limit status = %d, Retry-After = %q, want 429 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// Another client is counted separately, until the store is full
+	req = httptest.NewRequest("POST", "/api/feeds", strings.NewReader(`{"query":"Grad=3"}`))
+	req.RemoteAddr = "198.51.100.7:4711"
+	w = httptest.NewRecorder()
+	server.FeedsAPI(w, req)
+	if w.Code != http.StatusCreated {
+		t.Errorf("Create by another client status = %d, want %d", w.Code, http.StatusCreated)
+	}
+	if code, _ := feedsAPIRequest(t, server, "POST", "/api/feeds", "admin-secret", `{"query":"Grad=3"}`); code != http.StatusInsufficientStorage {
+		t.Errorf("Create in a full store status = %d, want %d", code, http.StatusInsufficientStorage)
+	}
+}
@@ -53,6 +53,7 @@ func TestIntegrationConfigPage(t *testing.T) {
 		"<input type=\"checkbox\" id=\"remove-installt\">",
 		"<button id=\"copy-url-btn\"",
 		"<button id=\"download-ical-btn\"",
+		"const FORM_PARAMS = ['source', 'Grad', 'Loge', 'RemoveUnconfirmed', 'RemoveInstallt'];",
 	}
 
 	for _, elem := range requiredElements {
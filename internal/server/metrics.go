@@ -0,0 +1,237 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/linus/recal/internal/cache"
+	"github.com/linus/recal/internal/logging"
+	"github.com/linus/recal/internal/metrics"
+)
+
+// eventBuckets are bucket bounds for events per calendar
+var eventBuckets = []float64{10, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// Upstream fetch outcomes used as the outcome label
+const (
+	fetchOK          = "ok"
+	fetchNotModified = "not_modified"
+	fetchError       = "error"
+)
+
+// serverMetrics holds the Prometheus metrics exposed on /metrics
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	requests      *metrics.CounterVec   // By endpoint and status code
+	duration      *metrics.HistogramVec // By endpoint
+	fetches       *metrics.CounterVec   // By host and outcome
+	fetchDuration *metrics.HistogramVec // By host
+	parseErrors   *metrics.CounterVec   // By host
+	eventsIn      *metrics.HistogramVec // Events before filtering, per build
+	eventsOut     *metrics.HistogramVec // Events after filtering, per build
+
+	conditional atomic.Int64 // Conditional upstream requests sent
+	notModified atomic.Int64 // Conditional requests answered with 304
+}
+
+// newServerMetrics registers all metrics; cache and coalescing statistics are read from s on scrape
+func newServerMetrics(s *Server) *serverMetrics {
+	r := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry: r,
+		requests: r.NewCounterVec("recal_http_requests_total",
+			"HTTP requests handled, by endpoint and status code.", "endpoint", "code"),
+		duration: r.NewHistogramVec("recal_http_request_duration_seconds",
+			"HTTP request latency in seconds, by endpoint.", metrics.DefaultBuckets, "endpoint"),
+		fetches: r.NewCounterVec("recal_upstream_fetches_total",
+			"Upstream requests, by host and outcome (ok, not_modified, error).", "host", "outcome"),
+		fetchDuration: r.NewHistogramVec("recal_upstream_fetch_duration_seconds",
+			"Upstream request duration in seconds, by host.", metrics.DefaultBuckets, "host"),
+		parseErrors: r.NewCounterVec("recal_upstream_parse_errors_total",
+			"Upstream feeds that could not be parsed as iCal, by host.", "host"),
+		eventsIn: r.NewHistogramVec("recal_filter_events_in",
+			"Events in the upstream calendars of each filtered build.", eventBuckets),
+		eventsOut: r.NewHistogramVec("recal_filter_events_out",
+			"Events left after filtering in each filtered build.", eventBuckets),
+	}
+
+	r.RegisterFunc(func() []metrics.Family {
+		ratio := 0.0
+		if conditional := m.conditional.Load(); conditional > 0 {
+			ratio = float64(m.notModified.Load()) / float64(conditional)
+		}
+		upstreamFlight, renderFlight := s.upstreamFlight.GetStats(), s.renderFlight.GetStats()
+		return []metrics.Family{
+			gauge("recal_upstream_not_modified_ratio",
+				"Share of conditional upstream requests answered with 304 Not Modified.", ratio),
+			{
+				Name: "recal_coalesced_total",
+				Help: "Requests that shared another request's upstream fetch or filter build.",
+				Type: metrics.TypeCounter,
+				Samples: []metrics.Sample{
+					{Labels: []metrics.Label{{Name: "work", Value: "upstream"}}, Value: float64(upstreamFlight.Coalesced)},
+					{Labels: []metrics.Label{{Name: "work", Value: "render"}}, Value: float64(renderFlight.Coalesced)},
+				},
+			},
+			gauge("recal_start_time_seconds", "Unix time the server started.", float64(s.startTime.Unix())),
+		}
+	})
+	r.RegisterFunc(func() []metrics.Family {
+		return cacheFamilies(map[string]cache.Stats{
+			"upstream": s.upstreamCache.GetStats(),
+			"filtered": s.filteredCache.GetStats(),
+		})
+	})
+
+	return m
+}
+
+// gauge returns a family with a single unlabelled gauge sample
+func gauge(name, help string, value float64) metrics.Family {
+	return metrics.Family{Name: name, Help: help, Type: metrics.TypeGauge, Samples: []metrics.Sample{{Value: value}}}
+}
+
+// cacheFamilies exposes cache.Stats for each named cache
+// Stats are fetched once per scrape, which matters for shared backends.
+func cacheFamilies(stats map[string]cache.Stats) []metrics.Family {
+	names := []string{"upstream", "filtered"}
+	fields := []struct {
+		name, help, typ string
+		value           func(cache.Stats) float64
+	}{
+		{"recal_cache_entries", "Entries in the cache.", metrics.TypeGauge, func(s cache.Stats) float64 { return float64(s.Entries) }},
+		{"recal_cache_max_entries", "Maximum entries in memory (0 for shared backends).", metrics.TypeGauge, func(s cache.Stats) float64 { return float64(s.MaxSize) }},
+		{"recal_cache_memory_bytes", "Approximate size of cached entries in bytes (0 for shared backends).", metrics.TypeGauge, func(s cache.Stats) float64 { return float64(s.Memory) }},
+		{"recal_cache_max_memory_bytes", "Memory limit in bytes (0 for shared backends).", metrics.TypeGauge, func(s cache.Stats) float64 { return float64(s.MaxMemory) }},
+		{"recal_cache_hits_total", "Cache hits.", metrics.TypeCounter, func(s cache.Stats) float64 { return float64(s.Hits) }},
+		{"recal_cache_misses_total", "Cache misses.", metrics.TypeCounter, func(s cache.Stats) float64 { return float64(s.Misses) }},
+		{"recal_cache_hit_ratio", "Hits divided by lookups since start.", metrics.TypeGauge, func(s cache.Stats) float64 { return s.HitRatio }},
+		{"recal_cache_evictions_total", "Entries evicted to stay within limits.", metrics.TypeCounter, func(s cache.Stats) float64 { return float64(s.Evictions) }},
+		{"recal_cache_expired_total", "Expired entries swept from memory.", metrics.TypeCounter, func(s cache.Stats) float64 { return float64(s.Expired) }},
+		{"recal_cache_stale_hits_total", "Expired entries served because upstreams failed.", metrics.TypeCounter, func(s cache.Stats) float64 { return float64(s.StaleHits) }},
+		{"recal_cache_disk_entries", "Entries in the disk tier.", metrics.TypeGauge, func(s cache.Stats) float64 { return float64(s.DiskEntries) }},
+		{"recal_cache_disk_bytes", "Size of the disk tier in bytes.", metrics.TypeGauge, func(s cache.Stats) float64 { return float64(s.DiskBytes) }},
+		{"recal_cache_disk_max_bytes", "Disk tier budget in bytes (0 when disabled).", metrics.TypeGauge, func(s cache.Stats) float64 { return float64(s.DiskMaxBytes) }},
+		{"recal_cache_disk_hits_total", "Entries read back from the disk tier.", metrics.TypeCounter, func(s cache.Stats) float64 { return float64(s.DiskHits) }},
+		{"recal_cache_disk_errors_total", "Failed disk writes and corrupt files discarded.", metrics.TypeCounter, func(s cache.Stats) float64 { return float64(s.DiskErrors) }},
+		{"recal_cache_backend_errors_total", "Errors talking to a shared cache backend.", metrics.TypeCounter, func(s cache.Stats) float64 { return float64(s.Errors) }},
+	}
+
+	families := make([]metrics.Family, len(fields))
+	for i, field := range fields {
+		f := metrics.Family{Name: field.name, Help: field.help, Type: field.typ}
+		for _, name := range names {
+			f.Samples = append(f.Samples, metrics.Sample{
+				Labels: []metrics.Label{{Name: "cache", Value: name}},
+				Value:  field.value(stats[name]),
+			})
+		}
+		families[i] = f
+	}
+	return families
+}
+
+// Metrics serves all metrics in the Prometheus text exposition format
+func (s *Server) Metrics(w http.ResponseWriter, r *http.Request) {
+	s.metrics.registry.ServeHTTP(w, r)
+}
+
+// instrument wraps h to count requests and measure latency under the endpoint label
+// endpoint is the registered route pattern, so label values stay bounded. Requests
+// are also counted in the sliding windows shown on the status page, given a request
+// ID (returned in X-Request-ID) and written to the access log.
+func (s *Server) instrument(endpoint string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := requestID(r)
+		w.Header().Set("X-Request-ID", id)
+		info := &accessInfo{}
+		ctx := withAccessInfo(logging.WithRequestID(r.Context(), id), info)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r.WithContext(ctx))
+		duration := time.Since(start)
+
+		s.metrics.requests.Inc(endpoint, strconv.Itoa(rec.status))
+		s.metrics.duration.Observe(duration.Seconds(), endpoint)
+		s.requestMetrics.Record(endpoint, rec.status)
+		logAccess(ctx, endpoint, r, rec, info, duration)
+	}
+}
+
+// statusRecorder remembers the status code and body size written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	bytes       int64
+}
+
+// Write counts the bytes written
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// WriteHeader records the first status code
+func (r *statusRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.status = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// observeFetch records the duration and outcome of an upstream request
+func (s *Server) observeFetch(upstreamURL string, start time.Time, conditional, notModified bool, err error) {
+	host := s.upstreamHost(upstreamURL)
+	outcome := fetchOK
+	switch {
+	case err != nil:
+		outcome = fetchError
+	case notModified:
+		outcome = fetchNotModified
+	}
+	s.metrics.fetches.Inc(host, outcome)
+	s.metrics.fetchDuration.Observe(time.Since(start).Seconds(), host)
+
+	if conditional && err == nil {
+		s.metrics.conditional.Add(1)
+		if notModified {
+			s.metrics.notModified.Add(1)
+		}
+	}
+}
+
+// upstreamHost returns the host of an upstream URL for use as a label
+// Without an allowlist anyone can pick an upstream, so hosts other than those of
+// the default URL and named sources are reported as "other" to bound the series.
+func (s *Server) upstreamHost(upstreamURL string) string {
+	cfg := s.config()
+	known := cfg.Upstream.Allowed.Enabled || upstreamURL == cfg.Upstream.DefaultURL
+	for _, sourceURL := range cfg.Upstream.Sources {
+		known = known || upstreamURL == sourceURL
+	}
+	if !known {
+		return "other"
+	}
+
+	u, err := url.Parse(upstreamURL)
+	if err != nil || u.Host == "" {
+		return "invalid"
+	}
+	return u.Host
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMetricsEndpoint tests the Prometheus endpoint end to end
+// Validates: Request counts by route and status, upstream fetch outcomes, 304 ratio,
+// parse errors, events in and out, cache gauges
+func TestMetricsEndpoint(t *testing.T) {
+	upstream := newICSUpstream(t, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n"+
+		"BEGIN:VEVENT\r\nUID:a\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\nSUMMARY:Grad 3\r\nEND:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\nUID:b\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250111T170000Z\r\nSUMMARY:Grad 8\r\nEND:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/calendar")
+		_, _ = w.Write([]byte("BEGIN:VCALENDAR\r\nnot ical"))
+	}))
+	t.Cleanup(broken.Close)
+
+	cfg := getTestConfig()
+	cfg.Upstream.DefaultURL = upstream.URL
+	cfg.Upstream.Sources = map[string]string{"broken": broken.URL}
+	cfg.Cache.MaxMemory = 1024 * 1024
+	cfg.Cache.MaxTTL = time.Hour
+	server := New(cfg)
+	handler := server.Handler()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	get("/query?pattern=Grad%208")
+	get("/query?pattern=Grad%208")
+	get("/query?source=broken&pattern=x")
+	get("/api/feeds/missing")
+
+	w := get("/metrics")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want %d", w.Code, http.StatusOK)
+	}
+	body := w.Body.String()
+
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	for _, want := range []string{
+		`recal_http_requests_total{endpoint="/query",code="200"} 2`,
+		`recal_http_requests_total{endpoint="/api/feeds/",code="404"} 1`,
+		`recal_http_request_duration_seconds_count{endpoint="/query"} 3`,
+		`recal_upstream_fetches_total{host="` + host + `",outcome="ok"} 1`,
+		`recal_upstream_parse_errors_total{host="` + strings.TrimPrefix(broken.URL, "http://") + `"} 1`,
+		`recal_upstream_not_modified_ratio 0`,
+		`recal_filter_events_in_sum 2`,
+		`recal_filter_events_out_sum 1`,
+		`recal_cache_entries{cache="upstream"} 2`,
+		`recal_cache_entries{cache="filtered"} 1`,
+		`recal_cache_hits_total{cache="filtered"} 1`,
+		"# TYPE recal_cache_hits_total counter",
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("Metrics missing %q", want)
+		}
+	}
+}
+
+// TestUpstreamHostLabel tests that host labels stay bounded
+// Validates: Configured upstreams labelled by host, arbitrary ones as "other" unless an allowlist is set
+func TestUpstreamHostLabel(t *testing.T) {
+	cfg := getTestConfig()
+	cfg.Upstream.DefaultURL = "https://calendar.example.com/a.ics"
+	cfg.Upstream.Sources = map[string]string{"b": "https://other.example.org/b.ics"}
+	server := New(cfg)
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://calendar.example.com/a.ics", "calendar.example.com"},
+		{"https://other.example.org/b.ics", "other.example.org"},
+		{"https://random.example.net/c.ics", "other"},
+	}
+	for _, tt := range tests {
+		if got := server.upstreamHost(tt.url); got != tt.want {
+			t.Errorf("upstreamHost(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+
+	cfg.Upstream.Allowed.Enabled = true
+	if got := server.upstreamHost("https://random.example.net/c.ics"); got != "random.example.net" {
+		t.Errorf("upstreamHost() with allowlist = %q, want random.example.net", got)
+	}
+}
@@ -0,0 +1,453 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	htmlutil "html"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/linus/recal/internal/cache"
+	"github.com/linus/recal/internal/config"
+	"github.com/linus/recal/internal/filter"
+)
+
+// Refresh defaults used when the corresponding cache.refresh setting is zero
+const (
+	defaultRefreshInterval  = 30 * time.Second
+	defaultRefreshLead      = 2 * time.Minute
+	defaultRefreshJitter    = 15 * time.Second
+	defaultRefreshWorkers   = 4
+	defaultRefreshMinHits   = 2
+	defaultRefreshHotWindow = time.Hour
+
+	maxRecentRefreshes = 20 // Outcomes kept for the status page
+)
+
+// Refresh job kinds
+const (
+	refreshUpstream = "upstream" // Revalidate a cached upstream feed
+	refreshFiltered = "filtered" // Rebuild a cached filtered output
+)
+
+// Refresh results
+const (
+	refreshUpdated     = "updated"
+	refreshNotModified = "not modified"
+	refreshFailed      = "failed"
+)
+
+// refreshJob is a single cache entry to refresh
+type refreshJob struct {
+	Kind string
+	Key  string // Upstream URL or filtered cache key
+}
+
+// refreshOutcome records the result of a refresh job for the status page
+type refreshOutcome struct {
+	Time     time.Time
+	Kind     string
+	Target   string
+	Result   string
+	Duration time.Duration
+	Err      string
+}
+
+// refreshStats summarizes background refresh activity
+type refreshStats struct {
+	Enabled     bool
+	Workers     int
+	Tracked     int // Filtered outputs that can be rebuilt
+	LastScan    time.Time
+	Updated     int64
+	NotModified int64
+	Failed      int64
+	Skipped     int64 // Hot entries not queued because the workers were busy
+	Recent      []refreshOutcome
+}
+
+// refresher keeps popular cache entries warm
+// On every scan it picks entries that were hit recently and often enough and
+// expire soon, and refreshes them on a worker pool with a random delay so
+// upstreams do not see bursts. Upstream feeds are revalidated with conditional
+// requests; filtered outputs are rebuilt from the parameters that produced them.
+type refresher struct {
+	s        *Server
+	cfg      config.RefreshConfig
+	interval time.Duration
+	lead     time.Duration
+	jitter   time.Duration
+	workers  int
+	minHits  int64
+	window   time.Duration
+
+	mu       sync.Mutex
+	queries  map[string]*Params // Filtered cache key → parameters that produced it
+	inFlight map[refreshJob]bool
+	stats    refreshStats
+
+	jobs     chan refreshJob
+	stopOnce sync.Once
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// newRefresher creates a refresher for s, applying defaults for unset settings
+func newRefresher(s *Server, cfg config.RefreshConfig) *refresher {
+	r := &refresher{
+		s:        s,
+		cfg:      cfg,
+		interval: orDefault(cfg.Interval, defaultRefreshInterval),
+		lead:     orDefault(cfg.Lead, defaultRefreshLead),
+		jitter:   orDefault(cfg.Jitter, defaultRefreshJitter),
+		workers:  cfg.Workers,
+		minHits:  cfg.MinHits,
+		window:   orDefault(cfg.HotWindow, defaultRefreshHotWindow),
+		queries:  make(map[string]*Params),
+		inFlight: make(map[refreshJob]bool),
+	}
+	if r.workers == 0 {
+		r.workers = defaultRefreshWorkers
+	}
+	if r.minHits == 0 {
+		r.minHits = defaultRefreshMinHits
+	}
+	r.stats.Enabled = cfg.Enabled
+	r.stats.Workers = r.workers
+	return r
+}
+
+// orDefault returns d, or def if d is zero
+func orDefault(d, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return d
+}
+
+// track remembers the parameters behind a filtered cache entry so it can be rebuilt
+func (r *refresher) track(key string, params *Params) {
+	if !r.cfg.Enabled {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queries[key] = params
+}
+
+// start launches the scan loop and worker pool
+func (r *refresher) start() {
+	if !r.cfg.Enabled {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.jobs = make(chan refreshJob, r.workers*4)
+
+	for i := 0; i < r.workers; i++ {
+		r.wg.Add(1)
+		go r.worker(ctx)
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.dispatch(r.scan())
+			}
+		}
+	}()
+
+	slog.Info("background refresh enabled", "workers", r.workers, "interval", r.interval)
+}
+
+// stop cancels running refreshes and waits for all goroutines to exit
+func (r *refresher) stop() {
+	r.stopOnce.Do(func() {
+		if r.cancel == nil {
+			return
+		}
+		r.cancel()
+		r.wg.Wait()
+	})
+}
+
+// worker runs queued jobs after a random delay
+func (r *refresher) worker(ctx context.Context) {
+	defer r.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-r.jobs:
+			if r.jitter > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(rand.N(r.jitter)):
+				}
+			}
+			r.run(ctx, job)
+		}
+	}
+}
+
+// dispatch queues jobs without blocking; jobs that do not fit are skipped until the next scan
+func (r *refresher) dispatch(jobs []refreshJob) {
+	for _, job := range jobs {
+		r.mu.Lock()
+		if r.inFlight[job] {
+			r.mu.Unlock()
+			continue
+		}
+		select {
+		case r.jobs <- job:
+			r.inFlight[job] = true
+		default:
+			r.stats.Skipped++
+		}
+		r.mu.Unlock()
+	}
+}
+
+// scan returns the hot entries of both caches that expire within the lead time
+// Tracked parameters whose output has left the filtered cache are forgotten.
+func (r *refresher) scan() []refreshJob {
+	now := time.Now()
+	var jobs []refreshJob
+
+	for _, info := range r.s.upstreamCache.Entries() {
+		if r.due(info, now) {
+			jobs = append(jobs, refreshJob{Kind: refreshUpstream, Key: info.Key})
+		}
+	}
+
+	present := make(map[string]bool)
+	for _, info := range r.s.filteredCache.Entries() {
+		present[info.Key] = true
+		if r.due(info, now) {
+			jobs = append(jobs, refreshJob{Kind: refreshFiltered, Key: info.Key})
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key := range r.queries {
+		if !present[key] {
+			delete(r.queries, key)
+		}
+	}
+	r.stats.LastScan = now
+
+	// Filtered jobs need tracked parameters; entries cached before tracking started are skipped
+	kept := jobs[:0]
+	for _, job := range jobs {
+		if job.Kind == refreshFiltered && r.queries[job.Key] == nil {
+			continue
+		}
+		kept = append(kept, job)
+	}
+	return kept
+}
+
+// due reports whether a cache entry is hot and expires within the lead time
+func (r *refresher) due(info cache.EntryInfo, now time.Time) bool {
+	return info.Hits >= r.minHits &&
+		now.Sub(info.LastAccess) <= r.window &&
+		info.Expiry.Sub(now) <= r.lead
+}
+
+// run executes a refresh job and records its outcome
+func (r *refresher) run(ctx context.Context, job refreshJob) {
+	start := time.Now()
+	target := job.Key
+
+	var result string
+	var err error
+	switch job.Kind {
+	case refreshUpstream:
+		target = upstreamHost(job.Key)
+		result, err = r.refreshUpstream(ctx, job.Key)
+	case refreshFiltered:
+		var params *Params
+		r.mu.Lock()
+		params = r.queries[job.Key]
+		r.mu.Unlock()
+		if params == nil {
+			err = fmt.Errorf("no parameters tracked")
+			break
+		}
+		hosts := make([]string, len(params.Upstreams))
+		for i, u := range params.Upstreams {
+			hosts[i] = upstreamHost(u)
+		}
+		target = strings.Join(hosts, ", ") + " [" + job.Key[:12] + "]"
+		result, err = r.refreshFiltered(ctx, job.Key, params)
+	}
+	if err != nil {
+		result = refreshFailed
+		slog.Warn("background refresh failed", "kind", job.Kind, "target", target, "error", err)
+	}
+
+	outcome := refreshOutcome{
+		Time:     start,
+		Kind:     job.Kind,
+		Target:   target,
+		Result:   result,
+		Duration: time.Since(start),
+	}
+	if err != nil {
+		outcome.Err = err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.inFlight, job)
+	switch result {
+	case refreshUpdated:
+		r.stats.Updated++
+	case refreshNotModified:
+		r.stats.NotModified++
+	case refreshFailed:
+		r.stats.Failed++
+	}
+	r.stats.Recent = append([]refreshOutcome{outcome}, r.stats.Recent...)
+	if len(r.stats.Recent) > maxRecentRefreshes {
+		r.stats.Recent = r.stats.Recent[:maxRecentRefreshes]
+	}
+}
+
+// refreshUpstream revalidates a cached upstream feed with a conditional request
+// A 304 response renews the cached copy for as long as its cache headers allow.
+func (r *refresher) refreshUpstream(ctx context.Context, upstreamURL string) (string, error) {
+	entry, found := r.s.upstreamCache.Peek(upstreamURL)
+	if !found {
+		return "", fmt.Errorf("entry no longer cached")
+	}
+
+	start := time.Now()
+	resp, notModified, err := r.s.fetcher.FetchConditional(ctx, upstreamURL, entry.ETag, entry.LastModified)
+	r.s.observeFetch(upstreamURL, start, true, notModified, err)
+	if err != nil {
+		return "", err
+	}
+	if notModified {
+		r.s.upstreamCache.Set(upstreamURL, entry.Data, r.s.upstreamTTL(resp), entry.ETag, entry.LastModified)
+		return refreshNotModified, nil
+	}
+
+	r.s.storeUpstream(upstreamURL, resp)
+	return refreshUpdated, nil
+}
+
+// refreshFiltered rebuilds a filtered output and stores it under its cache key
+// Partial results are not stored, so the previous output stays until it expires.
+func (r *refresher) refreshFiltered(ctx context.Context, key string, params *Params) (string, error) {
+	gen := r.s.generation()
+	engine := filter.NewEngine(r.s.config())
+	if err := r.s.buildFilters(engine, params); err != nil {
+		return "", err
+	}
+	pipeline, err := r.s.buildTransforms(engine, params)
+	if err != nil {
+		return "", err
+	}
+	anonymizer, err := r.s.buildPrivacy(engine, params)
+	if err != nil {
+		return "", err
+	}
+
+	output, ttl, failed, err := r.s.renderFiltered(ctx, key, gen, engine, pipeline, anonymizer, params)
+	if err != nil {
+		return "", err
+	}
+	if len(failed) > 0 {
+		msgs := make([]string, len(failed))
+		for i, f := range failed {
+			msgs[i] = fmt.Sprintf("%s: %s failed: %v", upstreamHost(f.URL), f.Stage, f.Err)
+		}
+		return "", fmt.Errorf("%s", strings.Join(msgs, "; "))
+	}
+
+	r.s.storeFiltered(key, gen, output, ttl, params, engine)
+	return refreshUpdated, nil
+}
+
+// snapshot returns a copy of the refresh statistics
+func (r *refresher) snapshot() refreshStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := r.stats
+	stats.Tracked = len(r.queries)
+	stats.Recent = append([]refreshOutcome(nil), r.stats.Recent...)
+	return stats
+}
+
+// refreshStatusHTML renders the background refresh section of the status page
+func refreshStatusHTML(stats refreshStats) string {
+	if !stats.Enabled {
+		return `<p>Background refresh is disabled (cache.refresh.enabled).</p>`
+	}
+
+	lastScan := "never"
+	if !stats.LastScan.IsZero() {
+		lastScan = formatDuration(time.Since(stats.LastScan)) + " ago"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<table>
+        <tr><th>Metric</th><th>Value</th></tr>
+        <tr><td>Workers</td><td>%d</td></tr>
+        <tr><td>Tracked Queries</td><td>%d</td></tr>
+        <tr><td>Last Scan</td><td>%s</td></tr>
+        <tr><td>Updated</td><td>%d</td></tr>
+        <tr><td>Not Modified</td><td>%d</td></tr>
+        <tr><td>Failed</td><td class="%s">%d</td></tr>
+        <tr><td>Skipped (busy)</td><td>%d</td></tr>
+    </table>`,
+		stats.Workers, stats.Tracked, lastScan,
+		stats.Updated, stats.NotModified,
+		failedClass(stats.Failed), stats.Failed, stats.Skipped)
+
+	if len(stats.Recent) == 0 {
+		return b.String()
+	}
+
+	b.WriteString(`
+    <h3>Recent Refreshes</h3>
+    <table>
+        <tr><th>When</th><th>Kind</th><th>Target</th><th>Result</th><th>Duration</th></tr>`)
+	for _, o := range stats.Recent {
+		result := htmlutil.EscapeString(o.Result)
+		if o.Err != "" {
+			result = fmt.Sprintf(`<span class="metric-bad">%s: %s</span>`, result, htmlutil.EscapeString(o.Err))
+		}
+		fmt.Fprintf(&b, `
+        <tr><td>%s ago</td><td>%s</td><td>%s</td><td>%s</td><td>%v</td></tr>`,
+			formatDuration(time.Since(o.Time)), o.Kind, htmlutil.EscapeString(o.Target), result,
+			o.Duration.Round(time.Millisecond))
+	}
+	b.WriteString(`
+    </table>`)
+	return b.String()
+}
+
+// failedClass returns CSS class for a failure count
+func failedClass(failed int64) string {
+	if failed == 0 {
+		return "metric-good"
+	}
+	return "metric-bad"
+}
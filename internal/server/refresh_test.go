@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newRefreshTestServer returns a server with background refresh enabled for an
+// upstream that answers conditional requests with 304 and a 30 minute max-age
+func newRefreshTestServer(t *testing.T) (*Server, *atomic.Int64) {
+	t.Helper()
+	t.Setenv("DISABLE_SSRF_PROTECTION", "true")
+
+	var requests atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.Header().Set("Cache-Control", "max-age=1800")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n" +
+			"BEGIN:VEVENT\r\nUID:a\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\nSUMMARY:Grad 3\r\nEND:VEVENT\r\n" +
+			"END:VCALENDAR\r\n"))
+	}))
+	t.Cleanup(upstream.Close)
+
+	cfg := getTestConfig()
+	cfg.Upstream.DefaultURL = upstream.URL
+	cfg.Cache.MaxMemory = 1024 * 1024
+	cfg.Cache.MaxTTL = time.Hour
+	cfg.Cache.Refresh.Enabled = true
+	cfg.Cache.Refresh.Lead = time.Hour // Everything is due
+	cfg.Cache.Refresh.MinHits = 1
+	cfg.Cache.Refresh.Jitter = time.Millisecond
+	cfg.Cache.Refresh.Interval = 10 * time.Millisecond
+
+	return New(cfg), &requests
+}
+
+// TestBackgroundRefresh tests hot entry selection and refresh outcomes
+// Validates: Only hit entries are refreshed, 304 revalidation renewed for the 304's
+// max-age, outcomes on /status
+func TestBackgroundRefresh(t *testing.T) {
+	server, requests := newRefreshTestServer(t)
+
+	query := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("GET", "/query?Grad=3", nil))
+		return w
+	}
+
+	// A single request leaves nothing hot
+	if w := query(); w.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if jobs := server.refresher.scan(); len(jobs) != 0 {
+		t.Fatalf("scan() = %v before any cache hit, want no jobs", jobs)
+	}
+
+	// A cache hit makes the filtered output hot
+	if w := query(); w.Header().Get("X-Cache") != "HIT" {
+		t.Fatal("Second request was not served from cache")
+	}
+	jobs := server.refresher.scan()
+	if len(jobs) != 1 || jobs[0].Kind != refreshFiltered {
+		t.Fatalf("scan() = %v, want one filtered job", jobs)
+	}
+
+	before := requests.Load()
+	server.refresher.run(context.Background(), jobs[0])
+	if requests.Load() != before+1 {
+		t.Errorf("Filtered refresh made %d upstream requests, want 1", requests.Load()-before)
+	}
+
+	// Rebuilding hit the upstream cache, so the upstream feed is hot now too
+	jobs = server.refresher.scan()
+	var upstreamJob *refreshJob
+	for i := range jobs {
+		if jobs[i].Kind == refreshUpstream {
+			upstreamJob = &jobs[i]
+		}
+	}
+	if upstreamJob == nil {
+		t.Fatalf("scan() = %v, want an upstream job", jobs)
+	}
+	server.refresher.run(context.Background(), *upstreamJob)
+	if entry, _ := server.upstreamCache.Peek(upstreamJob.Key); time.Until(entry.Expiry) < 29*time.Minute {
+		t.Errorf("Revalidated entry expires in %v, want the 304's max-age of 30m", time.Until(entry.Expiry))
+	}
+
+	stats := server.refresher.snapshot()
+	if stats.Updated != 1 || stats.NotModified != 1 || stats.Failed != 0 {
+		t.Errorf("Stats = %d updated, %d not modified, %d failed, want 1, 1, 0",
+			stats.Updated, stats.NotModified, stats.Failed)
+	}
+	if stats.Tracked != 1 || len(stats.Recent) != 2 {
+		t.Errorf("Tracked = %d, recent = %d, want 1, 2", stats.Tracked, len(stats.Recent))
+	}
+
+	w := httptest.NewRecorder()
+	server.Status(w, httptest.NewRequest("GET", "/status", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, "Background Refresh") || !strings.Contains(body, "not modified") {
+		t.Error("Status page missing refresh outcomes")
+	}
+}
+
+// TestRefresherStartStop tests the refresh loop lifecycle
+// Validates: Scheduled refreshes run, stop waits for workers and is idempotent
+func TestRefresherStartStop(t *testing.T) {
+	server, _ := newRefreshTestServer(t)
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("GET", "/query?Grad=3", nil))
+	}
+
+	server.refresher.start()
+	deadline := time.Now().Add(2 * time.Second)
+	for server.refresher.snapshot().Updated == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	server.refresher.stop()
+	server.refresher.stop()
+
+	if server.refresher.snapshot().Updated == 0 {
+		t.Error("No background refresh ran")
+	}
+}
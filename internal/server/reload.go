@@ -0,0 +1,259 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	htmlutil "html"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/linus/recal/internal/config"
+	"github.com/linus/recal/internal/filter"
+)
+
+// Reload triggers shown in logs and on the status page
+const (
+	ReloadSignal     = "SIGHUP"
+	ReloadFileChange = "file change"
+)
+
+// Prefixes of filtered cache tags naming a filter config section or transform preset
+const (
+	filterTagPrefix    = "filter:"
+	transformTagPrefix = "transform:"
+)
+
+// privacyTag is the filtered cache tag of anonymized output (privacy=)
+const privacyTag = "privacy"
+
+// filterTag returns the filtered cache tag for a filter config section
+func filterTag(section string) string {
+	return filterTagPrefix + section
+}
+
+// transformTag returns the filtered cache tag for a transform preset
+func transformTag(preset string) string {
+	return transformTagPrefix + preset
+}
+
+// reloadSections are the parts of the configuration compared on reload
+// Changed filters.* sections, transform presets and privacy settings purge the filtered
+// output built from them.
+var reloadSections = []struct {
+	name  string
+	field func(*config.Config) any
+}{
+	{"server", func(c *config.Config) any { return c.Server }},
+	{"upstream", func(c *config.Config) any { return c.Upstream }},
+	{"regex", func(c *config.Config) any { return c.Regex }},
+	{"filters." + filter.SectionGrade, func(c *config.Config) any { return c.Filters.Grade }},
+	{"filters." + filter.SectionLodge, func(c *config.Config) any { return c.Filters.Lodge }},
+	{"filters." + filter.SectionConfirmedOnly, func(c *config.Config) any { return c.Filters.ConfirmedOnly }},
+	{"filters." + filter.SectionInstallt, func(c *config.Config) any { return c.Filters.Installt }},
+	{"transforms", func(c *config.Config) any { return c.Transforms }},
+	{"privacy", func(c *config.Config) any { return c.Privacy }},
+	{"feeds", func(c *config.Config) any { return c.Feeds }},
+	{"admin", func(c *config.Config) any { return c.Admin }},
+}
+
+// restartSettings are read once at startup, so reloading cannot change them
+// A reload keeps their current values and reports the ones that differ.
+var restartSettings = []struct {
+	name  string
+	field func(*config.Config) any // Pointer to the setting
+}{
+	{"server.port", func(c *config.Config) any { return &c.Server.Port }},
+	{"server.read_timeout", func(c *config.Config) any { return &c.Server.ReadTimeout }},
+	{"server.write_timeout", func(c *config.Config) any { return &c.Server.WriteTimeout }},
+	{"server.idle_timeout", func(c *config.Config) any { return &c.Server.IdleTimeout }},
+	{"server.reload_interval", func(c *config.Config) any { return &c.Server.ReloadInterval }},
+	{"upstream.timeout", func(c *config.Config) any { return &c.Upstream.Timeout }},
+	{"upstream.allow_cidrs", func(c *config.Config) any { return &c.Upstream.AllowCIDRs }},
+	{"upstream.deny_cidrs", func(c *config.Config) any { return &c.Upstream.DenyCIDRs }},
+	{"cache", func(c *config.Config) any { return &c.Cache }},
+	{"feeds.store_path", func(c *config.Config) any { return &c.Feeds.StorePath }},
+	{"log", func(c *config.Config) any { return &c.Log }},
+}
+
+// reloadStatus records the outcome of the last configuration reload for the status page
+type reloadStatus struct {
+	Time    time.Time
+	Trigger string
+	Err     string   // Why the file was rejected; the previous configuration stays in use
+	Changed []string // Sections that changed, see reloadSections
+	Restart []string // Changed settings that need a restart, see restartSettings
+	Purged  int      // Filtered cache entries removed because their filters changed
+}
+
+// Reload loads the configuration file at path and swaps it in for new requests
+// The file is validated like at startup; if it cannot be loaded the current
+// configuration stays in use and the error is returned. Filtered output built
+// from changed filter sections, transform presets or privacy settings is purged
+// from the cache.
+func (s *Server) Reload(path, trigger string) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	status := reloadStatus{Time: time.Now(), Trigger: trigger}
+	next, err := config.Load(path)
+	if err != nil {
+		status.Err = err.Error()
+		s.lastReload = status
+		slog.Error("config reload failed, keeping the current configuration",
+			"trigger", trigger, "path", path, "error", err)
+		return err
+	}
+
+	current := s.config()
+	status.Restart = keepRestartSettings(current, next)
+	status.Changed = changedSections(current, next)
+
+	// Swap, then start a new purge generation before purging: builds still running
+	// with the old config are not stored
+	s.cfg.Store(next)
+	s.fetcher.SetConfig(next)
+	s.bumpGeneration()
+	for _, section := range status.Changed {
+		if name, ok := strings.CutPrefix(section, "filters."); ok {
+			status.Purged += s.filteredCache.DeleteTagged(filterTag(name))
+		}
+		if section == "privacy" {
+			status.Purged += s.filteredCache.DeleteTagged(privacyTag)
+		}
+	}
+	for _, preset := range changedPresets(current, next) {
+		status.Purged += s.filteredCache.DeleteTagged(transformTag(preset))
+	}
+	s.lastReload = status
+
+	slog.Info("configuration reloaded", "trigger", trigger, "path", path,
+		"changed", strings.Join(status.Changed, ","), "purged", status.Purged)
+	if len(status.Restart) > 0 {
+		slog.Warn("changed settings take effect after a restart", "settings", strings.Join(status.Restart, ","))
+	}
+	return nil
+}
+
+// keepRestartSettings copies settings that cannot be reloaded from current into next
+// and returns the names of those that differed
+func keepRestartSettings(current, next *config.Config) []string {
+	var changed []string
+	for _, setting := range restartSettings {
+		old := reflect.ValueOf(setting.field(current)).Elem()
+		value := reflect.ValueOf(setting.field(next)).Elem()
+		if !reflect.DeepEqual(old.Interface(), value.Interface()) {
+			changed = append(changed, setting.name)
+			value.Set(old)
+		}
+	}
+	return changed
+}
+
+// changedSections returns the names of the sections that differ between current and next
+func changedSections(current, next *config.Config) []string {
+	var changed []string
+	for _, section := range reloadSections {
+		if !reflect.DeepEqual(section.field(current), section.field(next)) {
+			changed = append(changed, section.name)
+		}
+	}
+	return changed
+}
+
+// changedPresets returns the names of the transform presets that differ between
+// current and next, including removed ones
+func changedPresets(current, next *config.Config) []string {
+	var changed []string
+	for name, rules := range current.Transforms.Presets {
+		if !reflect.DeepEqual(rules, next.Transforms.Presets[name]) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range next.Transforms.Presets {
+		if _, ok := current.Transforms.Presets[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// WatchConfig reloads the configuration whenever the file at path changes
+// The modification time and size are checked every interval until ctx is cancelled.
+// A file that cannot be read, e.g. while it is being replaced, is checked again
+// on the next tick.
+func (s *Server) WatchConfig(ctx context.Context, path string, interval time.Duration) {
+	last, _ := statVersion(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		version, err := statVersion(path)
+		if err != nil || version == last {
+			continue
+		}
+		last = version
+		_ = s.Reload(path, ReloadFileChange)
+	}
+}
+
+// fileVersion identifies the contents of a file well enough to notice edits
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+// statVersion returns the modification time and size of the file at path
+func statVersion(path string) (fileVersion, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}, err
+	}
+	return fileVersion{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// reloadSnapshot returns the outcome of the last reload
+func (s *Server) reloadSnapshot() reloadStatus {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	return s.lastReload
+}
+
+// reloadStatusHTML renders the last configuration reload for the status page
+func reloadStatusHTML(status reloadStatus) string {
+	if status.Time.IsZero() {
+		return `<p>Not reloaded since start. Send SIGHUP or set server.reload_interval to reload config.yaml.</p>`
+	}
+
+	result := `<span class="metric-good">applied</span>`
+	if status.Err != "" {
+		result = fmt.Sprintf(`<span class="metric-bad">rejected: %s</span>`, htmlutil.EscapeString(status.Err))
+	}
+	changed := "none"
+	if len(status.Changed) > 0 {
+		changed = strings.Join(status.Changed, ", ")
+	}
+	restart := "none"
+	if len(status.Restart) > 0 {
+		restart = fmt.Sprintf(`<span class="metric-warning">%s</span>`, strings.Join(status.Restart, ", "))
+	}
+
+	return fmt.Sprintf(`<table>
+        <tr><th>Metric</th><th>Value</th></tr>
+        <tr><td>Last Reload</td><td>%s ago (%s)</td></tr>
+        <tr><td>Result</td><td>%s</td></tr>
+        <tr><td>Changed Sections</td><td>%s</td></tr>
+        <tr><td>Purged Filtered Entries</td><td>%d</td></tr>
+        <tr><td>Pending Restart</td><td>%s</td></tr>
+    </table>`,
+		formatDuration(time.Since(status.Time)), htmlutil.EscapeString(status.Trigger),
+		result, changed, status.Purged, restart)
+}
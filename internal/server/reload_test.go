@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/linus/recal/internal/config"
+)
+
+// writeConfig writes a complete config file for the reload tests
+func writeConfig(t *testing.T, path, upstream string, port int, lodgeTemplate string) {
+	t.Helper()
+	data := fmt.Sprintf(`server:
+  port: %d
+  base_url: http://localhost
+upstream:
+  default_url: %s
+  timeout: 5s
+cache:
+  max_size: 100
+  max_memory: 1048576
+  default_ttl: 5m
+  min_output_cache: 15m
+  max_ttl: 1h
+regex:
+  max_execution_time: 1s
+filters:
+  grade:
+    field: SUMMARY
+    pattern_template: "Grad %%s"
+  lodge:
+    field: SUMMARY
+    names: [Göta, Borås]
+    patterns:
+      default:
+        template: %q
+`, port, upstream, lodgeTemplate)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+}
+
+// TestReload tests swapping in a changed config file
+// Validates: Only output built from changed filter sections is purged, restart-only
+// settings are kept and reported, invalid files leave the config in place, outcome
+// logged and shown on /status
+func TestReload(t *testing.T) {
+	upstream := newICSUpstream(t, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n"+
+		"BEGIN:VEVENT\r\nUID:a\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\nSUMMARY:Göta PB: Möte\r\nEND:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\nUID:b\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250111T170000Z\r\nSUMMARY:Borås Loge: Möte\r\nEND:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\nUID:c\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250112T170000Z\r\nSUMMARY:Grad 3\r\nEND:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, upstream.URL, 8080, "%s PB:")
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	s := New(cfg)
+	handler := s.Handler()
+	logs := captureLogs(t)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d: %s", path, w.Code, w.Body.String())
+		}
+		return w
+	}
+
+	const lodgeQuery, plainQuery = "/query?Loge=Bor%C3%A5s", "/query?pattern=Grad%203"
+	get(lodgeQuery)
+	get(plainQuery)
+	if !strings.Contains(get(lodgeQuery).Body.String(), "Loge: Möte") {
+		t.Fatal("Lodge pattern matched \"Borås Loge:\" before the reload")
+	}
+
+	// Change the lodge patterns and the port
+	writeConfig(t, path, upstream.URL, 9090, "%s (PB|Loge):")
+	if err := s.Reload(path, ReloadSignal); err != nil {
+		t.Fatalf("Reload() error: %v", err)
+	}
+	status := s.reloadSnapshot()
+	if !slices.Equal(status.Changed, []string{"filters.lodge"}) || status.Purged != 1 {
+		t.Errorf("Reload changed %v and purged %d, want filters.lodge and 1", status.Changed, status.Purged)
+	}
+	if !slices.Equal(status.Restart, []string{"server.port"}) || s.config().Server.Port != 8080 {
+		t.Errorf("Restart = %v with port %d, want server.port reported and 8080 kept", status.Restart, s.config().Server.Port)
+	}
+
+	lodge := get(lodgeQuery)
+	if lodge.Header().Get("X-Cache") == "HIT" || strings.Contains(lodge.Body.String(), "Loge: Möte") {
+		t.Error("Lodge query served from cache or with the old pattern after reload")
+	}
+	if get(plainQuery).Header().Get("X-Cache") != "HIT" {
+		t.Error("Query without special filters was purged, want it kept")
+	}
+
+	// An invalid file is rejected and the current config stays
+	current := s.config()
+	if err := os.WriteFile(path, []byte("server:\n  port: 8080\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+	if err := s.Reload(path, ReloadFileChange); err == nil {
+		t.Error("Reload() of an invalid file succeeded")
+	}
+	if s.config() != current {
+		t.Error("Invalid file replaced the configuration")
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/status", nil))
+	if body := w.Body.String(); !strings.Contains(body, "Configuration Reload") || !strings.Contains(body, "rejected: invalid configuration") {
+		t.Error("Status page does not show the rejected reload")
+	}
+	if lines := logs.lines(t, "configuration reloaded"); len(lines) != 1 || lines[0]["purged"] != 1.0 {
+		t.Errorf("Reload log lines = %v, want one with purged 1", lines)
+	}
+	if lines := logs.lines(t, "config reload failed, keeping the current configuration"); len(lines) != 1 {
+		t.Errorf("Logged %d failed reloads, want 1", len(lines))
+	}
+}
+
+// TestReloadInFlight tests reloading while a filtered result is being built
+// Validates: Output from a render that started with the old config is not cached
+func TestReloadInFlight(t *testing.T) {
+	t.Setenv("DISABLE_SSRF_PROTECTION", "true")
+
+	started, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { close(started) })
+		<-release
+		w.Header().Set("Content-Type", "text/calendar")
+		_, _ = w.Write([]byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n" +
+			"BEGIN:VEVENT\r\nUID:b\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250111T170000Z\r\nSUMMARY:Borås Loge: Möte\r\nEND:VEVENT\r\n" +
+			"END:VCALENDAR\r\n"))
+	}))
+	t.Cleanup(upstream.Close)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, upstream.URL, 8080, "%s PB:")
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	s := New(cfg)
+	captureLogs(t)
+
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/query?Loge=Bor%C3%A5s", nil))
+		done <- w.Code
+	}()
+
+	<-started
+	writeConfig(t, path, upstream.URL, 8080, "%s (PB|Loge):")
+	if err := s.Reload(path, ReloadSignal); err != nil {
+		t.Fatalf("Reload() error: %v", err)
+	}
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("Query status = %d, want %d", code, http.StatusOK)
+	}
+	if n := len(s.filteredCache.Entries()); n != 0 {
+		t.Errorf("%d filtered entries after a reload during the build, want 0", n)
+	}
+}
+
+// TestWatchConfig tests reloading when the config file changes
+// Validates: An edited file is picked up by polling without a signal
+func TestWatchConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, "https://example.com/calendar.ics", 8080, "%s PB:")
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	s := New(cfg)
+	captureLogs(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.WatchConfig(ctx, path, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond) // Let the watcher record the current version
+
+	writeConfig(t, path, "https://example.com/calendar.ics", 8080, "%s Loge:")
+	deadline := time.Now().Add(2 * time.Second)
+	for s.config().Filters.Lodge.Patterns["default"].Template != "%s Loge:" {
+		if time.Now().After(deadline) {
+			t.Fatal("Edited config file was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status := s.reloadSnapshot(); status.Trigger != ReloadFileChange {
+		t.Errorf("Reload trigger = %q, want %q", status.Trigger, ReloadFileChange)
+	}
+}
@@ -4,34 +4,92 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	htmlutil "html"
 	"html/template"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/linus/recal/internal/cache"
 	"github.com/linus/recal/internal/config"
+	"github.com/linus/recal/internal/feeds"
 	"github.com/linus/recal/internal/fetcher"
 	"github.com/linus/recal/internal/filter"
+	"github.com/linus/recal/internal/logging"
 	"github.com/linus/recal/internal/metrics"
 	"github.com/linus/recal/internal/parser"
+	"github.com/linus/recal/internal/privacy"
+	"github.com/linus/recal/internal/singleflight"
+	"github.com/linus/recal/internal/transform"
 	"golang.org/x/text/collate"
 	"golang.org/x/text/language"
 )
 
+// Default expansion window for recurring events when no from/to is given
+const (
+	defaultExpandPast   = 90 * 24 * time.Hour
+	defaultExpandFuture = 365 * 24 * time.Hour
+)
+
+// Expansion modes for recurring events (expand= parameter)
+const (
+	ExpandNone      = ""          // Filters see each series as one event
+	ExpandInstances = "instances" // Emit one standalone VEVENT per occurrence
+	ExpandExdate    = "exdate"    // Filter per occurrence, emit the master with EXDATEs (RDATEs if cut by the window)
+)
+
+// Output formats (format= parameter or Accept header)
+const (
+	FormatICS  = ""     // iCalendar (text/calendar)
+	FormatJSON = "json" // Event list, see parser.Calendar.SerializeJSON
+	FormatJCal = "jcal" // jCal (RFC 7265)
+)
+
+// formatContentTypes maps output formats to their Content-Type
+var formatContentTypes = map[string]string{
+	FormatICS:  "text/calendar; charset=utf-8",
+	FormatJSON: "application/json; charset=utf-8",
+	FormatJCal: "application/calendar+json; charset=utf-8",
+}
+
+// acceptFormats maps media types in the Accept header to output formats
+var acceptFormats = map[string]string{
+	"text/calendar":             FormatICS,
+	"application/json":          FormatJSON,
+	"application/calendar+json": FormatJCal,
+}
+
 // Server is the HTTP server for the ReCal application
 type Server struct {
-	cfg            *config.Config
-	upstreamCache  *cache.Cache
-	filteredCache  *cache.Cache
+	cfg            atomic.Pointer[config.Config] // Swapped by Reload; read through config()
+	upstreamCache  cache.Cache
+	filteredCache  cache.Cache
 	fetcher        *fetcher.Fetcher
+	feeds          *feeds.Store
+	feedLimiter    createLimiter // Per-client limit on feed creation, see feeds.create_limit
+	refresher      *refresher
+	upstreamFlight singleflight.Group[upstreamResult] // Coalesces fetches of the same upstream URL
+	renderFlight   singleflight.Group[renderResult]   // Coalesces builds of the same filtered output
 	requestMetrics *metrics.RequestMetrics
+	metrics        *serverMetrics // Prometheus metrics served on /metrics
 	startTime      time.Time
+	ready          atomic.Bool // Reported on /ready; set while serving, cleared when draining
+	reloadMu       sync.Mutex  // Serializes Reload and guards lastReload
+	lastReload     reloadStatus
+	purgeGen       atomic.Uint64 // Bumped by purges and reloads, see generation
+	purgeMu        sync.RWMutex  // Orders storeFiltered against bumpGeneration
 }
 
 // New creates a new server
@@ -40,39 +98,137 @@ func New(cfg *config.Config) *Server {
 	// This allows CI tests to access localhost for test data
 	var f *fetcher.Fetcher
 	if os.Getenv("DISABLE_SSRF_PROTECTION") == "true" {
-		log.Println("WARNING: SSRF protection disabled (test mode)")
+		slog.Warn("SSRF protection disabled (test mode)")
 		f = fetcher.NewTestFetcher(cfg)
 	} else {
 		f = fetcher.NewFetcher(cfg)
 	}
 
-	return &Server{
-		cfg: cfg,
-		upstreamCache: cache.NewCacheWithMemoryLimit(
-			cfg.Cache.MaxSize,
-			cfg.Cache.DefaultTTL,
-			cfg.Cache.MinOutputCache,
-			cfg.Cache.MaxMemory,
-			cfg.Cache.MaxTTL,
-		),
-		filteredCache: cache.NewCacheWithMemoryLimit(
-			cfg.Cache.MaxSize*2, // Filtered cache can be larger
-			cfg.Cache.DefaultTTL,
-			cfg.Cache.MinOutputCache,
-			cfg.Cache.MaxMemory*2, // Double memory for filtered cache
-			cfg.Cache.MaxTTL,
-		),
+	// Load saved feeds; on failure fall back to memory only so a broken file is never overwritten
+	store, err := feeds.NewStore(cfg.Feeds.StorePath)
+	if err != nil {
+		slog.Error("saved feeds are not persisted", "error", err)
+		store, _ = feeds.NewStore("")
+	}
+
+	upstreamCache, filteredCache := newCaches(cfg)
+
+	s := &Server{
+		upstreamCache:  upstreamCache,
+		filteredCache:  filteredCache,
 		fetcher:        f,
+		feeds:          store,
 		requestMetrics: metrics.NewRequestMetrics(),
 		startTime:      time.Now(),
 	}
+	s.cfg.Store(cfg)
+	s.refresher = newRefresher(s, cfg.Cache.Refresh)
+	s.metrics = newServerMetrics(s)
+	return s
+}
+
+// config returns the current configuration
+// Requests should call it once and use the result throughout, so a concurrent
+// reload cannot give them a mix of old and new settings.
+func (s *Server) config() *config.Config {
+	return s.cfg.Load()
+}
+
+// newCaches creates the upstream and filtered caches for the configured backend
+func newCaches(cfg *config.Config) (cache.Cache, cache.Cache) {
+	if cfg.Cache.Backend == "redis" {
+		prefix := cfg.Cache.Redis.KeyPrefix
+		if prefix == "" {
+			prefix = "recal:"
+		}
+		opts := cache.RedisOptions{
+			Addr:       cfg.Cache.Redis.Addr,
+			Password:   cfg.Cache.Redis.Password,
+			DB:         cfg.Cache.Redis.DB,
+			PoolSize:   cfg.Cache.Redis.PoolSize,
+			Timeout:    cfg.Cache.Redis.Timeout,
+			DefaultTTL: cfg.Cache.DefaultTTL,
+			MinTTL:     cfg.Cache.MinOutputCache,
+			MaxTTL:     cfg.Cache.MaxTTL,
+		}
+		upstreamOpts := opts
+		upstreamOpts.Prefix = prefix + "upstream:"
+		filteredOpts := opts
+		filteredOpts.Prefix = prefix + "filtered:"
+		filteredOpts.StaleGrace = cfg.Cache.StaleGrace
+
+		slog.Info("using shared Redis cache", "addr", cfg.Cache.Redis.Addr)
+		return cache.NewRedisCache(upstreamOpts), cache.NewRedisCache(filteredOpts)
+	}
+
+	filteredCache := cache.NewCacheWithMemoryLimit(
+		cfg.Cache.MaxSize*2, // Filtered cache can be larger
+		cfg.Cache.DefaultTTL,
+		cfg.Cache.MinOutputCache,
+		cfg.Cache.MaxMemory*2, // Double memory for filtered cache
+		cfg.Cache.MaxTTL,
+	)
+	// Keep expired output around so it can be served while upstreams are down
+	filteredCache.SetStaleGrace(cfg.Cache.StaleGrace)
+
+	upstreamCache := cache.NewCacheWithMemoryLimit(
+		cfg.Cache.MaxSize,
+		cfg.Cache.DefaultTTL,
+		cfg.Cache.MinOutputCache,
+		cfg.Cache.MaxMemory,
+		cfg.Cache.MaxTTL,
+	)
+
+	// Persist both caches so they are warm after a restart
+	if dir := cfg.Cache.Disk.Dir; dir != "" {
+		enableDiskCache(upstreamCache, "upstream", filepath.Join(dir, "upstream"), cfg.Cache.Disk.MaxBytes/2)
+		enableDiskCache(filteredCache, "filtered", filepath.Join(dir, "filtered"), cfg.Cache.Disk.MaxBytes/2)
+	}
+
+	return upstreamCache, filteredCache
+}
+
+// startJanitors sweeps expired entries from the in-process caches in the background
+// Shared backends expire entries on their own.
+func (s *Server) startJanitors() {
+	interval := s.config().Cache.CleanupInterval
+	if interval == 0 {
+		interval = time.Minute
+	}
+	for _, c := range []cache.Cache{s.upstreamCache, s.filteredCache} {
+		if m, ok := c.(*cache.MemoryCache); ok {
+			m.StartJanitor(interval)
+		}
+	}
+}
+
+// stopWorkers stops the background work started by serve and closes shared cache connections
+func (s *Server) stopWorkers() {
+	s.refresher.stop()
+	for _, c := range []cache.Cache{s.upstreamCache, s.filteredCache} {
+		switch c := c.(type) {
+		case *cache.MemoryCache:
+			c.StopJanitor()
+		case io.Closer:
+			_ = c.Close()
+		}
+	}
+	s.requestMetrics.StopCleanup()
+}
+
+// enableDiskCache adds a disk tier to c, logging instead of failing so a broken
+// cache directory only costs a cold start
+func enableDiskCache(c *cache.MemoryCache, name, dir string, maxBytes int64) {
+	loaded, err := c.EnableDisk(dir, maxBytes)
+	if err != nil {
+		slog.Error("cache is memory only", "cache", name, "error", err)
+		return
+	}
+	slog.Info("loaded cache entries from disk", "cache", name, "entries", loaded, "dir", dir)
 }
 
 // ServeHTTP handles HTTP requests for filtered iCal feeds
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Record request metrics
-	s.requestMetrics.RecordRequest()
-
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -100,78 +256,194 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Use default upstream URL if none specified
-	if params.Upstream == "" {
-		params.Upstream = s.cfg.Upstream.DefaultURL
+	s.serveFiltered(w, r, params)
+}
+
+// serveFiltered fetches, filters and serves the calendar described by params
+// Shared by /query and saved feeds (/f/{slug}).
+func (s *Server) serveFiltered(w http.ResponseWriter, r *http.Request, params *Params) {
+	// Resolve named sources, falling back to the default upstream URL
+	if err := s.resolveUpstreams(params); err != nil {
+		writeUpstreamError(w, err)
+		return
 	}
 
 	// If no filters specified and no upstream available, show configuration page
-	if params.Upstream == "" && len(params.Filters) == 0 &&
-		params.SpecialFilters.Grad == "" && params.SpecialFilters.Loge == "" &&
-		!params.SpecialFilters.RemoveUnconfirmed && !params.SpecialFilters.RemoveInstallt {
+	if len(params.Upstreams) == 0 && !params.hasFilters() {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
 	// Create cache key for filtered result
 	cacheKey := createCacheKey(params)
+	w.Header().Set("Vary", "Accept")
 
 	// Check filtered cache first
+	access := accessFromContext(r.Context())
 	if entry, found := s.filteredCache.Get(cacheKey); found {
-		s.serveFromCache(w, entry, false)
+		access.setCache("HIT")
+		s.serveFromCache(w, entry, params.Format, false)
 		return
 	}
+	access.setCache("MISS")
 
-	// Fetch upstream feed
-	upstreamData, upstreamTTL, err := s.fetchUpstream(r.Context(), params.Upstream)
+	// Build filters first so invalid parameters fail without touching upstreams
+	gen := s.generation()
+	engine := filter.NewEngine(s.config())
+	if err := s.buildFilters(engine, params); err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	pipeline, err := s.buildTransforms(engine, params)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to fetch upstream: %v", err), http.StatusBadGateway)
+		writeFilterError(w, err)
 		return
 	}
-
-	// Parse iCal
-	cal, err := parser.Parse(bytes.NewReader(upstreamData))
+	anonymizer, err := s.buildPrivacy(engine, params)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to parse iCal: %v", err), http.StatusInternalServerError)
+		writeFilterError(w, err)
 		return
 	}
 
-	// Apply filters
-	engine := filter.NewEngine(s.cfg)
-	if err := s.buildFilters(engine, params); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to build filters: %v", err), http.StatusBadRequest)
+	// Fetch, parse, merge, filter, transform and anonymize upstream feeds
+	output, upstreamTTL, failed, err := s.renderFiltered(r.Context(), cacheKey, gen, engine, pipeline, anonymizer, params)
+	logSourceErrors(r.Context(), failed)
+
+	// Prefer the last complete output over an error or a partial calendar,
+	// so clients do not drop events while an upstream is down
+	if len(failed) > 0 {
+		if entry, found := s.filteredCache.GetStale(cacheKey); found {
+			access.setCache("STALE")
+			s.serveStale(w, entry, params.Format, failed)
+			return
+		}
+	}
+	if err != nil {
+		var budgetErr *filter.BudgetError
+		if errors.As(err, &budgetErr) {
+			writeFilterError(w, err)
+			return
+		}
+		logging.FromContext(r.Context()).Error("failed to render calendar", "error", err)
+		http.Error(w, fmt.Sprintf("Failed to render iCal: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if output == nil {
+		writeSourceFailure(w, failed)
 		return
 	}
 
-	filteredCal, _ := engine.Apply(cal)
-
-	// Serialize iCal
-	var buf bytes.Buffer
-	if err := filteredCal.Serialize(&buf); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to serialize iCal: %v", err), http.StatusInternalServerError)
+	// Report failed sources and serve the partial result uncached
+	if len(failed) > 0 {
+		for _, f := range failed {
+			w.Header().Add("X-Upstream-Error", f.Error())
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Content-Type", formatContentTypes[params.Format])
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(output)
 		return
 	}
-	output := buf.Bytes()
 
-	// Cache the result
-	s.filteredCache.Set(cacheKey, output, upstreamTTL, "", "")
+	// Cache the result and let the refresher keep it warm
+	s.storeFiltered(cacheKey, gen, output, upstreamTTL, params, engine)
+	s.refresher.track(cacheKey, params)
 
 	// Set cache headers for client
 	cacheDuration := upstreamTTL
-	if cacheDuration < s.cfg.Cache.MinOutputCache {
-		cacheDuration = s.cfg.Cache.MinOutputCache
+	if minCache := s.config().Cache.MinOutputCache; cacheDuration < minCache {
+		cacheDuration = minCache
 	}
 	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(cacheDuration.Seconds())))
-	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Type", formatContentTypes[params.Format])
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write(output)
 }
 
+// renderResult is the outcome of renderFiltered, shared between coalesced callers
+type renderResult struct {
+	output []byte
+	ttl    time.Duration
+	failed []sourceError
+	err    error
+
+	// Reported in the access log of every caller
+	upstream  []string // Upstream fetch statuses
+	counted   bool     // Whether a calendar was filtered and the event counts are set
+	eventsIn  int
+	eventsOut int
+}
+
+// renderFiltered fetches the upstreams in params, applies the filters in engine
+// and the rules in pipeline, anonymizes the result if anonymizer is set and serializes it
+// Concurrent calls for the same cache key and purge generation share one
+// computation. output is nil if every source failed; failed lists the sources
+// that could not be used. The error is only set if the filters run over their
+// time budget, serialization fails or the shared build panicked.
+func (s *Server) renderFiltered(ctx context.Context, cacheKey string, gen uint64, engine *filter.Engine, pipeline *transform.Pipeline, anonymizer *privacy.Anonymizer, params *Params) ([]byte, time.Duration, []sourceError, error) {
+	// The work is shared, so one caller going away must not cancel it for the others
+	ctx = context.WithoutCancel(ctx)
+
+	// Callers from after a purge must not join a build that started before it
+	flightKey := strconv.FormatUint(gen, 10) + ":" + cacheKey
+	res, err, _ := s.renderFlight.Do(flightKey, func() (renderResult, error) {
+		// Collect upstream statuses for the shared result rather than the first caller
+		work := &accessInfo{}
+		res := s.render(withAccessInfo(ctx, work), engine, pipeline, anonymizer, params)
+		res.upstream = work.upstreamStatuses()
+		return res, nil
+	})
+	if err != nil {
+		// The build this caller joined panicked
+		return nil, 0, nil, err
+	}
+
+	access := accessFromContext(ctx)
+	access.addUpstream(res.upstream...)
+	if res.counted {
+		access.setEvents(res.eventsIn, res.eventsOut)
+	}
+	return res.output, res.ttl, res.failed, res.err
+}
+
+// render does the work of renderFiltered for one caller
+func (s *Server) render(ctx context.Context, engine *filter.Engine, pipeline *transform.Pipeline, anonymizer *privacy.Anonymizer, params *Params) renderResult {
+	cal, ttl, failed := s.fetchCalendar(ctx, params.Upstreams)
+	if cal == nil {
+		return renderResult{failed: failed}
+	}
+
+	filteredCal, _, err := s.applyFilters(ctx, engine, cal, params)
+	if err != nil {
+		return renderResult{failed: failed, err: err}
+	}
+	s.metrics.eventsIn.Observe(float64(len(cal.Events)))
+	s.metrics.eventsOut.Observe(float64(len(filteredCal.Events)))
+	res := renderResult{failed: failed, counted: true, eventsIn: len(cal.Events), eventsOut: len(filteredCal.Events)}
+	filteredCal = anonymizer.Apply(pipeline.Apply(filteredCal))
+
+	var buf bytes.Buffer
+	if err := serialize(&buf, filteredCal, params.Format); err != nil {
+		res.err = err
+		return res
+	}
+	res.output, res.ttl = buf.Bytes(), ttl
+	return res
+}
+
+// serialize writes cal in the output format
+func serialize(w io.Writer, cal *parser.Calendar, format string) error {
+	switch format {
+	case FormatJSON:
+		return cal.SerializeJSON(w)
+	case FormatJCal:
+		return cal.SerializeJCal(w)
+	}
+	return cal.Serialize(w)
+}
+
 // DebugHTTP handles HTTP requests for debug mode (HTML output)
 func (s *Server) DebugHTTP(w http.ResponseWriter, r *http.Request) {
-	// Record request metrics
-	s.requestMetrics.RecordRequest()
-
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -186,44 +458,53 @@ func (s *Server) DebugHTTP(w http.ResponseWriter, r *http.Request) {
 	params.Debug = true // Force debug mode on /debug endpoint
 
 	// If no filters specified and no upstream, show error
-	if params.Upstream == "" && len(params.Filters) == 0 &&
-		params.SpecialFilters.Grad == "" && params.SpecialFilters.Loge == "" &&
-		!params.SpecialFilters.RemoveUnconfirmed && !params.SpecialFilters.RemoveInstallt {
+	if len(params.Upstreams) == 0 && len(params.Sources) == 0 && !params.hasFilters() {
 		http.Error(w, "No filters specified. Use /debug?pattern=... or other filter parameters.", http.StatusBadRequest)
 		return
 	}
 
-	// Use default upstream URL if none specified
-	if params.Upstream == "" {
-		params.Upstream = s.cfg.Upstream.DefaultURL
-	}
-
-	// Fetch upstream feed (no caching for debug mode)
-	upstreamData, _, err := s.fetchUpstream(r.Context(), params.Upstream)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to fetch upstream: %v", err), http.StatusBadGateway)
+	// Resolve named sources, falling back to the default upstream URL
+	if err := s.resolveUpstreams(params); err != nil {
+		writeUpstreamError(w, err)
 		return
 	}
 
-	// Parse iCal
-	cal, err := parser.Parse(bytes.NewReader(upstreamData))
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to parse iCal: %v", err), http.StatusInternalServerError)
+	// Fetch, parse and merge upstream feeds (no caching for debug mode)
+	cal, _, failed := s.fetchCalendar(r.Context(), params.Upstreams)
+	logSourceErrors(r.Context(), failed)
+	if cal == nil {
+		writeSourceFailure(w, failed)
 		return
 	}
 
 	// Apply filters
-	engine := filter.NewEngine(s.cfg)
+	engine := filter.NewEngine(s.config())
 	if err := s.buildFilters(engine, params); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to build filters: %v", err), http.StatusBadRequest)
+		writeFilterError(w, err)
+		return
+	}
+	pipeline, err := s.buildTransforms(engine, params)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	anonymizer, err := s.buildPrivacy(engine, params)
+	if err != nil {
+		writeFilterError(w, err)
 		return
 	}
 
 	originalCal := cal
-	filteredCal, matches := engine.Apply(cal)
+	filteredCal, matches, err := s.applyFilters(r.Context(), engine, cal, params)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	accessFromContext(r.Context()).setEvents(len(originalCal.Events), len(filteredCal.Events))
+	filteredCal = anonymizer.Apply(pipeline.Apply(filteredCal))
 
 	// Generate debug HTML
-	output := s.generateDebugHTML(originalCal, filteredCal, matches, engine, r.URL.RawQuery)
+	output := s.generateDebugHTML(originalCal, filteredCal, matches, engine, pipeline, anonymizer, failed, r.URL.RawQuery)
 
 	// No caching for debug mode
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -253,6 +534,21 @@ func (s *Server) Health(w http.ResponseWriter, r *http.Request) {
 		stats.Entries, filteredStats.Entries)
 }
 
+// Ready handles readiness probes
+// Unlike /health it fails (503) until the server is listening and once it starts
+// draining on shutdown, so load balancers stop sending new requests.
+func (s *Server) Ready(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	if !s.ready.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"status":"not ready"}`))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(`{"status":"ready"}`))
+}
+
 // Status handles status page requests with metrics and cache statistics
 func (s *Server) Status(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -267,6 +563,10 @@ func (s *Server) Status(w http.ResponseWriter, r *http.Request) {
 	upstreamStats := s.upstreamCache.GetStats()
 	filteredStats := s.filteredCache.GetStats()
 
+	// Get request coalescing statistics
+	upstreamFlight := s.upstreamFlight.GetStats()
+	renderFlight := s.renderFlight.GetStats()
+
 	// Calculate uptime
 	uptime := time.Since(s.startTime)
 
@@ -370,6 +670,9 @@ func (s *Server) Status(w http.ResponseWriter, r *http.Request) {
         </div>
     </div>
 
+    <h3>Last Hour by Endpoint</h3>
+    %s
+
     <h2>Upstream Cache</h2>
     <table>
         <tr><th>Metric</th><th>Value</th></tr>
@@ -379,9 +682,12 @@ func (s *Server) Status(w http.ResponseWriter, r *http.Request) {
         <tr><td>Misses</td><td>%d</td></tr>
         <tr><td>Hit Ratio</td><td class="%s">%.1f%%</td></tr>
         <tr><td>Evictions</td><td>%d</td></tr>
+        <tr><td>Expired</td><td>%d</td></tr>
         <tr><td>Default TTL</td><td>%s</td></tr>
         <tr><td>Min TTL</td><td>%s</td></tr>
         <tr><td>Max TTL</td><td>%s</td></tr>
+        <tr><td>Backend</td><td>%s</td></tr>
+        <tr><td>Disk</td><td>%s</td></tr>
     </table>
 
     <h2>Filtered Cache</h2>
@@ -393,11 +699,29 @@ func (s *Server) Status(w http.ResponseWriter, r *http.Request) {
         <tr><td>Misses</td><td>%d</td></tr>
         <tr><td>Hit Ratio</td><td class="%s">%.1f%%</td></tr>
         <tr><td>Evictions</td><td>%d</td></tr>
+        <tr><td>Expired</td><td>%d</td></tr>
         <tr><td>Default TTL</td><td>%s</td></tr>
         <tr><td>Min TTL</td><td>%s</td></tr>
         <tr><td>Max TTL</td><td>%s</td></tr>
+        <tr><td>Stale Grace</td><td>%s</td></tr>
+        <tr><td>Stale Hits</td><td>%d</td></tr>
+        <tr><td>Backend</td><td>%s</td></tr>
+        <tr><td>Disk</td><td>%s</td></tr>
     </table>
 
+    <h2>Request Coalescing</h2>
+    <table>
+        <tr><th>Work</th><th>Executed</th><th>Coalesced</th></tr>
+        <tr><td>Upstream fetches</td><td>%d</td><td>%d</td></tr>
+        <tr><td>Filter builds</td><td>%d</td><td>%d</td></tr>
+    </table>
+
+    <h2>Background Refresh</h2>
+    %s
+
+    <h2>Configuration Reload</h2>
+    %s
+
     <p style="margin-top: 40px; text-align: center;">
         <a href="/">← Back to Configuration</a> |
         <a href="/health">Health Check (JSON)</a>
@@ -406,18 +730,28 @@ func (s *Server) Status(w http.ResponseWriter, r *http.Request) {
 </html>`,
 		req5m, req1h, req24h,
 		formatDuration(uptime),
+		requestBreakdownHTML(s.requestMetrics.Breakdown(time.Hour)),
 		upstreamStats.Entries, upstreamStats.MaxSize,
 		formatBytes(upstreamStats.Memory), formatBytes(upstreamStats.MaxMemory),
 		upstreamStats.Hits, upstreamStats.Misses,
 		hitRatioClass(upstreamStats.HitRatio), upstreamStats.HitRatio*100,
-		upstreamStats.Evictions,
+		upstreamStats.Evictions, upstreamStats.Expired,
 		upstreamStats.DefaultTTL, upstreamStats.MinTTL, upstreamStats.MaxTTL,
+		s.backendStatus(upstreamStats),
+		diskStatus(upstreamStats),
 		filteredStats.Entries, filteredStats.MaxSize,
 		formatBytes(filteredStats.Memory), formatBytes(filteredStats.MaxMemory),
 		filteredStats.Hits, filteredStats.Misses,
 		hitRatioClass(filteredStats.HitRatio), filteredStats.HitRatio*100,
-		filteredStats.Evictions,
-		filteredStats.DefaultTTL, filteredStats.MinTTL, filteredStats.MaxTTL)
+		filteredStats.Evictions, filteredStats.Expired,
+		filteredStats.DefaultTTL, filteredStats.MinTTL, filteredStats.MaxTTL,
+		filteredStats.StaleGrace, filteredStats.StaleHits,
+		s.backendStatus(filteredStats),
+		diskStatus(filteredStats),
+		upstreamFlight.Executed, upstreamFlight.Coalesced,
+		renderFlight.Executed, renderFlight.Coalesced,
+		refreshStatusHTML(s.refresher.snapshot()),
+		reloadStatusHTML(s.reloadSnapshot()))
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
@@ -457,6 +791,48 @@ func formatDuration(d time.Duration) string {
 	return fmt.Sprintf("%dd %dh", days, hours)
 }
 
+// requestBreakdownHTML renders request counts per endpoint and status code
+func requestBreakdownHTML(counts []metrics.RequestCount) string {
+	if len(counts) == 0 {
+		return `<p>No requests in the last hour.</p>`
+	}
+
+	var b strings.Builder
+	b.WriteString(`<table>
+        <tr><th>Endpoint</th><th>Status</th><th>Requests</th></tr>`)
+	for _, c := range counts {
+		class := ""
+		if c.Status >= http.StatusInternalServerError {
+			class = "metric-bad"
+		}
+		fmt.Fprintf(&b, `
+        <tr><td>%s</td><td class="%s">%d</td><td>%d</td></tr>`,
+			htmlutil.EscapeString(c.Endpoint), class, c.Status, c.Count)
+	}
+	b.WriteString(`
+    </table>`)
+	return b.String()
+}
+
+// backendStatus describes the cache backend for the status page
+func (s *Server) backendStatus(stats cache.Stats) string {
+	cfg := s.config()
+	if cfg.Cache.Backend != "redis" {
+		return "memory"
+	}
+	return fmt.Sprintf("redis (%s), %d errors", htmlutil.EscapeString(cfg.Cache.Redis.Addr), stats.Errors)
+}
+
+// diskStatus summarizes the disk tier of a cache for the status page
+func diskStatus(stats cache.Stats) string {
+	if stats.DiskMaxBytes == 0 {
+		return "disabled"
+	}
+	return fmt.Sprintf("%d entries, %s / %s, %d loaded, %d errors",
+		stats.DiskEntries, formatBytes(stats.DiskBytes), formatBytes(stats.DiskMaxBytes),
+		stats.DiskHits, stats.DiskErrors)
+}
+
 // hitRatioClass returns CSS class based on hit ratio
 func hitRatioClass(ratio float64) string {
 	if ratio >= 0.8 {
@@ -468,49 +844,271 @@ func hitRatioClass(ratio float64) string {
 	return "metric-bad"
 }
 
+// upstreamResult is the outcome of fetchUpstream, shared between coalesced callers
+type upstreamResult struct {
+	data   []byte
+	ttl    time.Duration
+	status string // Upstream status for the access log, see upstreamStatus
+}
+
 // fetchUpstream fetches the upstream feed, using cache if available
+// Concurrent calls for the same URL share one request.
 func (s *Server) fetchUpstream(ctx context.Context, upstreamURL string) ([]byte, time.Duration, error) {
-	// Check upstream cache
-	if entry, found := s.upstreamCache.Get(upstreamURL); found {
-		// Try conditional request
-		resp, notModified, err := s.fetcher.FetchConditional(ctx, upstreamURL, entry.ETag, entry.LastModified)
+	// The request is shared, so one caller going away must not cancel it for the others
+	ctx = context.WithoutCancel(ctx)
+
+	res, err, _ := s.upstreamFlight.Do(upstreamURL, func() (upstreamResult, error) {
+		// Check upstream cache
+		if entry, found := s.upstreamCache.Get(upstreamURL); found {
+			// Try conditional request
+			start := time.Now()
+			resp, notModified, err := s.fetcher.FetchConditional(ctx, upstreamURL, entry.ETag, entry.LastModified)
+			s.observeFetch(upstreamURL, start, true, notModified, err)
+			status := upstreamStatus(resp, notModified, err)
+			logging.FromContext(ctx).Debug("upstream fetch", "host", upstreamHost(upstreamURL), "status", status,
+				"conditional", true, "duration", time.Since(start))
+			if err != nil {
+				return upstreamResult{status: status}, err
+			}
+
+			if notModified {
+				// Use cached data
+				return upstreamResult{entry.Data, time.Until(entry.Expiry), status}, nil
+			}
+
+			// Content modified, use new data
+			return upstreamResult{resp.Body, s.storeUpstream(upstreamURL, resp), status}, nil
+		}
+
+		// No cache entry, fetch fresh
+		start := time.Now()
+		resp, err := s.fetcher.Fetch(ctx, upstreamURL)
+		s.observeFetch(upstreamURL, start, false, false, err)
+		status := upstreamStatus(resp, false, err)
+		logging.FromContext(ctx).Debug("upstream fetch", "host", upstreamHost(upstreamURL), "status", status,
+			"conditional", false, "duration", time.Since(start))
 		if err != nil {
-			return nil, 0, err
+			return upstreamResult{status: status}, err
 		}
 
-		if notModified {
-			// Use cached data
-			return entry.Data, time.Until(entry.Expiry), nil
+		return upstreamResult{resp.Body, s.storeUpstream(upstreamURL, resp), status}, nil
+	})
+	accessFromContext(ctx).addUpstream(res.status)
+	return res.data, res.ttl, err
+}
+
+// storeUpstream caches a fetched upstream response and returns its TTL
+func (s *Server) storeUpstream(upstreamURL string, resp *fetcher.Response) time.Duration {
+	ttl := s.upstreamTTL(resp)
+	s.upstreamCache.Set(upstreamURL, resp.Body, ttl, resp.ETag, resp.LastModified)
+	return ttl
+}
+
+// upstreamTTL returns how long to cache an upstream response: its Cache-Control or
+// Expires header, or cache.default_ttl without either
+func (s *Server) upstreamTTL(resp *fetcher.Response) time.Duration {
+	if ttl := fetcher.ParseCacheHeaders(resp.CacheControl, resp.Expires); ttl != 0 {
+		return ttl
+	}
+	return s.config().Cache.DefaultTTL
+}
+
+// sourceError describes an upstream source that could not be used
+type sourceError struct {
+	URL   string
+	Stage string // "fetch" or "parse"
+	Err   error
+}
+
+// Error implements the error interface
+func (e sourceError) Error() string {
+	return fmt.Sprintf("%s: %s failed: %v", e.URL, e.Stage, e.Err)
+}
+
+// resolveUpstreams expands named sources into params.Upstreams
+// Falls back to the default upstream when none is given; duplicate URLs are dropped
+// so the cache key does not depend on how a source was named. URLs outside the
+// configured allowlist are rejected with fetcher.ErrUpstreamNotAllowed.
+func (s *Server) resolveUpstreams(params *Params) error {
+	cfg := s.config()
+	urls := append([]string(nil), params.Upstreams...)
+	for _, name := range params.Sources {
+		sourceURL, ok := cfg.Upstream.Sources[name]
+		if !ok {
+			return fmt.Errorf("unknown source %q", name)
 		}
+		urls = append(urls, sourceURL)
+	}
 
-		// Content modified, use new data
-		ttl := fetcher.ParseCacheHeaders(resp.CacheControl, resp.Expires)
-		if ttl == 0 {
-			ttl = s.cfg.Cache.DefaultTTL
+	if len(urls) == 0 && cfg.Upstream.DefaultURL != "" {
+		urls = append(urls, cfg.Upstream.DefaultURL)
+	}
+
+	seen := make(map[string]bool, len(urls))
+	params.Upstreams = params.Upstreams[:0:0]
+	for _, u := range urls {
+		if seen[u] {
+			continue
 		}
+		if !cfg.UpstreamAllowed(u) {
+			return fmt.Errorf("%w: %s", fetcher.ErrUpstreamNotAllowed, u)
+		}
+		seen[u] = true
+		params.Upstreams = append(params.Upstreams, u)
+	}
+	return nil
+}
 
-		s.upstreamCache.Set(upstreamURL, resp.Body, ttl, resp.ETag, resp.LastModified)
-		return resp.Body, ttl, nil
+// storeFiltered caches filtered output tagged with the upstreams it was built from,
+// so purging an upstream also purges everything derived from it, and with the filter
+// config sections engine used, the transform presets in params and privacy, so
+// reloading one of them purges the output too.
+// gen is the purge generation read when the render started. Output from an older
+// generation is not stored: a purge or reload since then may already have deleted
+// its key.
+func (s *Server) storeFiltered(key string, gen uint64, output []byte, ttl time.Duration, params *Params, engine *filter.Engine) {
+	s.purgeMu.RLock()
+	defer s.purgeMu.RUnlock()
+	if s.purgeGen.Load() != gen {
+		return
+	}
+	tags := append([]string(nil), params.Upstreams...)
+	for _, section := range engine.Sections() {
+		tags = append(tags, filterTag(section))
+	}
+	for _, preset := range params.Transforms {
+		tags = append(tags, transformTag(preset))
 	}
+	if params.Privacy != nil {
+		tags = append(tags, privacyTag)
+	}
+	s.filteredCache.Set(key, output, ttl, "", "", tags...)
+}
 
-	// No cache entry, fetch fresh
-	resp, err := s.fetcher.Fetch(ctx, upstreamURL)
-	if err != nil {
-		return nil, 0, err
+// generation returns the current purge generation
+// Read it before the config a render is built from and pass it to storeFiltered.
+func (s *Server) generation() uint64 {
+	return s.purgeGen.Load()
+}
+
+// bumpGeneration starts a new purge generation, so renders already running are
+// not stored. Call it before deleting from the filtered cache: it waits for stores
+// in progress, so the deletion sees them.
+func (s *Server) bumpGeneration() {
+	s.purgeMu.Lock()
+	defer s.purgeMu.Unlock()
+	s.purgeGen.Add(1)
+}
+
+// writeUpstreamError responds to an upstream selection error from resolveUpstreams
+// Upstreams outside the allowlist are forbidden; anything else is a bad request.
+func writeUpstreamError(w http.ResponseWriter, err error) {
+	if errors.Is(err, fetcher.ErrUpstreamNotAllowed) {
+		http.Error(w, fmt.Sprintf("Forbidden: %v (use one of the configured sources)", err), http.StatusForbidden)
+		return
 	}
+	http.Error(w, fmt.Sprintf("Invalid parameters: %v", err), http.StatusBadRequest)
+}
 
-	ttl := fetcher.ParseCacheHeaders(resp.CacheControl, resp.Expires)
-	if ttl == 0 {
-		ttl = s.cfg.Cache.DefaultTTL
+// filterErrorStatus returns the status for filters that could not be built or applied
+// Filters over a regex budget are well-formed but refused, so they get 422.
+func filterErrorStatus(err error) int {
+	var budgetErr *filter.BudgetError
+	if errors.As(err, &budgetErr) {
+		return http.StatusUnprocessableEntity
 	}
+	return http.StatusBadRequest
+}
 
-	s.upstreamCache.Set(upstreamURL, resp.Body, ttl, resp.ETag, resp.LastModified)
-	return resp.Body, ttl, nil
+// writeFilterError reports filters that could not be built or applied
+func writeFilterError(w http.ResponseWriter, err error) {
+	http.Error(w, fmt.Sprintf("Failed to build filters: %v", err), filterErrorStatus(err))
+}
+
+// fetchCalendar fetches and parses all upstreams concurrently and merges them
+// The returned TTL is the shortest of the successful sources. Sources that fail are
+// returned in failed; the calendar is nil only if every source failed.
+func (s *Server) fetchCalendar(ctx context.Context, upstreams []string) (*parser.Calendar, time.Duration, []sourceError) {
+	type result struct {
+		cal *parser.Calendar
+		ttl time.Duration
+		err *sourceError
+	}
+
+	results := make([]result, len(upstreams))
+	var wg sync.WaitGroup
+	for i, upstreamURL := range upstreams {
+		wg.Add(1)
+		go func(i int, upstreamURL string) {
+			defer wg.Done()
+
+			data, ttl, err := s.fetchUpstream(ctx, upstreamURL)
+			if err != nil {
+				results[i].err = &sourceError{URL: upstreamURL, Stage: "fetch", Err: err}
+				return
+			}
+			cal, err := parser.ParseContext(ctx, bytes.NewReader(data))
+			if err != nil {
+				s.metrics.parseErrors.Inc(s.upstreamHost(upstreamURL))
+				results[i].err = &sourceError{URL: upstreamURL, Stage: "parse", Err: err}
+				return
+			}
+			results[i] = result{cal: cal, ttl: ttl}
+		}(i, upstreamURL)
+	}
+	wg.Wait()
+
+	var cals []*parser.Calendar
+	var failed []sourceError
+	var ttl time.Duration
+	for _, res := range results {
+		if res.err != nil {
+			failed = append(failed, *res.err)
+			continue
+		}
+		if len(cals) == 0 || res.ttl < ttl {
+			ttl = res.ttl
+		}
+		cals = append(cals, res.cal)
+	}
+
+	switch len(cals) {
+	case 0:
+		return nil, 0, failed
+	case 1:
+		return cals[0], ttl, failed
+	default:
+		return parser.Merge(cals...), ttl, failed
+	}
+}
+
+// writeSourceFailure responds with an error when no upstream source could be used
+// A single source keeps the fetch (502) and parse (500) errors of a plain upstream.
+func writeSourceFailure(w http.ResponseWriter, failed []sourceError) {
+	if len(failed) == 1 {
+		if failed[0].Stage == "parse" {
+			http.Error(w, fmt.Sprintf("Failed to parse iCal: %v", failed[0].Err), http.StatusInternalServerError)
+			return
+		}
+		if errors.Is(failed[0].Err, fetcher.ErrUpstreamNotAllowed) {
+			// Redirected outside the allowlist
+			writeUpstreamError(w, failed[0].Err)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to fetch upstream: %v", failed[0].Err), http.StatusBadGateway)
+		return
+	}
+
+	msgs := make([]string, len(failed))
+	for i, f := range failed {
+		msgs[i] = f.Error()
+	}
+	http.Error(w, "All upstreams failed: "+strings.Join(msgs, "; "), http.StatusBadGateway)
 }
 
 // serveFromCache serves a response from cache
-func (s *Server) serveFromCache(w http.ResponseWriter, entry *cache.Entry, debug bool) {
-	contentType := "text/calendar; charset=utf-8"
+func (s *Server) serveFromCache(w http.ResponseWriter, entry *cache.Entry, format string, debug bool) {
+	contentType := formatContentTypes[format]
 	if debug {
 		contentType = "text/html; charset=utf-8"
 	}
@@ -527,14 +1125,98 @@ func (s *Server) serveFromCache(w http.ResponseWriter, entry *cache.Entry, debug
 	_, _ = w.Write(entry.Data)
 }
 
+// serveStale serves expired cached output because upstream sources failed
+// The response is marked with X-Cache: STALE and a Warning header and is not
+// cacheable by clients, so they pick up fresh data once the upstream recovers.
+func (s *Server) serveStale(w http.ResponseWriter, entry *cache.Entry, format string, failed []sourceError) {
+	for _, f := range failed {
+		w.Header().Add("X-Upstream-Error", f.Error())
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	w.Header().Set("X-Cache", "STALE")
+	w.Header().Set("Warning", `110 - "Response is Stale"`)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(entry.Data)
+}
+
 // Params represents parsed URL parameters
 type Params struct {
-	Upstream       string
+	Upstreams      []string // Upstream URLs merged into one calendar (repeated upstream=)
+	Sources        []string // Named upstreams from config (source=), resolved by the server
 	Filters        []FilterParam
 	SpecialFilters SpecialFilters
+	Query          string // Boolean filter expression (q=), see filter.ParseExpression
+	DateRange      DateRange
+	Transforms     []string               // Transform presets from config (transform=), applied first
+	Rules          []config.TransformRule // Transform rules given inline (rule=, rule1=, ...)
+	Privacy        *PrivacyParams         // Anonymized busy/free output (privacy=), nil if off
+	Expand         string                 // ExpandNone, ExpandInstances or ExpandExdate
+	Format         string                 // FormatICS, FormatJSON or FormatJCal
 	Debug          bool
 }
 
+// PrivacyParams selects the privacy profile and the options added to it
+type PrivacyParams struct {
+	Profile string                // Profile from privacy.profiles, empty for the default
+	Options config.PrivacyProfile // privacy.placeholder, privacy.round_hours, ...
+}
+
+// DateRange holds the raw from/to/horizon parameters
+// Values are kept unresolved so relative bounds ("-30d") produce a stable cache key
+type DateRange struct {
+	From    string
+	To      string
+	Horizon string
+}
+
+// IsSet reports whether any date bound was given
+func (d DateRange) IsSet() bool {
+	return d.From != "" || d.To != "" || d.Horizon != ""
+}
+
+// Resolve converts the raw bounds to absolute times relative to now
+// "today", dates and day offsets are taken in loc, the zone of the calendar being
+// filtered. Horizon is shorthand for a relative "to" (e.g. horizon=180d is to=+180d)
+func (d DateRange) Resolve(now time.Time, loc *time.Location) (from, to time.Time, err error) {
+	if d.From != "" {
+		if from, err = filter.ParseTimeBound(d.From, now, loc); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("from: %w", err)
+		}
+	}
+
+	if d.To != "" && d.Horizon != "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("to and horizon cannot be combined")
+	}
+
+	if d.To != "" {
+		if to, err = filter.ParseTimeBound(d.To, now, loc); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("to: %w", err)
+		}
+	}
+
+	if d.Horizon != "" {
+		horizon := d.Horizon
+		if horizon[0] != '+' && horizon[0] != '-' {
+			horizon = "+" + horizon
+		}
+		if to, err = filter.ParseTimeBound(horizon, now, loc); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("horizon: %w", err)
+		}
+	}
+
+	return from, to, nil
+}
+
+// hasFilters reports whether any filter parameter was given
+func (p *Params) hasFilters() bool {
+	return len(p.Filters) > 0 || p.Query != "" ||
+		p.SpecialFilters.Grad != "" || p.SpecialFilters.Loge != "" ||
+		p.SpecialFilters.RemoveUnconfirmed || p.SpecialFilters.RemoveInstallt ||
+		p.DateRange.IsSet() || len(p.Transforms) > 0 || len(p.Rules) > 0 || p.Privacy != nil
+}
+
 // FilterParam represents a single filter (field + pattern)
 type FilterParam struct {
 	Fields  []string
@@ -550,16 +1232,71 @@ type SpecialFilters struct {
 }
 
 // parseParams parses URL query parameters
+// Without format= the output format is taken from the Accept header.
 func parseParams(r *http.Request) (*Params, error) {
 	q := r.URL.Query()
+	params, err := parseQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := q["format"]; !ok {
+		params.Format = acceptFormat(r.Header.Get("Accept"))
+	}
+	return params, nil
+}
+
+// parseFormat parses the value of format=
+func parseFormat(value string) (string, error) {
+	switch format := strings.ToLower(trimSpace(value)); format {
+	case "", "ics", "ical":
+		return FormatICS, nil
+	case FormatJSON, FormatJCal:
+		return format, nil
+	}
+	return "", fmt.Errorf("invalid format %q (use ics, json or jcal)", value)
+}
+
+// acceptFormat returns the output format of the first media type in an Accept
+// header that names one, or FormatICS if none does
+// Quality values are not weighed; types with q=0 are skipped.
+func acceptFormat(accept string) string {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(mediaRange, ";")
+		format, ok := acceptFormats[strings.ToLower(trimSpace(mediaType))]
+		if ok && !zeroQuality(params) {
+			return format
+		}
+	}
+	return FormatICS
+}
 
+// zeroQuality reports whether media range parameters include q=0, meaning "not acceptable"
+func zeroQuality(params string) bool {
+	for _, param := range strings.Split(params, ";") {
+		if value, ok := strings.CutPrefix(trimSpace(param), "q="); ok {
+			q, err := strconv.ParseFloat(value, 64)
+			return err == nil && q == 0
+		}
+	}
+	return false
+}
+
+// parseQuery parses filter parameters from a query string
+func parseQuery(q url.Values) (*Params, error) {
 	params := &Params{
-		Upstream: q.Get("upstream"),
-		Debug:    q.Get("debug") == "true" || q.Get("debug") == "1",
+		Debug: q.Get("debug") == "true" || q.Get("debug") == "1",
 	}
 
-	// If no upstream specified, use default from config
-	// We'll need to pass config here, but for now leave empty to be filled by caller
+	// Upstreams may be repeated to merge several feeds; named sources may also be
+	// comma-separated. Both are resolved (and defaulted) by the caller.
+	for _, u := range q["upstream"] {
+		if u = trimSpace(u); u != "" {
+			params.Upstreams = append(params.Upstreams, u)
+		}
+	}
+	for _, v := range q["source"] {
+		params.Sources = append(params.Sources, parseFieldList(v)...)
+	}
 
 	// Parse basic filters (field + pattern, field1 + pattern1, etc.)
 	// First check for non-indexed filter
@@ -595,6 +1332,9 @@ func parseParams(r *http.Request) (*Params, error) {
 		})
 	}
 
+	// Parse boolean filter expression
+	params.Query = trimSpace(q.Get("q"))
+
 	// Parse special filters
 	params.SpecialFilters.Grad = q.Get("Grad")
 	params.SpecialFilters.Loge = q.Get("Loge")
@@ -604,9 +1344,106 @@ func parseParams(r *http.Request) (*Params, error) {
 	params.SpecialFilters.RemoveUnconfirmed = parseBoolParam(q, "RemoveUnconfirmed")
 	params.SpecialFilters.RemoveInstallt = parseBoolParam(q, "RemoveInstallt")
 
+	// Parse date window (from=-30d&to=+180d, or horizon=180d)
+	params.DateRange = DateRange{
+		From:    q.Get("from"),
+		To:      q.Get("to"),
+		Horizon: q.Get("horizon"),
+	}
+	if params.DateRange.IsSet() {
+		if _, _, err := params.DateRange.Resolve(time.Now(), time.UTC); err != nil {
+			return nil, fmt.Errorf("invalid date range: %w", err)
+		}
+	}
+
+	// Parse transforms: presets (transform=a,b) run first, then inline rules in index order
+	for _, v := range q["transform"] {
+		params.Transforms = append(params.Transforms, parseFieldList(v)...)
+	}
+	for i := 0; i <= 20; i++ { // rule, then up to 20 indexed rules
+		key := "rule"
+		if i > 0 {
+			key = fmt.Sprintf("rule%d", i)
+		}
+		rule, ok, err := parseRuleParam(q, key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			params.Rules = append(params.Rules, rule)
+		}
+	}
+
+	// Parse privacy mode: privacy (or privacy=1) uses the default profile, privacy=name
+	// a saved one. The privacy.* options add to either and turn privacy on by themselves.
+	privacyOptions := config.PrivacyProfile{
+		Placeholder: q.Get("privacy.placeholder"),
+		RoundHours:  parseBoolParam(q, "privacy.round_hours"),
+		Minimal:     parseBoolParam(q, "privacy.minimal"),
+		HashUID:     parseBoolParam(q, "privacy.hash_uid"),
+	}
+	if _, ok := q["privacy"]; ok || privacyOptions != (config.PrivacyProfile{}) {
+		switch profile := trimSpace(q.Get("privacy")); profile {
+		case "0", "false":
+		case "", "1", "true":
+			params.Privacy = &PrivacyParams{Options: privacyOptions}
+		default:
+			params.Privacy = &PrivacyParams{Profile: profile, Options: privacyOptions}
+		}
+	}
+
+	// Parse output format (format=json, format=jcal)
+	format, err := parseFormat(q.Get("format"))
+	if err != nil {
+		return nil, err
+	}
+	params.Format = format
+
+	// Parse recurrence expansion mode (expand, expand=instances, expand=exdate)
+	if _, ok := q["expand"]; ok {
+		switch q.Get("expand") {
+		case "", "1", "true", ExpandInstances:
+			params.Expand = ExpandInstances
+		case ExpandExdate:
+			params.Expand = ExpandExdate
+		case "0", "false":
+			params.Expand = ExpandNone
+		default:
+			return nil, fmt.Errorf("invalid expand mode %q (use instances or exdate)", q.Get("expand"))
+		}
+	}
+
 	return params, nil
 }
 
+// parseRuleParam parses the inline transform rule named key
+// The rule type is the value of key itself, its options are key.field, key.value,
+// key.pattern, key.source, key.if_missing and key.before. ok is false if key is absent.
+func parseRuleParam(q url.Values, key string) (rule config.TransformRule, ok bool, err error) {
+	ruleType := q.Get(key)
+	if ruleType == "" {
+		return rule, false, nil
+	}
+
+	rule = config.TransformRule{
+		Type:      ruleType,
+		Field:     trimSpace(q.Get(key + ".field")),
+		Value:     q.Get(key + ".value"),
+		Pattern:   q.Get(key + ".pattern"),
+		Source:    trimSpace(q.Get(key + ".source")),
+		IfMissing: parseBoolParam(q, key+".if_missing"),
+	}
+	if before := q.Get(key + ".before"); before != "" {
+		if rule.Before, err = time.ParseDuration(before); err != nil {
+			return rule, false, fmt.Errorf("invalid %s.before: %w", key, err)
+		}
+	}
+	if err := rule.Validate(); err != nil {
+		return rule, false, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return rule, true, nil
+}
+
 // parseBoolParam checks if a boolean parameter is present or set to true
 // Returns true if: parameter exists without value, or value is "true" or "1"
 func parseBoolParam(q map[string][]string, key string) bool {
@@ -678,7 +1515,8 @@ func isSpace(b byte) bool {
 
 // createCacheKey creates a cache key from parameters
 func createCacheKey(params *Params) string {
-	components := []string{params.Upstream}
+	// Upstreams are order-sensitive: earlier sources win UID conflicts when merging
+	components := append([]string{}, params.Upstreams...)
 
 	// Add filters
 	for _, f := range params.Filters {
@@ -700,6 +1538,40 @@ func createCacheKey(params *Params) string {
 		components = append(components, "RemoveInstallt:true")
 	}
 
+	// Add filter expression
+	if params.Query != "" {
+		components = append(components, "q:"+params.Query)
+	}
+
+	// Add date window (raw values, so relative bounds map to a stable key)
+	if params.DateRange.IsSet() {
+		components = append(components,
+			"from:"+params.DateRange.From,
+			"to:"+params.DateRange.To,
+			"horizon:"+params.DateRange.Horizon)
+	}
+
+	if params.Expand != ExpandNone {
+		components = append(components, "expand:"+params.Expand)
+	}
+
+	if params.Format != FormatICS {
+		components = append(components, "format:"+params.Format)
+	}
+
+	// Add transforms, in the order they are applied
+	for _, preset := range params.Transforms {
+		components = append(components, "transform:"+preset)
+	}
+	for _, rule := range params.Rules {
+		components = append(components, "rule:"+rule.String())
+	}
+
+	// Add privacy profile and options
+	if params.Privacy != nil {
+		components = append(components, "privacy:"+params.Privacy.Profile+" "+params.Privacy.Options.String())
+	}
+
 	// Add debug flag
 	if params.Debug {
 		components = append(components, "debug:true")
@@ -742,11 +1614,100 @@ func (s *Server) buildFilters(engine *filter.Engine, params *Params) error {
 		}
 	}
 
+	if params.Query != "" {
+		if err := engine.AddExpression(params.Query); err != nil {
+			return fmt.Errorf("q error: %w", err)
+		}
+	}
+
+	// Checked here so bad ranges fail before fetching; applyFilters sets the window
+	// again in the zone of the calendar
+	if params.DateRange.IsSet() {
+		from, to, err := params.DateRange.Resolve(time.Now(), time.UTC)
+		if err != nil {
+			return fmt.Errorf("date range error: %w", err)
+		}
+		if err := engine.AddDateRangeFilter(from, to); err != nil {
+			return fmt.Errorf("date range error: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// buildTransforms builds the transform pipeline from parameters
+// Inline rule patterns share the regex budgets of engine.
+func (s *Server) buildTransforms(engine *filter.Engine, params *Params) (*transform.Pipeline, error) {
+	pipeline := transform.NewPipeline(engine)
+	for _, preset := range params.Transforms {
+		if err := pipeline.AddPreset(preset); err != nil {
+			return nil, fmt.Errorf("transform error: %w", err)
+		}
+	}
+	for _, rule := range params.Rules {
+		if err := pipeline.AddRule(rule); err != nil {
+			return nil, fmt.Errorf("rule error: %w", err)
+		}
+	}
+	return pipeline, nil
+}
+
+// buildPrivacy builds the anonymizer for the privacy profile in params
+// It returns nil if privacy is off.
+func (s *Server) buildPrivacy(engine *filter.Engine, params *Params) (*privacy.Anonymizer, error) {
+	if params.Privacy == nil {
+		return nil, nil
+	}
+	anonymizer, err := privacy.New(engine.Config(), params.Privacy.Profile, params.Privacy.Options)
+	if err != nil {
+		return nil, fmt.Errorf("privacy error: %w", err)
+	}
+	return anonymizer, nil
+}
+
+// applyFilters runs the filter engine over a parsed calendar
+// The date window is resolved in the calendar's zone (X-WR-TIMEZONE), so "today" and
+// dates start at its midnight. With expansion enabled, recurring series are materialised
+// within the date window (or the default window) so filters act per occurrence, then
+// re-emitted per params.Expand.
+func (s *Server) applyFilters(ctx context.Context, engine *filter.Engine, cal *parser.Calendar, params *Params) (*parser.Calendar, []filter.MatchResult, error) {
+	now := time.Now()
+	if params.DateRange.IsSet() {
+		// Already validated in UTC by buildFilters, which keeps that window if the
+		// bounds only fail in the calendar zone
+		if from, to, err := params.DateRange.Resolve(now, cal.Location); err == nil {
+			_ = engine.AddDateRangeFilter(from, to)
+		}
+	}
+
+	if params.Expand == ExpandNone {
+		return engine.ApplyContext(ctx, cal)
+	}
+
+	// Open bounds fall back to the default window
+	from, to := now.Add(-defaultExpandPast), now.Add(defaultExpandFuture)
+	if window := engine.GetDateWindow(); window != nil {
+		if !window.From.IsZero() {
+			from = window.From
+		}
+		if !window.To.IsZero() {
+			to = window.To
+		}
+	}
+
+	expanded := cal.Expand(from, to)
+	filtered, matches, err := engine.ApplyContext(ctx, expanded)
+	if err != nil {
+		return nil, nil, err
+	}
+	if params.Expand == ExpandExdate {
+		filtered = filtered.Collapse(expanded)
+	}
+	return filtered, matches, nil
+}
+
 // generateDebugHTML generates debug mode HTML output
-func (s *Server) generateDebugHTML(original, filtered *parser.Calendar, matches []filter.MatchResult, engine *filter.Engine, queryString string) string {
+func (s *Server) generateDebugHTML(original, filtered *parser.Calendar, matches []filter.MatchResult, engine *filter.Engine, pipeline *transform.Pipeline, anonymizer *privacy.Anonymizer, failed []sourceError, queryString string) string {
 	stats := filter.GetStats(original, filtered)
 
 	// Build back-to-config URL
@@ -784,11 +1745,27 @@ func (s *Server) generateDebugHTML(original, filtered *parser.Calendar, matches
 		<p><strong>Events in filtered output:</strong> ` + strconv.Itoa(stats.FilteredEvents) + `</p>
 		<p><strong>Events removed:</strong> ` + strconv.Itoa(stats.RemovedEvents) + `</p>
 	</div>
+`
 
+	if len(failed) > 0 {
+		html += `
+	<h2>Failed Sources</h2>`
+		for _, f := range failed {
+			html += `<div class="match"><code>` + htmlutil.EscapeString(f.Error()) + `</code></div>`
+		}
+	}
+
+	html += `
 	<h2>Active Filters</h2>`
 
 	filters := engine.GetFilters()
-	if len(filters) == 0 {
+	if window := engine.GetDateWindow(); window != nil {
+		html += `<div class="filter"><strong>Date window:</strong> ` + htmlutil.EscapeString(window.String()) + `</div>`
+	}
+	for _, expr := range engine.GetExpressions() {
+		html += `<div class="filter"><strong>Expression (keeps matching):</strong> <code>` + htmlutil.EscapeString(expr.String()) + `</code></div>`
+	}
+	if len(filters) == 0 && engine.GetDateWindow() == nil && len(engine.GetExpressions()) == 0 {
 		html += `<p>No filters applied</p>`
 	} else {
 		for i, f := range filters {
@@ -801,6 +1778,23 @@ func (s *Server) generateDebugHTML(original, filtered *parser.Calendar, matches
 		}
 	}
 
+	if rules := pipeline.Rules(); len(rules) > 0 {
+		html += `
+	<h2>Active Transforms</h2>`
+		if presets := pipeline.Presets(); len(presets) > 0 {
+			html += `<div class="filter"><strong>Presets:</strong> ` + htmlutil.EscapeString(strings.Join(presets, ", ")) + `</div>`
+		}
+		for i, rule := range rules {
+			html += fmt.Sprintf(`<div class="filter"><strong>Rule %d:</strong> <code>%s</code></div>`, i+1, htmlutil.EscapeString(rule.String()))
+		}
+	}
+
+	if anonymizer != nil {
+		html += `
+	<h2>Privacy</h2>
+	<div class="filter"><strong>Profile:</strong> <code>` + htmlutil.EscapeString(anonymizer.String()) + `</code></div>`
+	}
+
 	html += `<h2>Removed Events</h2>`
 
 	if len(matches) == 0 {
@@ -862,9 +1856,6 @@ func (s *Server) generateDebugHTML(original, filtered *parser.Calendar, matches
 
 // ConfigPage serves the web UI configuration page
 func (s *Server) ConfigPage(w http.ResponseWriter, r *http.Request) {
-	// Record request metrics
-	s.requestMetrics.RecordRequest()
-
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -874,37 +1865,36 @@ func (s *Server) ConfigPage(w http.ResponseWriter, r *http.Request) {
 	tmpl, err := template.New("config").Parse(configPageTemplate)
 	if err != nil {
 		http.Error(w, "Failed to parse template", http.StatusInternalServerError)
-		log.Printf("Template parse error: %v", err)
+		logging.FromContext(r.Context()).Error("failed to parse template", "error", err)
 		return
 	}
 
+	cfg := s.config()
 	data := struct {
 		BaseURL string
+		Sources []string
 	}{
-		BaseURL: s.cfg.Server.BaseURL,
+		BaseURL: cfg.Server.BaseURL,
+		Sources: cfg.SourceNames(),
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if err := tmpl.Execute(w, data); err != nil {
 		http.Error(w, "Failed to render template", http.StatusInternalServerError)
-		log.Printf("Template execute error: %v", err)
+		logging.FromContext(r.Context()).Error("failed to render template", "error", err)
 		return
 	}
 }
 
 // GetLodges returns a JSON list of unique lodge names from the upstream feed
 func (s *Server) GetLodges(w http.ResponseWriter, r *http.Request) {
-	// Record request metrics
-	s.requestMetrics.RecordRequest()
-
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	// Return canonical lodge list from config (already sorted in config)
-	lodges := make([]string, len(s.cfg.Filters.Lodge.Names))
-	copy(lodges, s.cfg.Filters.Lodge.Names)
+	lodges := append([]string(nil), s.config().Filters.Lodge.Names...)
 
 	// Sort with Swedish collation
 	sortSwedish(lodges)
@@ -923,28 +1913,94 @@ func sortSwedish(strings []string) {
 	})
 }
 
-// Start starts the HTTP server
-func (s *Server) Start() error {
+// Handler returns the HTTP handler with all routes registered
+func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", s.ConfigPage)
-	mux.HandleFunc("/query", s.ServeHTTP)
-	mux.HandleFunc("/query/preview", s.DebugHTTP)
-	mux.HandleFunc("/debug", s.DebugRedirect)
-	mux.HandleFunc("/status", s.Status)
-	mux.HandleFunc("/api/lodges", s.GetLodges)
-	mux.HandleFunc("/health", s.Health)
-
-	addr := fmt.Sprintf(":%d", s.cfg.Server.Port)
-	log.Printf("Starting server on %s", addr)
-	log.Printf("Endpoints: / /query /query/preview /debug (redirect) /status /api/lodges /health")
+	// Every route is counted and timed under its pattern
+	handle := func(pattern string, h http.HandlerFunc) {
+		mux.HandleFunc(pattern, s.instrument(pattern, h))
+	}
+	handle("/", s.ConfigPage)
+	handle("/query", s.ServeHTTP)
+	handle("/query/preview", s.DebugHTTP)
+	handle("/debug", s.DebugRedirect)
+	handle("/status", s.Status)
+	handle("/api/lodges", s.GetLodges)
+	handle("/f/", s.FeedHTTP)
+	handle("/api/feeds", s.FeedsAPI)
+	handle("/api/feeds/", s.FeedsAPI)
+	handle("/api/admin/cache", s.AdminCacheAPI)
+	handle("/health", s.Health)
+	handle("/ready", s.Ready)
+	handle("/metrics", s.Metrics)
+	return mux
+}
+
+// defaultShutdownTimeout bounds the drain when server.shutdown_timeout is zero
+const defaultShutdownTimeout = 30 * time.Second
+
+// Run serves HTTP until ctx is cancelled, then shuts down gracefully
+// On cancellation /ready starts failing, new connections are refused after
+// server.shutdown_delay, and in-flight requests get server.shutdown_timeout to finish
+// before background workers are stopped. It returns nil after a clean shutdown.
+func (s *Server) Run(ctx context.Context) error {
+	addr := fmt.Sprintf(":%d", s.config().Server.Port)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("starting server", "addr", addr,
+		"endpoints", "/ /query /query/preview /debug (redirect) /status /api/lodges /f/{slug} /api/feeds /api/admin/cache /health /ready /metrics")
+	return s.serve(ctx, ln)
+}
 
+// serve runs the server on ln until ctx is cancelled, see Run
+func (s *Server) serve(ctx context.Context, ln net.Listener) error {
+	// Keep popular cache entries warm and sweep expired ones
+	s.refresher.start()
+	s.startJanitors()
+	s.requestMetrics.StartCleanup(time.Minute)
+	defer s.stopWorkers()
+
+	cfg := s.config().Server
 	server := &http.Server{
-		Addr:         addr,
-		Handler:      mux,
-		ReadTimeout:  s.cfg.Server.ReadTimeout,
-		WriteTimeout: s.cfg.Server.WriteTimeout,
-		IdleTimeout:  s.cfg.Server.IdleTimeout,
+		Handler:      s.Handler(),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.Serve(ln) }()
+	s.ready.Store(true)
+
+	select {
+	case err := <-serveErr:
+		s.ready.Store(false)
+		return err
+	case <-ctx.Done():
+	}
+
+	// Shutdown settings may have been reloaded while serving
+	cfg = s.config().Server
+	timeout := cfg.ShutdownTimeout
+	if timeout == 0 {
+		timeout = defaultShutdownTimeout
 	}
+	s.ready.Store(false)
+	slog.Info("shutting down", "delay", cfg.ShutdownDelay, "timeout", timeout)
+
+	// Give load balancers time to see /ready fail before connections are refused
+	time.Sleep(cfg.ShutdownDelay)
 
-	return server.ListenAndServe()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("drain timed out, closing remaining connections", "error", err)
+		_ = server.Close()
+	}
+	<-serveErr // http.ErrServerClosed
+
+	slog.Info("server stopped")
+	return nil
 }
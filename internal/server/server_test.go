@@ -2,12 +2,17 @@ package server
 
 import (
 	"html"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/linus/recal/internal/cache"
 	"github.com/linus/recal/internal/config"
 )
 
@@ -149,8 +154,8 @@ func TestParseParams(t *testing.T) {
 				t.Fatalf("parseParams() error = %v", err)
 			}
 
-			if tt.wantUpstream != "" && params.Upstream != tt.wantUpstream {
-				t.Errorf("Upstream = %q, want %q", params.Upstream, tt.wantUpstream)
+			if tt.wantUpstream != "" && (len(params.Upstreams) != 1 || params.Upstreams[0] != tt.wantUpstream) {
+				t.Errorf("Upstreams = %q, want [%q]", params.Upstreams, tt.wantUpstream)
 			}
 
 			if params.Debug != tt.wantDebug {
@@ -180,6 +185,62 @@ func TestParseParams(t *testing.T) {
 	}
 }
 
+// TestParseParamsDateRange tests from/to/horizon parsing
+// Validates: Raw values kept, invalid values and to+horizon rejected
+func TestParseParamsDateRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		want    DateRange
+		wantErr bool
+	}{
+		{
+			name: "relative window",
+			url:  "/query?from=-30d&to=%2B180d",
+			want: DateRange{From: "-30d", To: "+180d"},
+		},
+		{
+			name: "horizon",
+			url:  "/query?from=today&horizon=180d",
+			want: DateRange{From: "today", Horizon: "180d"},
+		},
+		{
+			name: "absolute dates",
+			url:  "/query?from=2025-01-01&to=2025-07-01",
+			want: DateRange{From: "2025-01-01", To: "2025-07-01"},
+		},
+		{
+			name:    "invalid from",
+			url:     "/query?from=yesterday",
+			wantErr: true,
+		},
+		{
+			name:    "to and horizon",
+			url:     "/query?to=%2B10d&horizon=10d",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			params, err := parseParams(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if params.DateRange != tt.want {
+				t.Errorf("DateRange = %+v, want %+v", params.DateRange, tt.want)
+			}
+			if !params.hasFilters() {
+				t.Error("hasFilters() = false, want true for a date range")
+			}
+		})
+	}
+}
+
 // TestParseFieldList tests field list parsing
 // Validates: Comma-separated fields, trimming, empty handling
 func TestParseFieldList(t *testing.T) {
@@ -240,13 +301,13 @@ func TestCreateCacheKey(t *testing.T) {
 		{
 			name: "identical params",
 			params1: &Params{
-				Upstream: "https://example.com/cal.ics",
+				Upstreams: []string{"https://example.com/cal.ics"},
 				Filters: []FilterParam{
 					{Fields: []string{"SUMMARY"}, Pattern: "Meeting"},
 				},
 			},
 			params2: &Params{
-				Upstream: "https://example.com/cal.ics",
+				Upstreams: []string{"https://example.com/cal.ics"},
 				Filters: []FilterParam{
 					{Fields: []string{"SUMMARY"}, Pattern: "Meeting"},
 				},
@@ -257,10 +318,10 @@ func TestCreateCacheKey(t *testing.T) {
 		{
 			name: "different upstream",
 			params1: &Params{
-				Upstream: "https://example.com/cal1.ics",
+				Upstreams: []string{"https://example.com/cal1.ics"},
 			},
 			params2: &Params{
-				Upstream: "https://example.com/cal2.ics",
+				Upstreams: []string{"https://example.com/cal2.ics"},
 			},
 			wantSame: false,
 			comment:  "Different upstream should produce different key",
@@ -268,13 +329,13 @@ func TestCreateCacheKey(t *testing.T) {
 		{
 			name: "different pattern",
 			params1: &Params{
-				Upstream: "https://example.com/cal.ics",
+				Upstreams: []string{"https://example.com/cal.ics"},
 				Filters: []FilterParam{
 					{Fields: []string{"SUMMARY"}, Pattern: "Meeting"},
 				},
 			},
 			params2: &Params{
-				Upstream: "https://example.com/cal.ics",
+				Upstreams: []string{"https://example.com/cal.ics"},
 				Filters: []FilterParam{
 					{Fields: []string{"SUMMARY"}, Pattern: "Event"},
 				},
@@ -282,15 +343,28 @@ func TestCreateCacheKey(t *testing.T) {
 			wantSame: false,
 			comment:  "Different pattern should produce different key",
 		},
+		{
+			name: "different date range",
+			params1: &Params{
+				Upstreams: []string{"https://example.com/cal.ics"},
+				DateRange: DateRange{From: "-30d"},
+			},
+			params2: &Params{
+				Upstreams: []string{"https://example.com/cal.ics"},
+				DateRange: DateRange{From: "-7d"},
+			},
+			wantSame: false,
+			comment:  "Different date range should produce different key",
+		},
 		{
 			name: "debug flag difference",
 			params1: &Params{
-				Upstream: "https://example.com/cal.ics",
-				Debug:    false,
+				Upstreams: []string{"https://example.com/cal.ics"},
+				Debug:     false,
 			},
 			params2: &Params{
-				Upstream: "https://example.com/cal.ics",
-				Debug:    true,
+				Upstreams: []string{"https://example.com/cal.ics"},
+				Debug:     true,
 			},
 			wantSame: false,
 			comment:  "Debug flag should affect cache key",
@@ -603,3 +677,798 @@ func TestDebugModeOutput(t *testing.T) {
 	// Would be better as an integration test with real data
 	t.Skip("Integration test - requires full server with test data")
 }
+
+// newICSUpstream starts a mock upstream serving the given iCal body
+// SSRF protection is disabled for the test so the server may fetch from localhost
+func newICSUpstream(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	t.Setenv("DISABLE_SSRF_PROTECTION", "true")
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/calendar")
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+// TestQueryDateRangeCalendarZone tests date bounds in the zone of the calendar
+// Validates: Bare dates and "today" start at midnight in X-WR-TIMEZONE, not UTC
+func TestQueryDateRangeCalendarZone(t *testing.T) {
+	// 23:30 and 00:30 in Auckland (UTC+13) on either side of local midnight
+	upstream := newICSUpstream(t, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\nX-WR-TIMEZONE:Pacific/Auckland\r\n"+
+		"BEGIN:VEVENT\r\nUID:before\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T103000Z\r\nSUMMARY:Before\r\nEND:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\nUID:after\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T113000Z\r\nSUMMARY:After\r\nEND:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+
+	cfg := getTestConfig()
+	cfg.Upstream.DefaultURL = upstream.URL
+	server := New(cfg)
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/query?from=2025-01-11&to=2025-01-12", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want %d, body: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if body := w.Body.String(); !strings.Contains(body, "SUMMARY:After") || strings.Contains(body, "SUMMARY:Before") {
+		t.Errorf("from=2025-01-11 should start at midnight in Pacific/Auckland:\n%s", body)
+	}
+
+	auckland, _ := time.LoadLocation("Pacific/Auckland")
+	from, _, err := DateRange{From: "today"}.Resolve(time.Now(), auckland)
+	if err != nil || from.Location() != auckland || from.Hour() != 0 {
+		t.Errorf("today = %v, %v, want midnight in Pacific/Auckland", from, err)
+	}
+}
+
+// TestQueryDateRange tests date window filtering end to end
+// Validates: Past and far-future events dropped, events inside the window kept
+func TestQueryDateRange(t *testing.T) {
+	now := time.Now().UTC()
+	stamp := func(ts time.Time) string { return ts.Format("20060102T150405Z") }
+
+	upstream := newICSUpstream(t, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n"+
+		"BEGIN:VEVENT\r\nUID:past\r\nDTSTAMP:"+stamp(now)+"\r\nDTSTART:"+stamp(now.AddDate(0, 0, -60))+"\r\nSUMMARY:Past\r\nEND:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\nUID:soon\r\nDTSTAMP:"+stamp(now)+"\r\nDTSTART:"+stamp(now.AddDate(0, 0, 7))+"\r\nSUMMARY:Soon\r\nEND:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\nUID:far\r\nDTSTAMP:"+stamp(now)+"\r\nDTSTART:"+stamp(now.AddDate(1, 0, 0))+"\r\nSUMMARY:Far\r\nEND:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+
+	cfg := getTestConfig()
+	cfg.Upstream.DefaultURL = upstream.URL
+	server := New(cfg)
+
+	req := httptest.NewRequest("GET", "/query?from=-30d&horizon=90d", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want %d, body: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	body := w.Body.String()
+	if !strings.Contains(body, "SUMMARY:Soon") {
+		t.Error("Event inside the window was removed")
+	}
+	if strings.Contains(body, "SUMMARY:Past") {
+		t.Error("Past event was not removed")
+	}
+	if strings.Contains(body, "SUMMARY:Far") {
+		t.Error("Event beyond the horizon was not removed")
+	}
+}
+
+// TestQueryExpandRecurring tests per-occurrence filtering of recurring events
+// Validates: A cancelled override is removed without dropping the series, in both expand modes
+func TestQueryExpandRecurring(t *testing.T) {
+	upstream := newICSUpstream(t, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n"+
+		"BEGIN:VEVENT\r\nUID:series\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250106T170000Z\r\nDTEND:20250106T190000Z\r\n"+
+		"RRULE:FREQ=WEEKLY;COUNT=4\r\nSUMMARY:Weekly meeting\r\nEND:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\nUID:series\r\nDTSTAMP:20250101T000000Z\r\nRECURRENCE-ID:20250113T170000Z\r\n"+
+		"DTSTART:20250113T170000Z\r\nDTEND:20250113T190000Z\r\nSUMMARY:INSTÄLLT Weekly meeting\r\nEND:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+
+	cfg := getTestConfig()
+	cfg.Upstream.DefaultURL = upstream.URL
+	server := New(cfg)
+
+	tests := []struct {
+		name  string
+		url   string
+		check func(t *testing.T, body string)
+	}{
+		{
+			name: "instances",
+			url:  "/query?RemoveInstallt&expand=instances&from=2025-01-01&to=2025-03-01",
+			check: func(t *testing.T, body string) {
+				if got := strings.Count(body, "BEGIN:VEVENT"); got != 3 {
+					t.Errorf("Got %d events, want 3 remaining occurrences", got)
+				}
+				if strings.Contains(body, "INSTÄLLT") || strings.Contains(body, "RRULE") {
+					t.Error("Output still contains the cancelled occurrence or an RRULE")
+				}
+			},
+		},
+		{
+			name: "exdate",
+			url:  "/query?RemoveInstallt&expand=exdate&from=2025-01-01&to=2025-03-01",
+			check: func(t *testing.T, body string) {
+				if got := strings.Count(body, "BEGIN:VEVENT"); got != 1 {
+					t.Errorf("Got %d events, want only the master", got)
+				}
+				if !strings.Contains(body, "RRULE:FREQ=WEEKLY") {
+					t.Error("Master RRULE missing")
+				}
+				if !strings.Contains(body, "EXDATE:20250113T170000Z") {
+					t.Error("Cancelled occurrence not added as EXDATE")
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Status = %d, want %d, body: %s", w.Code, http.StatusOK, w.Body.String())
+			}
+			tt.check(t, w.Body.String())
+		})
+	}
+}
+
+// TestParseParamsExpand tests the expand parameter
+// Validates: Mode aliases, invalid values rejected, cache key includes the mode
+func TestParseParamsExpand(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{"/query?pattern=x", ExpandNone, false},
+		{"/query?expand", ExpandInstances, false},
+		{"/query?expand=1", ExpandInstances, false},
+		{"/query?expand=instances", ExpandInstances, false},
+		{"/query?expand=exdate", ExpandExdate, false},
+		{"/query?expand=false", ExpandNone, false},
+		{"/query?expand=bogus", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			params, err := parseParams(httptest.NewRequest("GET", tt.url, nil))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && params.Expand != tt.want {
+				t.Errorf("Expand = %q, want %q", params.Expand, tt.want)
+			}
+		})
+	}
+
+	plain := createCacheKey(&Params{Upstreams: []string{"https://example.com/cal.ics"}})
+	expanded := createCacheKey(&Params{Upstreams: []string{"https://example.com/cal.ics"}, Expand: ExpandExdate})
+	if plain == expanded {
+		t.Error("Expand mode should affect cache key")
+	}
+}
+
+// TestQueryExpression tests the q= boolean expression parameter end to end
+// Validates: Expression keeps matching events, invalid expressions return 400, cache key
+func TestQueryExpression(t *testing.T) {
+	upstream := newICSUpstream(t, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n"+
+		"BEGIN:VEVENT\r\nUID:1\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\nSUMMARY:TestLodge PB: Grad 3\r\nEND:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\nUID:2\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250111T170000Z\r\nSUMMARY:TestLodge PB: Grad 8\r\nEND:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\nUID:3\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250112T170000Z\r\nSUMMARY:PB, Moderlogen: Grad 8\r\nEND:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\nUID:4\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250113T170000Z\r\nSUMMARY:INSTÄLLT PB, Moderlogen: Grad 2\r\nEND:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+
+	cfg := getTestConfig()
+	cfg.Upstream.DefaultURL = upstream.URL
+	server := New(cfg)
+
+	q := url.QueryEscape("(grad:4 OR loge:Moderlogen) AND NOT summary:INSTÄLLT")
+	req := httptest.NewRequest("GET", "/query?q="+q, nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want %d, body: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	body := w.Body.String()
+	for uid, want := range map[string]bool{"1": true, "2": false, "3": true, "4": false} {
+		if got := strings.Contains(body, "UID:"+uid+"\r\n"); got != want {
+			t.Errorf("Event %s present = %v, want %v", uid, got, want)
+		}
+	}
+
+	// Malformed expression is a client error
+	req = httptest.NewRequest("GET", "/query?q="+url.QueryEscape("(grad:4"), nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Status = %d, want %d for malformed expression", w.Code, http.StatusBadRequest)
+	}
+
+	// Expression is part of the cache key
+	key1 := createCacheKey(&Params{Upstreams: []string{upstream.URL}, Query: "Göta"})
+	key2 := createCacheKey(&Params{Upstreams: []string{upstream.URL}, Query: "Borås"})
+	if key1 == key2 {
+		t.Error("Different expressions should produce different cache keys")
+	}
+}
+
+// TestQueryAnyProperty tests filtering on properties beyond the built-in fields
+// Validates: field=CATEGORIES removes events with a matching category, PROP;PARAM in field= and q=
+func TestQueryAnyProperty(t *testing.T) {
+	upstream := newICSUpstream(t, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n"+
+		"BEGIN:VEVENT\r\nUID:1\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\nSUMMARY:Möte\r\nCATEGORIES:Ekonomi,Styrelse\r\n"+
+		"ATTENDEE;PARTSTAT=ACCEPTED:mailto:a@example.com\r\nEND:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\nUID:2\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250111T170000Z\r\nSUMMARY:Möte\r\nCATEGORIES:Grad\r\n"+
+		"ATTENDEE;PARTSTAT=DECLINED:mailto:a@example.com\r\nEND:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+
+	cfg := getTestConfig()
+	cfg.Upstream.DefaultURL = upstream.URL
+	server := New(cfg)
+
+	tests := []struct {
+		name, query, kept string
+	}{
+		{"categories", "field=CATEGORIES&pattern=Styrelse", "2"},
+		{"anchored category", "field=categories&pattern=" + url.QueryEscape("^Grad$"), "1"},
+		{"parameter", "field=" + url.QueryEscape("ATTENDEE;PARTSTAT") + "&pattern=DECLINED", "1"},
+		{"parameter in expression", "q=" + url.QueryEscape("attendee;partstat:DECLINED"), "2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest("GET", "/query?"+tt.query, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Status = %d, want %d, body: %s", w.Code, http.StatusOK, w.Body.String())
+			}
+			for _, uid := range []string{"1", "2"} {
+				if got, want := strings.Contains(w.Body.String(), "UID:"+uid+"\r\n"), uid == tt.kept; got != want {
+					t.Errorf("Event %s present = %v, want %v", uid, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestQueryTransforms tests rewriting events with transform presets and inline rules
+// Validates: Presets run before rules, changes serialized, cache key, preview lists the
+// rules, unknown presets and invalid rules rejected
+func TestQueryTransforms(t *testing.T) {
+	upstream := newICSUpstream(t, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n"+
+		"BEGIN:VEVENT\r\nUID:1\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\nSUMMARY:Göta PB: Grad 3\r\nEND:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\nUID:2\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250111T170000Z\r\nSUMMARY:INSTÄLLT Göta PB: Grad 8\r\nEND:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+
+	cfg := getTestConfig()
+	cfg.Upstream.DefaultURL = upstream.URL
+	cfg.Transforms.Presets = map[string][]config.TransformRule{
+		"kort": {{Type: "replace", Field: "SUMMARY", Pattern: "Göta PB: ", Value: ""}},
+	}
+	server := New(cfg)
+
+	query := "transform=kort&rule1=replace&rule1.field=SUMMARY&rule1.pattern=" + url.QueryEscape(`^INSTÄLLT\s*`) +
+		"&rule1.value=" + url.QueryEscape("[INSTÄLLT] ") + "&rule2=alarm&rule2.before=1h"
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/query?"+query, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want %d, body: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	body := w.Body.String()
+	for _, want := range []string{"SUMMARY:Grad 3\r\n", "SUMMARY:[INSTÄLLT] Grad 8\r\n", "TRIGGER:-PT3600S\r\n"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Output does not contain %q:\n%s", want, body)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/query/preview?"+query, nil))
+	if !strings.Contains(w.Body.String(), "Active Transforms") || !strings.Contains(w.Body.String(), "[INSTÄLLT] Grad 8") {
+		t.Error("Preview does not list the transforms or show the rewritten events")
+	}
+
+	// Order matters: presets run before rules, so swapping them is a different output
+	key1 := createCacheKey(&Params{Transforms: []string{"kort"}})
+	key2 := createCacheKey(&Params{Rules: []config.TransformRule{{Type: "alarm"}}})
+	if key1 == key2 || key1 == createCacheKey(&Params{}) {
+		t.Error("Transforms should be part of the cache key")
+	}
+
+	for _, bad := range []string{"transform=saknas", "rule=set&rule.field=UID&rule.value=x", "rule=alarm&rule.before=soon", "rule=rename"} {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("GET", "/query?"+bad, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("GET /query?%s = %d, want %d", bad, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// TestQueryPrivacy tests anonymized output on /query
+// Validates: Default and saved profiles with request options, nothing private in the output,
+// unknown profiles rejected, privacy=0 off, the profile part of the cache key and tag
+func TestQueryPrivacy(t *testing.T) {
+	upstream := newICSUpstream(t, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\nX-WR-CALNAME:Göta PB\r\n"+
+		"BEGIN:VEVENT\r\nUID:1\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T173000Z\r\nDTEND:20250110T191500Z\r\n"+
+		"SUMMARY:Göta PB: Grad 3\r\nDESCRIPTION:Logemöte\r\nLOCATION:Logehuset\r\n"+
+		"ORGANIZER;CN=Ordförande:mailto:ordf@example.com\r\nURL:https://example.com/loge\r\nEND:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+
+	cfg := getTestConfig()
+	cfg.Upstream.DefaultURL = upstream.URL
+	cfg.Cache.MaxMemory = 1024 * 1024
+	cfg.Cache.MaxTTL = time.Hour
+	cfg.Privacy.Profiles = map[string]config.PrivacyProfile{"publik": {Placeholder: "Upptagen", RoundHours: true}}
+	cfg.Privacy.HashKey = "nyckel"
+	server := New(cfg)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"privacy", []string{"SUMMARY:Busy\r\n", "UID:1\r\n", "DTSTART:20250110T173000Z\r\n"}},
+		{"privacy=publik&privacy.minimal", []string{"UID:1\r\n", "DTSTART:20250110T170000Z\r\n", "DTEND:20250110T200000Z\r\n"}},
+		{"privacy.placeholder=Loge&privacy.hash_uid", []string{"SUMMARY:Loge\r\n", "@recal\r\n"}},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("GET", "/query?"+tt.query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /query?%s = %d, body: %s", tt.query, w.Code, w.Body.String())
+		}
+		body := w.Body.String()
+		for _, want := range tt.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("GET /query?%s does not contain %q:\n%s", tt.query, want, body)
+			}
+		}
+		for _, private := range []string{"Göta", "Logemöte", "Logehuset", "ordf", "example.com"} {
+			if strings.Contains(body, private) {
+				t.Errorf("GET /query?%s leaked %q:\n%s", tt.query, private, body)
+			}
+		}
+	}
+	if n := server.filteredCache.DeleteTagged(privacyTag); n != len(tests) {
+		t.Errorf("%d entries tagged %q, want %d", n, privacyTag, len(tests))
+	}
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/query?privacy=saknas", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Unknown profile = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/query?privacy=0&pattern=Styrelse", nil))
+	if !strings.Contains(w.Body.String(), "SUMMARY:Göta PB: Grad 3") {
+		t.Errorf("privacy=0 should leave events as they are:\n%s", w.Body.String())
+	}
+
+	plain := createCacheKey(&Params{})
+	def := createCacheKey(&Params{Privacy: &PrivacyParams{}})
+	named := createCacheKey(&Params{Privacy: &PrivacyParams{Profile: "publik"}})
+	if plain == def || def == named {
+		t.Error("The privacy profile should be part of the cache key")
+	}
+}
+
+// TestQueryFormats tests JSON and jCal output on /query
+// Validates: format= and Accept select the format and Content-Type, filters apply to
+// every format, each format cached under its own key, invalid formats rejected
+func TestQueryFormats(t *testing.T) {
+	upstream := newICSUpstream(t, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n"+
+		"BEGIN:VEVENT\r\nUID:1\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\nSUMMARY:Göta PB: Grad 3\r\nEND:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\nUID:2\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250111T170000Z\r\nSUMMARY:Styrelsemöte\r\nEND:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+
+	cfg := getTestConfig()
+	cfg.Upstream.DefaultURL = upstream.URL
+	cfg.Cache.MaxMemory = 1024 * 1024
+	cfg.Cache.MaxTTL = time.Hour
+	server := New(cfg)
+
+	tests := []struct {
+		name, query, accept, wantType, want string
+	}{
+		{"ics", "", "", "text/calendar", "BEGIN:VCALENDAR"},
+		{"json", "&format=json", "", "application/json", `"summary":"Göta PB: Grad 3","start":"2025-01-10T17:00:00Z"`},
+		{"jcal", "&format=jcal", "", "application/calendar+json", `["summary",{},"text","Göta PB: Grad 3"]`},
+		{"accept json", "", "text/html, application/json;q=0.9", "application/json", `"uid":"1"`},
+		{"accept jcal", "", "application/calendar+json", "application/calendar+json", `["vcalendar",`},
+		{"accept refused", "", "application/json;q=0", "text/calendar", "BEGIN:VCALENDAR"},
+		{"format wins", "&format=json", "application/calendar+json", "application/json", `"events":[`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/query?pattern=Styrelse"+tt.query, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Status = %d, want %d, body: %s", w.Code, http.StatusOK, w.Body.String())
+			}
+			if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, tt.wantType) {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if w.Header().Get("Vary") != "Accept" {
+				t.Error("Response should vary on Accept")
+			}
+			body := w.Body.String()
+			if !strings.Contains(body, tt.want) || strings.Contains(body, "Styrelsemöte") {
+				t.Errorf("Body does not contain %q or was not filtered:\n%s", tt.want, body)
+			}
+		})
+	}
+
+	// Three formats, three entries; a repeated request is a hit with the right type
+	if entries := server.filteredCache.GetStats().Entries; entries != 3 {
+		t.Errorf("Filtered cache has %d entries, want one per format", entries)
+	}
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/query?pattern=Styrelse&format=jcal", nil))
+	if w.Header().Get("X-Cache") != "HIT" || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/calendar+json") {
+		t.Errorf("Cached jCal served with X-Cache %q and Content-Type %q", w.Header().Get("X-Cache"), w.Header().Get("Content-Type"))
+	}
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/query?pattern=Styrelse&format=xml", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("format=xml = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+// TestQueryFilterBudgets tests requests over the regex budgets
+// Validates: 422 naming the offending filter for long patterns and the active filters for
+// exhausted time budgets, on /query and /query/preview, and nothing cached
+func TestQueryFilterBudgets(t *testing.T) {
+	upstream := newICSUpstream(t, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n"+
+		"BEGIN:VEVENT\r\nUID:1\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\nSUMMARY:TestLodge PB: Grad 3\r\nEND:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+
+	cfg := getTestConfig()
+	cfg.Upstream.DefaultURL = upstream.URL
+	cfg.Cache.MaxMemory = 1024 * 1024
+	cfg.Cache.MaxTTL = time.Hour
+	cfg.Regex.MaxExecutionTime = time.Nanosecond
+	server := New(cfg)
+
+	tests := []struct {
+		name, path string
+		code       int
+		want       string
+	}{
+		{"long pattern", "/query?pattern=" + strings.Repeat("a", 600), http.StatusUnprocessableEntity, "is 600 characters long"},
+		{"time budget", "/query?pattern=Cancelled", http.StatusUnprocessableEntity, `filter "NOT SUMMARY,DESCRIPTION:Cancelled" exceeded the 1ns time budget after 1 of 1 events`},
+		{"time budget with expression", "/query?q=G%C3%B6ta+OR+Cancelled", http.StatusUnprocessableEntity, `filter "SUMMARY,DESCRIPTION:Göta OR SUMMARY,DESCRIPTION:Cancelled" exceeded`},
+		{"time budget in preview", "/query/preview?pattern=Cancelled", http.StatusUnprocessableEntity, `filter "NOT SUMMARY,DESCRIPTION:Cancelled" exceeded`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.code || !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("GET %s = %d %q, want %d containing %q", tt.path, w.Code, w.Body.String(), tt.code, tt.want)
+			}
+		})
+	}
+	if entries := server.filteredCache.GetStats().Entries; entries != 0 {
+		t.Errorf("Filtered cache has %d entries, want none", entries)
+	}
+}
+
+// TestQueryMultipleUpstreams tests merging repeated upstream= and named source= feeds
+// Validates: Events from all sources, UID de-duplication, partial failures reported, not cached
+func TestQueryMultipleUpstreams(t *testing.T) {
+	first := newICSUpstream(t, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n"+
+		"BEGIN:VEVENT\r\nUID:shared\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\nSUMMARY:Shared\r\nEND:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\nUID:a\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250111T170000Z\r\nSUMMARY:Göta PB: Grad 3\r\nEND:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+	second := newICSUpstream(t, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n"+
+		"BEGIN:VEVENT\r\nUID:shared\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\nSUMMARY:Shared\r\nEND:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\nUID:b\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250112T170000Z\r\nSUMMARY:Borås PB: Grad 8\r\nEND:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	t.Cleanup(broken.Close)
+
+	cfg := getTestConfig()
+	cfg.Upstream.Sources = map[string]string{"boras": second.URL}
+	server := New(cfg)
+
+	query := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := query("/query?upstream=" + url.QueryEscape(first.URL) + "&source=boras&pattern=Grad%208")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want %d, body: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	body := w.Body.String()
+	if got := strings.Count(body, "UID:shared\r\n"); got != 1 {
+		t.Errorf("Shared UID appears %d times, want 1", got)
+	}
+	if !strings.Contains(body, "UID:a\r\n") || strings.Contains(body, "UID:b\r\n") {
+		t.Errorf("Filter not applied across merged sources:\n%s", body)
+	}
+
+	// One failing source still serves the others, reports the failure and is not cached
+	partial := "/query?upstream=" + url.QueryEscape(first.URL) + "&upstream=" + url.QueryEscape(broken.URL)
+	w = query(partial)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Partial failure status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if errHeader := w.Header().Get("X-Upstream-Error"); !strings.Contains(errHeader, broken.URL) {
+		t.Errorf("X-Upstream-Error = %q, want it to name the failed source", errHeader)
+	}
+	if !strings.Contains(w.Body.String(), "UID:a\r\n") {
+		t.Error("Events from the working source missing")
+	}
+	if w = query(partial); w.Header().Get("X-Cache") == "HIT" {
+		t.Error("Partial result should not be cached")
+	}
+
+	// All sources failing is a gateway error; unknown sources are client errors
+	if w = query("/query?upstream=" + url.QueryEscape(broken.URL)); w.Code != http.StatusBadGateway {
+		t.Errorf("All failed status = %d, want %d", w.Code, http.StatusBadGateway)
+	}
+	if w = query("/query?source=nowhere"); w.Code != http.StatusBadRequest {
+		t.Errorf("Unknown source status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+// TestUpstreamAllowlist tests enforcement of upstream.allowed
+// Validates: Unlisted upstreams and redirects to them get 403, named sources pass, config
+// page shows a source dropdown
+func TestUpstreamAllowlist(t *testing.T) {
+	upstream := newICSUpstream(t, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n"+
+		"BEGIN:VEVENT\r\nUID:1\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\nSUMMARY:Göta PB\r\nEND:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://evil.example.com/cal.ics", http.StatusFound)
+	}))
+	t.Cleanup(redirector.Close)
+
+	cfg := getTestConfig()
+	cfg.Upstream.Sources = map[string]string{"gota": upstream.URL, "hop": redirector.URL}
+	cfg.Upstream.Allowed = config.AllowedConfig{Enabled: true, Hosts: []string{"*.google.com"}}
+	server := New(cfg)
+
+	tests := []struct {
+		url      string
+		wantCode int
+	}{
+		{"/query?source=gota", http.StatusOK},
+		{"/query?upstream=" + url.QueryEscape(upstream.URL), http.StatusOK},
+		{"/query?upstream=" + url.QueryEscape("https://evil.example.com/cal.ics"), http.StatusForbidden},
+		{"/query/preview?upstream=" + url.QueryEscape("https://evil.example.com/cal.ics"), http.StatusForbidden},
+		{"/query?source=hop", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			w := httptest.NewRecorder()
+			if strings.HasPrefix(tt.url, "/query/preview") {
+				server.DebugHTTP(w, req)
+			} else {
+				server.ServeHTTP(w, req)
+			}
+			if w.Code != tt.wantCode {
+				t.Errorf("Status = %d, want %d, body: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	server.ConfigPage(w, httptest.NewRequest("GET", "/", nil))
+	if body := w.Body.String(); !strings.Contains(body, `id="source-select"`) || !strings.Contains(body, `<option value="gota">gota</option>`) {
+		t.Error("Configuration page missing source dropdown")
+	}
+}
+
+// TestStaleIfError tests serving expired output while the upstream is down
+// Validates: Stale output with X-Cache/Warning headers, error once the grace period is disabled
+func TestStaleIfError(t *testing.T) {
+	t.Setenv("DISABLE_SSRF_PROTECTION", "true")
+
+	var down atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n" +
+			"BEGIN:VEVENT\r\nUID:a\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\nSUMMARY:Grad 3\r\nEND:VEVENT\r\n" +
+			"END:VCALENDAR\r\n"))
+	}))
+	defer upstream.Close()
+
+	cfg := getTestConfig()
+	cfg.Upstream.DefaultURL = upstream.URL
+	cfg.Cache.DefaultTTL = 20 * time.Millisecond
+	cfg.Cache.MinOutputCache = 10 * time.Millisecond
+	cfg.Cache.MaxMemory = 1024 * 1024
+	cfg.Cache.MaxTTL = time.Hour
+	cfg.Cache.StaleGrace = time.Hour
+	server := New(cfg)
+
+	query := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("GET", "/query?Grad=3", nil))
+		return w
+	}
+
+	if w := query(); w.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	down.Store(true)
+	time.Sleep(50 * time.Millisecond)
+
+	w := query()
+	if w.Code != http.StatusOK {
+		t.Fatalf("Stale status = %d, want %d, body: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if got := w.Header().Get("X-Cache"); got != "STALE" {
+		t.Errorf("X-Cache = %q, want STALE", got)
+	}
+	if got := w.Header().Get("Warning"); !strings.HasPrefix(got, "110 ") {
+		t.Errorf("Warning = %q, want 110 warning", got)
+	}
+	if w.Header().Get("X-Upstream-Error") == "" {
+		t.Error("X-Upstream-Error header missing")
+	}
+	if !strings.Contains(w.Body.String(), "UID:a\r\n") {
+		t.Error("Stale output missing last good events")
+	}
+
+	// Without a grace period the failure is reported
+	cfg.Cache.StaleGrace = 0
+	server = New(cfg)
+	if w := query(); w.Code != http.StatusBadGateway {
+		t.Errorf("Status without stale output = %d, want %d", w.Code, http.StatusBadGateway)
+	}
+}
+
+// TestRequestCoalescing tests that concurrent cache misses share one upstream fetch
+// Validates: Single upstream request and filter build, coalesced count on /status
+func TestRequestCoalescing(t *testing.T) {
+	t.Setenv("DISABLE_SSRF_PROTECTION", "true")
+
+	var requests atomic.Int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		_, _ = w.Write([]byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n" +
+			"BEGIN:VEVENT\r\nUID:a\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\nSUMMARY:Grad 3\r\nEND:VEVENT\r\n" +
+			"END:VCALENDAR\r\n"))
+	}))
+	defer upstream.Close()
+
+	cfg := getTestConfig()
+	cfg.Upstream.DefaultURL = upstream.URL
+	server := New(cfg)
+
+	const clients = 8
+	codes := make([]int, clients)
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest("GET", "/query?Grad=3", nil))
+			codes[i] = w.Code
+		}(i)
+	}
+
+	// Hold the upstream until every client is waiting on the first build
+	deadline := time.Now().Add(5 * time.Second)
+	for server.renderFlight.GetStats().Coalesced < clients-1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("Client %d status = %d, want %d", i, code, http.StatusOK)
+		}
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("Upstream requests = %d, want 1", got)
+	}
+	if stats := server.renderFlight.GetStats(); stats.Executed != 1 || stats.Coalesced != clients-1 {
+		t.Errorf("Filter builds = %+v, want 1 executed, %d coalesced", stats, clients-1)
+	}
+
+	w := httptest.NewRecorder()
+	server.Status(w, httptest.NewRequest("GET", "/status", nil))
+	if !strings.Contains(w.Body.String(), "Request Coalescing") {
+		t.Error("Status page missing coalescing metrics")
+	}
+}
+
+// TestDiskCacheRestart tests that a restarted server serves from the disk cache
+// Validates: Filtered output cached before restart served without contacting the upstream
+func TestDiskCacheRestart(t *testing.T) {
+	upstream := newICSUpstream(t, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n"+
+		"BEGIN:VEVENT\r\nUID:a\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\nSUMMARY:Grad 3\r\nEND:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+
+	cfg := getTestConfig()
+	cfg.Upstream.DefaultURL = upstream.URL
+	cfg.Cache.MaxMemory = 1024 * 1024
+	cfg.Cache.MaxTTL = time.Hour
+	cfg.Cache.Disk.Dir = t.TempDir()
+
+	query := func(server *Server) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("GET", "/query?Grad=3", nil))
+		return w
+	}
+
+	first := query(New(cfg))
+	if first.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want %d", first.Code, http.StatusOK)
+	}
+
+	upstream.Close()
+	w := query(New(cfg))
+	if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("After restart status = %d, X-Cache = %q, want 200 HIT", w.Code, w.Header().Get("X-Cache"))
+	}
+	if w.Body.String() != first.Body.String() {
+		t.Error("Output after restart differs from output before")
+	}
+}
+
+// TestRedisBackendUnavailable tests serving with an unreachable shared cache
+// Validates: Redis backend selected from config, cache errors degrade to misses
+func TestRedisBackendUnavailable(t *testing.T) {
+	upstream := newICSUpstream(t, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n"+
+		"BEGIN:VEVENT\r\nUID:a\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\nSUMMARY:Grad 3\r\nEND:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+
+	// Nothing listens on a closed listener's address
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	cfg := getTestConfig()
+	cfg.Upstream.DefaultURL = upstream.URL
+	cfg.Cache.MaxTTL = time.Hour
+	cfg.Cache.Backend = "redis"
+	cfg.Cache.Redis.Addr = addr
+	cfg.Cache.Redis.Timeout = 100 * time.Millisecond
+
+	server := New(cfg)
+	if _, ok := server.filteredCache.(*cache.RedisCache); !ok {
+		t.Fatalf("filteredCache is %T, want *cache.RedisCache", server.filteredCache)
+	}
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("GET", "/query?Grad=3", nil))
+		if w.Code != http.StatusOK || w.Header().Get("X-Cache") == "HIT" {
+			t.Errorf("Request %d: status = %d, X-Cache = %q, want 200 without cache hit", i, w.Code, w.Header().Get("X-Cache"))
+		}
+	}
+	if stats := server.filteredCache.GetStats(); stats.Errors == 0 {
+		t.Error("Backend errors not counted")
+	}
+}
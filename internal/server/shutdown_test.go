@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// testClient opens a connection per request
+// With keep-alives the transport may dial spare connections that never send a request,
+// which Shutdown waits on for several seconds before treating them as idle.
+var testClient = &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+// startServing runs s on a random local port
+// It returns the base URL, a function starting shutdown and serve's result.
+func startServing(t *testing.T, s *Server) (string, context.CancelFunc, <-chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- s.serve(ctx, ln) }()
+
+	base := "http://" + ln.Addr().String()
+	deadline := time.Now().Add(2 * time.Second)
+	for readyStatus(base) != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("Server did not become ready")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return base, cancel, done
+}
+
+// readyStatus returns the /ready status code, or 0 if the server cannot be reached
+func readyStatus(base string) int {
+	resp, err := testClient.Get(base + "/ready")
+	if err != nil {
+		return 0
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+// blockingUpstream returns an upstream that answers once release is closed,
+// and a channel that receives a value when a request arrives
+func blockingUpstream(t *testing.T, release chan struct{}) (string, <-chan struct{}) {
+	t.Helper()
+	t.Setenv("DISABLE_SSRF_PROTECTION", "true")
+	arrived := make(chan struct{}, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+		w.Header().Set("Content-Type", "text/calendar")
+		_, _ = w.Write([]byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n" +
+			"BEGIN:VEVENT\r\nUID:a\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\nSUMMARY:Grad 3\r\nEND:VEVENT\r\n" +
+			"END:VCALENDAR\r\n"))
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream.URL, arrived
+}
+
+// TestGracefulShutdown tests draining on cancellation
+// Validates: /ready fails before serving and during the shutdown delay while /health stays up,
+// in-flight requests complete, new connections are refused afterwards
+func TestGracefulShutdown(t *testing.T) {
+	release := make(chan struct{})
+	upstream, arrived := blockingUpstream(t, release)
+
+	cfg := getTestConfig()
+	cfg.Server.ShutdownDelay = 200 * time.Millisecond
+	s := New(cfg)
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/ready", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Ready before serving = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+
+	base, shutdown, done := startServing(t, s)
+
+	// A slow request is in flight when shutdown starts
+	type result struct {
+		status int
+		err    error
+	}
+	inFlight := make(chan result, 1)
+	go func() {
+		resp, err := testClient.Get(base + "/query?pattern=Cancelled&upstream=" + url.QueryEscape(upstream))
+		if err != nil {
+			inFlight <- result{err: err}
+			return
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		inFlight <- result{status: resp.StatusCode}
+	}()
+	<-arrived
+	shutdown()
+
+	// During the delay the listener is still open but no longer ready
+	time.Sleep(50 * time.Millisecond)
+	if status := readyStatus(base); status != http.StatusServiceUnavailable {
+		t.Errorf("Ready while draining = %d, want %d", status, http.StatusServiceUnavailable)
+	}
+	if resp, err := testClient.Get(base + "/health"); err != nil {
+		t.Errorf("Health while draining failed: %v", err)
+	} else {
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Health while draining = %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+	}
+
+	close(release)
+	if res := <-inFlight; res.err != nil || res.status != http.StatusOK {
+		t.Errorf("In-flight request = %d, %v, want 200", res.status, res.err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("serve() = %v, want nil after shutdown", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve() did not return after shutdown")
+	}
+	if status := readyStatus(base); status != 0 {
+		t.Errorf("Request after shutdown got %d, want connection refused", status)
+	}
+}
+
+// TestShutdownTimeout tests that a stuck request cannot block shutdown forever
+// Validates: Connections are closed once the drain timeout expires
+func TestShutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	upstream, arrived := blockingUpstream(t, release)
+
+	cfg := getTestConfig()
+	cfg.Server.ShutdownTimeout = 100 * time.Millisecond
+	s := New(cfg)
+
+	base, shutdown, done := startServing(t, s)
+
+	go func() {
+		if resp, err := testClient.Get(base + "/query?pattern=Cancelled&upstream=" + url.QueryEscape(upstream)); err == nil {
+			_ = resp.Body.Close()
+		}
+	}()
+	<-arrived
+
+	start := time.Now()
+	shutdown()
+	select {
+	case <-done:
+		if elapsed := time.Since(start); elapsed < cfg.Server.ShutdownTimeout {
+			t.Errorf("serve() returned after %v, want it to wait for the drain timeout", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve() did not return after the drain timeout")
+	}
+}
@@ -38,8 +38,9 @@ const configPageTemplate = `<!DOCTYPE html>
       margin-top: 0;
       color: #444;
     }
-    select {
+    select, input[type="text"] {
       width: 100%;
+      box-sizing: border-box;
       padding: 10px;
       border: 1px solid #ddd;
       border-radius: 4px;
@@ -134,6 +135,21 @@ const configPageTemplate = `<!DOCTYPE html>
     <h1>ReCal</h1>
     <p class="subtitle">Konfigurera dina kalenderfilter</p>
 
+    {{if .Sources}}
+    <!-- Source Selection -->
+    <div class="filter-section">
+      <h3>Källa</h3>
+      <select id="source-select">
+        <option value="">Standardkalender</option>
+        {{range .Sources}}<option value="{{.}}">{{.}}</option>
+        {{end}}
+      </select>
+      <p class="help-text">
+        Välj vilken kalender som ska filtreras
+      </p>
+    </div>
+    {{end}}
+
     <!-- Grade Filter -->
     <div class="filter-section">
       <h3>Grad</h3>
@@ -204,6 +220,21 @@ const configPageTemplate = `<!DOCTYPE html>
       </button>
     </div>
 
+    <!-- Short Link -->
+    <div class="filter-section">
+      <h3>Kortlänk</h3>
+      <input type="text" id="feed-slug" placeholder="Namn, t.ex. mina-loger (lämna tomt för slumpat namn)">
+      <div class="action-buttons" style="margin-top: 15px;">
+        <button id="save-feed-btn" class="btn-primary">
+          🔗 Spara som kortlänk
+        </button>
+      </div>
+      <p class="help-text">
+        Prenumerera på kortlänken så kan du ändra filtret senare utan att prenumerera på nytt.
+        Rätten att ändra sparas i den här webbläsaren.
+      </p>
+    </div>
+
     <!-- Calendar App Integration -->
     <div class="filter-section">
       <h3>Öppna i kalenderapp</h3>
@@ -219,6 +250,12 @@ const configPageTemplate = `<!DOCTYPE html>
   <script>
     const BASE_URL = '{{.BaseURL}}';
 
+    // Saved short link ({slug, query, url}) the current settings correspond to
+    let savedFeed = null;
+
+    // Parameters set by the form; a saved feed's other parameters are kept as they are
+    const FORM_PARAMS = ['source', 'Grad', 'Loge', 'RemoveUnconfirmed', 'RemoveInstallt'];
+
     // Load lodges from API
     async function loadLodges() {
       try {
@@ -254,6 +291,12 @@ const configPageTemplate = `<!DOCTYPE html>
     function applyURLParameters() {
       const params = new URLSearchParams(window.location.search);
 
+      // Apply source parameter
+      const sourceSelect = document.getElementById('source-select');
+      if (sourceSelect && params.has('source')) {
+        sourceSelect.value = params.get('source');
+      }
+
       // Apply Grad parameter
       if (params.has('Grad')) {
         document.getElementById('grad-select').value = params.get('Grad');
@@ -278,12 +321,33 @@ const configPageTemplate = `<!DOCTYPE html>
       if (params.has('RemoveInstallt')) {
         document.getElementById('remove-installt').checked = true;
       }
+
+      // Editing a saved feed (redirected from /f/{slug}?configure)
+      if (params.has('feed')) {
+        const slug = params.get('feed');
+        params.delete('feed');
+        document.getElementById('feed-slug').value = slug;
+        savedFeed = { slug: slug, query: params.toString(), url: BASE_URL + '/f/' + slug };
+      }
     }
 
-    // Generate URL based on current settings
-    function generateURL() {
-      const baseURL = BASE_URL + '/query';
-      const params = new URLSearchParams();
+    // Canonical form of a query string, so parameter order does not matter
+    function canonicalQuery(query) {
+      const params = new URLSearchParams(query);
+      params.sort();
+      return params.toString();
+    }
+
+    // Filter parameters for the current settings
+    // Starts from the saved feed so settings the form does not show (patterns, q=,
+    // dates, transforms, privacy, format...) survive editing it.
+    function buildParams() {
+      const params = new URLSearchParams(savedFeed ? savedFeed.query : '');
+      FORM_PARAMS.forEach(name => params.delete(name));
+
+      // Add named source
+      const sourceSelect = document.getElementById('source-select');
+      if (sourceSelect && sourceSelect.value) params.append('source', sourceSelect.value);
 
       // Add Grad filter
       const grad = document.getElementById('grad-select').value;
@@ -305,12 +369,27 @@ const configPageTemplate = `<!DOCTYPE html>
         params.append('RemoveInstallt', '');
       }
 
-      const url = params.toString() ? baseURL + '?' + params : baseURL;
+      return params;
+    }
+
+    // Generate URL based on current settings
+    // Uses the short link while the settings match the saved feed
+    function generateURL() {
+      const baseURL = BASE_URL + '/query';
+      const params = buildParams();
+
+      let url = params.toString() ? baseURL + '?' + params : baseURL;
+      if (savedFeed && canonicalQuery(savedFeed.query) === canonicalQuery(params)) {
+        url = savedFeed.url;
+      }
       document.getElementById('generated-url').textContent = url;
       return url;
     }
 
     // Update URL on any input change
+    if (document.getElementById('source-select')) {
+      document.getElementById('source-select').addEventListener('change', generateURL);
+    }
     document.getElementById('grad-select').addEventListener('change', generateURL);
     document.getElementById('remove-unconfirmed').addEventListener('change', generateURL);
     document.getElementById('remove-installt').addEventListener('change', generateURL);
@@ -347,10 +426,55 @@ const configPageTemplate = `<!DOCTYPE html>
     // Preview button - open in debug/preview mode
     document.getElementById('preview-btn').addEventListener('click', () => {
       const currentURL = new URL(generateURL());
-      const previewURL = currentURL.origin + '/query/preview' + currentURL.search;
+      const query = buildParams().toString();
+      const previewURL = currentURL.origin + '/query/preview' + (query ? '?' + query : '');
       window.open(previewURL, '_blank');
     });
 
+    // Save as short link - creates the feed, or updates it if this browser holds its edit token
+    document.getElementById('save-feed-btn').addEventListener('click', async () => {
+      const slug = document.getElementById('feed-slug').value.trim().toLowerCase();
+      const query = buildParams().toString();
+      const token = slug ? localStorage.getItem('recal-feed-token:' + slug) : null;
+
+      try {
+        let response;
+        if (token) {
+          response = await fetch('/api/feeds/' + encodeURIComponent(slug), {
+            method: 'PUT',
+            headers: { 'Content-Type': 'application/json', 'Authorization': 'Bearer ' + token },
+            body: JSON.stringify({ query: query })
+          });
+        } else {
+          response = await fetch('/api/feeds', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ slug: slug, query: query })
+          });
+        }
+
+        const data = await response.json();
+        if (response.status === 409) {
+          alert('Namnet "' + slug + '" är redan upptaget. Välj ett annat.');
+          return;
+        }
+        if (!response.ok) {
+          alert('Kunde inte spara kortlänk: ' + data.error);
+          return;
+        }
+
+        if (data.token) {
+          localStorage.setItem('recal-feed-token:' + data.slug, data.token);
+        }
+        document.getElementById('feed-slug').value = data.slug;
+        savedFeed = { slug: data.slug, query: data.query, url: data.url };
+        generateURL();
+        alert((token ? 'Kortlänken är uppdaterad: ' : 'Kortlänk skapad: ') + data.url);
+      } catch (err) {
+        alert('Kunde inte spara kortlänk: ' + err.message);
+      }
+    });
+
     // Platform detection
     function detectPlatform() {
       const ua = navigator.userAgent;
@@ -0,0 +1,104 @@
+// Package singleflight coalesces concurrent calls for the same key into one
+// execution, so a burst of cache misses does only the work of a single miss.
+package singleflight
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+// PanicError is returned to the waiting callers of a call whose function panicked
+type PanicError struct {
+	Value any    // Value passed to panic
+	Stack []byte // Stack of the panicking goroutine
+}
+
+// Error implements the error interface
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("shared call panicked: %v", e.Value)
+}
+
+// call is an in-flight or completed Do call
+type call[T any] struct {
+	wg   sync.WaitGroup
+	val  T
+	err  error
+	dups int // Callers waiting for this call, guarded by Group.mu
+}
+
+// Group runs at most one function per key at a time
+// Callers arriving while a call for their key is running wait for it and
+// receive its result instead of running their own.
+type Group[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*call[T]
+
+	// Metrics
+	executed  atomic.Int64
+	coalesced atomic.Int64
+}
+
+// Stats holds coalescing statistics
+type Stats struct {
+	Executed  int64 // Functions actually run
+	Coalesced int64 // Calls that shared another call's result
+}
+
+// Do runs fn for key unless a call for key is already in flight, in which case
+// it waits for that call and returns its result
+// shared reports whether the result was given to more than one caller. If fn
+// panics, the caller running it panics with the same value and the waiting
+// callers get a *PanicError.
+func (g *Group[T]) Do(key string, fn func() (T, error)) (val T, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call[T])
+	}
+	if c, ok := g.calls[key]; ok {
+		c.dups++
+		g.mu.Unlock()
+		g.coalesced.Add(1)
+		c.wg.Wait()
+		return c.val, c.err, true
+	}
+	c := &call[T]{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	g.executed.Add(1)
+	var panicked any
+	func() {
+		// Release waiters even if fn panics, with an error rather than a zero result
+		defer func() {
+			if r := recover(); r != nil {
+				panicked = r
+				c.err = &PanicError{Value: r, Stack: debug.Stack()}
+			}
+			g.mu.Lock()
+			delete(g.calls, key)
+			g.mu.Unlock()
+			c.wg.Done()
+		}()
+		c.val, c.err = fn()
+	}()
+	if panicked != nil {
+		panic(panicked)
+	}
+
+	// c has left the map, so dups can no longer change
+	g.mu.Lock()
+	shared = c.dups > 0
+	g.mu.Unlock()
+	return c.val, c.err, shared
+}
+
+// GetStats returns coalescing statistics
+func (g *Group[T]) GetStats() Stats {
+	return Stats{
+		Executed:  g.executed.Load(),
+		Coalesced: g.coalesced.Load(),
+	}
+}
@@ -0,0 +1,120 @@
+package singleflight
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestDo tests that a single call returns its own result
+// Validates: Value and error passed through, not shared
+func TestDo(t *testing.T) {
+	var g Group[string]
+
+	val, err, shared := g.Do("key", func() (string, error) { return "value", nil })
+	if val != "value" || err != nil || shared {
+		t.Errorf("Do() = %q, %v, %v, want value, nil, false", val, err, shared)
+	}
+
+	wantErr := errors.New("boom")
+	if _, err, _ := g.Do("key", func() (string, error) { return "", wantErr }); err != wantErr {
+		t.Errorf("Do() error = %v, want %v", err, wantErr)
+	}
+}
+
+// TestDoCoalesces tests concurrent calls for the same key
+// Validates: One execution per key, all callers get the result, stats count coalesced calls
+func TestDoCoalesces(t *testing.T) {
+	var g Group[int]
+	var runs atomic.Int32
+	release := make(chan struct{})
+
+	const callers = 10
+	var wg sync.WaitGroup
+	results := make([]int, callers)
+	sharedCount := atomic.Int32{}
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			val, _, shared := g.Do("key", func() (int, error) {
+				runs.Add(1)
+				<-release
+				return 42, nil
+			})
+			results[i] = val
+			if shared {
+				sharedCount.Add(1)
+			}
+		}(i)
+	}
+
+	// Wait until every other caller is queued behind the first
+	for g.GetStats().Coalesced < callers-1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if runs.Load() != 1 {
+		t.Errorf("Function ran %d times, want 1", runs.Load())
+	}
+	for i, val := range results {
+		if val != 42 {
+			t.Errorf("Caller %d got %d, want 42", i, val)
+		}
+	}
+	if sharedCount.Load() != callers {
+		t.Errorf("%d callers saw shared = true, want %d", sharedCount.Load(), callers)
+	}
+	if stats := g.GetStats(); stats.Executed != 1 || stats.Coalesced != callers-1 {
+		t.Errorf("Stats = %+v, want 1 executed, %d coalesced", stats, callers-1)
+	}
+
+	// The key is free again once the call finished
+	if _, _, shared := g.Do("key", func() (int, error) { return 0, nil }); shared {
+		t.Error("Do() after completion should not be shared")
+	}
+}
+
+// TestDoPanic tests a function that panics while others wait for it
+// Validates: The running caller panics with the value, waiters get a *PanicError, key freed
+func TestDoPanic(t *testing.T) {
+	var g Group[int]
+	release := make(chan struct{})
+
+	recovered := make(chan any)
+	go func() {
+		defer func() { recovered <- recover() }()
+		g.Do("key", func() (int, error) {
+			<-release
+			panic("boom")
+		})
+	}()
+	for g.GetStats().Executed < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	waited := make(chan error)
+	go func() {
+		_, err, _ := g.Do("key", func() (int, error) { return 42, nil })
+		waited <- err
+	}()
+	for g.GetStats().Coalesced < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	if r := <-recovered; r != "boom" {
+		t.Errorf("Running caller recovered %v, want boom", r)
+	}
+	var panicErr *PanicError
+	if err := <-waited; !errors.As(err, &panicErr) || panicErr.Value != "boom" || len(panicErr.Stack) == 0 {
+		t.Errorf("Waiting caller got %v, want a *PanicError for boom with a stack", err)
+	}
+	if val, err, _ := g.Do("key", func() (int, error) { return 42, nil }); val != 42 || err != nil {
+		t.Errorf("Do() after the panic = %d, %v, want 42, nil", val, err)
+	}
+}
@@ -0,0 +1,355 @@
+// Package transform rewrites events after filtering, e.g. to shorten summaries or add reminders
+package transform
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/emersion/go-ical"
+	"github.com/linus/recal/internal/config"
+	"github.com/linus/recal/internal/filter"
+	"github.com/linus/recal/internal/parser"
+)
+
+// alarmAction is the VALARM action of added reminders
+const alarmAction = "DISPLAY"
+
+// textEscaper escapes TEXT values (RFC 5545 section 3.3.11)
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\r", `\n`, "\n", `\n`)
+
+// lineBreaks puts values that are not TEXT on one line
+var lineBreaks = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// paramEscaper replaces what parameter values cannot hold (RFC 5545 section 3.2)
+// Rules are validated, but submatches of event text may still bring these in.
+var paramEscaper = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ", `"`, "'")
+
+// Pipeline applies transform rules to the events of a calendar, in the order they were added
+// See config.TransformRule for what each rule type does.
+type Pipeline struct {
+	engine  *filter.Engine // Compiles request patterns within the regex budgets
+	rules   []*rule
+	presets []string // Presets added so far, see Presets
+}
+
+// rule is a TransformRule ready to apply
+type rule struct {
+	config.TransformRule
+	pattern *regexp.Regexp // nil if the rule has no pattern
+	name    string         // Upper-case property name from Field
+	param   string         // Upper-case parameter name from Field, empty for the property itself
+}
+
+// NewPipeline creates an empty pipeline using the configuration and budgets of engine
+func NewPipeline(engine *filter.Engine) *Pipeline {
+	return &Pipeline{engine: engine}
+}
+
+// AddPreset appends the rules of a named preset from transforms.presets
+// Presets are trusted configuration, so their patterns don't count against the request budgets.
+func (p *Pipeline) AddPreset(name string) error {
+	specs, ok := p.engine.Config().Transforms.Presets[name]
+	if !ok {
+		return fmt.Errorf("unknown transform preset %q", name)
+	}
+
+	for i, spec := range specs {
+		var re *regexp.Regexp
+		if spec.Pattern != "" {
+			var err error
+			if re, err = regexp.Compile(spec.Pattern); err != nil {
+				return fmt.Errorf("transform preset %q rule %d: %w", name, i+1, err)
+			}
+		}
+		p.rules = append(p.rules, newRule(spec, re))
+	}
+	p.presets = append(p.presets, name)
+	return nil
+}
+
+// AddRule appends a rule given with the request
+// Its pattern counts against the regex budgets like a filter pattern.
+func (p *Pipeline) AddRule(spec config.TransformRule) error {
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+
+	var re *regexp.Regexp
+	if spec.Pattern != "" {
+		var err error
+		if re, err = p.engine.CompilePattern(spec.Pattern); err != nil {
+			return err
+		}
+	}
+	p.rules = append(p.rules, newRule(spec, re))
+	return nil
+}
+
+// newRule prepares spec with its compiled pattern
+func newRule(spec config.TransformRule, pattern *regexp.Regexp) *rule {
+	name, param, _ := strings.Cut(strings.ToUpper(spec.Field), ";")
+	return &rule{TransformRule: spec, pattern: pattern, name: name, param: param}
+}
+
+// Presets returns the names of the added presets
+// Output built by this pipeline changes when one of them is reloaded.
+func (p *Pipeline) Presets() []string {
+	return p.presets
+}
+
+// Rules returns the rules in the order they are applied, for display purposes
+func (p *Pipeline) Rules() []config.TransformRule {
+	rules := make([]config.TransformRule, len(p.rules))
+	for i, r := range p.rules {
+		rules[i] = r.TransformRule
+	}
+	return rules
+}
+
+// Apply returns cal with every rule applied to each event
+// Events are copied before they are changed, so cal itself is left as it was.
+func (p *Pipeline) Apply(cal *parser.Calendar) *parser.Calendar {
+	if len(p.rules) == 0 {
+		return cal
+	}
+
+	out := &parser.Calendar{Raw: cal.Raw, Location: cal.Location, Events: make([]*parser.Event, 0, len(cal.Events))}
+	for _, event := range cal.Events {
+		if event.RawEvent != nil && event.RawEvent.Component != nil {
+			event = event.Clone()
+			for _, r := range p.rules {
+				if r.apply(event) {
+					event.SyncText()
+				}
+			}
+		}
+		out.Events = append(out.Events, event)
+	}
+	return out
+}
+
+// apply rewrites event and reports whether anything changed
+func (r *rule) apply(event *parser.Event) bool {
+	props := event.RawEvent.Props
+	switch r.Type {
+	case config.TransformReplace:
+		return r.replace(props)
+	case config.TransformRemove:
+		return r.remove(props)
+	}
+
+	value, ok := r.condition(event)
+	if !ok {
+		return false
+	}
+	switch r.Type {
+	case config.TransformSet:
+		if r.IfMissing && len(event.GetFieldValues(r.Field)) > 0 {
+			return false
+		}
+		return r.set(props, value)
+	case config.TransformAppend:
+		return r.append(props, value)
+	case config.TransformAlarm:
+		r.alarm(event, value)
+		return true
+	}
+	return false
+}
+
+// condition matches the pattern against the source values of event
+// It returns the rule value with the submatches of the first match expanded, and
+// false if nothing matched. Rules without a pattern always apply with their value as is.
+func (r *rule) condition(event *parser.Event) (string, bool) {
+	if r.pattern == nil {
+		return r.Value, true
+	}
+
+	source := r.Source
+	if source == "" {
+		source = r.Field
+	}
+	if source == "" {
+		source = ical.PropSummary
+	}
+	for _, text := range event.GetFieldValues(source) {
+		if match := r.pattern.FindStringSubmatchIndex(text); match != nil {
+			return string(r.pattern.ExpandString(nil, r.Value, text, match)), true
+		}
+	}
+	return "", false
+}
+
+// set replaces the property with value, or sets the parameter on every occurrence
+func (r *rule) set(props ical.Props, value string) bool {
+	if r.param != "" {
+		occurrences := props.Values(r.name)
+		for i := range occurrences {
+			if occurrences[i].Params == nil {
+				occurrences[i].Params = make(ical.Params)
+			}
+			occurrences[i].Params.Set(r.param, paramEscaper.Replace(value))
+		}
+		return len(occurrences) > 0
+	}
+
+	prop := ical.NewProp(r.name)
+	prop.Value = encode(prop, value)
+	props.Set(prop)
+	return true
+}
+
+// replace substitutes pattern matches in every value of the property or parameter
+// Values are matched as they appear in the feed, like filters do.
+func (r *rule) replace(props ical.Props) bool {
+	changed := false
+	occurrences := props.Values(r.name)
+	for i := range occurrences {
+		if r.param != "" {
+			values := occurrences[i].Params.Values(r.param)
+			for j, value := range values {
+				if replaced := paramEscaper.Replace(r.pattern.ReplaceAllString(value, r.Value)); replaced != value {
+					values[j] = replaced
+					changed = true
+				}
+			}
+			continue
+		}
+
+		value := occurrences[i].Value
+		if replaced := lineBreaks.Replace(r.pattern.ReplaceAllString(value, r.Value)); replaced != value {
+			occurrences[i].Value = replaced
+			changed = true
+		}
+	}
+	return changed
+}
+
+// remove deletes the property or parameter, or only the values matching the pattern
+// List properties like CATEGORIES lose the matching items and are dropped once empty.
+func (r *rule) remove(props ical.Props) bool {
+	occurrences := props.Values(r.name)
+	if len(occurrences) == 0 {
+		return false
+	}
+
+	changed := false
+	if r.param != "" {
+		for _, prop := range occurrences {
+			values := prop.Params.Values(r.param)
+			kept := r.keep(values)
+			if len(kept) == len(values) {
+				continue
+			}
+			changed = true
+			prop.Params.Del(r.param)
+			for _, value := range kept {
+				prop.Params.Add(r.param, value)
+			}
+		}
+		return changed
+	}
+
+	var kept []ical.Prop
+	for _, prop := range occurrences {
+		if parser.IsListProp(r.name) {
+			items := parser.SplitList(prop.Value)
+			left := r.keep(items)
+			if len(left) != len(items) {
+				changed = true
+				prop.Value = strings.Join(left, ",")
+			}
+			if len(left) > 0 {
+				kept = append(kept, prop)
+			}
+			continue
+		}
+		if r.pattern == nil || r.pattern.MatchString(prop.Value) {
+			changed = true
+			continue
+		}
+		kept = append(kept, prop)
+	}
+
+	if len(kept) == 0 {
+		props.Del(r.name)
+	} else {
+		props[r.name] = kept
+	}
+	return changed
+}
+
+// keep returns the values the pattern does not match, none for rules without a pattern
+func (r *rule) keep(values []string) []string {
+	var kept []string
+	for _, value := range values {
+		if r.pattern != nil && !r.pattern.MatchString(value) {
+			kept = append(kept, value)
+		}
+	}
+	return kept
+}
+
+// append adds value to the end of the property, creating it if missing
+// List properties like CATEGORIES get value as a new item unless it is already listed.
+func (r *rule) append(props ical.Props, value string) bool {
+	if value == "" {
+		return false
+	}
+
+	prop := props.Get(r.name)
+	if prop == nil {
+		prop = ical.NewProp(r.name)
+		prop.Value = encode(prop, value)
+		props.Set(prop)
+		return true
+	}
+
+	if parser.IsListProp(r.name) {
+		item := textEscaper.Replace(value)
+		for _, existing := range parser.SplitList(prop.Value) {
+			if existing == item {
+				return false
+			}
+		}
+		prop.Value += "," + item
+		return true
+	}
+	prop.Value += encode(prop, value)
+	return true
+}
+
+// alarm adds a display reminder before the event starts
+// The description is the event summary unless the rule gives one.
+func (r *rule) alarm(event *parser.Event, description string) {
+	alarm := ical.NewComponent(ical.CompAlarm)
+	alarm.Props.SetText(ical.PropAction, alarmAction)
+
+	trigger := ical.NewProp(ical.PropTrigger)
+	trigger.SetDuration(-r.Before)
+	alarm.Props.Set(trigger)
+
+	desc := ical.NewProp(ical.PropDescription)
+	switch {
+	case description != "":
+		desc.Value = textEscaper.Replace(description)
+	case event.Summary != "":
+		desc.Value = event.Summary
+	default:
+		desc.Value = "Reminder"
+	}
+	alarm.Props.Set(desc)
+
+	event.RawEvent.Children = append(event.RawEvent.Children, alarm)
+}
+
+// encode returns text as a value of prop, escaped if prop holds text and on one
+// line otherwise. Properties of unknown type, like most X- properties, are treated as text.
+func encode(prop *ical.Prop, text string) string {
+	switch prop.ValueType() {
+	case ical.ValueText, ical.ValueDefault:
+		return textEscaper.Replace(text)
+	}
+	return lineBreaks.Replace(text)
+}
@@ -0,0 +1,253 @@
+package transform
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linus/recal/internal/config"
+	"github.com/linus/recal/internal/filter"
+	"github.com/linus/recal/internal/parser"
+)
+
+// testCalendar has a lodge meeting, a cancelled meeting and a board meeting
+const testCalendar = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n" +
+	"BEGIN:VEVENT\r\nUID:1\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250110T170000Z\r\nSUMMARY:Göta PB: Grad 3\r\n" +
+	"CATEGORIES:Möte\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:2\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250111T170000Z\r\nSUMMARY:INSTÄLLT PB\\, Moderlogen: Grad 8\r\n" +
+	"LOCATION:Stora salen\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:3\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250112T170000Z\r\nSUMMARY:Styrelsemöte\r\n" +
+	"CATEGORIES:Styrelse,Intern\r\nATTENDEE;ROLE=CHAIR;PARTSTAT=ACCEPTED:mailto:a@example.com\r\n" +
+	"ATTENDEE;PARTSTAT=DECLINED:mailto:b@example.com\r\nEND:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+// parseTestCalendar parses testCalendar
+func parseTestCalendar(t *testing.T) *parser.Calendar {
+	t.Helper()
+	cal, err := parser.Parse(strings.NewReader(testCalendar))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	return cal
+}
+
+// TestApply tests each rule type on the raw events
+// Validates: set, if_missing, replace, append to text and lists, submatch expansion from a
+// source, remove of properties, list items and parameters, PROP;PARAM, alarms, serialization
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []config.TransformRule
+		uid   string
+		field string
+		want  []string
+	}{
+		{
+			name:  "strip prefix",
+			rules: []config.TransformRule{{Type: "replace", Field: "SUMMARY", Pattern: "^Göta PB: ", Value: ""}},
+			uid:   "1", field: "SUMMARY", want: []string{"Grad 3"},
+		},
+		{
+			name:  "marker instead of removal",
+			rules: []config.TransformRule{{Type: "replace", Field: "summary", Pattern: `^INSTÄLLT\s*`, Value: "[INSTÄLLT] "}},
+			uid:   "2", field: "SUMMARY", want: []string{`[INSTÄLLT] PB\, Moderlogen: Grad 8`},
+		},
+		{
+			name:  "default location",
+			rules: []config.TransformRule{{Type: "set", Field: "LOCATION", Value: "Logehuset, Göteborg", IfMissing: true}},
+			uid:   "1", field: "LOCATION", want: []string{`Logehuset\, Göteborg`},
+		},
+		{
+			name:  "default location kept",
+			rules: []config.TransformRule{{Type: "set", Field: "LOCATION", Value: "Logehuset", IfMissing: true}},
+			uid:   "2", field: "LOCATION", want: []string{"Stora salen"},
+		},
+		{
+			name: "lodge moved to categories",
+			rules: []config.TransformRule{
+				{Type: "append", Field: "CATEGORIES", Source: "SUMMARY", Pattern: `^(\S+) PB: `, Value: "$1"},
+				{Type: "replace", Field: "SUMMARY", Pattern: `^\S+ PB: `, Value: ""},
+			},
+			uid: "1", field: "CATEGORIES", want: []string{"Möte", "Göta"},
+		},
+		{
+			name:  "append creates list",
+			rules: []config.TransformRule{{Type: "append", Field: "CATEGORIES", Value: "Loge"}},
+			uid:   "2", field: "CATEGORIES", want: []string{"Loge"},
+		},
+		{
+			name:  "append to text",
+			rules: []config.TransformRule{{Type: "append", Field: "SUMMARY", Pattern: "INSTÄLLT", Value: " (inställt)"}},
+			uid:   "2", field: "SUMMARY", want: []string{`INSTÄLLT PB\, Moderlogen: Grad 8 (inställt)`},
+		},
+		{
+			name:  "condition not met",
+			rules: []config.TransformRule{{Type: "set", Field: "CLASS", Source: "SUMMARY", Pattern: "Styrelse", Value: "PRIVATE"}},
+			uid:   "1", field: "CLASS", want: nil,
+		},
+		{
+			name:  "remove list item",
+			rules: []config.TransformRule{{Type: "remove", Field: "CATEGORIES", Pattern: "^Intern$"}},
+			uid:   "3", field: "CATEGORIES", want: []string{"Styrelse"},
+		},
+		{
+			name:  "remove matching occurrences",
+			rules: []config.TransformRule{{Type: "remove", Field: "ATTENDEE", Pattern: "b@example"}},
+			uid:   "3", field: "ATTENDEE", want: []string{"mailto:a@example.com"},
+		},
+		{
+			name:  "remove parameter",
+			rules: []config.TransformRule{{Type: "remove", Field: "ATTENDEE;ROLE"}},
+			uid:   "3", field: "ATTENDEE;ROLE", want: nil,
+		},
+		{
+			name:  "set parameter",
+			rules: []config.TransformRule{{Type: "set", Field: "attendee;partstat", Value: "NEEDS-ACTION"}},
+			uid:   "3", field: "ATTENDEE;PARTSTAT", want: []string{"NEEDS-ACTION", "NEEDS-ACTION"},
+		},
+		{
+			name:  "replace in parameter",
+			rules: []config.TransformRule{{Type: "replace", Field: "ATTENDEE;PARTSTAT", Pattern: "DECLINED", Value: "TENTATIVE"}},
+			uid:   "3", field: "ATTENDEE;PARTSTAT", want: []string{"ACCEPTED", "TENTATIVE"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline := NewPipeline(filter.NewEngine(&config.Config{}))
+			for _, rule := range tt.rules {
+				if err := pipeline.AddRule(rule); err != nil {
+					t.Fatalf("AddRule(%v) failed: %v", rule, err)
+				}
+			}
+
+			cal := parseTestCalendar(t)
+			out := pipeline.Apply(cal)
+			for _, event := range out.Events {
+				if event.UID != tt.uid {
+					continue
+				}
+				got := event.GetFieldValues(tt.field)
+				if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+					t.Errorf("%s = %q, want %q", tt.field, got, tt.want)
+				}
+			}
+			if cal.Events[0].Summary != "Göta PB: Grad 3" || len(cal.Events[1].RawEvent.Props.Values("CATEGORIES")) != 0 {
+				t.Error("Apply() changed the input calendar")
+			}
+		})
+	}
+}
+
+// TestApplyAlarmAndSerialize tests that rewritten events are emitted by Serialize
+// Validates: Struct fields follow the raw component, VALARM added with TRIGGER and description
+func TestApplyAlarmAndSerialize(t *testing.T) {
+	pipeline := NewPipeline(filter.NewEngine(&config.Config{}))
+	rules := []config.TransformRule{
+		{Type: "replace", Field: "SUMMARY", Pattern: "^Göta PB: ", Value: ""},
+		{Type: "alarm", Pattern: "Grad", Before: 90 * time.Minute},
+	}
+	for _, rule := range rules {
+		if err := pipeline.AddRule(rule); err != nil {
+			t.Fatalf("AddRule() failed: %v", err)
+		}
+	}
+
+	out := pipeline.Apply(parseTestCalendar(t))
+	if out.Events[0].Summary != "Grad 3" {
+		t.Errorf("Summary = %q, want the rewritten summary", out.Events[0].Summary)
+	}
+
+	var buf bytes.Buffer
+	if err := out.Serialize(&buf); err != nil {
+		t.Fatalf("Serialize() failed: %v", err)
+	}
+	ics := buf.String()
+	for _, want := range []string{"SUMMARY:Grad 3\r\n", "BEGIN:VALARM\r\nACTION:DISPLAY\r\nDESCRIPTION:Grad 3\r\nTRIGGER:-PT5400S\r\nEND:VALARM"} {
+		if !strings.Contains(ics, want) {
+			t.Errorf("Serialized calendar does not contain %q:\n%s", want, ics)
+		}
+	}
+	if n := strings.Count(ics, "BEGIN:VALARM"); n != 2 {
+		t.Errorf("Serialized calendar has %d alarms, want 2 (events mentioning Grad)", n)
+	}
+}
+
+// TestApplyLineBreaks tests values with line breaks
+// Validates: Escaped in TEXT, joined onto one line in other types, output still serializes
+func TestApplyLineBreaks(t *testing.T) {
+	pipeline := NewPipeline(filter.NewEngine(&config.Config{}))
+	for _, rule := range []config.TransformRule{
+		{Type: "set", Field: "DESCRIPTION", Value: "rad 1\r\nrad 2\rrad 3"},
+		{Type: "set", Field: "URL", Value: "https://example.com/\r\nX-EVIL:1"},
+	} {
+		if err := pipeline.AddRule(rule); err != nil {
+			t.Fatalf("AddRule() failed: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := pipeline.Apply(parseTestCalendar(t)).Serialize(&buf); err != nil {
+		t.Fatalf("Serialize() failed: %v", err)
+	}
+	ics := buf.String()
+	if !strings.Contains(ics, `DESCRIPTION:rad 1\nrad 2\nrad 3`+"\r\n") || !strings.Contains(ics, "URL:https://example.com/ X-EVIL:1\r\n") {
+		t.Errorf("Line breaks not escaped or joined:\n%s", ics)
+	}
+	if strings.Contains(ics, "\r\nX-EVIL") {
+		t.Errorf("Value injected a property:\n%s", ics)
+	}
+}
+
+// TestPipelineBuild tests adding presets and request rules
+// Validates: Presets in order, unknown presets, invalid rules (protected properties, line breaks
+// in replacements and parameters, quotes in parameters), request patterns within the regex budgets
+func TestPipelineBuild(t *testing.T) {
+	cfg := &config.Config{
+		Regex: config.RegexConfig{MaxFilters: 1},
+		Transforms: config.TransformsConfig{Presets: map[string][]config.TransformRule{
+			"kort": {
+				{Type: "replace", Field: "SUMMARY", Pattern: "^Göta PB: ", Value: ""},
+				{Type: "replace", Field: "SUMMARY", Pattern: "^Grad ", Value: "G"},
+			},
+		}},
+	}
+
+	pipeline := NewPipeline(filter.NewEngine(cfg))
+	if err := pipeline.AddPreset("kort"); err != nil {
+		t.Fatalf("AddPreset() failed: %v", err)
+	}
+	if err := pipeline.AddPreset("saknas"); err == nil {
+		t.Error("AddPreset() of an unknown preset should fail")
+	}
+	if got := pipeline.Apply(parseTestCalendar(t)).Events[0].Summary; got != "G3" {
+		t.Errorf("Summary = %q, want the preset rules applied in order", got)
+	}
+	if len(pipeline.Presets()) != 1 || len(pipeline.Rules()) != 2 {
+		t.Errorf("Presets() = %v with %d rules, want kort with 2", pipeline.Presets(), len(pipeline.Rules()))
+	}
+
+	for _, rule := range []config.TransformRule{
+		{Type: "set", Field: "UID", Value: "x"},
+		{Type: "remove", Field: "DTSTAMP"},
+		{Type: "set", Field: "BEGIN", Value: "VTODO"},
+		{Type: "set", Field: "end", Value: "VEVENT"},
+		{Type: "replace", Field: "SUMMARY", Pattern: "Grad", Value: "x\nX-EVIL:1"},
+		{Type: "set", Field: "ATTENDEE;CN", Value: "a\rb"},
+		{Type: "set", Field: "ATTENDEE;CN", Value: `a"b`},
+	} {
+		if err := pipeline.AddRule(rule); err == nil {
+			t.Errorf("AddRule(%v) should fail", rule)
+		}
+	}
+	if err := pipeline.AddRule(config.TransformRule{Type: "replace", Field: "SUMMARY", Pattern: "a"}); err != nil {
+		t.Fatalf("AddRule() failed: %v", err)
+	}
+	err := pipeline.AddRule(config.TransformRule{Type: "replace", Field: "SUMMARY", Pattern: "b"})
+	var budgetErr *filter.BudgetError
+	if !errors.As(err, &budgetErr) {
+		t.Errorf("AddRule() over regex.max_filters = %v, want a *BudgetError", err)
+	}
+}